@@ -25,6 +25,7 @@ import (
 	refs "github.com/ssbc/go-ssb-refs"
 	"github.com/ssbc/go-ssb/blobstore"
 	"github.com/ssbc/go-ssb/message"
+	"github.com/ssbc/go-ssb/network"
 	"github.com/ssbc/go-ssb/plugins/whoami"
 )
 
@@ -80,7 +81,22 @@ func FromEndpoint(edp muxrpc.Endpoint, opts ...Option) (*Client, error) {
 	return c, nil
 }
 
+// NewTCP connects to the tcp part of the remote address and authenticates with the secret-handshake.
 func NewTCP(own ssb.KeyPair, remote net.Addr, opts ...Option) (*Client, error) {
+	return newSecretStream(own, remote, netwrap.Dial, netwrap.GetAddr(remote, "tcp"), opts)
+}
+
+// NewWebsocket connects to the websocket part of the remote address (see network.ParseAddress) and authenticates with the secret-handshake.
+// This works with pubs that are only reachable through a http reverse proxy.
+func NewWebsocket(own ssb.KeyPair, remote net.Addr, opts ...Option) (*Client, error) {
+	wsAddr := netwrap.GetAddr(remote, network.WebsocketNetwork)
+	if wsAddr == nil {
+		return nil, errors.New("ssbClient: expected an address containing a websocket addr")
+	}
+	return newSecretStream(own, remote, network.DialWebsocket, wsAddr, opts)
+}
+
+func newSecretStream(own ssb.KeyPair, remote net.Addr, dial netwrap.Dialer, dialAddr net.Addr, opts []Option) (*Client, error) {
 	c, err := newClientWithOptions(opts)
 	if err != nil {
 		return nil, err
@@ -107,7 +123,7 @@ func NewTCP(own ssb.KeyPair, remote net.Addr, opts ...Option) (*Client, error) {
 	}
 	copy(pubKey[:], shsAddr.PubKey)
 
-	conn, err := netwrap.Dial(netwrap.GetAddr(remote, "tcp"), shsClient.ConnWrapper(pubKey))
+	conn, err := dial(dialAddr, shsClient.ConnWrapper(pubKey))
 	if err != nil {
 		return nil, fmt.Errorf("error dialing: %w", err)
 	}
@@ -359,6 +375,14 @@ func (c Client) TanglesThread(o message.TanglesArgs) (*muxrpc.ByteSource, error)
 	return src, nil
 }
 
+func (c Client) Links(o message.LinksArgs) (*muxrpc.ByteSource, error) {
+	src, err := c.Source(c.rootCtx, muxrpc.TypeJSON, muxrpc.Method{"links"}, o)
+	if err != nil {
+		return nil, fmt.Errorf("ssbClient: links query failed: (%T): %w", o, err)
+	}
+	return src, nil
+}
+
 func (c Client) InviteCreate(o message.InviteCreateArgs) (string, error) {
 	var invite string
 	err := c.Async(c.rootCtx, &invite, muxrpc.TypeString, muxrpc.Method{"invite", "create"}, o)
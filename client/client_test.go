@@ -6,10 +6,12 @@ package client_test
 
 import (
 	"context"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"net"
 	"os"
 	"path/filepath"
 	"sync/atomic"
@@ -29,6 +31,7 @@ import (
 	"github.com/ssbc/go-ssb/internal/testutils"
 	"github.com/ssbc/go-ssb/message"
 	"github.com/ssbc/go-ssb/network"
+	"github.com/ssbc/go-ssb/query"
 	"github.com/ssbc/go-ssb/sbot"
 )
 
@@ -142,6 +145,265 @@ func TestWhoami(t *testing.T) {
 	r.NoError(<-srvErrc)
 }
 
+func TestWebsocketWhoami(t *testing.T) {
+	r, a := require.New(t), assert.New(t)
+
+	srvRepo := filepath.Join("testrun", t.Name(), "serv")
+	os.RemoveAll(srvRepo)
+	srvLog := testutils.NewRelativeTimeLogger(nil)
+
+	// find a free port for the websocket listener
+	tmpLis, err := net.Listen("tcp", "127.0.0.1:0")
+	r.NoError(err)
+	wsAddr := tmpLis.Addr().String()
+	r.NoError(tmpLis.Close())
+
+	srv, err := sbot.New(
+		sbot.WithInfo(srvLog),
+		sbot.WithRepoPath(srvRepo),
+		sbot.WithListenAddr(":0"),
+		sbot.WithWebsocketAddress(wsAddr))
+	r.NoError(err, "sbot srv init failed")
+
+	kp, err := ssb.LoadKeyPair(filepath.Join(srvRepo, "secret"))
+	r.NoError(err, "failed to load servers keypair")
+
+	remote, err := network.ParseAddress(fmt.Sprintf("ws://%s~shs:%s", wsAddr, base64.StdEncoding.EncodeToString(kp.ID().PubKey())))
+	r.NoError(err)
+
+	c, err := client.NewWebsocket(kp, remote)
+	r.NoError(err, "failed to make client connection")
+
+	ref, err := c.Whoami()
+	r.NoError(err, "failed to call whoami")
+	a.Equal(kp.ID().String(), ref.String())
+
+	a.NoError(c.Close())
+
+	srv.Shutdown()
+	r.NoError(srv.Close())
+}
+
+func TestGrantedClient(t *testing.T) {
+	r, a := require.New(t), assert.New(t)
+
+	srvRepo := filepath.Join("testrun", t.Name(), "serv")
+	os.RemoveAll(srvRepo)
+	srvLog := testutils.NewRelativeTimeLogger(nil)
+
+	srv, err := sbot.New(
+		sbot.WithInfo(srvLog),
+		sbot.WithRepoPath(srvRepo),
+		sbot.WithListenAddr(":0"))
+	r.NoError(err, "sbot srv init failed")
+
+	var srvErrc = make(chan error, 1)
+	go func() {
+		err := srv.Network.Serve(context.TODO())
+		if err != nil {
+			srvErrc <- fmt.Errorf("ali serve exited: %w", err)
+		}
+		close(srvErrc)
+	}()
+
+	kp, err := ssb.NewKeyPair(nil, refs.RefAlgoFeedSSB1)
+	r.NoError(err)
+	srvAddr := srv.Network.GetListenAddr()
+
+	// without a grant the client only gets the public handler
+	c, err := client.NewTCP(kp, srvAddr)
+	r.NoError(err, "failed to make client connection")
+	_, err = c.Publish(struct {
+		Type string `json:"type"`
+	}{"post"})
+	r.Error(err, "unknown client could publish")
+	a.NoError(c.Close())
+
+	_, err = srv.ClientGrants.Grant(kp.ID(), []string{"read", "publish:post"}, "testing")
+	r.NoError(err)
+
+	c, err = client.NewTCP(kp, srvAddr)
+	r.NoError(err, "failed to make client connection")
+	// end test boilerplate
+
+	ref, err := c.Whoami()
+	r.NoError(err, "failed to call whoami")
+	a.Equal(srv.KeyPair.ID().String(), ref.String(), "should be the servers feed")
+
+	_, err = c.Publish(struct {
+		Type string `json:"type"`
+		Text string `json:"text"`
+	}{"post", "hello from the frontend"})
+	r.NoError(err, "publish of granted type failed")
+
+	_, err = c.Publish(struct {
+		Type    string       `json:"type"`
+		Contact refs.FeedRef `json:"contact"`
+	}{"contact", kp.ID()})
+	r.Error(err, "publish of other type should fail")
+
+	seq, err := srv.CurrentSequence(srv.KeyPair.ID())
+	r.NoError(err)
+	a.EqualValues(1, seq.Seq, "expected only one message")
+
+	// get with private:true only decrypts for the bot itself, a read grant gets the box
+	boxed, err := srv.Groups.EncryptBox1([]byte(`{"type":"post","text":"only for the bot"}`), srv.KeyPair.ID())
+	r.NoError(err)
+	privMsg, err := srv.PublishLog.Publish(boxed)
+	r.NoError(err)
+	getPrivate := func(c *client.Client) refs.KeyValueRaw {
+		var kv refs.KeyValueRaw
+		err := c.Async(context.TODO(), &kv, muxrpc.TypeJSON, muxrpc.Method{"get"}, map[string]interface{}{"id": privMsg.Key().String(), "private": true})
+		r.NoError(err)
+		return kv
+	}
+	kv := getPrivate(c)
+	a.NotContains(string(kv.Value.Content), "only for the bot")
+	a.Contains(string(kv.Value.Content), ".box")
+
+	// nor do the subset operations that look at decrypted messages
+	getSubset := func(c *client.Client, args ...interface{}) (int, error) {
+		ctx := context.TODO()
+		src, err := c.Source(ctx, muxrpc.TypeJSON, muxrpc.Method{"partialReplication", "getSubset"}, args...)
+		if err != nil {
+			return 0, err
+		}
+		var n int
+		for src.Next(ctx) {
+			if _, err := src.Bytes(); err != nil {
+				return n, err
+			}
+			n++
+		}
+		return n, src.Err()
+	}
+	_, err = getSubset(c, query.NewSubsetOpPrivate())
+	a.Error(err, "read grant evaluated private")
+	_, err = getSubset(c, query.NewSubsetOpByLink(srv.KeyPair.ID(), ""))
+	a.Error(err, "read grant evaluated link")
+	_, err = getSubset(c, query.NewSubsetOpByAuthor(srv.KeyPair.ID()), query.SubsetOptions{Keys: true, PageLimit: -1, Live: true})
+	a.Error(err, "read grant started a live query")
+	n, err := getSubset(c, query.NewSubsetOpByAuthor(srv.KeyPair.ID()))
+	r.NoError(err)
+	a.Equal(2, n)
+
+	self, err := client.NewTCP(srv.KeyPair, srvAddr)
+	r.NoError(err)
+	kv = getPrivate(self)
+	a.Contains(string(kv.Value.Content), "only for the bot")
+	n, err = getSubset(self, query.NewSubsetOpPrivate())
+	r.NoError(err)
+	a.Equal(1, n)
+	a.NoError(self.Close())
+
+	a.NoError(c.Close())
+
+	srv.Shutdown()
+	r.NoError(srv.Close())
+	r.NoError(<-srvErrc)
+}
+
+func TestPartialSubsetOps(t *testing.T) {
+	r, a := require.New(t), assert.New(t)
+
+	srvRepo := filepath.Join("testrun", t.Name(), "serv")
+	os.RemoveAll(srvRepo)
+	srvLog := testutils.NewRelativeTimeLogger(nil)
+
+	srv, err := sbot.New(
+		sbot.WithInfo(srvLog),
+		sbot.WithRepoPath(srvRepo),
+		sbot.WithListenAddr(":0"))
+	r.NoError(err, "sbot srv init failed")
+
+	var srvErrc = make(chan error, 1)
+	go func() {
+		err := srv.Network.Serve(context.TODO())
+		if err != nil {
+			srvErrc <- fmt.Errorf("ali serve exited: %w", err)
+		}
+		close(srvErrc)
+	}()
+
+	for _, typ := range []string{"post", "about", "post"} {
+		_, err = srv.PublishLog.Publish(map[string]interface{}{"type": typ})
+		r.NoError(err)
+	}
+	r.NoError(srv.WaitUntilIndexed(context.TODO(), srv.ReceiveLog.Seq()))
+
+	kp, err := ssb.NewKeyPair(nil, refs.RefAlgoFeedSSB1)
+	r.NoError(err)
+	srvAddr := srv.Network.GetListenAddr()
+	srv.Replicate(kp.ID())
+
+	getSubset := func(c *client.Client, args ...interface{}) (int, error) {
+		ctx := context.TODO()
+		src, err := c.Source(ctx, muxrpc.TypeJSON, muxrpc.Method{"partialReplication", "getSubset"}, args...)
+		if err != nil {
+			return 0, err
+		}
+		var n int
+		for src.Next(ctx) {
+			if _, err := src.Bytes(); err != nil {
+				return n, err
+			}
+			n++
+		}
+		return n, src.Err()
+	}
+
+	byAuthor := query.NewSubsetAndCombination(
+		query.NewSubsetOpByAuthor(srv.KeyPair.ID()),
+		query.NewSubsetOpByType("post"),
+	)
+	notPosts := query.NewSubsetAndCombination(
+		query.NewSubsetOpByAuthor(srv.KeyPair.ID()),
+		query.NewSubsetNot(query.NewSubsetOpByType("post")),
+	)
+
+	// remote peers only get the operations that don't scan the receive log
+	c, err := client.NewTCP(kp, srvAddr)
+	r.NoError(err, "failed to make client connection")
+	n, err := getSubset(c, byAuthor)
+	r.NoError(err)
+	a.Equal(2, n)
+	_, err = getSubset(c, notPosts)
+	a.Error(err, "public handler evaluated a not")
+	_, err = getSubset(c, byAuthor, query.SubsetOptions{Keys: true, PageLimit: -1, Live: true})
+	a.Error(err, "public handler started a live query")
+	a.NoError(c.Close())
+
+	self, err := client.NewTCP(srv.KeyPair, srvAddr)
+	r.NoError(err)
+	n, err = getSubset(self, notPosts)
+	r.NoError(err)
+	a.Equal(1, n)
+
+	// the local user can follow new messages
+	ctx, cancel := context.WithCancel(context.TODO())
+	src, err := self.Source(ctx, muxrpc.TypeJSON, muxrpc.Method{"partialReplication", "getSubset"},
+		byAuthor, query.SubsetOptions{Keys: true, PageLimit: -1, Live: true})
+	r.NoError(err)
+	for i := 0; i < 2; i++ {
+		r.True(src.Next(ctx), "missing existing message %d: %v", i, src.Err())
+		_, err = src.Bytes()
+		r.NoError(err)
+	}
+	newPost, err := srv.PublishLog.Publish(map[string]interface{}{"type": "post"})
+	r.NoError(err)
+	r.True(src.Next(ctx), "missing live message: %v", src.Err())
+	var kv refs.KeyValueRaw
+	err = src.Reader(decodeMuxMsg(&kv))
+	r.NoError(err)
+	a.Equal(newPost.Key().String(), kv.Key_.String())
+	cancel()
+	a.NoError(self.Close())
+
+	srv.Shutdown()
+	r.NoError(srv.Close())
+	r.NoError(<-srvErrc)
+}
+
 func TestLotsOfWhoami(t *testing.T) {
 	// defer leakcheck.Check(t)
 	r, a := require.New(t), assert.New(t)
@@ -93,6 +93,31 @@ func ReadEnvironmentVariables(config *config.SbotConfig) {
 		config.SetPresence("promisc", true)
 	}
 
+	if val := os.Getenv("SSB_SEARCH_ENABLED"); val != "" {
+		config.EnableSearch = readEnvironmentBoolean(val)
+		config.SetPresence("enable-search", true)
+	}
+
+	if val := os.Getenv("SSB_FEED_SEGMENTS_ENABLED"); val != "" {
+		config.FeedSegments = readEnvironmentBoolean(val)
+		config.SetPresence("feed-segments", true)
+	}
+
+	if val := os.Getenv("SSB_LOG_FORMAT"); val != "" {
+		config.LogFormat = val
+		config.SetPresence("log-format", true)
+	}
+
+	if val := os.Getenv("SSB_REPO_KEYFILE"); val != "" {
+		config.RepoKeyfile = val
+		config.SetPresence("repo-keyfile", true)
+	}
+
+	if val := os.Getenv("SSB_SECRET_KEYFILE"); val != "" {
+		config.SecretKeyfile = val
+		config.SetPresence("secret-keyfile", true)
+	}
+
 	if val := os.Getenv("SSB_SOCKET_ENABLED"); val != "" {
 		config.NoUnixSocket = !readEnvironmentBoolean(val)
 		config.SetPresence("nounixsock", true)
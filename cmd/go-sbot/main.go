@@ -33,9 +33,12 @@ import (
 
 	"github.com/ssbc/go-ssb"
 	"github.com/ssbc/go-ssb/internal/ctxutils"
+	"github.com/ssbc/go-ssb/internal/passphrase"
 	"github.com/ssbc/go-ssb/internal/storedrefs"
 	"github.com/ssbc/go-ssb/internal/testutils"
 	"github.com/ssbc/go-ssb/multilogs"
+	"github.com/ssbc/go-ssb/network"
+	"github.com/ssbc/go-ssb/repo"
 	mksbot "github.com/ssbc/go-ssb/sbot"
 )
 
@@ -43,6 +46,7 @@ var (
 	// flags
 	flagCleanup  bool
 	flagReindex  bool
+	flagCompact  bool
 	flagFSCK     string
 	flagRepair   bool
 	flagFatBot   bool
@@ -57,11 +61,23 @@ var (
 
 	flagDisableUNIXSock bool
 
+	flagConnFirewall bool
+	flagEnableSearch bool
+	flagFeedSegments bool
+	flagLogFormat    string
+	flagRepoKeyfile  string
+	flagRepoEncrypt  bool
+
+	flagSecretKeyfile string
+	flagSecretEncrypt bool
+
 	repoDir     string
 	listenAddr  string
 	wsLisAddr   string
 	wsTLSCert   string
 	wsTLSKey    string
+	onionProxy  string
+	onionLis    string
 	debugAddr   string
 	debugLogDir string
 	configPath  string
@@ -117,10 +133,27 @@ func initFlags() {
 	flag.StringVar(&wsTLSCert, "wstlscert", "", "tls certificate file for ssb-ws connections")
 	flag.StringVar(&wsTLSKey, "wstlskey", "", "tls key file for ssb-ws connections")
 
+	flag.StringVar(&onionProxy, "onion-proxy", "", "SOCKS5 proxy to dial onion addresses through (like tor on localhost:9050)")
+	flag.StringVar(&onionLis, "onion-lis", "", "address to listen on for connections from a tor hidden service")
+
 	flag.BoolVar(&flagEnableEBT, "enable-ebt", false, "enable syncing by using epidemic-broadcast-trees (new code, test with caution)")
 
 	flag.BoolVar(&flagDisableUNIXSock, "nounixsock", false, "disable the UNIX socket RPC interface")
 
+	flag.BoolVar(&flagConnFirewall, "conn-firewall", false, "rate-limit incoming connections per IP and ban IPs that repeatedly fail the handshake or authorization")
+
+	flag.BoolVar(&flagEnableSearch, "enable-search", false, "index the text of posts and abouts, including the private ones, for search.query")
+
+	flag.BoolVar(&flagFeedSegments, "feed-segments", false, "also keep the messages of every feed in a log of its own, for sequential history reads and deleting feeds")
+
+	flag.StringVar(&flagLogFormat, "log-format", "", "format of a new receive log: offset2 (default), bipf or segmented")
+
+	flag.StringVar(&flagRepoKeyfile, "repo-keyfile", "", "keyfile of an encrypted repo, otherwise the passphrase is read from $"+repoPassphraseEnv+" or asked for")
+	flag.BoolVar(&flagRepoEncrypt, "repo-encrypt", false, "encrypt a new repo with -repo-keyfile, $"+repoPassphraseEnv+" or a passphrase that is asked for")
+
+	flag.StringVar(&flagSecretKeyfile, "secret-keyfile", "", "keyfile of an encrypted secret file, otherwise the passphrase is read from $"+secretPassphraseEnv+" or asked for")
+	flag.BoolVar(&flagSecretEncrypt, "secret-encrypt", false, "encrypt a new secret file with -secret-keyfile, $"+secretPassphraseEnv+" or a passphrase that is asked for")
+
 	flag.StringVar(&repoDir, "repo", filepath.Join(u.HomeDir, DEFAULT_GO_SSB_DIR), "where to put the log and indexes")
 
 	flag.StringVar(&debugAddr, "debuglis", "localhost:6078", "listen addr for metrics and pprof HTTP server")
@@ -132,7 +165,9 @@ func initFlags() {
 
 	flag.BoolVar(&flagCleanup, "cleanup", false, "remove blocked feeds")
 
-	flag.StringVar(&flagFSCK, "fsck", "", "run a filesystem check on the repo (possible values: length, sequences)")
+	flag.BoolVar(&flagCompact, "compact", false, "rewrite the receive log without the nulled messages before starting")
+
+	flag.StringVar(&flagFSCK, "fsck", "", "run a filesystem check on the repo (possible values: length, sequences, verify)")
 	flag.BoolVar(&flagRepair, "repair", false, "run repo healing if fsck fails")
 
 	flag.BoolVar(&flagPrintVersion, "version", false, "print version number and build date")
@@ -246,12 +281,36 @@ func applyConfigValues() {
 	if UseConfigValue("wstlskey") {
 		wsTLSKey = config.WebsocketTLSKey
 	}
+	if UseConfigValue("onion-proxy") {
+		onionProxy = config.OnionProxy
+	}
+	if UseConfigValue("onion-lis") {
+		onionLis = config.OnionListenAddress
+	}
 	if UseConfigValue("enable-ebt") {
 		flagEnableEBT = (bool)(config.EnableEBT)
 	}
 	if UseConfigValue("nounixsock") {
 		flagDisableUNIXSock = (bool)(config.NoUnixSocket)
 	}
+	if UseConfigValue("conn-firewall") {
+		flagConnFirewall = (bool)(config.ConnFirewall)
+	}
+	if UseConfigValue("enable-search") {
+		flagEnableSearch = (bool)(config.EnableSearch)
+	}
+	if UseConfigValue("feed-segments") {
+		flagFeedSegments = (bool)(config.FeedSegments)
+	}
+	if UseConfigValue("log-format") {
+		flagLogFormat = config.LogFormat
+	}
+	if UseConfigValue("repo-keyfile") {
+		flagRepoKeyfile = config.RepoKeyfile
+	}
+	if UseConfigValue("secret-keyfile") {
+		flagSecretKeyfile = config.SecretKeyfile
+	}
 	if UseConfigValue("hmac") {
 		hmacSec = config.Hmac
 	}
@@ -266,6 +325,53 @@ func applyConfigValues() {
 	}
 }
 
+// repoPassphraseEnv is the environment variable with the passphrase of an encrypted repo.
+// Unlike the other settings it isn't read from the config, which is written to running-config.json.
+const repoPassphraseEnv = "SSB_REPO_PASSPHRASE"
+
+// repoSecret returns the secret that unlocks the encrypted repo, or the one a new repo is encrypted with if -repo-encrypt is set
+func repoSecret() ([]byte, error) {
+	encrypted, err := repo.IsEncrypted(repo.New(repoDir))
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case encrypted:
+		return passphrase.Read(flagRepoKeyfile, repoPassphraseEnv, "passphrase of the repo: ")
+	case flagRepoEncrypt:
+		return passphrase.ReadNew(flagRepoKeyfile, repoPassphraseEnv, "passphrase for the new repo: ")
+	case flagRepoKeyfile != "" || os.Getenv(repoPassphraseEnv) != "":
+		return nil, fmt.Errorf("the repo is not encrypted, a new one is encrypted with -repo-encrypt")
+	}
+	return nil, nil
+}
+
+// secretPassphraseEnv is the environment variable with the passphrase of an encrypted secret file, like repoPassphraseEnv
+const secretPassphraseEnv = "SSB_SECRET_PASSPHRASE"
+
+// keyPairSecret returns the secret that decrypts the secret file of the repo, or the one a new secret file is encrypted with if -secret-encrypt is set
+func keyPairSecret() ([]byte, error) {
+	secPath := repo.KeyPairPath(repo.New(repoDir), "-")
+	encrypted, err := ssb.IsEncryptedKeyPair(secPath)
+	exists := err == nil
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	switch {
+	case encrypted:
+		return passphrase.Read(flagSecretKeyfile, secretPassphraseEnv, "passphrase of the secret file: ")
+	case !exists && flagSecretEncrypt:
+		return passphrase.ReadNew(flagSecretKeyfile, secretPassphraseEnv, "passphrase for the new secret file: ")
+	case exists && flagSecretEncrypt:
+		return nil, fmt.Errorf("the secret file %s is not encrypted, it is encrypted with ssb-keygen reencrypt -", secPath)
+	case flagSecretKeyfile != "" || os.Getenv(secretPassphraseEnv) != "":
+		return nil, fmt.Errorf("the secret file is not encrypted, a new one is encrypted with -secret-encrypt")
+	}
+	return nil, nil
+}
+
 func runSbot() error {
 	initFlags()
 
@@ -343,6 +449,34 @@ func runSbot() error {
 		opts = append(opts, mksbot.LateOption(mksbot.WithUNIXSocket()))
 	}
 
+	if flagConnFirewall {
+		opts = append(opts, mksbot.WithFirewall(network.DefaultFirewallConfig()))
+	}
+
+	if flagEnableSearch {
+		opts = append(opts, mksbot.EnableSearch())
+	}
+
+	if flagFeedSegments {
+		opts = append(opts, mksbot.EnableFeedSegments())
+	}
+
+	if flagLogFormat != "" {
+		format, err := repo.ParseLogFormat(flagLogFormat)
+		if err != nil {
+			return err
+		}
+		opts = append(opts, mksbot.WithReceiveLogFormat(format))
+	}
+
+	if onionProxy != "" {
+		opts = append(opts, mksbot.WithOnionProxy(onionProxy))
+	}
+
+	if onionLis != "" {
+		opts = append(opts, mksbot.WithOnionListenAddr(onionLis))
+	}
+
 	if debugLogDir != "" {
 		opts = append(opts, mksbot.WithPostSecureConnWrapper(func(conn net.Conn) (net.Conn, error) {
 			parts := strings.Split(conn.RemoteAddr().String(), "|")
@@ -377,6 +511,32 @@ func runSbot() error {
 		opts = append(opts, mksbot.WithHMACSigning(hcbytes))
 	}
 
+	secret, err := repoSecret()
+	if err != nil {
+		return err
+	}
+	var secretOpts []mksbot.Option
+	if secret != nil {
+		secretOpts = append(secretOpts, mksbot.WithRepoSecret(secret))
+		opts = append(opts, secretOpts...)
+	}
+
+	kpSecret, err := keyPairSecret()
+	if err != nil {
+		return err
+	}
+	if kpSecret != nil {
+		opts = append(opts, mksbot.WithKeyPairSecret(kpSecret))
+	}
+
+	if flagCompact {
+		stats, err := mksbot.CompactReceiveLog(repoDir, append([]mksbot.Option{mksbot.WithInfo(log)}, secretOpts...)...)
+		if err != nil {
+			return fmt.Errorf("failed to compact the receive log: %w", err)
+		}
+		level.Info(log).Log("event", "compacted receive log", "kept", stats.Kept, "removed", stats.Removed, "reclaimed", stats.Reclaimed)
+	}
+
 	sbot, err := mksbot.New(opts...)
 	if err != nil {
 		return fmt.Errorf("failed to instantiate ssb server: %w", err)
@@ -417,6 +577,8 @@ func runSbot() error {
 			fsckMode = mksbot.FSCKModeSequences
 		case "length":
 			fsckMode = mksbot.FSCKModeLength
+		case "verify":
+			fsckMode = mksbot.FSCKModeVerify
 		default:
 			return fmt.Errorf("unknown fsck mode: %q", flagFSCK)
 		}
@@ -503,7 +665,8 @@ func runSbot() error {
 	if flagCleanup {
 		level.Warn(log).Log("mode", "cleanup")
 
-		tg, err := sbot.GraphBuilder.Build()
+		// including the private blocks
+		tg, err := sbot.GraphBuilder.BuildWithPrivate()
 		if err != nil {
 			return fmt.Errorf("failed to build graph during cleanup: %w", err)
 		}
@@ -13,7 +13,6 @@ import (
 	"github.com/ssbc/go-muxrpc/v2"
 	"github.com/urfave/cli/v2"
 
-	"github.com/ssbc/go-ssb"
 	refs "github.com/ssbc/go-ssb-refs"
 	"github.com/ssbc/go-ssb/internal/aliases"
 )
@@ -38,7 +37,7 @@ var aliasRegisterCmd = &cli.Command{
 			return errors.New("alias.register: need a name to register")
 		}
 
-		localKey, err := ssb.LoadKeyPair(ctx.String("key"))
+		localKey, err := loadLocalKey(ctx)
 		if err != nil {
 			return err
 		}
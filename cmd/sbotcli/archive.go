@@ -0,0 +1,137 @@
+// SPDX-FileCopyrightText: 2021 The Go-SSB Authors
+//
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/ssbc/go-muxrpc/v2"
+	"github.com/urfave/cli/v2"
+
+	"github.com/ssbc/go-ssb"
+	refs "github.com/ssbc/go-ssb-refs"
+	archiveplug "github.com/ssbc/go-ssb/plugins/archive"
+)
+
+var archiveCmd = &cli.Command{
+	Name:  "archive",
+	Usage: "Move feeds and their blobs between bots as portable archive files",
+	Subcommands: []*cli.Command{
+		archiveExportCmd,
+		archiveImportCmd,
+	},
+}
+
+var archiveExportCmd = &cli.Command{
+	Name:  "export",
+	Usage: "Write the messages of feeds, the bot's own by default, to an archive",
+	Flags: []cli.Flag{
+		&cli.StringSliceFlag{Name: "feeds", Usage: "the feeds to export, comma separated or repeated"},
+		&cli.BoolFlag{Name: "with-blobs", Usage: "include the blobs the messages reference"},
+		&cli.StringFlag{Name: "out", Value: "-", Usage: "the archive file (stdout by default)"},
+	},
+	Action: func(ctx *cli.Context) error {
+		var args archiveplug.ExportArgs
+		for _, f := range ctx.StringSlice("feeds") {
+			for _, s := range strings.Split(f, ",") {
+				if s = strings.TrimSpace(s); s == "" {
+					continue
+				}
+				ref, err := refs.ParseFeedRef(s)
+				if err != nil {
+					return fmt.Errorf("archive export: invalid feed %q: %w", s, err)
+				}
+				args.Feeds = append(args.Feeds, ref)
+			}
+		}
+		args.Blobs = ctx.Bool("with-blobs")
+
+		client, err := newClient(ctx)
+		if err != nil {
+			return err
+		}
+
+		src, err := client.Source(longctx, muxrpc.TypeBinary, muxrpc.Method{"archive", "export"}, args)
+		if err != nil {
+			return fmt.Errorf("archive export: source call failed: %w", err)
+		}
+
+		var out io.Writer = os.Stdout
+		if name := ctx.String("out"); name != "-" {
+			f, err := os.Create(name)
+			if err != nil {
+				return fmt.Errorf("archive export: failed to create output file: %w", err)
+			}
+			defer f.Close()
+			out = f
+		}
+
+		n, err := io.Copy(out, muxrpc.NewSourceReader(src))
+		if err != nil {
+			return fmt.Errorf("archive export: failed to write the archive: %w", err)
+		}
+		log.Log("event", "archive exported", "bytes", n)
+		return nil
+	},
+}
+
+var archiveImportCmd = &cli.Command{
+	Name:      "import",
+	Usage:     "Verify the messages of an archive and store them with its blobs",
+	ArgsUsage: "<archive file or - for stdin>",
+	Action: func(ctx *cli.Context) error {
+		name := ctx.Args().First()
+		if name == "" {
+			return errors.New("archive import: need an archive file")
+		}
+
+		var in io.Reader = os.Stdin
+		if name != "-" {
+			f, err := os.Open(name)
+			if err != nil {
+				return fmt.Errorf("archive import: failed to open archive: %w", err)
+			}
+			defer f.Close()
+			in = f
+		}
+
+		client, err := newClient(ctx)
+		if err != nil {
+			return err
+		}
+
+		src, snk, err := client.Duplex(longctx, muxrpc.TypeBinary, muxrpc.Method{"archive", "import"})
+		if err != nil {
+			return fmt.Errorf("archive import: duplex call failed: %w", err)
+		}
+
+		// the bot replies once it read the end of the archive and closes the call afterwards
+		w := muxrpc.NewSinkWriter(snk)
+		if _, err := io.Copy(w, in); err != nil {
+			return fmt.Errorf("archive import: failed to send the archive: %w", err)
+		}
+
+		if !src.Next(longctx) {
+			return fmt.Errorf("archive import: no reply (%v)", src.Err())
+		}
+		data, err := src.Bytes()
+		if err != nil {
+			return fmt.Errorf("archive import: failed to read the reply: %w", err)
+		}
+		var stats ssb.ArchiveStats
+		if err := json.Unmarshal(data, &stats); err != nil {
+			return fmt.Errorf("archive import: invalid reply: %w", err)
+		}
+
+		fmt.Printf("imported %d messages and %d blobs, %d were already stored, %d were invalid\n",
+			stats.Messages, stats.Blobs, stats.Known, stats.Invalid)
+		return nil
+	},
+}
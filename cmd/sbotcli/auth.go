@@ -0,0 +1,130 @@
+// SPDX-FileCopyrightText: 2021 The Go-SSB Authors
+//
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/ssbc/go-muxrpc/v2"
+	"github.com/urfave/cli/v2"
+
+	refs "github.com/ssbc/go-ssb-refs"
+	"github.com/ssbc/go-ssb/plugins/clientauth"
+)
+
+var authCmd = &cli.Command{
+	Name:  "auth",
+	Usage: "Manage which other client keypairs may use the server and with which capabilities",
+	Subcommands: []*cli.Command{
+		authGrantCmd,
+		authRevokeCmd,
+		authListCmd,
+	},
+}
+
+var authGrantCmd = &cli.Command{
+	Name:      "grant",
+	Usage:     "Allow a client keypair to make calls within the given capabilities",
+	ArgsUsage: "<@...ed25519> <capability>...",
+	Description: `Allow a client keypair to make calls within the given capabilities.
+An existing grant for the same client is replaced.
+
+Supported capabilities:
+
+* read (read-only streams and lookups)
+* private (read messages decrypted by the server)
+* publish (publish any type of message)
+* publish:<type> (only publish messages of that type)
+* call:<method> (one additional method)
+
+Example:
+
+    sbotcli auth grant --note "web frontend" @HEqy940T6uB+T+d9Jaa58aNfRzLx9eRWqkZljBmnkmk=.ed25519 read publish:post call:blobs.add`,
+	Flags: []cli.Flag{
+		&cli.StringFlag{Name: "note", Usage: "Remember what this client is used for"},
+	},
+	Action: func(ctx *cli.Context) error {
+		if ctx.NArg() < 2 {
+			return errors.New("auth.grant: needs a client and at least one capability")
+		}
+
+		clientRef, err := refs.ParseFeedRef(ctx.Args().Get(0))
+		if err != nil {
+			return err
+		}
+
+		var arg clientauth.GrantArgs
+		arg.Client = clientRef
+		arg.Capabilities = ctx.Args().Slice()[1:]
+		arg.Note = ctx.String("note")
+
+		client, err := newClient(ctx)
+		if err != nil {
+			return err
+		}
+
+		var g clientauth.Grant
+		err = client.Async(longctx, &g, muxrpc.TypeJSON, muxrpc.Method{"auth", "grant"}, arg)
+		if err != nil {
+			return fmt.Errorf("auth.grant: async call failed: %w", err)
+		}
+
+		return json.NewEncoder(os.Stdout).Encode(g)
+	},
+}
+
+var authRevokeCmd = &cli.Command{
+	Name:      "revoke",
+	Usage:     "Remove all capabilities of a client keypair",
+	ArgsUsage: "<@...ed25519>",
+	Action: func(ctx *cli.Context) error {
+		who := ctx.Args().Get(0)
+		if who == "" {
+			return errors.New("auth.revoke: needs a client as param 1")
+		}
+
+		clientRef, err := refs.ParseFeedRef(who)
+		if err != nil {
+			return err
+		}
+
+		client, err := newClient(ctx)
+		if err != nil {
+			return err
+		}
+
+		var revoked bool
+		err = client.Async(longctx, &revoked, muxrpc.TypeJSON, muxrpc.Method{"auth", "revoke"}, clientauth.RevokeArgs{Client: clientRef})
+		if err != nil {
+			return fmt.Errorf("auth.revoke: async call failed: %w", err)
+		}
+
+		log.Log("event", "auth.revoke", "client", clientRef.String(), "revoked", revoked)
+		return nil
+	},
+}
+
+var authListCmd = &cli.Command{
+	Name:  "list",
+	Usage: "List all client keypairs and their capabilities",
+	Action: func(ctx *cli.Context) error {
+		client, err := newClient(ctx)
+		if err != nil {
+			return err
+		}
+
+		src, err := client.Source(longctx, muxrpc.TypeJSON, muxrpc.Method{"auth", "list"})
+		if err != nil {
+			return err
+		}
+
+		err = jsonDrain(os.Stdout, src)
+		log.Log("done", err)
+		return err
+	},
+}
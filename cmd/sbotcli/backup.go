@@ -0,0 +1,106 @@
+// SPDX-FileCopyrightText: 2021 The Go-SSB Authors
+//
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+
+	"github.com/ssbc/go-muxrpc/v2"
+	"github.com/urfave/cli/v2"
+
+	"github.com/ssbc/go-ssb"
+	"github.com/ssbc/go-ssb/internal/passphrase"
+	"github.com/ssbc/go-ssb/repo"
+	"github.com/ssbc/go-ssb/sbot"
+)
+
+var backupCmd = &cli.Command{
+	Name:      "backup",
+	Usage:     "Take a snapshot of the repo of the running bot, which restore turns into a new repo",
+	ArgsUsage: "<snapshot folder, which must not exist yet or be empty>",
+	Action: func(ctx *cli.Context) error {
+		dir := ctx.Args().First()
+		if dir == "" {
+			return errors.New("backup: need a folder for the snapshot")
+		}
+		// the bot resolves the folder, not us
+		dir, err := filepath.Abs(dir)
+		if err != nil {
+			return fmt.Errorf("backup: %w", err)
+		}
+
+		client, err := newClient(ctx)
+		if err != nil {
+			return err
+		}
+
+		var info ssb.SnapshotInfo
+		err = client.Async(longctx, &info, muxrpc.TypeJSON, muxrpc.Method{"snapshot"}, dir)
+		if err != nil {
+			return fmt.Errorf("backup: async call failed: %w", err)
+		}
+
+		fmt.Printf("snapshot of %s in %s: %d entries of the receive log (%s) and %d blobs\n",
+			info.Feed.String(), dir, info.Seq+1, info.LogFormat, info.Blobs)
+		return nil
+	},
+}
+
+var restoreCmd = &cli.Command{
+	Name:      "restore",
+	Usage:     "Create a new repo from a snapshot and check it, without a running bot",
+	ArgsUsage: "<snapshot folder>",
+	Flags: []cli.Flag{
+		&cli.StringFlag{Name: "repo", Usage: "the repo to create, must not exist yet or be empty (default ~/" + DEFAULT_GO_SSB_DIR + ")"},
+		&cli.BoolFlag{Name: "verify", Usage: "also verify the signatures and hash chains of all feeds"},
+		&cli.BoolFlag{Name: "encrypt", Usage: "encrypt the new repo, the snapshot of an encrypted repo always is"},
+		&cli.StringFlag{Name: "keyfile", Usage: "the keyfile of an encrypted snapshot or the new repo, otherwise $" + repoPassphraseEnv + " or a prompt"},
+	},
+	Action: func(ctx *cli.Context) error {
+		dir := ctx.Args().First()
+		if dir == "" {
+			return errors.New("restore: need the folder of the snapshot")
+		}
+
+		repoPath, err := localRepoPath(ctx)
+		if err != nil {
+			return err
+		}
+
+		mode := sbot.FSCKModeSequences
+		if ctx.Bool("verify") {
+			mode = sbot.FSCKModeVerify
+		}
+
+		opts := []sbot.Option{sbot.WithInfo(log)}
+		encrypted, err := repo.IsEncrypted(repo.New(dir))
+		if err != nil {
+			return err
+		}
+		var secret []byte
+		if encrypted {
+			secret, err = passphrase.Read(ctx.String("keyfile"), repoPassphraseEnv, "passphrase of the snapshot: ")
+		} else if ctx.Bool("encrypt") {
+			secret, err = passphrase.ReadNew(ctx.String("keyfile"), repoPassphraseEnv, "passphrase for the new repo: ")
+		}
+		if err != nil {
+			return err
+		}
+		if secret != nil {
+			opts = append(opts, sbot.WithRepoSecret(secret))
+		}
+
+		info, err := sbot.RestoreSnapshot(dir, repoPath, mode, opts...)
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("restored the repo of %s from %s into %s: %d entries of the receive log and %d blobs\n",
+			info.Feed.String(), info.Created.Format("2006-01-02 15:04:05"), repoPath, info.Seq+1, info.Blobs)
+		return nil
+	},
+}
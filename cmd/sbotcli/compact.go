@@ -0,0 +1,35 @@
+// SPDX-FileCopyrightText: 2021 The Go-SSB Authors
+//
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/ssbc/go-muxrpc/v2"
+	"github.com/urfave/cli/v2"
+
+	"github.com/ssbc/go-ssb"
+)
+
+var compactCmd = &cli.Command{
+	Name:  "compact",
+	Usage: "Copy the receive log without the nulled messages, the copy is used after the bot restarts",
+	Action: func(ctx *cli.Context) error {
+		client, err := newClient(ctx)
+		if err != nil {
+			return err
+		}
+
+		var stats ssb.CompactionStats
+		err = client.Async(longctx, &stats, muxrpc.TypeJSON, muxrpc.Method{"compact"})
+		if err != nil {
+			return fmt.Errorf("compact: async call failed: %w", err)
+		}
+
+		fmt.Printf("kept %d messages and left out %d nulled ones, about %d bytes\n", stats.Kept, stats.Removed, stats.Reclaimed)
+		fmt.Println("restart the bot to finish the compaction")
+		return nil
+	},
+}
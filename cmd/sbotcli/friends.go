@@ -5,6 +5,7 @@
 package main
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"os"
@@ -23,6 +24,7 @@ var friendsCmd = &cli.Command{
 		friendsIsFollowingCmd,
 		friendsBlocksCmd,
 		friendsHopsCmd,
+		friendsExplainCmd,
 	},
 }
 
@@ -158,3 +160,44 @@ Example:
 		return err
 	},
 }
+
+var friendsExplainCmd = &cli.Command{
+	Name:      "explain",
+	Usage:     "Explain why the bot replicates a feed or not",
+	ArgsUsage: "<@...ed25519>",
+	Description: `Explain why the bot replicates a feed or not.
+
+The output lists the distance to each trust root of the replication policy,
+with a path through the social graph, and the blocks that count against the feed.
+Without an argument the bots own feed is explained.
+
+Example:
+
+    sbotcli friends explain @fGWzOR/FXU3Acbn4P65CpMewJIynFyqocvfLAyJdDno=.ed25519`,
+
+	Action: func(ctx *cli.Context) error {
+		var arg friends.ExplainArgs
+
+		if who := ctx.Args().Get(0); who != "" {
+			ref, err := refs.ParseFeedRef(who)
+			if err != nil {
+				return err
+			}
+			arg.Feed = &ref
+		}
+
+		client, err := newClient(ctx)
+		if err != nil {
+			return err
+		}
+
+		var expl json.RawMessage
+		err = client.Async(longctx, &expl, muxrpc.TypeJSON, muxrpc.Method{"friends", "explain"}, arg)
+		if err != nil {
+			return fmt.Errorf("friends.explain: call failed: %w", err)
+		}
+
+		_, err = fmt.Fprintln(os.Stdout, string(expl))
+		return err
+	},
+}
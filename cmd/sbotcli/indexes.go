@@ -0,0 +1,108 @@
+// SPDX-FileCopyrightText: 2021 The Go-SSB Authors
+//
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/ssbc/go-muxrpc/v2"
+	"github.com/urfave/cli/v2"
+
+	"github.com/ssbc/go-ssb/plugins/indexes"
+)
+
+var indexesCmd = &cli.Command{
+	Name:  "indexes",
+	Usage: "Show how far the indexes are and control them (status, pause, resume, rebuild)",
+	Subcommands: []*cli.Command{
+		indexesStatusCmd,
+		indexesPauseCmd,
+		indexesResumeCmd,
+		indexesRebuildCmd,
+	},
+}
+
+var indexesStatusCmd = &cli.Command{
+	Name:  "status",
+	Usage: "List every index with the receive log sequence it processed, what it has left and how fast it goes",
+	Action: func(ctx *cli.Context) error {
+		client, err := newClient(ctx)
+		if err != nil {
+			return err
+		}
+
+		var status indexes.Status
+		err = client.Async(longctx, &status, muxrpc.TypeJSON, muxrpc.Method{"indexes", "status"})
+		if err != nil {
+			return fmt.Errorf("indexes.status: async call failed: %w", err)
+		}
+
+		tw := tabwriter.NewWriter(os.Stdout, 2, 4, 2, ' ', 0)
+		fmt.Fprintf(tw, "receive log\t%d\n\n", status.RxSeq)
+		fmt.Fprintln(tw, "NAME\tSEQ\tPENDING\tMSG/S\tREBUILD\tSTATE")
+		for _, idx := range status.Indexes {
+			rebuild := "offline"
+			if idx.Rebuildable {
+				rebuild = "online"
+			}
+			fmt.Fprintf(tw, "%s\t%d\t%d\t%.1f\t%s\t%s\n", idx.Name, idx.Seq, idx.Pending, idx.Rate, rebuild, idx.State)
+		}
+		return tw.Flush()
+	},
+}
+
+var indexesPauseCmd = &cli.Command{
+	Name:      "pause",
+	Usage:     "Stop updating the named indexes, or all of them",
+	ArgsUsage: "[name...]",
+	Action: func(ctx *cli.Context) error {
+		return callIndexes(ctx, "pause", ctx.Args().Slice())
+	},
+}
+
+var indexesResumeCmd = &cli.Command{
+	Name:      "resume",
+	Usage:     "Continue updating the named indexes, or all of them",
+	ArgsUsage: "[name...]",
+	Action: func(ctx *cli.Context) error {
+		return callIndexes(ctx, "resume", ctx.Args().Slice())
+	},
+}
+
+var indexesRebuildCmd = &cli.Command{
+	Name:      "rebuild",
+	Usage:     "Process all the messages of one index again while the bot keeps running",
+	ArgsUsage: "<name>",
+	Action: func(ctx *cli.Context) error {
+		if ctx.Args().Len() != 1 {
+			return errors.New("indexes.rebuild: needs the name of one index")
+		}
+		return callIndexes(ctx, "rebuild", ctx.Args().Slice())
+	},
+}
+
+func callIndexes(ctx *cli.Context, call string, names []string) error {
+	client, err := newClient(ctx)
+	if err != nil {
+		return err
+	}
+
+	args := make([]interface{}, len(names))
+	for i, n := range names {
+		args[i] = n
+	}
+
+	var ok bool
+	err = client.Async(longctx, &ok, muxrpc.TypeJSON, muxrpc.Method{"indexes", call}, args...)
+	if err != nil {
+		return fmt.Errorf("indexes.%s: async call failed: %w", call, err)
+	}
+
+	log.Log("event", "indexes."+call, "indexes", fmt.Sprint(names))
+	return nil
+}
@@ -27,13 +27,13 @@ import (
 	"github.com/ssbc/go-secretstream"
 	"github.com/ssbc/go-ssb/invite"
 	"github.com/ssbc/go-ssb/query"
+	"github.com/ssbc/go-ssb/network"
 	cli "github.com/urfave/cli/v2"
 	kitlog "go.mindeco.de/log"
 	"go.mindeco.de/log/level"
 	"go.mindeco.de/log/term"
 	"golang.org/x/crypto/ed25519"
 
-	"github.com/ssbc/go-ssb"
 	refs "github.com/ssbc/go-ssb-refs"
 	ssbClient "github.com/ssbc/go-ssb/client"
 	"github.com/ssbc/go-ssb/plugins/legacyinvites"
@@ -81,9 +81,10 @@ Please note, global options must be placed before sub-commands, e.g.
 	Flags: []cli.Flag{
 		&configFileFlag,
 		&cli.StringFlag{Name: "shscap", Value: "1KHLiKZvAvjbY1ziZEHMXawbCEIM6qwjCDm3VYRan/s=", Usage: "SHS key"},
-		&cli.StringFlag{Name: "addr", Value: "localhost:8008", Usage: "TCP address of the sbot to connect to (or listen on)"},
+		&cli.StringFlag{Name: "addr", Value: "localhost:8008", Usage: "TCP address of the sbot to connect to (or listen on), or a ws:// or wss:// URL of its websocket endpoint"},
 		&cli.StringFlag{Name: "remotekey", Aliases: []string{"remoteKey"}, Value: "", Usage: "The remote pubkey you are connecting to (by default the local key)"},
 		&keyFileFlag,
+		&cli.StringFlag{Name: "key-keyfile", Usage: "keyfile of an encrypted secret key file, otherwise $" + secretPassphraseEnv + " or a prompt"},
 		&unixSockFlag,
 		&cli.BoolFlag{Name: "verbose,vv", Usage: "Print MUXRPC packets"},
 
@@ -93,6 +94,7 @@ Please note, global options must be placed before sub-commands, e.g.
 	Before: initClient,
 	Commands: []*cli.Command{
 		aliasCmd,
+		authCmd,
 		blobsCmd,
 		blockCmd,
 		friendsCmd,
@@ -104,12 +106,19 @@ Please note, global options must be placed before sub-commands, e.g.
 		typeStreamCmd,
 		historyStreamCmd,
 		replicateUptoCmd,
+		replicationProgressCmd,
 		repliesStreamCmd,
 		callCmd,
 		sourceCmd,
 		connectCmd,
 		publishCmd,
 		groupsCmd,
+		indexesCmd,
+		compactCmd,
+		archiveCmd,
+		backupCmd,
+		restoreCmd,
+		repoKeyCmd,
 	},
 }
 
@@ -222,7 +231,7 @@ func newClient(ctx *cli.Context) (*ssbClient.Client, error) {
 }
 
 func newTCPClient(ctx *cli.Context) (*ssbClient.Client, error) {
-	localKey, err := ssb.LoadKeyPair(ctx.String("key"))
+	localKey, err := loadLocalKey(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -239,6 +248,21 @@ func newTCPClient(ctx *cli.Context) (*ssbClient.Client, error) {
 		copy(remotePubKey, rpk)
 	}
 
+	if addr := ctx.String("addr"); strings.HasPrefix(addr, "ws://") || strings.HasPrefix(addr, "wss://") {
+		wsAddr, err := network.ParseAddress(addr + "~shs:" + base64.StdEncoding.EncodeToString(remotePubKey))
+		if err != nil {
+			return nil, fmt.Errorf("init: failed to parse websocket address: %w", err)
+		}
+		client, err := ssbClient.NewWebsocket(localKey, wsAddr,
+			ssbClient.WithSHSAppKey(ctx.String("shscap")),
+			ssbClient.WithContext(longctx))
+		if err != nil {
+			return nil, fmt.Errorf("init: failed to connect to %s: %w", addr, err)
+		}
+		level.Info(log).Log("client", "connected", "method", "websocket")
+		return client, nil
+	}
+
 	plainAddr, err := net.ResolveTCPAddr("tcp", ctx.String("addr"))
 	if err != nil {
 		return nil, fmt.Errorf("int: failed to resolve TCP address: %w", err)
@@ -0,0 +1,94 @@
+// SPDX-FileCopyrightText: 2021 The Go-SSB Authors
+//
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/urfave/cli/v2"
+
+	"github.com/ssbc/go-ssb"
+	"github.com/ssbc/go-ssb/internal/passphrase"
+	"github.com/ssbc/go-ssb/repo"
+)
+
+// repoPassphraseEnv is the environment variable with the passphrase of an encrypted repo, like for go-sbot
+const repoPassphraseEnv = "SSB_REPO_PASSPHRASE"
+
+// secretPassphraseEnv is the environment variable with the passphrase of an encrypted secret key file, like for go-sbot
+const secretPassphraseEnv = "SSB_SECRET_PASSPHRASE"
+
+// loadLocalKey loads --key, which is decrypted with --key-keyfile or a passphrase if it is encrypted
+func loadLocalKey(ctx *cli.Context) (ssb.KeyPair, error) {
+	fname := ctx.String("key")
+	encrypted, err := ssb.IsEncryptedKeyPair(fname)
+	if err != nil {
+		return nil, err
+	}
+
+	var secret []byte
+	if encrypted {
+		secret, err = passphrase.Read(ctx.String("key-keyfile"), secretPassphraseEnv, "passphrase of the secret key file: ")
+		if err != nil {
+			return nil, err
+		}
+	}
+	return ssb.LoadKeyPairWithSecret(fname, secret)
+}
+
+// localRepoPath returns the --repo of the commands that work on a repo without a running bot
+func localRepoPath(ctx *cli.Context) (string, error) {
+	repoPath := ctx.String("repo")
+	if repoPath == "" {
+		homedir, err := os.UserHomeDir()
+		if err != nil {
+			return "", fmt.Errorf("failed to get home directory (%w)", err)
+		}
+		repoPath = filepath.Join(homedir, DEFAULT_GO_SSB_DIR)
+	}
+	return repoPath, nil
+}
+
+var repoKeyCmd = &cli.Command{
+	Name:  "repokey",
+	Usage: "Manage the secret of an encrypted repo, without a running bot",
+	Subcommands: []*cli.Command{
+		repoKeyRotateCmd,
+	},
+}
+
+var repoKeyRotateCmd = &cli.Command{
+	Name:  "rotate",
+	Usage: "Change the passphrase or keyfile the data key of an encrypted repo is unlocked with",
+	Flags: []cli.Flag{
+		&cli.StringFlag{Name: "repo", Usage: "the encrypted repo (default ~/" + DEFAULT_GO_SSB_DIR + ")"},
+		&cli.StringFlag{Name: "keyfile", Usage: "the current keyfile, otherwise $" + repoPassphraseEnv + " or a prompt"},
+		&cli.StringFlag{Name: "new-keyfile", Usage: "the new keyfile, otherwise a prompt for a new passphrase"},
+	},
+	Action: func(ctx *cli.Context) error {
+		repoPath, err := localRepoPath(ctx)
+		if err != nil {
+			return err
+		}
+		r := repo.New(repoPath)
+
+		oldSecret, err := passphrase.Read(ctx.String("keyfile"), repoPassphraseEnv, "current passphrase of the repo: ")
+		if err != nil {
+			return err
+		}
+		newSecret, err := passphrase.ReadNew(ctx.String("new-keyfile"), "", "new passphrase of the repo: ")
+		if err != nil {
+			return err
+		}
+
+		if err := repo.RotateEncryption(r, oldSecret, newSecret); err != nil {
+			return err
+		}
+		fmt.Printf("changed the secret of the repo in %s\n", repoPath)
+		return nil
+	},
+}
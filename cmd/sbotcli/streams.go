@@ -10,12 +10,14 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"time"
 
 	"github.com/ssbc/go-muxrpc/v2"
 	cli "github.com/urfave/cli/v2"
 
 	refs "github.com/ssbc/go-ssb-refs"
 	"github.com/ssbc/go-ssb/message"
+	"github.com/ssbc/go-ssb/plugins/replicate"
 )
 
 var streamFlags = []cli.Flag{
@@ -225,6 +227,33 @@ var replicateUptoCmd = &cli.Command{
 	},
 }
 
+var replicationProgressCmd = &cli.Command{
+	Name:  "progress",
+	Usage: "Show how far behind the server is with replicating the feeds it wants",
+	Flags: []cli.Flag{
+		&cli.BoolFlag{Name: "live", Usage: "keep printing updates"},
+		&cli.DurationFlag{Name: "interval", Value: time.Second, Usage: "time between updates"},
+	},
+	Action: func(ctx *cli.Context) error {
+		client, err := newClient(ctx)
+		if err != nil {
+			return err
+		}
+		var args replicate.ProgressArgs
+		args.Live = ctx.Bool("live")
+		args.Interval = int(ctx.Duration("interval") / time.Millisecond)
+		src, err := client.Source(longctx, muxrpc.TypeJSON, muxrpc.Method{"replicate", "progress"}, args)
+		if err != nil {
+			return fmt.Errorf("source stream call failed: %w", err)
+		}
+		err = jsonDrain(os.Stdout, src)
+		if err != nil {
+			err = fmt.Errorf("message pump failed: %w", err)
+		}
+		return err
+	},
+}
+
 func jsonDrain(w io.Writer, r *muxrpc.ByteSource) error {
 
 	var buf = &bytes.Buffer{}
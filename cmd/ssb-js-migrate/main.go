@@ -0,0 +1,117 @@
+// SPDX-FileCopyrightText: 2021 The Go-SSB Authors
+//
+// SPDX-License-Identifier: MIT
+
+// ssb-js-migrate moves messages between a go-ssb repo and the database of the JS implementation.
+//
+// import reads the flume/log.offset of ssb-db or the db2/log.bipf of ssb-db2, verifies the messages
+// and appends the ones the repo doesn't have yet. The indexes are updated before it exits.
+//
+// export writes the classic messages of the repo into a new log in a ~/.ssb directory,
+// which ssb-db or ssb-db2 index when the JS sbot starts.
+//
+// The go-sbot of the repo must not be running.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/user"
+	"path/filepath"
+	"time"
+
+	"github.com/ssbc/go-ssb/repo"
+	"github.com/ssbc/go-ssb/sbot"
+)
+
+func check(err error, msg string, args ...interface{}) {
+	if err != nil {
+		fmt.Fprintf(os.Stderr, msg+": %s\n", append(args, err)...)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage:\n")
+	fmt.Fprintf(os.Stderr, "  %s [options] import <~/.ssb, flume/log.offset or db2/log.bipf>\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "  %s [options] export <~/.ssb>\n", os.Args[0])
+	flag.PrintDefaults()
+	os.Exit(1)
+}
+
+func main() {
+	u, err := user.Current()
+	check(err, "failed to get the current user")
+
+	var repoDir, format string
+	flag.StringVar(&repoDir, "repo", filepath.Join(u.HomeDir, ".ssb-go"), "the go-ssb repo")
+	flag.StringVar(&format, "format", string(repo.JSLogDB2), "the format of the exported log: db2 (ssb-db2) or flume (ssb-db)")
+	flag.Usage = usage
+	flag.Parse()
+
+	if flag.NArg() != 2 {
+		usage()
+	}
+	cmd, path := flag.Arg(0), flag.Arg(1)
+
+	var jsLog io.Closer
+	switch cmd {
+	case "import":
+	case "export":
+		if f := repo.JSLogFormat(format); f != repo.JSLogDB2 && f != repo.JSLogFlume {
+			check(fmt.Errorf("unknown format %q", format), "invalid -format")
+		}
+	default:
+		usage()
+	}
+
+	bot, err := sbot.New(
+		sbot.WithRepoPath(repoDir),
+		sbot.DisableNetworkNode(),
+	)
+	check(err, "failed to open the repo %s", repoDir)
+
+	start := time.Now()
+	switch cmd {
+	case "import":
+		src, f, err := repo.OpenJSLog(path)
+		check(err, "failed to open the JS log")
+		log.Printf("importing the %s log from %s", f, path)
+
+		stats, err := bot.ImportLog(src)
+		check(err, "import failed")
+		log.Printf("imported %d messages, %d were already stored, %d were invalid and %d skipped (took %v)",
+			stats.Imported, stats.Known, stats.Invalid, stats.Skipped, time.Since(start))
+
+		if c, ok := src.(io.Closer); ok {
+			jsLog = c
+		}
+
+		start = time.Now()
+		bot.WaitUntilIndexesAreSynced()
+		log.Println("indexes updated", time.Since(start))
+
+	case "export":
+		dst, err := repo.CreateJSLog(path, repo.JSLogFormat(format))
+		check(err, "failed to create the JS log")
+
+		stats, err := bot.ExportLog(dst)
+		check(err, "export failed")
+		log.Printf("exported %d messages, %d can't be read by the JS implementation (took %v)",
+			stats.Exported, stats.Skipped, time.Since(start))
+
+		if c, ok := dst.(io.Closer); ok {
+			jsLog = c
+		}
+	}
+
+	if jsLog != nil {
+		check(jsLog.Close(), "failed to close the JS log")
+	}
+
+	bot.Shutdown()
+	check(bot.Close(), "failed to close the repo")
+}
@@ -5,13 +5,18 @@
 package main
 
 import (
+	"encoding/json"
 	"flag"
 	"fmt"
+	"io/ioutil"
 	"os"
 	"os/user"
 	"path/filepath"
+	"strings"
 
+	"github.com/ssbc/go-ssb"
 	refs "github.com/ssbc/go-ssb-refs"
+	"github.com/ssbc/go-ssb/internal/passphrase"
 	"github.com/ssbc/go-ssb/repo"
 )
 
@@ -29,10 +34,18 @@ func fail(err error) {
 }
 
 var (
-	repoDir  string
-	feedAlgo = refs.RefAlgoFeedSSB1
+	repoDir   string
+	feedAlgo  = refs.RefAlgoFeedSSB1
+	formatSet bool
+
+	encrypt    bool
+	keyfile    string
+	newKeyfile string
 )
 
+// secretPassphraseEnv is the environment variable with the passphrase of an encrypted secret file, like for go-sbot
+const secretPassphraseEnv = "SSB_SECRET_PASSPHRASE"
+
 func init() {
 	u, err := user.Current()
 	check(err)
@@ -46,9 +59,14 @@ func init() {
 			return err
 		}
 
+		feedAlgo, formatSet = candidate, true
 		return nil
 	})
 
+	flag.BoolVar(&encrypt, "encrypt", false, "encrypt the new secret file with -keyfile, $"+secretPassphraseEnv+" or a passphrase that is asked for")
+	flag.StringVar(&keyfile, "keyfile", "", "keyfile of the secret file, otherwise the passphrase is read from $"+secretPassphraseEnv+" or asked for")
+	flag.StringVar(&newKeyfile, "new-keyfile", "", "keyfile reencrypt uses, otherwise a new passphrase is asked for")
+
 	flag.Parse()
 
 }
@@ -57,24 +75,105 @@ func main() {
 
 	args := flag.Args()
 
-	if len(args) != 1 {
-		fmt.Fprintf(os.Stderr, "usage: %s (-format=algo, -repo=location) <name>", os.Args[0])
+	r := repo.New(repoDir)
+
+	switch {
+	case len(args) == 1:
+		var (
+			kp  ssb.KeyPair
+			err error
+		)
+		if encrypt {
+			secret, err := passphrase.ReadNew(keyfile, secretPassphraseEnv, "passphrase for the secret file: ")
+			check(err)
+			kp, err = repo.NewEncryptedKeyPair(r, args[0], feedAlgo, secret)
+			check(err)
+		} else {
+			kp, err = repo.NewKeyPair(r, args[0], feedAlgo)
+			check(err)
+		}
+		fmt.Println(kp.ID().String())
+
+	case len(args) == 2 && args[0] == "reencrypt":
+		secPath := repo.KeyPairPath(r, args[1])
+		oldSecret := readSecret(secPath)
+		newSecret, err := passphrase.ReadNew(newKeyfile, "", "new passphrase for the secret file: ")
+		check(err)
+		check(ssb.ReencryptKeyPair(secPath, oldSecret, newSecret))
+		fmt.Fprintf(os.Stderr, "encrypted %s\n", secPath)
+
+	case len(args) == 2 && args[0] == "export":
+		secPath := repo.KeyPairPath(r, args[1])
+		kp, err := ssb.LoadKeyPairWithSecret(secPath, readSecret(secPath))
+		check(err)
+		if enc, ok := kp.(json.Marshaler); ok {
+			data, err := enc.MarshalJSON()
+			check(err)
+			fmt.Println(string(data))
+		} else {
+			check(ssb.EncodeKeyPairAsJSON(kp, os.Stdout))
+		}
+
+	case len(args) == 2 && args[0] == "mnemonic":
+		secPath := repo.KeyPairPath(r, args[1])
+		kp, err := ssb.LoadKeyPairWithSecret(secPath, readSecret(secPath))
+		check(err)
+		words, err := ssb.KeyPairToMnemonic(kp)
+		check(err)
+		fmt.Println(words)
+
+	case len(args) == 2 && args[0] == "recover":
+		fmt.Fprintln(os.Stderr, "enter the words, end with ctrl-d:")
+		words, err := ioutil.ReadAll(os.Stdin)
+		check(err)
+
+		// the 48 words of a go-ssb metafeed seed are recovered as one
+		algo := feedAlgo
+		if !formatSet && len(strings.Fields(string(words))) == 48 {
+			algo = refs.RefAlgoFeedBendyButt
+		}
+		kp, err := ssb.KeyPairFromMnemonic(string(words), algo)
+		check(err)
+
+		secPath := repo.KeyPairPath(r, args[1])
+		check(os.MkdirAll(filepath.Dir(secPath), 0700))
+		if encrypt {
+			secret, err := passphrase.ReadNew(keyfile, secretPassphraseEnv, "passphrase for the secret file: ")
+			check(err)
+			check(ssb.SaveEncryptedKeyPair(kp, secPath, secret))
+		} else {
+			check(ssb.SaveKeyPair(kp, secPath))
+		}
+		fmt.Println(kp.ID().String())
+
+	default:
+		fmt.Fprintf(os.Stderr, "usage: %s (-format=algo, -repo=location, -encrypt) <name>\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s (-repo=location, -keyfile, -new-keyfile) reencrypt <name>\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s (-repo=location, -keyfile) export <name>\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s (-repo=location, -keyfile) mnemonic <name>\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s (-format=algo, -repo=location, -encrypt) recover <name>\n", os.Args[0])
+		fmt.Fprintln(os.Stderr, "the name - is the default secret of the repo")
 		flag.PrintDefaults()
 		os.Exit(1)
 	}
+}
 
-	r := repo.New(repoDir)
-
-	kp, err := repo.NewKeyPair(r, args[0], feedAlgo)
+// readSecret returns the secret of the secret file at secPath, or nil if it isn't encrypted
+func readSecret(secPath string) []byte {
+	encrypted, err := ssb.IsEncryptedKeyPair(secPath)
 	check(err)
-
-	fmt.Println(kp.ID().String())
+	if !encrypted {
+		return nil
+	}
+	secret, err := passphrase.Read(keyfile, secretPassphraseEnv, "passphrase of the secret file: ")
+	check(err)
+	return secret
 }
 
 func isValidFormat(f refs.RefAlgo) error {
 	//  enums would be nice
-	if f != refs.RefAlgoFeedSSB1 && f != refs.RefAlgoFeedGabby {
-		return fmt.Errorf("invalid feed refrence algo. %s or %s", refs.RefAlgoFeedSSB1, refs.RefAlgoFeedGabby)
+	if f != refs.RefAlgoFeedSSB1 && f != refs.RefAlgoFeedGabby && f != refs.RefAlgoFeedBendyButt {
+		return fmt.Errorf("invalid feed refrence algo. %s, %s or %s", refs.RefAlgoFeedSSB1, refs.RefAlgoFeedGabby, refs.RefAlgoFeedBendyButt)
 	}
 	return nil
 }
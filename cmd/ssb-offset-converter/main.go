@@ -5,26 +5,23 @@
 package main
 
 import (
-	"context"
 	"flag"
 	"fmt"
-	"io"
 	"os"
 	"strings"
 
-	"github.com/ssbc/go-luigi"
 	"github.com/ssbc/go-ssb/message/multimsg"
+	"github.com/ssbc/go-ssb/repo"
 	"github.com/ssbc/margaret"
 	"github.com/ssbc/margaret/legacyflumeoffset"
-	"github.com/ssbc/margaret/offset2"
 )
 
 func main() {
 
 	var iformat, oformat string = "offset2", "offset2"
 
-	flag.Func("if", "what format to use for the input", validateLogFormat(&iformat))
-	flag.Func("of", "what format to use for the output", validateLogFormat(&oformat))
+	flag.Func("if", "what format to use for the input (offset2, lfo, bipf or segmented)", validateLogFormat(&iformat))
+	flag.Func("of", "what format to use for the output (offset2, bipf or segmented)", validateLogFormat(&oformat))
 
 	var dryRun bool
 	flag.BoolVar(&dryRun, "dry", false, "only output what it would do")
@@ -52,78 +49,58 @@ func main() {
 		return
 	}
 
-	var (
-		err           error
-		input, output margaret.Log
-	)
+	if oformat == "lfo" {
+		fmt.Fprintln(os.Stderr, "lfo logs can only be read, not written")
+		os.Exit(1)
+	}
 
-	input, err = openLogWithFormat(logPaths[0], iformat)
+	input, err := openLogWithFormat(logPaths[0], iformat)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "failed to open input log %s: %s\n", logPaths[0], err)
 		os.Exit(1)
 	}
 
-	output, err = openLogWithFormat(logPaths[1], oformat)
+	output, err := repo.OpenLogAt(logPaths[1], repo.LogFormat(oformat))
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "failed to open output log %s: %s\n", logPaths[1], err)
 		os.Exit(1)
 	}
 
-	src, err := input.Query(margaret.Limit(limit))
+	stats, err := repo.ConvertLog(input, output, limit)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "failed to create query on input log %s: %s\n", logPaths[0], err)
+		fmt.Fprintf(os.Stderr, "failed to convert %s: %s\n", logPaths[0], err)
 		os.Exit(1)
 	}
 
-	ctx := context.Background()
-	for {
-		v, err := src.Next(ctx)
-		if err != nil {
-			if luigi.IsEOS(err) {
-				break
-			}
-			fmt.Fprintf(os.Stderr, "failed to get log entry %s: %s\n", logPaths[0], err)
-			os.Exit(1)
-		}
-
-		_, err = output.Append(v)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "failed to write entry to output log %s: %s\n", logPaths[1], err)
-			os.Exit(1)
-		}
-	}
-
+	fmt.Fprintf(os.Stderr, "copied %d messages, %d entries were nulled and %d messages can't be stored as %s.\n", stats.Copied, stats.Nulled, stats.Dropped, oformat)
+	// the sequences didn't change, the output can replace the log directory of a repo without rebuilding its indexes
 	fmt.Fprintln(os.Stderr, "all done. closing output log.")
 
-	if c, ok := output.(io.Closer); ok {
-		if err = c.Close(); err != nil {
-			fmt.Fprintf(os.Stderr, "failed to close output log %s: %s\n", logPaths[1], err)
-		}
+	if err = output.Close(); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to close output log %s: %s\n", logPaths[1], err)
 	}
 }
 
 func validateLogFormat(flag *string) func(string) error {
 	return func(input string) error {
 		switch strings.ToLower(input) {
-		case "offset2":
-			*flag = "offset2"
-			return nil
 		case "lfo", "legacy", "dominic", "flumelog":
 			*flag = "lfo"
 			return nil
 		default:
-			return fmt.Errorf("unknown log format: %s", input)
+			format, err := repo.ParseLogFormat(input)
+			if err != nil {
+				return err
+			}
+			*flag = string(format)
+			return nil
 		}
 	}
 }
 
 func openLogWithFormat(path string, format string) (margaret.Log, error) {
-	switch format {
-	case "offset2":
-		return offset2.Open(path, multimsg.MargaretCodec{})
-	case "lfo":
-		return legacyflumeoffset.Open(path, FlumeToMultiMsgCodec{})
-	default:
-		return nil, fmt.Errorf("unknown log format: %s", format)
+	if format == "lfo" {
+		return legacyflumeoffset.Open(path, multimsg.FlumeCodec{})
 	}
+	return repo.OpenLogAt(path, repo.LogFormat(format))
 }
@@ -45,6 +45,7 @@ require (
 	go.mindeco.de v1.12.0
 	golang.org/x/crypto v0.4.0
 	golang.org/x/sync v0.1.0
+	golang.org/x/sys v0.3.0
 	golang.org/x/text v0.5.0
 	gonum.org/v1/gonum v0.12.0
 	modernc.org/kv v1.0.5
@@ -89,7 +90,6 @@ require (
 	golang.org/x/exp v0.0.0-20221025133541-111beb427cde // indirect
 	golang.org/x/mod v0.6.0 // indirect
 	golang.org/x/net v0.3.0 // indirect
-	golang.org/x/sys v0.3.0 // indirect
 	golang.org/x/tools v0.2.0 // indirect
 	google.golang.org/protobuf v1.28.1 // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
@@ -16,6 +16,7 @@ import (
 
 type authorizer struct {
 	b       Builder
+	build   func() (*Graph, error)
 	from    refs.FeedRef
 	maxHops int
 	log     log.Logger
@@ -31,7 +32,7 @@ func (nsf ErrNoSuchFrom) Error() string {
 }
 
 func (a *authorizer) Authorize(to refs.FeedRef) error {
-	fg, err := a.b.Build()
+	fg, err := a.build()
 	if err != nil {
 		return fmt.Errorf("graph/Authorize: failed to make friendgraph: %w", err)
 	}
@@ -32,6 +32,12 @@ type Builder interface {
 	// Build a complete graph of all follow/block relations
 	Build() (*Graph, error)
 
+	// SubscribeEdges streams the relations of Build and the changes to them
+	SubscribeEdges(fn func([]EdgeChange)) (func(), error)
+
+	// SubscribeHops streams the hop distances of the feeds around root and the changes to them
+	SubscribeHops(root refs.FeedRef, max int, fn func([]HopsChange)) (func(), error)
+
 	// Follows returns a set of all people ref follows
 	Follows(refs.FeedRef) (*ssb.StrFeedSet, error)
 
@@ -59,19 +65,40 @@ type BadgerBuilder struct {
 	idxSinkMetaFeeds     librarian.SinkIndex
 	idxSinkAnnouncements librarian.SinkIndex
 
-	idxInSync   sync.WaitGroup
+	// contacts that were published privately, one index per source of decrypted messages
+	idxPrivate             map[string]librarian.SeqSetterIndex
+	idxSinkPrivateContacts map[string]librarian.SinkIndex
+
+	// idxPending counts the index updates that are in progress, WaitUntilIndexesAreSynced waits for it to be zero.
+	// unlike a WaitGroup it can be waited on while new updates start.
+	idxPendingMu   sync.Mutex
+	idxPendingZero *sync.Cond
+	idxPending     int
 
 	log log.Logger
 
 	cacheLock   sync.Mutex
 	cachedGraph *Graph
 
+	// cachedGraph plus the private contacts
+	cachedWithPrivate *Graph
+
+	// hop trackers and policy engines are patched together with cachedWithPrivate
+	observers []graphObserver
+
+	// subscribers of the public graph are patched together with cachedGraph
+	publicObservers []graphObserver
+
 	hmacSecret *[32]byte
 }
 
 var (
 	dbKeyPrefix    = []byte("trust-graph")
 	dbKeyPrefixLen = len(dbKeyPrefix)
+
+	// private contacts are stored under dbKeyPrefixPrivate + source name + "/" + from + to.
+	// It must not start with dbKeyPrefix.
+	dbKeyPrefixPrivate = []byte("private-trust/")
 )
 
 // NewBuilder creates a Builder that is backed by a badger database
@@ -82,8 +109,12 @@ func NewBuilder(log log.Logger, db *badger.DB, hmacSecret *[32]byte) *BadgerBuil
 
 		idx: libbadger.NewIndexWithKeyPrefix(db, 0, dbKeyPrefix),
 
+		idxPrivate:             make(map[string]librarian.SeqSetterIndex),
+		idxSinkPrivateContacts: make(map[string]librarian.SinkIndex),
+
 		hmacSecret: hmacSecret,
 	}
+	b.idxPendingZero = sync.NewCond(&b.idxPendingMu)
 
 	// make sure we initialize the waitgroup so we have an opportunity to index
 	b.indexSyncStart()
@@ -97,7 +128,8 @@ func (b *BadgerBuilder) DeleteAuthor(who refs.FeedRef) error {
 	b.cacheLock.Lock()
 	defer b.cacheLock.Unlock()
 	b.cachedGraph = nil
-	return b.kv.Update(func(txn *badger.Txn) error {
+	b.cachedWithPrivate = nil
+	err := b.kv.Update(func(txn *badger.Txn) error {
 		iter := txn.NewIterator(badger.DefaultIteratorOptions)
 		defer iter.Close()
 
@@ -105,27 +137,58 @@ func (b *BadgerBuilder) DeleteAuthor(who refs.FeedRef) error {
 		for iter.Seek(prefix); iter.ValidForPrefix(prefix); iter.Next() {
 			it := iter.Item()
 
-			k := it.Key()
+			k := it.KeyCopy(nil) // the iterator reuses the buffer of Key()
 			if err := txn.Delete(k); err != nil {
 				return fmt.Errorf("DeleteAuthor: failed to drop record %x: %w", k, err)
 			}
 		}
+
+		// the private relations are grouped by source and need to be checked one by one
+		author := []byte(storedrefs.Feed(who))
+		for iter.Seek(dbKeyPrefixPrivate); iter.ValidForPrefix(dbKeyPrefixPrivate); iter.Next() {
+			it := iter.Item()
+
+			k := it.Key()
+			if rel, ok := privateRelation(k); ok && bytes.HasPrefix(rel, author) {
+				if err := txn.Delete(it.KeyCopy(nil)); err != nil {
+					return fmt.Errorf("DeleteAuthor: failed to drop private record %x: %w", k, err)
+				}
+			}
+		}
 		return nil
 	})
+	if err != nil {
+		return err
+	}
+
+	// the observers need a graph to stay current
+	if len(b.publicObservers) > 0 {
+		if _, err := b.build(); err != nil {
+			return fmt.Errorf("DeleteAuthor: failed to rebuild graph: %w", err)
+		}
+	}
+	if len(b.observers) > 0 {
+		if _, err := b.buildWithPrivate(); err != nil {
+			return fmt.Errorf("DeleteAuthor: failed to rebuild graph: %w", err)
+		}
+	}
+	return nil
 }
 
 func (b *BadgerBuilder) Authorizer(from refs.FeedRef, maxHops int) ssb.Authorizer {
 	return &authorizer{
 		b:       b,
+		build:   b.BuildWithPrivate,
 		from:    from,
 		maxHops: maxHops,
 		log:     b.log,
 	}
 }
 
+// Build returns the graph of all follow/block relations.
+// It is only read from disk once and then kept up to date as new contacts are indexed.
 func (b *BadgerBuilder) Build() (*Graph, error) {
 	b.WaitUntilIndexesAreSynced()
-	dg := NewGraph()
 
 	b.cacheLock.Lock()
 	defer b.cacheLock.Unlock()
@@ -134,108 +197,268 @@ func (b *BadgerBuilder) Build() (*Graph, error) {
 		return b.cachedGraph, nil
 	}
 
+	return b.build()
+}
+
+// build reads the public graph from the database and resets the observers of it.
+// The caller needs to hold cacheLock.
+func (b *BadgerBuilder) build() (*Graph, error) {
+	dg := NewGraph()
 	err := b.kv.View(func(txn *badger.Txn) error {
-		iter := txn.NewIterator(badger.DefaultIteratorOptions)
-		defer iter.Close()
+		return loadGraph(dg, txn, dbKeyPrefix)
+	})
 
-		for iter.Seek(dbKeyPrefix); iter.ValidForPrefix(dbKeyPrefix); iter.Next() {
-			it := iter.Item()
-			k := it.Key()
-			if len(k) != 68+dbKeyPrefixLen {
-				continue
-			}
+	b.cachedGraph = dg
 
-			rawFrom := k[dbKeyPrefixLen : 34+dbKeyPrefixLen]
-			rawTo := k[34+dbKeyPrefixLen:]
+	dg.Lock()
+	for _, o := range b.publicObservers {
+		o.reset(dg)
+	}
+	dg.Unlock()
 
-			if bytes.Equal(rawFrom, rawTo) {
-				// contact self?!
-				continue
-			}
+	return dg, err
+}
 
-			var to, from tfk.Feed
-			if err := from.UnmarshalBinary(rawFrom); err != nil {
-				return fmt.Errorf("builder: couldnt idx key value (from): %w", err)
-			}
-			if err := to.UnmarshalBinary(rawTo); err != nil {
-				return fmt.Errorf("builder: couldnt idx key value (to): %w", err)
-			}
+// BuildWithPrivate returns the graph of Build, combined with the contacts that were published privately.
+// Between the same two feeds, the private relation takes precedence over the public one.
+// This graph is for the replication and blocking decisions of this bot and must not be exposed to other peers.
+func (b *BadgerBuilder) BuildWithPrivate() (*Graph, error) {
+	b.WaitUntilIndexesAreSynced()
 
-			bfrom := librarian.Addr(rawFrom)
-			nFrom, has := dg.lookup[bfrom]
-			if !has {
-				fromRef, err := from.Feed()
-				if err != nil {
-					return err
-				}
+	b.cacheLock.Lock()
+	defer b.cacheLock.Unlock()
 
-				nFrom = &contactNode{dg.NewNode(), fromRef, ""}
-				dg.AddNode(nFrom)
-				dg.lookup[bfrom] = nFrom
-			}
+	if b.cachedWithPrivate != nil {
+		return b.cachedWithPrivate, nil
+	}
 
-			bto := librarian.Addr(rawTo)
-			nTo, has := dg.lookup[bto]
-			if !has {
-				toRef, err := to.Feed()
-				if err != nil {
+	return b.buildWithPrivate()
+}
+
+// buildWithPrivate reads the combined graph from the database and resets the observers.
+// The caller needs to hold cacheLock.
+func (b *BadgerBuilder) buildWithPrivate() (*Graph, error) {
+	dg := NewGraph()
+	err := b.kv.View(func(txn *badger.Txn) error {
+		if err := loadGraph(dg, txn, dbKeyPrefix); err != nil {
+			return err
+		}
+		return loadGraph(dg, txn, dbKeyPrefixPrivate)
+	})
+
+	b.cachedWithPrivate = dg
+
+	dg.Lock()
+	for _, o := range b.observers {
+		o.reset(dg)
+	}
+	dg.Unlock()
+
+	return dg, err
+}
+
+// loadGraph adds the relations stored under prefix to dg
+func loadGraph(dg *Graph, txn *badger.Txn, prefix []byte) error {
+	private := bytes.Equal(prefix, dbKeyPrefixPrivate)
+
+	iter := txn.NewIterator(badger.DefaultIteratorOptions)
+	defer iter.Close()
+
+	for iter.Seek(prefix); iter.ValidForPrefix(prefix); iter.Next() {
+		it := iter.Item()
+		k := it.Key()
+
+		var rel []byte
+		if private {
+			var ok bool
+			if rel, ok = privateRelation(k); !ok {
+				continue
+			}
+		} else {
+			rel = k[len(prefix):]
+			if len(rel) == 34 {
+				if err := loadAnnouncement(dg, it); err != nil {
 					return err
 				}
-				nTo = &contactNode{dg.NewNode(), toRef, ""}
-				dg.AddNode(nTo)
-				dg.lookup[bto] = nTo
+				continue
 			}
-
-			if nFrom.ID() == nTo.ID() {
+			if len(rel) != 68 {
 				continue
 			}
+		}
 
-			var edg graph.WeightedEdge
+		rawFrom := rel[:34]
+		rawTo := rel[34:]
 
-			err := it.Value(func(v []byte) error {
-				if len(v) >= 1 {
-					switch v[0] {
-					case '0': // not following
-						edg = contactEdge{
-							WeightedEdge: simple.WeightedEdge{F: nFrom, T: nTo, W: math.Inf(-1)},
-							isBlock:      false,
-						}
-					case '1': // following
-						edg = contactEdge{
-							WeightedEdge: simple.WeightedEdge{F: nFrom, T: nTo, W: 1},
-							isBlock:      false,
-						}
-					case '2': // blocking
-						edg = contactEdge{
-							WeightedEdge: simple.WeightedEdge{F: nFrom, T: nTo, W: math.Inf(1)},
-							isBlock:      true,
-						}
-					case '3': // metafeed
-						edg = metafeedEdge{
-							WeightedEdge: simple.WeightedEdge{F: nFrom, T: nTo, W: 0.1},
-						}
-					default:
-						return fmt.Errorf("barbage value in graph strore %q", string(v))
-					}
-				}
-				return nil
-			})
+		if bytes.Equal(rawFrom, rawTo) {
+			// contact self?!
+			continue
+		}
+
+		var to, from tfk.Feed
+		if err := from.UnmarshalBinary(rawFrom); err != nil {
+			return fmt.Errorf("builder: couldnt idx key value (from): %w", err)
+		}
+		if err := to.UnmarshalBinary(rawTo); err != nil {
+			return fmt.Errorf("builder: couldnt idx key value (to): %w", err)
+		}
+
+		bfrom := librarian.Addr(rawFrom)
+		nFrom, has := dg.lookup[bfrom]
+		if !has {
+			fromRef, err := from.Feed()
 			if err != nil {
-				return fmt.Errorf("failed to get value from item:%q: %w", string(k), err)
+				return err
 			}
 
-			if math.IsInf(edg.Weight(), -1) {
-				//dg.RemoveEdge(nFrom.ID(), nTo.ID())
-				continue
+			nFrom = &contactNode{dg.NewNode(), fromRef, ""}
+			dg.AddNode(nFrom)
+			dg.lookup[bfrom] = nFrom
+		}
+
+		bto := librarian.Addr(rawTo)
+		nTo, has := dg.lookup[bto]
+		if !has {
+			toRef, err := to.Feed()
+			if err != nil {
+				return err
 			}
+			nTo = &contactNode{dg.NewNode(), toRef, ""}
+			dg.AddNode(nTo)
+			dg.lookup[bto] = nTo
+		}
+
+		if nFrom.ID() == nTo.ID() {
+			continue
+		}
+
+		var edg graph.WeightedEdge
+
+		err := it.Value(func(v []byte) error {
+			if len(v) >= 1 {
+				// '0' not following, '1' following, '2' blocking, '3' metafeed
+				if v[0] < '0' || v[0] > '3' {
+					return fmt.Errorf("barbage value in graph strore %q", string(v))
+				}
+				edg = newEdge(nFrom, nTo, idxRelationState(v[0]-'0'))
+			}
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("failed to get value from item:%q: %w", string(k), err)
+		}
+
+		if private {
+			dg.private[[2]int64{nFrom.ID(), nTo.ID()}] = struct{}{}
+		}
+
+		if edg == nil { // not following
+			dg.RemoveEdge(nFrom.ID(), nTo.ID())
+			continue
+		}
+
+		dg.SetWeightedEdge(edg)
+	}
+	return nil
+}
+
+// privateRelation returns the from and to part of a private relation key
+func privateRelation(k []byte) ([]byte, bool) {
+	rel := bytes.TrimPrefix(k, dbKeyPrefixPrivate)
+	i := bytes.IndexByte(rel, '/') // after the name of the source
+	if i < 0 || len(rel[i+1:]) != 68 {
+		return nil, false
+	}
+	return rel[i+1:], true
+}
 
-			dg.SetWeightedEdge(edg)
+// loadAnnouncement adds the metafeed announcement stored in it to the graph
+func loadAnnouncement(dg *Graph, it *badger.Item) error {
+	var author, metafeed tfk.Feed
+	if err := author.UnmarshalBinary(it.Key()[dbKeyPrefixLen:]); err != nil {
+		return nil // not an announcement
+	}
+
+	return it.Value(func(v []byte) error {
+		if err := metafeed.UnmarshalBinary(v); err != nil {
+			return nil // not an announcement
 		}
+
+		authorRef, err := author.Feed()
+		if err != nil {
+			return err
+		}
+		metafeedRef, err := metafeed.Feed()
+		if err != nil {
+			return err
+		}
+		dg.addMetafeed(dg.node(authorRef).ID(), dg.node(metafeedRef).ID())
 		return nil
 	})
+}
 
-	b.cachedGraph = dg
-	return dg, err
+// SubscribeHops calls fn with the feeds that are in range of root (like Hops(root, max)) and are blocked by it.
+// After that fn is called with every change to them, as new contacts and metafeed messages are indexed.
+// The hops are computed on the graph of BuildWithPrivate.
+// fn is called while the graph is locked and must not call back into the builder.
+// The returned function cancels the subscription.
+func (b *BadgerBuilder) SubscribeHops(root refs.FeedRef, max int, fn func([]HopsChange)) (func(), error) {
+	return b.subscribe(newHopTracker(root, max, fn), true)
+}
+
+// SubscribeEdges calls fn with all the follow and block relations of the graph of Build.
+// After that fn is called with every change to them, as new contacts are indexed.
+// fn is called while the graph is locked and must not call back into the builder.
+// The returned function cancels the subscription.
+func (b *BadgerBuilder) SubscribeEdges(fn func([]EdgeChange)) (func(), error) {
+	return b.subscribe(newEdgeObserver(fn), false)
+}
+
+// subscribe resets o with the graph of BuildWithPrivate (or Build, without private)
+// and patches it together with the graph from then on
+func (b *BadgerBuilder) subscribe(o graphObserver, private bool) (func(), error) {
+	b.WaitUntilIndexesAreSynced()
+
+	b.cacheLock.Lock()
+	defer b.cacheLock.Unlock()
+
+	var (
+		g         *Graph
+		observers *[]graphObserver
+		err       error
+	)
+	if private {
+		g, observers = b.cachedWithPrivate, &b.observers
+		if g == nil {
+			g, err = b.buildWithPrivate()
+		}
+	} else {
+		g, observers = b.cachedGraph, &b.publicObservers
+		if g == nil {
+			g, err = b.build()
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	*observers = append(*observers, o)
+
+	g.Lock()
+	o.reset(g)
+	g.Unlock()
+
+	cancel := func() {
+		b.cacheLock.Lock()
+		defer b.cacheLock.Unlock()
+		for i, other := range *observers {
+			if other == o {
+				*observers = append((*observers)[:i], (*observers)[i+1:]...)
+				break
+			}
+		}
+	}
+	return cancel, nil
 }
 
 type Lookup struct {
@@ -492,6 +715,8 @@ func (b *BadgerBuilder) DumpXMLOverHTTP(self refs.FeedRef, w http.ResponseWriter
 		http.Error(w, "graph build failure", http.StatusInternalServerError)
 		return
 	}
+	g.Lock()
+	defer g.Unlock()
 
 	// initialze new reducer
 	var rg graphReducer
@@ -12,6 +12,7 @@ import (
 	"github.com/ssbc/go-ssb-refs/tfk"
 	"github.com/ssbc/margaret"
 	librarian "github.com/ssbc/margaret/indexes"
+	libbadger "github.com/ssbc/margaret/indexes/badger"
 	"github.com/zeebo/bencode"
 	"go.mindeco.de/log"
 	"go.mindeco.de/log/level"
@@ -33,20 +34,31 @@ const (
 )
 
 func (b *BadgerBuilder) indexSyncStart() {
-	b.idxInSync.Add(1)
+	b.idxPendingMu.Lock()
+	b.idxPending++
+	b.idxPendingMu.Unlock()
 }
 
 func (b *BadgerBuilder) indexSyncDone() {
 	// this delay is here so that the WaitGroup is held while Luigi continues to process more data
 	// TODO: eliminate this delay once we have a way to query Luigi directly to see if it's done with its source queue
-	time.AfterFunc(100 * time.Millisecond, func() {
-		b.idxInSync.Done()
+	time.AfterFunc(100*time.Millisecond, func() {
+		b.idxPendingMu.Lock()
+		b.idxPending--
+		if b.idxPending == 0 {
+			b.idxPendingZero.Broadcast()
+		}
+		b.idxPendingMu.Unlock()
 	})
 }
 
 // WaitUntilIndexesAreSynced blocks until all the index processing is in sync with the rootlog
 func (b *BadgerBuilder) WaitUntilIndexesAreSynced() {
-	b.idxInSync.Wait()
+	b.idxPendingMu.Lock()
+	for b.idxPending > 0 {
+		b.idxPendingZero.Wait()
+	}
+	b.idxPendingMu.Unlock()
 }
 
 func (b *BadgerBuilder) updateAnnouncement(ctx context.Context, seq int64, val interface{}, idx librarian.SetterIndex) error {
@@ -86,8 +98,12 @@ func (b *BadgerBuilder) updateAnnouncement(ctx context.Context, seq int64, val i
 		return fmt.Errorf("db/idx announcements: failed to update index %+v: %w", announceMsg, err)
 	}
 
-	b.cachedGraph = nil
-	// TODO: patch existing graph instead of invalidating
+	if b.cachedGraph != nil {
+		b.cachedGraph.setMetafeed(msg.Author(), announceMsg.Metafeed, b.publicObservers)
+	}
+	if b.cachedWithPrivate != nil {
+		b.cachedWithPrivate.setMetafeed(msg.Author(), announceMsg.Metafeed, b.observers)
+	}
 	return nil
 }
 
@@ -130,34 +146,102 @@ func (b *BadgerBuilder) updateContacts(ctx context.Context, seq int64, val inter
 
 	addr := storedrefs.Feed(abs.Author())
 	addr += storedrefs.Feed(c.Contact)
+
+	state := contactState(c)
+	err = idx.Set(ctx, addr, state)
+	if err != nil {
+		return fmt.Errorf("db/idx contacts: failed to update index. %+v: %w", c, err)
+	}
+
+	if b.cachedGraph != nil {
+		b.cachedGraph.setRelation(abs.Author(), c.Contact, state, b.publicObservers)
+	}
+	if b.cachedWithPrivate != nil {
+		b.cachedWithPrivate.setRelation(abs.Author(), c.Contact, state, b.observers)
+	}
+	return nil
+}
+
+func (b *BadgerBuilder) OpenContactsIndex() (librarian.SeqSetterIndex, librarian.SinkIndex) {
+	b.indexSyncStart()
+	defer b.indexSyncDone()
+	if b.idxSinkContacts == nil {
+		b.idxSinkContacts = librarian.NewSinkIndex(b.updateContacts, b.idx)
+	}
+	return b.idx, b.idxSinkContacts
+}
+
+func contactState(c refs.Contact) idxRelationState {
 	switch {
 	case c.Following:
-		err = idx.Set(ctx, addr, idxRelValueFollowing)
+		return idxRelValueFollowing
 	case c.Blocking:
-		err = idx.Set(ctx, addr, idxRelValueBlocking)
+		return idxRelValueBlocking
 	default:
-		err = idx.Set(ctx, addr, idxRelValueNone)
-		// cryptix: not sure why this doesn't work
+		// cryptix: not sure why deleting doesn't work
 		// it also removes the node if this is the only follow from that peer
 		// 3 state handling seems saner
-		// err = idx.Delete(ctx, librarian.Addr(addr))
+		return idxRelValueNone
+	}
+}
+
+// updatePrivateContacts expects decrypted messages.
+// Their relations only end up in the graph of BuildWithPrivate.
+func (b *BadgerBuilder) updatePrivateContacts(ctx context.Context, seq int64, val interface{}, idx librarian.SetterIndex) error {
+	b.cacheLock.Lock()
+	b.indexSyncStart()
+	defer b.indexSyncDone()
+	defer b.cacheLock.Unlock()
+
+	if nulled, ok := val.(error); ok {
+		if margaret.IsErrNulled(nulled) {
+			return nil
+		}
+		return nulled
 	}
+
+	msg, ok := val.(refs.Message)
+	if !ok {
+		err := fmt.Errorf("graph/idx: invalid private msg value %T", val)
+		level.Warn(b.log).Log("msg", "private contact eval failed", "reason", err)
+		return err
+	}
+
+	var c refs.Contact
+	err := c.UnmarshalJSON(msg.ContentBytes())
 	if err != nil {
-		return fmt.Errorf("db/idx contacts: failed to update index. %+v: %w", c, err)
+		// most private messages are not about contacts
+		return nil
 	}
 
-	b.cachedGraph = nil
-	// TODO: patch existing graph instead of invalidating
+	addr := storedrefs.Feed(msg.Author())
+	addr += storedrefs.Feed(c.Contact)
+
+	state := contactState(c)
+	err = idx.Set(ctx, addr, state)
+	if err != nil {
+		return fmt.Errorf("db/idx private contacts: failed to update index. %+v: %w", c, err)
+	}
+
+	if b.cachedWithPrivate != nil {
+		b.cachedWithPrivate.setPrivateRelation(msg.Author(), c.Contact, state, b.observers)
+	}
 	return nil
 }
 
-func (b *BadgerBuilder) OpenContactsIndex() (librarian.SeqSetterIndex, librarian.SinkIndex) {
+// OpenPrivateContactsIndex returns the index for contact messages that were published privately, to us or to a group we are part of.
+// The sink expects decrypted messages. Since they can come from more than one source (like box1 and box2),
+// each source needs its own name to keep track of the processed sequences.
+func (b *BadgerBuilder) OpenPrivateContactsIndex(name string) (librarian.SeqSetterIndex, librarian.SinkIndex) {
 	b.indexSyncStart()
 	defer b.indexSyncDone()
-	if b.idxSinkContacts == nil {
-		b.idxSinkContacts = librarian.NewSinkIndex(b.updateContacts, b.idx)
+	if _, has := b.idxSinkPrivateContacts[name]; !has {
+		prefix := append(append([]byte{}, dbKeyPrefixPrivate...), name+"/"...)
+		idx := libbadger.NewIndexWithKeyPrefix(b.kv, 0, prefix)
+		b.idxPrivate[name] = idx
+		b.idxSinkPrivateContacts[name] = librarian.NewSinkIndex(b.updatePrivateContacts, idx)
 	}
-	return b.idx, b.idxSinkContacts
+	return b.idxPrivate[name], b.idxSinkPrivateContacts[name]
 }
 
 func (b *BadgerBuilder) updateMetafeeds(ctx context.Context, seq int64, val interface{}, idx librarian.SetterIndex) error {
@@ -219,6 +303,10 @@ func (b *BadgerBuilder) updateMetafeeds(ctx context.Context, seq int64, val inte
 
 	addr := storedrefs.Feed(msg.Author())
 
+	var (
+		subfeed refs.FeedRef
+		state   idxRelationState
+	)
 	switch justTheType.Type {
 	case "metafeed/add/existing":
 		var addMsg metamngmt.AddExisting
@@ -236,7 +324,7 @@ func (b *BadgerBuilder) updateMetafeeds(ctx context.Context, seq int64, val inte
 		addr += storedrefs.Feed(addMsg.SubFeed)
 
 		level.Info(msgLogger).Log("adding", addMsg.SubFeed.String())
-		err = idx.Set(ctx, addr, idxRelValueMetafeed)
+		subfeed, state = addMsg.SubFeed, idxRelValueMetafeed
 
 	case "metafeed/add/derived":
 		var addMsg metamngmt.AddDerived
@@ -254,7 +342,7 @@ func (b *BadgerBuilder) updateMetafeeds(ctx context.Context, seq int64, val inte
 		addr += storedrefs.Feed(addMsg.SubFeed)
 
 		level.Info(msgLogger).Log("adding", addMsg.SubFeed.ShortSigil())
-		err = idx.Set(ctx, addr, idxRelValueMetafeed)
+		subfeed, state = addMsg.SubFeed, idxRelValueMetafeed
 
 	case "metafeed/tombstone":
 		var tMsg metamngmt.Tombstone
@@ -272,16 +360,24 @@ func (b *BadgerBuilder) updateMetafeeds(ctx context.Context, seq int64, val inte
 		addr += storedrefs.Feed(tMsg.SubFeed)
 
 		level.Info(msgLogger).Log("removing", tMsg.SubFeed.ShortSigil())
-		err = idx.Set(ctx, addr, idxRelValueNone)
+		subfeed, state = tMsg.SubFeed, idxRelValueNone
 
 	default:
 		level.Warn(msgLogger).Log("warning", "unhandeled message type", "type", justTheType.Type)
+		return nil
 	}
 
+	err = idx.Set(ctx, addr, state)
 	if err != nil {
 		return fmt.Errorf("failed to update metafeed index with message %s: %w", msg.Key().String(), err)
 	}
 
+	if b.cachedGraph != nil {
+		b.cachedGraph.setRelation(msg.Author(), subfeed, state, b.publicObservers)
+	}
+	if b.cachedWithPrivate != nil {
+		b.cachedWithPrivate.setRelation(msg.Author(), subfeed, state, b.observers)
+	}
 	return nil
 
 }
@@ -0,0 +1,121 @@
+// SPDX-FileCopyrightText: 2021 The Go-SSB Authors
+//
+// SPDX-License-Identifier: MIT
+
+package graph
+
+import (
+	refs "github.com/ssbc/go-ssb-refs"
+	"github.com/ssbc/go-ssb/internal/storedrefs"
+	librarian "github.com/ssbc/margaret/indexes"
+)
+
+// EdgeChange is published for every follow or block relation that changed.
+// If neither Following nor Blocking is set, the relation was removed.
+type EdgeChange struct {
+	From refs.FeedRef
+	To   refs.FeedRef
+
+	Following bool
+	Blocking  bool
+}
+
+// edgeObserver publishes the contact edges of a graph. Metafeed edges are left out.
+type edgeObserver struct {
+	notify func([]EdgeChange)
+
+	// the relations as last published, so that a reset only publishes the differences
+	published map[[2]librarian.Addr]EdgeChange
+}
+
+func newEdgeObserver(notify func([]EdgeChange)) *edgeObserver {
+	return &edgeObserver{
+		notify:    notify,
+		published: make(map[[2]librarian.Addr]EdgeChange),
+	}
+}
+
+func (o *edgeObserver) reset(g *Graph) {
+	current := make(map[[2]librarian.Addr]EdgeChange)
+	edges := g.WeightedEdges()
+	for edges.Next() {
+		edg := edges.WeightedEdge()
+		from, ok := edg.From().(*contactNode)
+		if !ok {
+			continue
+		}
+		to, ok := edg.To().(*contactNode)
+		if !ok {
+			continue
+		}
+		if ec := edgeChange(from.feed, to.feed, g.relation(from.ID(), to.ID())); ec.Following || ec.Blocking {
+			current[edgeKey(from.feed, to.feed)] = ec
+		}
+	}
+
+	var changes []EdgeChange
+	for k, ec := range o.published {
+		if _, has := current[k]; !has {
+			changes = append(changes, EdgeChange{From: ec.From, To: ec.To})
+		}
+	}
+	for k, ec := range current {
+		if prev, had := o.published[k]; !had || prev != ec {
+			changes = append(changes, ec)
+		}
+	}
+	o.published = current
+
+	if o.notify != nil {
+		// even if the graph is empty, subscribers expect their first call
+		o.notify(changes)
+	}
+}
+
+func (o *edgeObserver) relationChanged(g *Graph, from, to int64, old, state idxRelationState) {
+	nFrom, ok := g.Node(from).(*contactNode)
+	if !ok {
+		return
+	}
+	nTo, ok := g.Node(to).(*contactNode)
+	if !ok {
+		return
+	}
+
+	ec := edgeChange(nFrom.feed, nTo.feed, state)
+	k := edgeKey(nFrom.feed, nTo.feed)
+	prev, had := o.published[k]
+	if !had {
+		prev = EdgeChange{From: ec.From, To: ec.To}
+	}
+	if prev == ec {
+		return
+	}
+
+	if ec.Following || ec.Blocking {
+		o.published[k] = ec
+	} else {
+		delete(o.published, k)
+	}
+	if o.notify != nil {
+		o.notify([]EdgeChange{ec})
+	}
+}
+
+func (o *edgeObserver) metafeedChanged(*Graph, int64, int64, bool, int64) {}
+
+// edgeChange returns the change for a relation state. Metafeed edges are the same as no relation.
+func edgeChange(from, to refs.FeedRef, state idxRelationState) EdgeChange {
+	ec := EdgeChange{From: from, To: to}
+	switch state {
+	case idxRelValueFollowing:
+		ec.Following = true
+	case idxRelValueBlocking:
+		ec.Blocking = true
+	}
+	return ec
+}
+
+func edgeKey(from, to refs.FeedRef) [2]librarian.Addr {
+	return [2]librarian.Addr{storedrefs.Feed(from), storedrefs.Feed(to)}
+}
@@ -0,0 +1,100 @@
+// SPDX-FileCopyrightText: 2021 The Go-SSB Authors
+//
+// SPDX-License-Identifier: MIT
+
+package graph
+
+import (
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSubscribeEdges(t *testing.T) {
+	if os.Getenv("LIBRARIAN_WRITEALL") != "0" {
+		t.Fatal("please 'export LIBRARIAN_WRITEALL=0' for this test to pass")
+		// TODO: expose index flushing
+	}
+
+	r := require.New(t)
+
+	tc := makeBadger(t)
+	b := tc.gbuilder.(*BadgerBuilder)
+
+	alice := tc.newPublisher(t)
+	bob := tc.newPublisher(t)
+	claire := tc.newPublisher(t)
+
+	alice.follow(bob.key.ID())
+	r.Eventually(func() bool {
+		g, err := b.Build()
+		r.NoError(err)
+		return g.Follows(alice.key.ID(), bob.key.ID())
+	}, 5*time.Second, 10*time.Millisecond)
+
+	var (
+		mu    sync.Mutex
+		calls [][]EdgeChange
+	)
+	cancel, err := b.SubscribeEdges(func(changes []EdgeChange) {
+		mu.Lock()
+		calls = append(calls, changes)
+		mu.Unlock()
+	})
+	r.NoError(err)
+
+	// the current graph
+	mu.Lock()
+	r.Len(calls, 1)
+	r.Equal([]EdgeChange{{From: alice.key.ID(), To: bob.key.ID(), Following: true}}, calls[0])
+	mu.Unlock()
+
+	expect := func(want ...EdgeChange) {
+		r.Eventually(func() bool {
+			mu.Lock()
+			defer mu.Unlock()
+			if len(calls) != 1+len(want) {
+				return false
+			}
+			for i, ec := range want {
+				if len(calls[1+i]) != 1 || calls[1+i][0] != ec {
+					return false
+				}
+			}
+			return true
+		}, 5*time.Second, 10*time.Millisecond, "calls: %v", calls)
+	}
+
+	bob.block(claire.key.ID())
+	alice.unfollow(bob.key.ID())
+	expect(
+		EdgeChange{From: bob.key.ID(), To: claire.key.ID(), Blocking: true},
+		EdgeChange{From: alice.key.ID(), To: bob.key.ID()},
+	)
+
+	// unfollowing again doesn't change anything
+	alice.unfollow(bob.key.ID())
+	alice.follow(claire.key.ID())
+	expect(
+		EdgeChange{From: bob.key.ID(), To: claire.key.ID(), Blocking: true},
+		EdgeChange{From: alice.key.ID(), To: bob.key.ID()},
+		EdgeChange{From: alice.key.ID(), To: claire.key.ID(), Following: true},
+	)
+
+	// rebuilding the graph only publishes the differences
+	r.NoError(b.DeleteAuthor(bob.key.ID()))
+	mu.Lock()
+	r.Len(calls, 5)
+	r.Equal([]EdgeChange{{From: bob.key.ID(), To: claire.key.ID()}}, calls[4])
+	mu.Unlock()
+
+	cancel()
+	claire.follow(alice.key.ID())
+	time.Sleep(100 * time.Millisecond)
+	mu.Lock()
+	r.Len(calls, 5)
+	mu.Unlock()
+}
@@ -24,12 +24,24 @@ type Graph struct {
 	sync.Mutex
 	*simple.WeightedDirectedGraph
 	lookup key2node
+
+	// metafeed announcements, from the node ID of the main feed to the one of its metafeed
+	metafeeds map[int64]int64
+	// the reverse of metafeeds, a metafeed can be announced by more than one feed
+	announcers map[int64]map[int64]struct{}
+
+	// pairs of node IDs with a private relation, which takes precedence over public ones
+	private map[[2]int64]struct{}
 }
 
 func NewGraph() *Graph {
 	return &Graph{
 		WeightedDirectedGraph: simple.NewWeightedDirectedGraph(0, math.Inf(1)),
 		lookup:                make(key2node),
+
+		metafeeds:  make(map[int64]int64),
+		announcers: make(map[int64]map[int64]struct{}),
+		private:    make(map[[2]int64]struct{}),
 	}
 }
 
@@ -112,8 +124,13 @@ func (g *Graph) MakeDijkstra(from refs.FeedRef) (*Lookup, error) {
 	if !has {
 		return nil, ErrNoSuchFrom{Who: from}
 	}
+	// the graph is patched in place as contacts come in, the lookup needs its own copy
+	lookup := make(key2node, len(g.lookup))
+	for k, n := range g.lookup {
+		lookup[k] = n
+	}
 	return &Lookup{
 		path.DijkstraFrom(nFrom, g),
-		g.lookup,
+		lookup,
 	}, nil
 }
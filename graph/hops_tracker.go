@@ -0,0 +1,434 @@
+// SPDX-FileCopyrightText: 2021 The Go-SSB Authors
+//
+// SPDX-License-Identifier: MIT
+
+package graph
+
+import (
+	"container/heap"
+
+	refs "github.com/ssbc/go-ssb-refs"
+)
+
+// HopsChange is published by a HopTracker for every feed that entered or left the hop range of its root,
+// changed its distance or was (un)blocked by the root.
+type HopsChange struct {
+	Feed refs.FeedRef
+
+	// Hops is the distance to the root like in Builder.Hops (0 for feeds the root follows) or -1 if the feed is out of range.
+	Hops int
+
+	// Blocked is true if the root blocks this feed
+	Blocked bool
+}
+
+// HopTracker keeps the hop distances of all feeds within range of a root up to date while edges are added to and removed from the graph.
+//
+// The distances follow the rules of Builder.Hops: the follows of the root and of its friends (mutual follows) are in range,
+// and subfeeds and announced metafeeds count as the same identity as the feed that links them.
+// To express this as a shortest path problem every node exists in two layers.
+// The friend layer holds the feeds that are expanded (the root and its friends up to max hops)
+// and the reach layer holds everything that is in range.
+//
+// When edges change only the distances that depended on them are recomputed.
+type HopTracker struct {
+	root refs.FeedRef
+	max  int
+
+	notify func([]HopsChange)
+
+	rootID int64
+	dist   map[hopKey]int
+
+	// the state per feed, as last published
+	published map[int64]HopsChange
+}
+
+type hopLayer uint8
+
+const (
+	layerFriend hopLayer = iota
+	layerReach
+)
+
+type hopKey struct {
+	id    int64
+	layer hopLayer
+}
+
+type hopEdge struct {
+	to hopKey
+	w  int
+}
+
+func newHopTracker(root refs.FeedRef, max int, notify func([]HopsChange)) *HopTracker {
+	return &HopTracker{
+		root:   root,
+		max:    max,
+		notify: notify,
+
+		published: make(map[int64]HopsChange),
+	}
+}
+
+// limit is the highest distance that is in range (direct follows of the root are at distance 1)
+func (t *HopTracker) limit() int { return t.max + 1 }
+
+func (t *HopTracker) isRoot(k hopKey) bool { return k.id == t.rootID && k.layer == layerFriend }
+
+// reset computes all distances from scratch, for instance after the graph was rebuilt.
+// The caller needs to hold the lock of g.
+func (t *HopTracker) reset(g *Graph) {
+	t.rootID = g.node(t.root).ID()
+	t.dist = make(map[hopKey]int)
+
+	if t.max >= 0 {
+		var q hopQueue
+		heap.Push(&q, hopItem{key: hopKey{t.rootID, layerFriend}, dist: 0})
+		t.propagate(g, &q, nil)
+	}
+
+	// every feed we published before is compared with the new state
+	changed := make(map[int64]struct{}, len(t.published))
+	for id := range t.published {
+		changed[id] = struct{}{}
+	}
+	for k := range t.dist {
+		changed[k.id] = struct{}{}
+	}
+	for _, edg := range t.outgoingBlocks(g) {
+		changed[edg] = struct{}{}
+	}
+	t.publish(g, changed)
+}
+
+// relationChanged updates the distances after the edge from -> to changed from old to state.
+func (t *HopTracker) relationChanged(g *Graph, from, to int64, old, state idxRelationState) {
+	// the reach and friend layer of to have different incoming edges now
+	// and the friendship of from depends on the (mutual) follow of to
+	touched := []hopKey{
+		{to, layerFriend},
+		{to, layerReach},
+		{from, layerFriend},
+	}
+	changed := t.update(g, touched)
+
+	if from == t.rootID && (old == idxRelValueBlocking || state == idxRelValueBlocking) {
+		changed[to] = struct{}{}
+	}
+	t.publish(g, changed)
+}
+
+// metafeedChanged updates the distances after feed announced a new metafeed
+func (t *HopTracker) metafeedChanged(g *Graph, feed, old int64, had bool, metafeed int64) {
+	touched := []hopKey{{metafeed, layerReach}}
+	if had {
+		touched = append(touched, hopKey{old, layerReach})
+	}
+	t.publish(g, t.update(g, touched))
+}
+
+// update repairs the distances after the incoming edges of the touched keys changed and returns the IDs of the nodes whose distance changed.
+//
+// Keys that lost the support for their distance are invalidated together with everything that (possibly) depended on them.
+// Those are then recomputed from their unaffected neighbours, together with the keys that got closer due to new edges.
+func (t *HopTracker) update(g *Graph, touched []hopKey) map[int64]struct{} {
+	before := make(map[hopKey]int)
+	record := func(k hopKey) {
+		if _, has := before[k]; has {
+			return
+		}
+		d, has := t.dist[k]
+		if !has {
+			d = -1
+		}
+		before[k] = d
+	}
+
+	// find the keys that depended on removed edges
+	affected := make(map[hopKey]struct{})
+	var work []hopKey
+	for _, k := range touched {
+		d, has := t.dist[k]
+		if !has || t.isRoot(k) {
+			continue
+		}
+		if s, ok := t.support(g, k); !ok || s > d {
+			affected[k] = struct{}{}
+			work = append(work, k)
+		}
+	}
+	for len(work) > 0 {
+		k := work[len(work)-1]
+		work = work[:len(work)-1]
+		dk := t.dist[k]
+		for _, e := range t.outgoing(g, k) {
+			if _, done := affected[e.to]; done || t.isRoot(e.to) {
+				continue
+			}
+			if d, has := t.dist[e.to]; has && d == dk+e.w {
+				affected[e.to] = struct{}{}
+				work = append(work, e.to)
+			}
+		}
+	}
+	for k := range affected {
+		record(k)
+		delete(t.dist, k)
+	}
+
+	// recompute them and propagate new shortcuts
+	var q hopQueue
+	for k := range affected {
+		if s, ok := t.support(g, k); ok {
+			heap.Push(&q, hopItem{key: k, dist: s})
+		}
+	}
+	for _, k := range touched {
+		if t.isRoot(k) {
+			continue
+		}
+		s, ok := t.support(g, k)
+		if !ok {
+			continue
+		}
+		if d, has := t.dist[k]; !has || s < d {
+			heap.Push(&q, hopItem{key: k, dist: s})
+		}
+	}
+	t.propagate(g, &q, record)
+
+	changed := make(map[int64]struct{})
+	for k, d := range before {
+		now, has := t.dist[k]
+		if !has {
+			now = -1
+		}
+		if now != d {
+			changed[k.id] = struct{}{}
+		}
+	}
+	return changed
+}
+
+// propagate runs dijkstra from the queued keys, only keeping distances up to the limit
+func (t *HopTracker) propagate(g *Graph, q *hopQueue, record func(hopKey)) {
+	for q.Len() > 0 {
+		it := heap.Pop(q).(hopItem)
+		if d, has := t.dist[it.key]; has && d <= it.dist {
+			continue
+		}
+		if record != nil {
+			record(it.key)
+		}
+		t.dist[it.key] = it.dist
+
+		for _, e := range t.outgoing(g, it.key) {
+			nd := it.dist + e.w
+			if nd > t.limit() {
+				continue
+			}
+			if d, has := t.dist[e.to]; !has || nd < d {
+				heap.Push(q, hopItem{key: e.to, dist: nd})
+			}
+		}
+	}
+}
+
+// support returns the shortest distance to k over its incoming edges
+func (t *HopTracker) support(g *Graph, k hopKey) (int, bool) {
+	best, found := 0, false
+	for _, e := range t.incoming(g, k) {
+		d, has := t.dist[e.to]
+		if !has {
+			continue
+		}
+		if nd := d + e.w; nd <= t.limit() && (!found || nd < best) {
+			best, found = nd, true
+		}
+	}
+	return best, found
+}
+
+// outgoing returns the edges of k in the layered graph
+func (t *HopTracker) outgoing(g *Graph, k hopKey) []hopEdge {
+	var edges []hopEdge
+	if k.layer == layerFriend {
+		edges = append(edges, hopEdge{hopKey{k.id, layerReach}, 0})
+	}
+
+	nodes := g.From(k.id)
+	for nodes.Next() {
+		to := nodes.Node().ID()
+		switch g.relation(k.id, to) {
+		case idxRelValueFollowing:
+			if k.layer != layerFriend {
+				continue
+			}
+			edges = append(edges, hopEdge{hopKey{to, layerReach}, 1})
+			if g.relation(to, k.id) == idxRelValueFollowing {
+				edges = append(edges, hopEdge{hopKey{to, layerFriend}, 1})
+			}
+		case idxRelValueMetafeed:
+			edges = append(edges, hopEdge{hopKey{to, k.layer}, 0})
+		}
+	}
+
+	if mf, has := g.metafeeds[k.id]; has && k.layer == layerReach {
+		edges = append(edges, hopEdge{hopKey{mf, layerReach}, 0})
+	}
+	return edges
+}
+
+// incoming returns the edges that point to k in the layered graph, with the other end in hopEdge.to
+func (t *HopTracker) incoming(g *Graph, k hopKey) []hopEdge {
+	var edges []hopEdge
+	if k.layer == layerReach {
+		edges = append(edges, hopEdge{hopKey{k.id, layerFriend}, 0})
+	}
+
+	nodes := g.To(k.id)
+	for nodes.Next() {
+		from := nodes.Node().ID()
+		switch g.relation(from, k.id) {
+		case idxRelValueFollowing:
+			if k.layer == layerReach || g.relation(k.id, from) == idxRelValueFollowing {
+				edges = append(edges, hopEdge{hopKey{from, layerFriend}, 1})
+			}
+		case idxRelValueMetafeed:
+			edges = append(edges, hopEdge{hopKey{from, k.layer}, 0})
+		}
+	}
+
+	if k.layer == layerReach {
+		for from := range g.announcers[k.id] {
+			edges = append(edges, hopEdge{hopKey{from, layerReach}, 0})
+		}
+	}
+	return edges
+}
+
+// outgoingBlocks returns the node IDs the root blocks
+func (t *HopTracker) outgoingBlocks(g *Graph) []int64 {
+	var blocked []int64
+	nodes := g.From(t.rootID)
+	for nodes.Next() {
+		to := nodes.Node().ID()
+		if g.relation(t.rootID, to) == idxRelValueBlocking {
+			blocked = append(blocked, to)
+		}
+	}
+	return blocked
+}
+
+// publish compares the state of the changed node IDs with what was published before and notifies about the differences
+func (t *HopTracker) publish(g *Graph, changed map[int64]struct{}) {
+	var changes []HopsChange
+	for id := range changed {
+		if id == t.rootID {
+			continue
+		}
+		n, ok := g.Node(id).(*contactNode)
+		if !ok {
+			continue
+		}
+
+		hc := HopsChange{
+			Feed:    n.feed,
+			Hops:    t.hops(id),
+			Blocked: g.relation(t.rootID, id) == idxRelValueBlocking,
+		}
+
+		prev, had := t.published[id]
+		if !had {
+			prev = HopsChange{Feed: n.feed, Hops: -1}
+		}
+		if prev == hc {
+			continue
+		}
+
+		if hc.Hops == -1 && !hc.Blocked {
+			delete(t.published, id)
+		} else {
+			t.published[id] = hc
+		}
+		changes = append(changes, hc)
+	}
+
+	if len(changes) > 0 && t.notify != nil {
+		t.notify(changes)
+	}
+}
+
+// hops returns the distance of the node ID like in HopsChange
+func (t *HopTracker) hops(id int64) int {
+	d, has := t.dist[hopKey{id, layerReach}]
+	if !has {
+		return -1
+	}
+	if d < 1 { // the root and its subfeeds
+		return 0
+	}
+	return d - 1
+}
+
+// path returns the feeds between the root and the node ID along one of the shortest paths, starting with the root.
+// It is nil if the node is out of range.
+func (t *HopTracker) path(g *Graph, id int64) []refs.FeedRef {
+	start := hopKey{id, layerReach}
+	if _, has := t.dist[start]; !has {
+		return nil
+	}
+
+	// walk back over the edges that support the distances until the root is found
+	next := map[hopKey]hopKey{start: start}
+	queue := []hopKey{start}
+	for len(queue) > 0 {
+		k := queue[0]
+		queue = queue[1:]
+		if t.isRoot(k) {
+			var feeds []refs.FeedRef
+			for {
+				if n, ok := g.Node(k.id).(*contactNode); ok {
+					if l := len(feeds); l == 0 || !feeds[l-1].Equal(n.feed) {
+						feeds = append(feeds, n.feed)
+					}
+				}
+				if k == start {
+					return feeds
+				}
+				k = next[k]
+			}
+		}
+
+		d := t.dist[k]
+		for _, e := range t.incoming(g, k) {
+			if _, seen := next[e.to]; seen {
+				continue
+			}
+			if prev, has := t.dist[e.to]; has && prev+e.w == d {
+				next[e.to] = k
+				queue = append(queue, e.to)
+			}
+		}
+	}
+	return nil
+}
+
+type hopItem struct {
+	key  hopKey
+	dist int
+}
+
+type hopQueue []hopItem
+
+func (q hopQueue) Len() int            { return len(q) }
+func (q hopQueue) Less(i, j int) bool  { return q[i].dist < q[j].dist }
+func (q hopQueue) Swap(i, j int)       { q[i], q[j] = q[j], q[i] }
+func (q *hopQueue) Push(x interface{}) { *q = append(*q, x.(hopItem)) }
+func (q *hopQueue) Pop() interface{} {
+	old := *q
+	it := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return it
+}
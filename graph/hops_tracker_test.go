@@ -0,0 +1,194 @@
+// SPDX-FileCopyrightText: 2021 The Go-SSB Authors
+//
+// SPDX-License-Identifier: MIT
+
+package graph
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	refs "github.com/ssbc/go-ssb-refs"
+	"github.com/ssbc/go-ssb/internal/testutils"
+)
+
+// hopsState applies the published changes of a tracker, like a replication lister would
+type hopsState map[string]HopsChange
+
+func (hs hopsState) apply(changes []HopsChange) {
+	for _, c := range changes {
+		if c.Hops == -1 && !c.Blocked {
+			delete(hs, c.Feed.String())
+			continue
+		}
+		hs[c.Feed.String()] = c
+	}
+}
+
+func TestHopTracker(t *testing.T) {
+	r := require.New(t)
+
+	tc := makeBadger(t)
+	b := tc.gbuilder.(*BadgerBuilder)
+
+	alice := tc.newPublisher(t)
+	bob := tc.newPublisher(t)
+	claire := tc.newPublisher(t)
+	dave := tc.newPublisher(t)
+
+	state := make(hopsState)
+	cancel, err := b.SubscribeHops(alice.key.ID(), 1, state.apply)
+	r.NoError(err)
+	defer cancel()
+
+	g, err := b.BuildWithPrivate()
+	r.NoError(err)
+
+	expect := func(want map[*publisher]HopsChange) {
+		r.Eventually(func() bool {
+			g.Lock()
+			defer g.Unlock()
+			if len(state) != len(want) {
+				return false
+			}
+			for p, hc := range want {
+				hc.Feed = p.key.ID()
+				if state[p.key.ID().String()] != hc {
+					return false
+				}
+			}
+			return true
+		}, 5*time.Second, 10*time.Millisecond, "state: %v", state)
+	}
+
+	alice.follow(bob.key.ID())
+	expect(map[*publisher]HopsChange{bob: {Hops: 0}})
+
+	// not a friend, yet
+	bob.follow(claire.key.ID())
+	claire.follow(dave.key.ID())
+	expect(map[*publisher]HopsChange{bob: {Hops: 0}})
+
+	bob.follow(alice.key.ID())
+	expect(map[*publisher]HopsChange{bob: {Hops: 0}, claire: {Hops: 1}})
+
+	// claire is a friend of bob now but two hops are too far for her follows
+	claire.follow(bob.key.ID())
+	expect(map[*publisher]HopsChange{bob: {Hops: 0}, claire: {Hops: 1}})
+
+	bob.unfollow(alice.key.ID())
+	expect(map[*publisher]HopsChange{bob: {Hops: 0}})
+
+	alice.block(bob.key.ID())
+	expect(map[*publisher]HopsChange{bob: {Hops: -1, Blocked: true}})
+
+	alice.unblock(bob.key.ID())
+	expect(map[*publisher]HopsChange{})
+}
+
+// TestHopTrackerRandom compares the incrementally updated distances with ones that are computed from scratch
+func TestHopTrackerRandom(t *testing.T) {
+	r := require.New(t)
+
+	tc := makeBadger(t)
+	b := tc.gbuilder.(*BadgerBuilder)
+
+	var peers []*publisher
+	for i := 0; i < 10; i++ {
+		peers = append(peers, tc.newPublisher(t))
+	}
+	root := peers[0].key.ID()
+
+	states := make([]hopsState, 3)
+	for max := range states {
+		states[max] = make(hopsState)
+		cancel, err := b.SubscribeHops(root, max, states[max].apply)
+		r.NoError(err)
+		defer cancel()
+	}
+
+	compare := func() bool {
+		g, err := b.BuildWithPrivate()
+		r.NoError(err)
+		g.Lock()
+		defer g.Unlock()
+		for max, state := range states {
+			fresh := make(hopsState)
+			newHopTracker(root, max, fresh.apply).reset(g)
+			if len(fresh) != len(state) {
+				t.Logf("hops %d: %d incremental vs %d fresh", max, len(state), len(fresh))
+				return false
+			}
+			for k, hc := range fresh {
+				if state[k] != hc {
+					t.Logf("hops %d: %s is %+v, should be %+v", max, k, state[k], hc)
+					return false
+				}
+			}
+		}
+		return true
+	}
+
+	rnd := rand.New(rand.NewSource(42))
+	for i := 0; i < 200; i++ {
+		from, to := peers[rnd.Intn(len(peers))], peers[rnd.Intn(len(peers))]
+		if from == to {
+			continue
+		}
+		switch op := rnd.Intn(10); {
+		case op < 5:
+			from.follow(to.key.ID())
+		case op < 8:
+			from.unfollow(to.key.ID())
+		case op < 9:
+			from.block(to.key.ID())
+		default:
+			from.unblock(to.key.ID())
+		}
+
+		if i%10 == 0 {
+			r.True(compare(), "op %d", i)
+		}
+	}
+
+	// once everything is indexed the patched graph needs to be the same as a full rebuild
+	other := NewBuilder(testutils.NewRelativeTimeLogger(nil), b.kv, nil)
+	r.Eventually(func() bool {
+		other.cacheLock.Lock()
+		other.cachedGraph = nil
+		other.cacheLock.Unlock()
+		rebuilt, err := other.Build()
+		r.NoError(err)
+
+		g, err := b.Build()
+		r.NoError(err)
+		g.Lock()
+		defer g.Unlock()
+
+		for _, p := range peers {
+			for _, q := range peers {
+				if relationOf(g, p.key.ID(), q.key.ID()) != relationOf(rebuilt, p.key.ID(), q.key.ID()) {
+					return false
+				}
+			}
+		}
+		return true
+	}, 5*time.Second, 50*time.Millisecond)
+
+	r.True(compare())
+}
+
+func relationOf(g *Graph, from, to refs.FeedRef) idxRelationState {
+	nFrom, has := g.getNode(from)
+	if !has {
+		return idxRelValueNone
+	}
+	nTo, has := g.getNode(to)
+	if !has {
+		return idxRelValueNone
+	}
+	return g.relation(nFrom.ID(), nTo.ID())
+}
@@ -0,0 +1,157 @@
+// SPDX-FileCopyrightText: 2021 The Go-SSB Authors
+//
+// SPDX-License-Identifier: MIT
+
+package graph
+
+import (
+	"math"
+
+	"gonum.org/v1/gonum/graph"
+	"gonum.org/v1/gonum/graph/simple"
+
+	refs "github.com/ssbc/go-ssb-refs"
+	"github.com/ssbc/go-ssb/internal/storedrefs"
+)
+
+// newEdge returns the edge for a relation between from and to, or nil for idxRelValueNone
+func newEdge(from, to *contactNode, state idxRelationState) graph.WeightedEdge {
+	switch state {
+	case idxRelValueFollowing:
+		return contactEdge{
+			WeightedEdge: simple.WeightedEdge{F: from, T: to, W: 1},
+			isBlock:      false,
+		}
+	case idxRelValueBlocking:
+		return contactEdge{
+			WeightedEdge: simple.WeightedEdge{F: from, T: to, W: math.Inf(1)},
+			isBlock:      true,
+		}
+	case idxRelValueMetafeed:
+		return metafeedEdge{
+			WeightedEdge: simple.WeightedEdge{F: from, T: to, W: 0.1},
+		}
+	}
+	return nil
+}
+
+// node returns the node of feed and adds it to the graph if it isn't part of it yet.
+func (g *Graph) node(feed refs.FeedRef) *contactNode {
+	addr := storedrefs.Feed(feed)
+	n, has := g.lookup[addr]
+	if !has {
+		n = &contactNode{g.NewNode(), feed, ""}
+		g.AddNode(n)
+		g.lookup[addr] = n
+	}
+	return n
+}
+
+// relation returns the state of the edge between the node IDs from and to
+func (g *Graph) relation(from, to int64) idxRelationState {
+	edg := g.WeightedEdge(from, to)
+	switch e := edg.(type) {
+	case contactEdge:
+		if e.isBlock {
+			return idxRelValueBlocking
+		}
+		return idxRelValueFollowing
+	case metafeedEdge:
+		return idxRelValueMetafeed
+	}
+	return idxRelValueNone
+}
+
+// graphObserver keeps state that is derived from the graph up to date while it is patched.
+// All methods are called while the graph is locked.
+type graphObserver interface {
+	// reset recomputes everything, for instance after the graph was rebuilt
+	reset(g *Graph)
+
+	// relationChanged is called after the edge from -> to changed from old to state
+	relationChanged(g *Graph, from, to int64, old, state idxRelationState)
+
+	// metafeedChanged is called after feed announced a new metafeed
+	metafeedChanged(g *Graph, feed, old int64, had bool, metafeed int64)
+}
+
+// setRelation patches the edge between from and to, instead of rebuilding the whole graph.
+// The observers are notified accordingly.
+// Public relations are ignored if the two feeds already have a private one.
+func (g *Graph) setRelation(from, to refs.FeedRef, state idxRelationState, observers []graphObserver) {
+	g.patch(from, to, state, false, observers)
+}
+
+// setPrivateRelation is like setRelation but for contacts that were published privately
+func (g *Graph) setPrivateRelation(from, to refs.FeedRef, state idxRelationState, observers []graphObserver) {
+	g.patch(from, to, state, true, observers)
+}
+
+func (g *Graph) patch(from, to refs.FeedRef, state idxRelationState, private bool, observers []graphObserver) {
+	if from.Equal(to) {
+		// contact self?!
+		return
+	}
+
+	g.Mutex.Lock()
+	defer g.Mutex.Unlock()
+
+	nFrom, nTo := g.node(from), g.node(to)
+	pair := [2]int64{nFrom.ID(), nTo.ID()}
+	if private {
+		g.private[pair] = struct{}{}
+	} else if _, overridden := g.private[pair]; overridden {
+		return
+	}
+
+	old := g.relation(nFrom.ID(), nTo.ID())
+	if old == state {
+		return
+	}
+
+	if edg := newEdge(nFrom, nTo, state); edg != nil {
+		g.SetWeightedEdge(edg)
+	} else {
+		g.RemoveEdge(nFrom.ID(), nTo.ID())
+	}
+
+	for _, o := range observers {
+		o.relationChanged(g, nFrom.ID(), nTo.ID(), old, state)
+	}
+}
+
+// setMetafeed records that feed announced metafeed as its metafeed.
+func (g *Graph) setMetafeed(feed, metafeed refs.FeedRef, observers []graphObserver) {
+	g.Mutex.Lock()
+	defer g.Mutex.Unlock()
+
+	nFeed, nMeta := g.node(feed), g.node(metafeed)
+	old, had := g.addMetafeed(nFeed.ID(), nMeta.ID())
+	if had && old == nMeta.ID() {
+		return
+	}
+
+	for _, o := range observers {
+		o.metafeedChanged(g, nFeed.ID(), old, had, nMeta.ID())
+	}
+}
+
+// addMetafeed updates the announcement maps and returns the previously announced metafeed, if there was one
+func (g *Graph) addMetafeed(feed, metafeed int64) (int64, bool) {
+	old, had := g.metafeeds[feed]
+	if had {
+		delete(g.announcers[old], feed)
+		if len(g.announcers[old]) == 0 {
+			delete(g.announcers, old)
+		}
+	}
+
+	g.metafeeds[feed] = metafeed
+	announcers, has := g.announcers[metafeed]
+	if !has {
+		announcers = make(map[int64]struct{})
+		g.announcers[metafeed] = announcers
+	}
+	announcers[feed] = struct{}{}
+	return old, had
+}
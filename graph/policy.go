@@ -0,0 +1,353 @@
+// SPDX-FileCopyrightText: 2021 The Go-SSB Authors
+//
+// SPDX-License-Identifier: MIT
+
+package graph
+
+import (
+	"fmt"
+	"math"
+
+	refs "github.com/ssbc/go-ssb-refs"
+)
+
+// PolicyRoot is a feed whose contacts are trusted to decide what to replicate
+type PolicyRoot struct {
+	Feed refs.FeedRef `json:"feed"`
+
+	// Hops is how far the follows of the root are walked, like the max argument of Builder.Hops
+	Hops int `json:"hops"`
+}
+
+// Policy decides which feeds are replicated, based on the contacts of one or more trust roots.
+// For instance our own feed with two hops and the feed of a community pub with one.
+type Policy struct {
+	Roots []PolicyRoot
+
+	// Allow lists feeds that are replicated regardless of the graph, unless they are blocked.
+	Allow []refs.FeedRef
+
+	// BlockStrength is the weight of a block by a feed that is in range of a root with hop 0 (followed by it).
+	// The weight is multiplied by BlockStrength for every further hop. Blocks by the roots weigh 1.
+	// A feed is blocked once the weights of the blocks against it add up to 1 or more.
+	// With 0 only the blocks by the roots count.
+	BlockStrength float64
+}
+
+// PolicyChange is published by a PolicyEngine for every feed whose replication decision changed
+type PolicyChange struct {
+	Feed refs.FeedRef
+
+	Replicate bool
+	Blocked   bool
+}
+
+// Explanation says why a feed is replicated or not
+type Explanation struct {
+	Feed refs.FeedRef `json:"feed"`
+
+	Replicate bool `json:"replicate"`
+	Blocked   bool `json:"blocked"`
+
+	// Root is true if the feed is one of the roots of the policy
+	Root bool `json:"root"`
+
+	// Allowed is true if the feed is on the allow-list of the policy
+	Allowed bool `json:"allowed"`
+
+	// Hops has the distance to each root, in the order of the policy
+	Hops []RootDistance `json:"hops"`
+
+	// Blocks are the blocks against the feed that count
+	Blocks []BlockWeight `json:"blocks"`
+
+	// BlockWeight is the sum of the weights of Blocks
+	BlockWeight float64 `json:"blockWeight"`
+}
+
+// RootDistance is the distance of a feed to one of the roots of a policy
+type RootDistance struct {
+	Root refs.FeedRef `json:"root"`
+	Max  int          `json:"max"`
+
+	// Hops is -1 if the feed is out of range
+	Hops int `json:"hops"`
+
+	// Path is a shortest path from the root to the feed
+	Path []refs.FeedRef `json:"path,omitempty"`
+}
+
+// BlockWeight is a block against a feed and how much it counts
+type BlockWeight struct {
+	By refs.FeedRef `json:"by"`
+
+	// Hops is the distance of the blocking feed to the closest root, -1 if it is a root itself
+	Hops int `json:"hops"`
+
+	Weight float64 `json:"weight"`
+}
+
+// PolicyEngine keeps the replication decisions of a Policy up to date, as new contacts are indexed.
+type PolicyEngine struct {
+	b      *BadgerBuilder
+	policy Policy
+	notify func([]PolicyChange)
+
+	trackers []*HopTracker
+
+	// the node IDs of the roots and the allow-list, set by reset
+	roots map[int64]struct{}
+	allow map[int64]struct{}
+
+	// the graph and node IDs that need to be evaluated, while the trackers update
+	current *Graph
+	pending map[int64]struct{}
+
+	// the decision per feed, as last published
+	published map[int64]PolicyChange
+}
+
+// SubscribePolicy calls fn with the decisions of p for every feed that is replicated or blocked.
+// After that fn is called with every change to them, as new contacts and metafeed messages are indexed.
+// Like SubscribeHops, this uses the graph of BuildWithPrivate and fn must not call back into the builder.
+// The returned engine can explain the decisions until the subscription is canceled.
+func (b *BadgerBuilder) SubscribePolicy(p Policy, fn func([]PolicyChange)) (*PolicyEngine, func(), error) {
+	if len(p.Roots) == 0 {
+		return nil, nil, fmt.Errorf("graph/policy: need at least one root")
+	}
+	if p.BlockStrength < 0 || p.BlockStrength > 1 {
+		return nil, nil, fmt.Errorf("graph/policy: block strength needs to be between 0 and 1, not %f", p.BlockStrength)
+	}
+
+	e := &PolicyEngine{
+		b:      b,
+		policy: p,
+		notify: fn,
+
+		published: make(map[int64]PolicyChange),
+	}
+	for _, r := range p.Roots {
+		e.trackers = append(e.trackers, newHopTracker(r.Feed, r.Hops, e.hopsChanged))
+	}
+
+	cancel, err := b.subscribe(e, true)
+	if err != nil {
+		return nil, nil, err
+	}
+	return e, cancel, nil
+}
+
+// Explain returns the reasoning behind the decision for feed
+func (e *PolicyEngine) Explain(feed refs.FeedRef) (Explanation, error) {
+	g, err := e.b.BuildWithPrivate()
+	if err != nil {
+		return Explanation{}, err
+	}
+
+	g.Lock()
+	defer g.Unlock()
+
+	id := int64(-1)
+	if n, has := g.getNode(feed); has {
+		id = n.ID()
+	}
+	return e.evaluate(g, id, feed, true), nil
+}
+
+func (e *PolicyEngine) reset(g *Graph) {
+	e.begin(g)
+
+	e.roots = make(map[int64]struct{})
+	for _, r := range e.policy.Roots {
+		e.roots[g.node(r.Feed).ID()] = struct{}{}
+	}
+	e.allow = make(map[int64]struct{})
+	for _, a := range e.policy.Allow {
+		e.allow[g.node(a).ID()] = struct{}{}
+	}
+
+	// every feed we published before is compared with the new state
+	for id := range e.published {
+		e.pending[id] = struct{}{}
+	}
+	for id := range e.roots {
+		e.pending[id] = struct{}{}
+	}
+	for id := range e.allow {
+		e.pending[id] = struct{}{}
+	}
+
+	for _, t := range e.trackers {
+		t.reset(g)
+	}
+	// the blocks of everything in range might weigh differently now
+	if e.policy.BlockStrength > 0 {
+		for _, t := range e.trackers {
+			for k := range t.dist {
+				e.blockedBy(g, k.id)
+			}
+		}
+	}
+	e.finish(g)
+}
+
+func (e *PolicyEngine) relationChanged(g *Graph, from, to int64, old, state idxRelationState) {
+	e.begin(g)
+	for _, t := range e.trackers {
+		t.relationChanged(g, from, to, old, state)
+	}
+	if old == idxRelValueBlocking || state == idxRelValueBlocking {
+		e.pending[to] = struct{}{}
+	}
+	e.finish(g)
+}
+
+func (e *PolicyEngine) metafeedChanged(g *Graph, feed, old int64, had bool, metafeed int64) {
+	e.begin(g)
+	for _, t := range e.trackers {
+		t.metafeedChanged(g, feed, old, had, metafeed)
+	}
+	e.finish(g)
+}
+
+func (e *PolicyEngine) begin(g *Graph) {
+	e.current = g
+	e.pending = make(map[int64]struct{})
+}
+
+// hopsChanged is the notify func of the trackers
+func (e *PolicyEngine) hopsChanged(changes []HopsChange) {
+	for _, c := range changes {
+		n, has := e.current.getNode(c.Feed)
+		if !has {
+			continue
+		}
+		e.pending[n.ID()] = struct{}{}
+
+		// the weight of its blocks depends on the distance
+		if e.policy.BlockStrength > 0 {
+			e.blockedBy(e.current, n.ID())
+		}
+	}
+}
+
+// blockedBy adds the feeds that id blocks to the pending ones
+func (e *PolicyEngine) blockedBy(g *Graph, id int64) {
+	nodes := g.From(id)
+	for nodes.Next() {
+		to := nodes.Node().ID()
+		if g.relation(id, to) == idxRelValueBlocking {
+			e.pending[to] = struct{}{}
+		}
+	}
+}
+
+// finish evaluates the pending node IDs and publishes the changed decisions
+func (e *PolicyEngine) finish(g *Graph) {
+	var changes []PolicyChange
+	for id := range e.pending {
+		n, ok := g.Node(id).(*contactNode)
+		if !ok {
+			continue
+		}
+
+		expl := e.evaluate(g, id, n.feed, false)
+		pc := PolicyChange{
+			Feed:      n.feed,
+			Replicate: expl.Replicate,
+			Blocked:   expl.Blocked,
+		}
+
+		prev, had := e.published[id]
+		if !had {
+			prev = PolicyChange{Feed: n.feed}
+		}
+		if prev == pc {
+			continue
+		}
+
+		if !pc.Replicate && !pc.Blocked {
+			delete(e.published, id)
+		} else {
+			e.published[id] = pc
+		}
+		changes = append(changes, pc)
+	}
+	e.pending = nil
+	e.current = nil
+
+	if len(changes) > 0 && e.notify != nil {
+		e.notify(changes)
+	}
+}
+
+// evaluate applies the policy to the node id, which is -1 if feed is not part of the graph.
+// The paths to the roots are only looked up if withPaths is set.
+func (e *PolicyEngine) evaluate(g *Graph, id int64, feed refs.FeedRef, withPaths bool) Explanation {
+	expl := Explanation{
+		Feed:   feed,
+		Blocks: []BlockWeight{},
+	}
+	_, expl.Root = e.roots[id]
+	_, expl.Allowed = e.allow[id]
+
+	inRange := false
+	for i, t := range e.trackers {
+		rd := RootDistance{
+			Root: e.policy.Roots[i].Feed,
+			Max:  e.policy.Roots[i].Hops,
+			Hops: -1,
+		}
+		if id >= 0 {
+			rd.Hops = t.hops(id)
+		}
+		if rd.Hops >= 0 {
+			inRange = true
+			if withPaths {
+				rd.Path = t.path(g, id)
+			}
+		}
+		expl.Hops = append(expl.Hops, rd)
+	}
+
+	if id >= 0 {
+		nodes := g.To(id)
+		for nodes.Next() {
+			from, ok := nodes.Node().(*contactNode)
+			if !ok || g.relation(from.ID(), id) != idxRelValueBlocking {
+				continue
+			}
+
+			bw := BlockWeight{By: from.feed, Hops: -1}
+			if _, isRoot := e.roots[from.ID()]; isRoot {
+				bw.Weight = 1
+			} else if e.policy.BlockStrength > 0 {
+				bw.Hops = e.closest(from.ID())
+				if bw.Hops < 0 {
+					continue
+				}
+				bw.Weight = math.Pow(e.policy.BlockStrength, float64(bw.Hops+1))
+			} else {
+				continue
+			}
+
+			expl.Blocks = append(expl.Blocks, bw)
+			expl.BlockWeight += bw.Weight
+		}
+	}
+
+	expl.Blocked = expl.BlockWeight >= 1
+	expl.Replicate = !expl.Blocked && (expl.Root || expl.Allowed || inRange)
+	return expl
+}
+
+// closest returns the smallest distance of the node id to one of the roots, or -1 if it is out of range of all of them
+func (e *PolicyEngine) closest(id int64) int {
+	best := -1
+	for _, t := range e.trackers {
+		if d := t.hops(id); d >= 0 && (best < 0 || d < best) {
+			best = d
+		}
+	}
+	return best
+}
@@ -0,0 +1,158 @@
+// SPDX-FileCopyrightText: 2021 The Go-SSB Authors
+//
+// SPDX-License-Identifier: MIT
+
+package graph
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	refs "github.com/ssbc/go-ssb-refs"
+)
+
+// policyState applies the published changes of an engine, like a replication lister would
+type policyState map[string]PolicyChange
+
+func (ps policyState) apply(changes []PolicyChange) {
+	for _, c := range changes {
+		if !c.Replicate && !c.Blocked {
+			delete(ps, c.Feed.String())
+			continue
+		}
+		ps[c.Feed.String()] = c
+	}
+}
+
+func TestPolicy(t *testing.T) {
+	r := require.New(t)
+
+	tc := makeBadger(t)
+	b := tc.gbuilder.(*BadgerBuilder)
+
+	alice := tc.newPublisher(t)
+	pub := tc.newPublisher(t)
+	bob := tc.newPublisher(t)
+	claire := tc.newPublisher(t)
+	dave := tc.newPublisher(t)
+	erin := tc.newPublisher(t)
+	frank := tc.newPublisher(t)
+
+	p := Policy{
+		Roots: []PolicyRoot{
+			{Feed: alice.key.ID(), Hops: 1},
+			{Feed: pub.key.ID(), Hops: 0},
+		},
+		Allow:         []refs.FeedRef{erin.key.ID()},
+		BlockStrength: 0.5,
+	}
+
+	state := make(policyState)
+	engine, cancel, err := b.SubscribePolicy(p, state.apply)
+	r.NoError(err)
+	defer cancel()
+
+	g, err := b.BuildWithPrivate()
+	r.NoError(err)
+
+	replicated := func(ps ...*publisher) map[*publisher]PolicyChange {
+		m := make(map[*publisher]PolicyChange)
+		for _, p := range ps {
+			m[p] = PolicyChange{Replicate: true}
+		}
+		return m
+	}
+	expect := func(want map[*publisher]PolicyChange) {
+		r.Eventually(func() bool {
+			g.Lock()
+			defer g.Unlock()
+			if len(state) != len(want) {
+				return false
+			}
+			for p, pc := range want {
+				pc.Feed = p.key.ID()
+				if state[p.key.ID().String()] != pc {
+					return false
+				}
+			}
+			return true
+		}, 5*time.Second, 10*time.Millisecond, "state: %v", state)
+	}
+
+	// the roots and the allow-list
+	expect(replicated(alice, pub, erin))
+
+	alice.follow(bob.key.ID())
+	bob.follow(alice.key.ID())
+	bob.follow(claire.key.ID())
+	pub.follow(dave.key.ID())
+	expect(replicated(alice, pub, erin, bob, claire, dave))
+
+	// a friend's block isn't enough
+	bob.block(dave.key.ID())
+	claire.block(dave.key.ID())
+	expect(replicated(alice, pub, erin, bob, claire, dave))
+
+	expl, err := engine.Explain(dave.key.ID())
+	r.NoError(err)
+	r.True(expl.Replicate)
+	r.False(expl.Blocked)
+	r.Len(expl.Hops, 2)
+	r.Equal(-1, expl.Hops[0].Hops)
+	r.Equal(0, expl.Hops[1].Hops)
+	r.Len(expl.Hops[1].Path, 2)
+	r.True(expl.Hops[1].Path[0].Equal(pub.key.ID()))
+	r.True(expl.Hops[1].Path[1].Equal(dave.key.ID()))
+	r.Len(expl.Blocks, 2)
+	r.InDelta(0.75, expl.BlockWeight, 0.0001)
+
+	// but together with a second friend it is
+	alice.follow(frank.key.ID())
+	frank.block(dave.key.ID())
+	blocked := replicated(alice, pub, erin, bob, claire, frank)
+	blocked[dave] = PolicyChange{Blocked: true}
+	expect(blocked)
+
+	expl, err = engine.Explain(dave.key.ID())
+	r.NoError(err)
+	r.True(expl.Blocked)
+	r.False(expl.Replicate)
+	r.InDelta(1.25, expl.BlockWeight, 0.0001)
+
+	// without frank in range his block does not count anymore
+	alice.unfollow(frank.key.ID())
+	expect(replicated(alice, pub, erin, bob, claire, dave))
+
+	// a root's block always counts, also for the allow-list
+	pub.block(erin.key.ID())
+	blocked = replicated(alice, pub, bob, claire, dave)
+	blocked[erin] = PolicyChange{Blocked: true}
+	expect(blocked)
+
+	expl, err = engine.Explain(erin.key.ID())
+	r.NoError(err)
+	r.True(expl.Allowed)
+	r.True(expl.Blocked)
+	r.Equal([]BlockWeight{{By: pub.key.ID(), Hops: -1, Weight: 1}}, expl.Blocks)
+
+	// the path to claire goes through bob
+	expl, err = engine.Explain(claire.key.ID())
+	r.NoError(err)
+	r.Equal(1, expl.Hops[0].Hops)
+	r.Len(expl.Hops[0].Path, 3)
+	r.True(expl.Hops[0].Path[1].Equal(bob.key.ID()))
+
+	// a fresh engine comes to the same decisions
+	fresh := make(policyState)
+	_, cancelFresh, err := b.SubscribePolicy(p, fresh.apply)
+	r.NoError(err)
+	cancelFresh()
+	g.Lock()
+	r.Equal(fresh, state)
+	g.Unlock()
+
+	_, _, err = b.SubscribePolicy(Policy{}, nil)
+	r.Error(err, "no roots")
+}
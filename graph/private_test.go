@@ -0,0 +1,108 @@
+// SPDX-FileCopyrightText: 2021 The Go-SSB Authors
+//
+// SPDX-License-Identifier: MIT
+
+package graph
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/ssbc/margaret"
+	"github.com/stretchr/testify/require"
+
+	refs "github.com/ssbc/go-ssb-refs"
+)
+
+func TestPrivateContacts(t *testing.T) {
+	if os.Getenv("LIBRARIAN_WRITEALL") != "0" {
+		t.Fatal("please 'export LIBRARIAN_WRITEALL=0' for this test to pass")
+		// TODO: expose index flushing
+	}
+
+	r := require.New(t)
+	ctx := context.Background()
+
+	tc := makeBadger(t)
+	b := tc.gbuilder.(*BadgerBuilder)
+
+	alice := tc.newPublisher(t)
+	bob := tc.newPublisher(t)
+	claire := tc.newPublisher(t)
+
+	state := make(hopsState)
+	cancel, err := b.SubscribeHops(alice.key.ID(), 1, state.apply)
+	r.NoError(err)
+	defer cancel()
+
+	seqs, privSink := b.OpenPrivateContactsIndex("test")
+	defer seqs.Close()
+
+	// the sink gets decrypted messages
+	var privSeq int64
+	pourPrivate := func(author, contact refs.FeedRef, following, blocking bool) {
+		content, err := json.Marshal(refs.Contact{Type: "contact", Contact: contact, Following: following, Blocking: blocking})
+		r.NoError(err)
+
+		var msg refs.KeyValueRaw
+		msg.Value.Author = author
+		msg.Value.Content = content
+		r.NoError(privSink.Pour(ctx, margaret.WrapWithSeq(msg, privSeq)))
+		privSeq++
+	}
+
+	alice.follow(bob.key.ID())
+	alice.follow(claire.key.ID())
+	r.Eventually(func() bool {
+		g, err := b.Build()
+		r.NoError(err)
+		return g.Follows(alice.key.ID(), bob.key.ID()) && g.Follows(alice.key.ID(), claire.key.ID())
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// privately, alice blocks bob
+	pourPrivate(alice.key.ID(), bob.key.ID(), false, true)
+
+	public, err := b.Build()
+	r.NoError(err)
+	r.True(public.Follows(alice.key.ID(), bob.key.ID()), "private contacts leaked into the public graph")
+	r.False(public.Blocks(alice.key.ID(), bob.key.ID()))
+	r.Equal(0, public.BlockedList(alice.key.ID()).Count())
+	r.Equal(2, b.Hops(alice.key.ID(), 1).Count())
+
+	withPrivate, err := b.BuildWithPrivate()
+	r.NoError(err)
+	r.True(withPrivate.Blocks(alice.key.ID(), bob.key.ID()))
+	r.True(withPrivate.BlockedList(alice.key.ID()).Has(bob.key.ID()))
+
+	withPrivate.Lock()
+	r.Equal(HopsChange{Feed: bob.key.ID(), Hops: -1, Blocked: true}, state[bob.key.ID().String()])
+	withPrivate.Unlock()
+
+	// the private relation takes precedence over public updates
+	alice.follow(bob.key.ID())
+	alice.unfollow(claire.key.ID())
+	r.Eventually(func() bool {
+		return !public.Follows(alice.key.ID(), claire.key.ID())
+	}, 5*time.Second, 10*time.Millisecond)
+	r.True(withPrivate.Blocks(alice.key.ID(), bob.key.ID()))
+	r.False(withPrivate.Follows(alice.key.ID(), claire.key.ID()))
+
+	// and also when the graph is read from disk
+	r.NoError(seqs.Close())
+	b.cacheLock.Lock()
+	b.cachedWithPrivate = nil
+	b.cacheLock.Unlock()
+	reloaded, err := b.BuildWithPrivate()
+	r.NoError(err)
+	r.True(reloaded.Blocks(alice.key.ID(), bob.key.ID()))
+	r.False(reloaded.Follows(alice.key.ID(), claire.key.ID()))
+
+	// deleting the author drops the private relations, too
+	r.NoError(b.DeleteAuthor(alice.key.ID()))
+	reloaded, err = b.BuildWithPrivate()
+	r.NoError(err)
+	r.False(reloaded.Blocks(alice.key.ID(), bob.key.ID()))
+}
@@ -0,0 +1,567 @@
+// SPDX-FileCopyrightText: 2021 The Go-SSB Authors
+//
+// SPDX-License-Identifier: MIT
+
+package declared
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+
+	"github.com/dgraph-io/badger/v3"
+	"github.com/dgraph-io/sroar"
+	"github.com/ssbc/go-luigi"
+	"github.com/ssbc/margaret"
+	librarian "github.com/ssbc/margaret/indexes"
+	"github.com/ssbc/margaret/multilog"
+	"github.com/ssbc/margaret/multilog/roaring"
+	multibadger "github.com/ssbc/margaret/multilog/roaring/badger"
+
+	refs "github.com/ssbc/go-ssb-refs"
+)
+
+// Decrypter is used for the indexes that include private messages, usually a *private.Manager
+type Decrypter interface {
+	DecryptMessage(refs.Message) ([]byte, error)
+}
+
+// the keys of an index in the shared badger db:
+// decl-spec:<name> is the spec it was built with, decl-state:<name> the last receive log sequence it looked at.
+// The values are in decl-bmap-<name>: as sublogs or in decl-kv-<name>:<value> as the latest sequence.
+const (
+	specPrefix  = "decl-spec:"
+	statePrefix = "decl-state:"
+	bmapPrefix  = "decl-bmap-"
+	kvPrefix    = "decl-kv-"
+)
+
+// ErrUnknownIndex is returned for lookups of indexes that were not declared
+var ErrUnknownIndex = errors.New("declared: unknown index")
+
+// ErrNotFound is returned by Get if no message has the value
+var ErrNotFound = errors.New("declared: value not found")
+
+// Registry holds the declared indexes of a bot
+type Registry struct {
+	db      *badger.DB
+	rxLog   margaret.Log
+	unboxer Decrypter
+
+	mu      sync.RWMutex
+	indexes map[string]*index
+}
+
+// NewRegistry returns a registry that keeps its indexes in db and fills them from rxLog.
+// unboxer can be nil if no index includes private messages.
+func NewRegistry(db *badger.DB, rxLog margaret.Log, unboxer Decrypter) *Registry {
+	return &Registry{
+		db:      db,
+		rxLog:   rxLog,
+		unboxer: unboxer,
+		indexes: make(map[string]*index),
+	}
+}
+
+type index struct {
+	spec Spec
+
+	// mu is held while catching up, so that only one query fills the index
+	mu sync.Mutex
+
+	// seq is the last receive log sequence that was indexed
+	seq int64
+
+	mlog *roaring.MultiLog // only for KindBitmap
+}
+
+// Declare adds an index. Nothing is indexed until it is queried.
+// If the index was built with a different spec before, it is dropped and starts over.
+// Declaring the same spec twice is fine, a different spec with the same name is an error.
+func (r *Registry) Declare(spec Spec) error {
+	if err := spec.Validate(); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.declare(spec)
+}
+
+func (r *Registry) declare(spec Spec) error {
+	specData, err := json.Marshal(spec)
+	if err != nil {
+		return err
+	}
+
+	if existing, has := r.indexes[spec.Name]; has {
+		existingData, err := json.Marshal(existing.spec)
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(specData, existingData) {
+			return fmt.Errorf("declared: index %s was already declared differently", spec.Name)
+		}
+		return nil
+	}
+
+	var stored []byte
+	err = r.db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get([]byte(specPrefix + spec.Name))
+		if err != nil {
+			if errors.Is(err, badger.ErrKeyNotFound) {
+				return nil
+			}
+			return err
+		}
+		stored, err = item.ValueCopy(nil)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("declared: failed to load spec of %s: %w", spec.Name, err)
+	}
+
+	if !bytes.Equal(stored, specData) {
+		if err := dropIndex(r.db, spec.Name); err != nil {
+			return err
+		}
+		err = r.db.Update(func(txn *badger.Txn) error {
+			return txn.Set([]byte(specPrefix+spec.Name), specData)
+		})
+		if err != nil {
+			return fmt.Errorf("declared: failed to store spec of %s: %w", spec.Name, err)
+		}
+	}
+
+	idx := &index{spec: spec}
+	if idx.seq, err = r.loadState(spec.Name); err != nil {
+		return err
+	}
+
+	if spec.Kind == KindBitmap {
+		idx.mlog, err = multibadger.NewShared(r.db, []byte(bmapPrefix+spec.Name+":"))
+		if err != nil {
+			return fmt.Errorf("declared: failed to open index %s: %w", spec.Name, err)
+		}
+	}
+
+	r.indexes[spec.Name] = idx
+	return nil
+}
+
+// Specs returns the declared indexes, sorted by name
+func (r *Registry) Specs() []Spec {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	specs := make([]Spec, 0, len(r.indexes))
+	for _, idx := range r.indexes {
+		specs = append(specs, idx.spec)
+	}
+	sort.Slice(specs, func(i, j int) bool { return specs[i].Name < specs[j].Name })
+	return specs
+}
+
+// Progress returns the last receive log sequence the index name looked at
+func (r *Registry) Progress(name string) (int64, error) {
+	r.mu.RLock()
+	idx, has := r.indexes[name]
+	r.mu.RUnlock()
+	if !has {
+		return margaret.SeqEmpty, fmt.Errorf("%w: %s", ErrUnknownIndex, name)
+	}
+
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	return idx.seq, nil
+}
+
+// Reset empties the index name, which is filled again on the next lookup
+func (r *Registry) Reset(name string) error {
+	r.mu.RLock()
+	idx, has := r.indexes[name]
+	r.mu.RUnlock()
+	if !has {
+		return fmt.Errorf("%w: %s", ErrUnknownIndex, name)
+	}
+
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	if idx.mlog != nil {
+		addrs, err := idx.mlog.List()
+		if err != nil {
+			return fmt.Errorf("declared: failed to list values of %s: %w", name, err)
+		}
+		for _, addr := range addrs {
+			if err := idx.mlog.Delete(addr); err != nil {
+				return fmt.Errorf("declared: failed to reset %s: %w", name, err)
+			}
+		}
+	}
+	if err := dropIndex(r.db, name); err != nil {
+		return err
+	}
+	idx.seq = margaret.SeqEmpty
+	return nil
+}
+
+// Lookup returns the receive log sequences of the messages with value in the bitmap index name,
+// after it caught up with the receive log up to and including upto. An upto of -1 is the end of the log.
+func (r *Registry) Lookup(name, value string, upto int64) (*sroar.Bitmap, error) {
+	idx, err := r.get(name, KindBitmap)
+	if err != nil {
+		return nil, err
+	}
+	if err := r.catchUp(idx, upto); err != nil {
+		return nil, err
+	}
+
+	bmap, err := idx.mlog.LoadInternalBitmap(librarian.Addr(value))
+	if err != nil {
+		if errors.Is(err, multilog.ErrSublogNotFound) {
+			return sroar.NewBitmap(), nil
+		}
+		return nil, fmt.Errorf("declared: failed to load %s of %s: %w", value, name, err)
+	}
+	if upto >= 0 {
+		// another lookup might have caught up further
+		bmap.RemoveRange(uint64(upto)+1, math.MaxUint64)
+	}
+	return bmap, nil
+}
+
+// Get returns the receive log sequence of the latest message with value in the key-value index name,
+// after it caught up with the whole receive log.
+func (r *Registry) Get(name, value string) (int64, error) {
+	idx, err := r.get(name, KindKeyValue)
+	if err != nil {
+		return margaret.SeqEmpty, err
+	}
+	if err := r.catchUp(idx, -1); err != nil {
+		return margaret.SeqEmpty, err
+	}
+
+	seq := margaret.SeqEmpty
+	err = r.db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(kvKey(name, value))
+		if err != nil {
+			return err
+		}
+		return item.Value(func(v []byte) error {
+			if len(v) != 8 {
+				return fmt.Errorf("broken value of length %d", len(v))
+			}
+			seq = int64(binary.BigEndian.Uint64(v))
+			return nil
+		})
+	})
+	if err != nil {
+		if errors.Is(err, badger.ErrKeyNotFound) {
+			return margaret.SeqEmpty, ErrNotFound
+		}
+		return margaret.SeqEmpty, fmt.Errorf("declared: failed to get %s of %s: %w", value, name, err)
+	}
+	return seq, nil
+}
+
+// LookupField is Lookup for the query planer. It uses a public bitmap index of path, preferring one that is
+// restricted to typ if that isn't empty, and declares one for all types if there is none yet.
+// It returns the name of the index it used.
+func (r *Registry) LookupField(path []string, value, typ string, upto int64) (*sroar.Bitmap, string, error) {
+	name, err := r.fieldIndex(path, typ)
+	if err != nil {
+		return nil, "", err
+	}
+	bmap, err := r.Lookup(name, value, upto)
+	return bmap, name, err
+}
+
+func (r *Registry) fieldIndex(path []string, typ string) (string, error) {
+	want := PathString(path)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var found string
+	for name, idx := range r.indexes {
+		s := idx.spec
+		if s.Kind != KindBitmap || s.Private || PathString(s.Path) != want {
+			continue
+		}
+		if typ != "" && s.Type == typ {
+			return name, nil
+		}
+		if s.Type == "" && (found == "" || name < found) {
+			found = name
+		}
+	}
+	if found != "" {
+		return found, nil
+	}
+
+	sum := sha256.Sum256([]byte(want))
+	spec := Spec{
+		Name: "auto-" + hex.EncodeToString(sum[:8]),
+		Path: path,
+		Kind: KindBitmap,
+	}
+	if err := spec.Validate(); err != nil {
+		return "", err
+	}
+	if err := r.declare(spec); err != nil {
+		return "", err
+	}
+	return spec.Name, nil
+}
+
+func (r *Registry) get(name string, kind Kind) (*index, error) {
+	r.mu.RLock()
+	idx, has := r.indexes[name]
+	r.mu.RUnlock()
+	if !has {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownIndex, name)
+	}
+	if idx.spec.Kind != kind {
+		return nil, fmt.Errorf("declared: index %s is a %s index", name, idx.spec.Kind)
+	}
+	return idx, nil
+}
+
+// catchUp indexes the messages of the receive log the index hasn't seen yet, up to and including upto
+func (r *Registry) catchUp(idx *index, upto int64) error {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	if upto < 0 {
+		upto = r.rxLog.Seq()
+	}
+	if upto <= idx.seq {
+		return nil
+	}
+
+	src, err := r.rxLog.Query(
+		margaret.Gt(idx.seq),
+		margaret.Lte(upto),
+		margaret.SeqWrap(true),
+	)
+	if err != nil {
+		return fmt.Errorf("declared: failed to query receive log: %w", err)
+	}
+
+	var wb *badger.WriteBatch
+	if idx.spec.Kind == KindKeyValue {
+		wb = r.db.NewWriteBatch()
+		defer wb.Cancel()
+	}
+
+	last := idx.seq
+	for {
+		v, err := src.Next(context.TODO())
+		if err != nil {
+			if luigi.IsEOS(err) {
+				break
+			}
+			return fmt.Errorf("declared: failed to read receive log: %w", err)
+		}
+
+		sw, ok := v.(margaret.SeqWrapper)
+		if !ok {
+			return fmt.Errorf("declared: unexpected receive log value %T", v)
+		}
+		rxSeq := sw.Seq()
+		last = rxSeq
+
+		for _, value := range r.extract(idx.spec, sw.Value()) {
+			if wb != nil {
+				var seqBytes [8]byte
+				binary.BigEndian.PutUint64(seqBytes[:], uint64(rxSeq))
+				err = wb.Set(kvKey(idx.spec.Name, value), seqBytes[:])
+			} else {
+				err = appendTo(idx.mlog, value, rxSeq)
+			}
+			if err != nil {
+				return fmt.Errorf("declared: failed to update %s: %w", idx.spec.Name, err)
+			}
+		}
+	}
+
+	// the values are written before the state, a crash in between only means they are indexed again
+	if wb != nil {
+		err = wb.Flush()
+	} else {
+		err = idx.mlog.Flush()
+	}
+	if err != nil {
+		return fmt.Errorf("declared: failed to write %s: %w", idx.spec.Name, err)
+	}
+
+	if err := r.storeState(idx.spec.Name, last); err != nil {
+		return err
+	}
+	idx.seq = last
+	return nil
+}
+
+// extract returns the values of a receive log entry for spec
+func (r *Registry) extract(spec Spec, v interface{}) []string {
+	msg, ok := v.(refs.Message)
+	if !ok {
+		// nulled or broken entries don't have values
+		return nil
+	}
+
+	content := msg.ContentBytes()
+	if len(content) == 0 {
+		return nil
+	}
+	if content[0] != '{' {
+		if !spec.Private || r.unboxer == nil {
+			return nil
+		}
+		decrypted, err := r.unboxer.DecryptMessage(msg)
+		if err != nil {
+			return nil
+		}
+		content = decrypted
+	}
+
+	if spec.Type != "" {
+		var typed struct {
+			Type string
+		}
+		if err := json.Unmarshal(content, &typed); err != nil || typed.Type != spec.Type {
+			return nil
+		}
+	}
+
+	return Extract(content, spec.Path)
+}
+
+func appendTo(mlog *roaring.MultiLog, value string, rxSeq int64) error {
+	sublog, err := mlog.Get(librarian.Addr(value))
+	if err != nil {
+		return err
+	}
+	_, err = sublog.Append(rxSeq)
+	return err
+}
+
+func kvKey(name, value string) []byte {
+	return []byte(kvPrefix + name + ":" + value)
+}
+
+func (r *Registry) loadState(name string) (int64, error) {
+	seq := margaret.SeqEmpty
+	err := r.db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get([]byte(statePrefix + name))
+		if err != nil {
+			if errors.Is(err, badger.ErrKeyNotFound) {
+				return nil
+			}
+			return err
+		}
+		return item.Value(func(v []byte) error {
+			if len(v) != 8 {
+				return fmt.Errorf("broken state of length %d", len(v))
+			}
+			seq = int64(binary.BigEndian.Uint64(v))
+			return nil
+		})
+	})
+	if err != nil {
+		return margaret.SeqEmpty, fmt.Errorf("declared: failed to load state of %s: %w", name, err)
+	}
+	return seq, nil
+}
+
+func (r *Registry) storeState(name string, seq int64) error {
+	var state [8]byte
+	binary.BigEndian.PutUint64(state[:], uint64(seq))
+	err := r.db.Update(func(txn *badger.Txn) error {
+		return txn.Set([]byte(statePrefix+name), state[:])
+	})
+	if err != nil {
+		return fmt.Errorf("declared: failed to store state of %s: %w", name, err)
+	}
+	return nil
+}
+
+// dropIndex removes the values and state of the index name, but not its spec
+func dropIndex(db *badger.DB, name string) error {
+	// the state is a single key, a prefix would also match the state of foo_bar for foo
+	err := db.Update(func(txn *badger.Txn) error {
+		return txn.Delete([]byte(statePrefix + name))
+	})
+	if err != nil {
+		return fmt.Errorf("declared: failed to drop state of %s: %w", name, err)
+	}
+
+	err = db.DropPrefix(
+		[]byte(bmapPrefix+name+":"),
+		[]byte(kvPrefix+name+":"),
+	)
+	if err != nil {
+		return fmt.Errorf("declared: failed to drop index %s: %w", name, err)
+	}
+	return nil
+}
+
+// DropIndexes removes all the declared indexes from db, so that they are filled again when they are queried
+func DropIndexes(db *badger.DB) error {
+	return db.DropPrefix(
+		[]byte(specPrefix),
+		[]byte(statePrefix),
+		[]byte(bmapPrefix),
+		[]byte(kvPrefix),
+	)
+}
+
+// Hold keeps the indexes from catching up and new ones from being declared until release is called.
+// It waits for the lookups that are catching up, so that everything they indexed is written to the db.
+func (r *Registry) Hold() (release func()) {
+	r.mu.Lock()
+	held := make([]*index, 0, len(r.indexes))
+	for _, idx := range r.indexes {
+		idx.mu.Lock()
+		held = append(held, idx)
+	}
+
+	return func() {
+		for _, idx := range held {
+			idx.mu.Unlock()
+		}
+		r.mu.Unlock()
+	}
+}
+
+// Close flushes and closes the bitmap indexes
+func (r *Registry) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var errs []error
+	for _, idx := range r.indexes {
+		if idx.mlog == nil {
+			continue
+		}
+		idx.mu.Lock()
+		if err := idx.mlog.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("declared: failed to close %s: %w", idx.spec.Name, err))
+		}
+		idx.mu.Unlock()
+	}
+	r.indexes = make(map[string]*index)
+	if len(errs) > 0 {
+		return errs[0]
+	}
+	return nil
+}
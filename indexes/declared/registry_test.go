@@ -0,0 +1,190 @@
+// SPDX-FileCopyrightText: 2021 The Go-SSB Authors
+//
+// SPDX-License-Identifier: MIT
+
+package declared_test
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.mindeco.de/log"
+
+	"github.com/ssbc/go-ssb/indexes/declared"
+	"github.com/ssbc/go-ssb/query"
+	"github.com/ssbc/go-ssb/sbot"
+)
+
+func TestExtract(t *testing.T) {
+	r := require.New(t)
+
+	content := []byte(`{"type":"post","channel":"go","n":3,"ok":true,"tags":["a","b","a",{"no":1}],"vote":{"link":"%x","value":1}}`)
+
+	r.Equal([]string{"go"}, declared.Extract(content, []string{"channel"}))
+	r.Equal([]string{"3"}, declared.Extract(content, []string{"n"}))
+	r.Equal([]string{"true"}, declared.Extract(content, []string{"ok"}))
+	r.Equal([]string{"a", "b"}, declared.Extract(content, []string{"tags"}))
+	r.Equal([]string{"%x"}, declared.Extract(content, []string{"vote", "link"}))
+	r.Nil(declared.Extract(content, []string{"vote"}), "objects are not values")
+	r.Nil(declared.Extract(content, []string{"nope"}))
+	r.Nil(declared.Extract(content, []string{"channel", "deeper"}))
+}
+
+func TestRegistry(t *testing.T) {
+	r := require.New(t)
+
+	tRepoPath := filepath.Join("testrun", t.Name())
+	os.RemoveAll(tRepoPath)
+
+	specs := []declared.Spec{
+		{Name: "votes", Path: []string{"vote", "link"}, Type: "vote", Kind: declared.KindBitmap},
+		{Name: "names", Path: []string{"name"}, Type: "about", Kind: declared.KindKeyValue},
+		{Name: "channels", Path: []string{"channel"}, Private: true, Kind: declared.KindBitmap},
+	}
+	opts := []sbot.Option{
+		sbot.WithInfo(log.NewNopLogger()),
+		sbot.WithRepoPath(tRepoPath),
+		sbot.DisableNetworkNode(),
+		sbot.DeclareIndex(specs...),
+	}
+	bot, err := sbot.New(opts...)
+	r.NoError(err)
+
+	publish := func(content interface{}) int64 {
+		_, err := bot.PublishLog.Publish(content)
+		r.NoError(err)
+		return bot.ReceiveLog.Seq()
+	}
+
+	target := "%AgICAgICAgICAgICAgICAgICAgICAgICAgICAgICAgI=.sha256"
+	vote1 := publish(map[string]interface{}{"type": "vote", "vote": map[string]interface{}{"link": target, "value": 1}})
+	publish(map[string]interface{}{"type": "post", "vote": map[string]interface{}{"link": target}})
+	publish(map[string]interface{}{"type": "about", "name": "arny"})
+	name2 := publish(map[string]interface{}{"type": "about", "name": "arny"})
+	public := publish(map[string]interface{}{"type": "post", "channel": "go"})
+
+	cloaked, _, err := bot.Groups.Create("just me")
+	r.NoError(err)
+	secret, err := json.Marshal(map[string]interface{}{"type": "post", "channel": "go"})
+	r.NoError(err)
+	_, err = bot.Groups.PublishTo(cloaked, secret)
+	r.NoError(err)
+	private := bot.ReceiveLog.Seq()
+
+	bmap, err := bot.Declared.Lookup("votes", target, -1)
+	r.NoError(err)
+	r.Equal([]uint64{uint64(vote1)}, bmap.ToArray(), "only the vote type")
+
+	seq, err := bot.Declared.Get("names", "arny")
+	r.NoError(err)
+	r.Equal(name2, seq, "the latest one")
+
+	_, err = bot.Declared.Get("names", "bert")
+	r.ErrorIs(err, declared.ErrNotFound)
+
+	bmap, err = bot.Declared.Lookup("channels", "go", -1)
+	r.NoError(err)
+	r.Equal([]uint64{uint64(public), uint64(private)}, bmap.ToArray())
+
+	bmap, err = bot.Declared.Lookup("channels", "go", public)
+	r.NoError(err)
+	r.Equal([]uint64{uint64(public)}, bmap.ToArray(), "not past upto")
+
+	_, err = bot.Declared.Lookup("nope", "go", -1)
+	r.ErrorIs(err, declared.ErrUnknownIndex)
+
+	_, err = bot.Declared.Lookup("names", "arny", -1)
+	r.Error(err, "wrong kind")
+
+	r.Error(bot.Declared.Declare(declared.Spec{Name: "votes", Path: []string{"vote"}, Kind: declared.KindBitmap}))
+
+	bot.Shutdown()
+	r.NoError(bot.Close())
+
+	// the progress is kept, new messages are added on the next lookup
+	bot, err = sbot.New(opts...)
+	r.NoError(err)
+
+	vote2 := publish(map[string]interface{}{"type": "vote", "vote": map[string]interface{}{"link": target, "value": 0}})
+	bmap, err = bot.Declared.Lookup("votes", target, -1)
+	r.NoError(err)
+	r.Equal([]uint64{uint64(vote1), uint64(vote2)}, bmap.ToArray())
+
+	bot.Shutdown()
+	r.NoError(bot.Close())
+
+	// a changed spec starts over
+	specs[0].Type = "post"
+	bot, err = sbot.New(opts[:3]...)
+	r.NoError(err)
+	r.NoError(bot.Declared.Declare(specs[0]))
+
+	bmap, err = bot.Declared.Lookup("votes", target, -1)
+	r.NoError(err)
+	r.Equal([]uint64{uint64(vote1) + 1}, bmap.ToArray())
+
+	bot.Shutdown()
+	r.NoError(bot.Close())
+}
+
+func TestRegistryResetKeepsOthers(t *testing.T) {
+	r := require.New(t)
+
+	tRepoPath := filepath.Join("testrun", t.Name())
+	os.RemoveAll(tRepoPath)
+
+	// the name of one is a prefix of the other
+	specs := []declared.Spec{
+		{Name: "names", Path: []string{"name"}, Type: "about", Kind: declared.KindKeyValue},
+		{Name: "names_all", Path: []string{"name"}, Kind: declared.KindKeyValue},
+	}
+	opts := []sbot.Option{
+		sbot.WithInfo(log.NewNopLogger()),
+		sbot.WithRepoPath(tRepoPath),
+		sbot.DisableNetworkNode(),
+		sbot.DeclareIndex(specs...),
+	}
+	bot, err := sbot.New(opts...)
+	r.NoError(err)
+
+	_, err = bot.PublishLog.Publish(map[string]interface{}{"type": "about", "name": "arny"})
+	r.NoError(err)
+	seq := bot.ReceiveLog.Seq()
+
+	for _, spec := range specs {
+		_, err = bot.Declared.Get(spec.Name, "arny")
+		r.NoError(err)
+	}
+	r.NoError(bot.Declared.Reset("names"))
+
+	bot.Shutdown()
+	r.NoError(bot.Close())
+
+	bot, err = sbot.New(opts...)
+	r.NoError(err)
+
+	progress, err := bot.Declared.Progress("names")
+	r.NoError(err)
+	r.EqualValues(-1, progress, "reset index kept its progress")
+
+	progress, err = bot.Declared.Progress("names_all")
+	r.NoError(err)
+	r.Equal(seq, progress, "other index lost its progress")
+
+	bot.Shutdown()
+	r.NoError(bot.Close())
+}
+
+func TestSpecValidate(t *testing.T) {
+	r := require.New(t)
+
+	r.NoError(declared.Spec{Name: "ok_1", Path: []string{"a"}, Kind: declared.KindBitmap}.Validate())
+	r.Error(declared.Spec{Name: "Not OK", Path: []string{"a"}, Kind: declared.KindBitmap}.Validate())
+	r.Error(declared.Spec{Name: "nopath", Kind: declared.KindBitmap}.Validate())
+	r.Error(declared.Spec{Name: "nokind", Path: []string{"a"}}.Validate())
+}
+
+var _ query.FieldIndexes = (*declared.Registry)(nil)
@@ -0,0 +1,124 @@
+// SPDX-FileCopyrightText: 2021 The Go-SSB Authors
+//
+// SPDX-License-Identifier: MIT
+
+// Package declared builds indexes from a declaration of what to extract from the messages, instead of a hand-written sink.
+// Like JITDB, the indexes are not updated as messages arrive but catch up with the receive log when they are queried,
+// and their progress is kept so that they only need to look at the new messages.
+package declared
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Kind is how the extracted values are stored
+type Kind string
+
+const (
+	// KindBitmap keeps the receive log sequences of all the messages per value, for equality queries.
+	KindBitmap Kind = "bitmap"
+
+	// KindKeyValue keeps the receive log sequence of the latest message per value, for unique keys.
+	KindKeyValue Kind = "keyvalue"
+)
+
+// Spec declares an index
+type Spec struct {
+	// Name identifies the index and where it is stored. Only lower-case letters, numbers, - and _ are allowed.
+	Name string `json:"name"`
+
+	// Path is the field of the content the values are taken from, like ["channel"] or ["vote", "link"].
+	// Strings, numbers and booleans are indexed, as are the ones in a list.
+	Path []string `json:"path"`
+
+	// Type restricts the index to the messages of this type, like post. Empty indexes all types.
+	Type string `json:"type,omitempty"`
+
+	// Private also indexes the content of the encrypted messages that can be decrypted.
+	// Such indexes should only be queried on behalf of the master.
+	Private bool `json:"private,omitempty"`
+
+	Kind Kind `json:"kind"`
+}
+
+var validName = regexp.MustCompile(`^[a-z0-9_-]+$`)
+
+// Validate checks that the spec can be used
+func (s Spec) Validate() error {
+	if !validName.MatchString(s.Name) {
+		return fmt.Errorf("declared: invalid index name %q", s.Name)
+	}
+	if len(s.Path) == 0 {
+		return fmt.Errorf("declared: index %s needs a path", s.Name)
+	}
+	switch s.Kind {
+	case KindBitmap, KindKeyValue:
+	default:
+		return fmt.Errorf("declared: index %s has unknown kind %q", s.Name, s.Kind)
+	}
+	return nil
+}
+
+// PathString returns the path in the dotted form, like vote.link
+func PathString(path []string) string {
+	return strings.Join(path, ".")
+}
+
+// maxValueLength skips values that are too big to be useful as keys, like whole texts
+const maxValueLength = 256
+
+// Extract returns the values of content at path. Every value is only returned once.
+func Extract(content []byte, path []string) []string {
+	raw := json.RawMessage(content)
+	for _, field := range path {
+		var obj map[string]json.RawMessage
+		if err := json.Unmarshal(raw, &obj); err != nil {
+			return nil
+		}
+		var has bool
+		raw, has = obj[field]
+		if !has {
+			return nil
+		}
+	}
+
+	var list []json.RawMessage
+	if err := json.Unmarshal(raw, &list); err != nil {
+		list = []json.RawMessage{raw}
+	}
+
+	var (
+		values []string
+		seen   = make(map[string]struct{})
+	)
+	for _, elem := range list {
+		v, ok := scalar(elem)
+		if !ok || len(v) > maxValueLength {
+			continue
+		}
+		if _, has := seen[v]; has {
+			continue
+		}
+		seen[v] = struct{}{}
+		values = append(values, v)
+	}
+	return values
+}
+
+// scalar returns strings as they are and numbers and booleans in their JSON form
+func scalar(raw json.RawMessage) (string, bool) {
+	var v interface{}
+	if err := json.Unmarshal(raw, &v); err != nil {
+		return "", false
+	}
+	switch tv := v.(type) {
+	case string:
+		return tv, true
+	case float64, bool:
+		return strings.TrimSpace(string(raw)), true
+	}
+	return "", false
+}
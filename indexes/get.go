@@ -19,9 +19,12 @@ import (
 	"github.com/ssbc/go-ssb/internal/storedrefs"
 )
 
+// GetKeyPrefix is what the keys of the get index start with
+const GetKeyPrefix = "byMsgRef"
+
 // OpenGet supplies the get(msgRef) -> rootLogSeq idx
 func OpenGet(db *badger.DB) (librarian.Index, librarian.SinkIndex) {
-	idx := libbadger.NewIndexWithKeyPrefix(db, int64(0), []byte("byMsgRef"))
+	idx := libbadger.NewIndexWithKeyPrefix(db, int64(0), []byte(GetKeyPrefix))
 	sinkIdx := librarian.NewSinkIndex(updateGetFn, idx)
 	return idx, sinkIdx
 }
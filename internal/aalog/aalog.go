@@ -0,0 +1,261 @@
+// SPDX-FileCopyrightText: 2021 The Go-SSB Authors
+//
+// SPDX-License-Identifier: MIT
+
+/*
+Package aalog reads and writes the file format of async-append-only-log, which ssb-db2 keeps its log.bipf in.
+See https://github.com/ssbc/async-append-only-log for the javascript implementation.
+
+The file is made of blocks of a fixed size (64KiB by default). A block holds records, which are never split across blocks:
+
+	<length: uint16 little endian><data: length bytes>
+
+A record with a length of zero marks the end of the data in a block, the rest of the block is zeros.
+Deleted records keep their length but their data is overwritten with zeros.
+
+The javascript side addresses records by their byte offset in the file, margaret logs by a sequence.
+Store keeps the offsets of all the records in memory, it finds them by reading the file once when it is opened.
+*/
+package aalog
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/ssbc/margaret"
+
+	"github.com/ssbc/go-ssb/internal/framelog"
+)
+
+// DefaultBlockSize is the block size that ssb-db2 uses
+const DefaultBlockSize = 64 * 1024
+
+// Store is a framelog.Store of the records in an async-append-only-log file.
+// It shouldn't be opened while a javascript process writes to the same file.
+type Store struct {
+	mu sync.Mutex
+
+	f         *os.File
+	blockSize int64
+
+	offsets []int64 // where each record starts
+	end     int64   // where the next record goes
+}
+
+var _ framelog.Store = (*Store)(nil)
+
+// Open opens the log file at path, or creates it if it doesn't exist
+func Open(path string, blockSize int64) (*Store, error) {
+	if blockSize < 64 || blockSize > 1<<16 {
+		return nil, fmt.Errorf("aalog: invalid block size %d", blockSize)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, fmt.Errorf("aalog: failed to create directory of %s: %w", path, err)
+	}
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("aalog: failed to open %s: %w", path, err)
+	}
+
+	s := &Store{
+		f:         f,
+		blockSize: blockSize,
+	}
+	if err := s.scan(); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("aalog: failed to read the records of %s: %w", path, err)
+	}
+	return s, nil
+}
+
+// scan finds the offsets of all the records
+func (s *Store) scan() error {
+	block := make([]byte, s.blockSize)
+	for start := int64(0); ; start += s.blockSize {
+		n, err := s.f.ReadAt(block, start)
+		if err != nil && !errors.Is(err, io.EOF) {
+			return err
+		}
+		if n == 0 {
+			return nil
+		}
+		for i := n; i < len(block); i++ {
+			block[i] = 0
+		}
+
+		pos := int64(0)
+		for pos+2 <= s.blockSize {
+			length := int64(binary.LittleEndian.Uint16(block[pos:]))
+			if length == 0 {
+				break
+			}
+			if pos+2+length > s.blockSize {
+				return fmt.Errorf("record at %d is longer than its block", start+pos)
+			}
+			if pos+2+length > int64(n) {
+				// the write of the last record didn't finish, drop what is there of it
+				_, err := s.f.WriteAt(make([]byte, int64(n)-pos), start+pos)
+				return err
+			}
+			s.offsets = append(s.offsets, start+pos)
+			pos += 2 + length
+			s.end = start + pos
+		}
+	}
+}
+
+// BlockSize returns the size of the blocks in the file
+func (s *Store) BlockSize() int64 { return s.blockSize }
+
+// Offset returns the byte offset of the record at seq, which is what ssb-db2 uses to address it
+func (s *Store) Offset(seq int64) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if seq < 0 || seq >= int64(len(s.offsets)) {
+		return -1, margaret.OOB
+	}
+	return s.offsets[seq], nil
+}
+
+func (s *Store) Count() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return int64(len(s.offsets))
+}
+
+func (s *Store) Read(seq int64) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data, err := s.read(seq)
+	if err != nil {
+		return nil, err
+	}
+	if isZero(data) {
+		return nil, margaret.ErrNulled
+	}
+	return data, nil
+}
+
+func (s *Store) read(seq int64) ([]byte, error) {
+	if seq >= int64(len(s.offsets)) {
+		return nil, io.EOF
+	}
+	ofst := s.offsets[seq]
+
+	var lenBuf [2]byte
+	if _, err := s.f.ReadAt(lenBuf[:], ofst); err != nil {
+		return nil, fmt.Errorf("aalog: failed to read length at %d: %w", ofst, err)
+	}
+
+	data := make([]byte, binary.LittleEndian.Uint16(lenBuf[:]))
+	if _, err := s.f.ReadAt(data, ofst+2); err != nil {
+		return nil, fmt.Errorf("aalog: failed to read record at %d: %w", ofst, err)
+	}
+	return data, nil
+}
+
+func (s *Store) Append(data []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	length := int64(len(data))
+	if length == 0 {
+		return errors.New("aalog: can't write an empty record")
+	}
+	// leave room for the end of block marker
+	if 2+length+2 > s.blockSize {
+		return fmt.Errorf("aalog: record of %d bytes doesn't fit into a block", length)
+	}
+
+	ofst := s.end
+	if inBlock := ofst % s.blockSize; inBlock+2+length+2 > s.blockSize {
+		ofst += s.blockSize - inBlock
+	}
+	if ofst%s.blockSize == 0 {
+		// the whole block is written like the javascript side does it, the zeros are the end of the data
+		if err := s.f.Truncate(ofst + s.blockSize); err != nil {
+			return fmt.Errorf("aalog: failed to add a block at %d: %w", ofst, err)
+		}
+	}
+
+	rec := make([]byte, 2+length)
+	binary.LittleEndian.PutUint16(rec, uint16(length))
+	copy(rec[2:], data)
+	if _, err := s.f.WriteAt(rec, ofst); err != nil {
+		return fmt.Errorf("aalog: failed to write record at %d: %w", ofst, err)
+	}
+
+	s.offsets = append(s.offsets, ofst)
+	s.end = ofst + 2 + length
+	return nil
+}
+
+// Null overwrites the data of the record with zeros, which is how async-append-only-log deletes records
+func (s *Store) Null(seq int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data, err := s.read(seq)
+	if err != nil {
+		return err
+	}
+	_, err = s.f.WriteAt(make([]byte, len(data)), s.offsets[seq]+2)
+	return err
+}
+
+// Replace overwrites the data of the record and fills the rest of it with zeros
+func (s *Store) Replace(seq int64, data []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	old, err := s.read(seq)
+	if err != nil {
+		return err
+	}
+	if len(data) > len(old) {
+		return fmt.Errorf("aalog: can't overwrite entry with larger data (diff:%d)", len(data)-len(old))
+	}
+
+	padded := make([]byte, len(old))
+	copy(padded, data)
+	_, err = s.f.WriteAt(padded, s.offsets[seq]+2)
+	return err
+}
+
+// Size returns how many bytes the file has
+func (s *Store) Size() (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fi, err := s.f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	return fi.Size(), nil
+}
+
+func (s *Store) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.f.Sync(); err != nil {
+		s.f.Close()
+		return err
+	}
+	return s.f.Close()
+}
+
+func isZero(data []byte) bool {
+	for _, b := range data {
+		if b != 0 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,115 @@
+// SPDX-FileCopyrightText: 2021 The Go-SSB Authors
+//
+// SPDX-License-Identifier: MIT
+
+package aalog
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ssbc/margaret"
+	mjson "github.com/ssbc/margaret/codec/json"
+	o2test "github.com/ssbc/margaret/offset2/test"
+	mtest "github.com/ssbc/margaret/test"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ssbc/go-ssb/internal/framelog"
+)
+
+func TestLogConformance(t *testing.T) {
+	newLog := func(name string, tipe interface{}) (margaret.Log, error) {
+		dir := filepath.Join("testrun", name)
+		os.RemoveAll(dir)
+		s, err := Open(filepath.Join(dir, "log.bipf"), DefaultBlockSize)
+		if err != nil {
+			return nil, err
+		}
+		return framelog.New(dir, s, mjson.New(tipe)), nil
+	}
+
+	t.Run("margaret", mtest.LogTest(newLog))
+	t.Run("pump", o2test.LogTestPump(newLog))
+	t.Run("pumplive", o2test.LogTestPumpLive(newLog))
+}
+
+func TestBlocks(t *testing.T) {
+	r := require.New(t)
+
+	path := filepath.Join("testrun", t.Name(), "log.bipf")
+	os.RemoveAll(filepath.Dir(path))
+
+	const blockSize = 64
+	s, err := Open(path, blockSize)
+	r.NoError(err)
+
+	r.NoError(s.Append([]byte("abc")))
+
+	data, err := os.ReadFile(path)
+	r.NoError(err)
+	r.Len(data, blockSize, "a whole block")
+	r.Equal([]byte{3, 0, 'a', 'b', 'c'}, data[:5])
+
+	// 20 bytes each, two of them fit next to the first record
+	var entries [][]byte
+	for i := 0; i < 6; i++ {
+		e := []byte(fmt.Sprintf("entry %014d", i))
+		r.NoError(s.Append(e))
+		entries = append(entries, e)
+	}
+	r.EqualValues(7, s.Count())
+
+	ofst, err := s.Offset(3)
+	r.NoError(err)
+	r.EqualValues(blockSize, ofst, "the 4th record doesn't fit into the first block")
+
+	r.Error(s.Append(bytes.Repeat([]byte("x"), blockSize-3)), "too large for a block")
+	r.Error(s.Append(nil))
+
+	r.NoError(s.Null(2))
+	_, err = s.Read(2)
+	r.ErrorIs(err, margaret.ErrNulled)
+
+	r.NoError(s.Replace(4, []byte("short")))
+	r.Error(s.Replace(5, bytes.Repeat([]byte("y"), 21)))
+
+	_, err = s.Read(7)
+	r.ErrorIs(err, io.EOF)
+	r.NoError(s.Close())
+
+	// the records are found again
+	s, err = Open(path, blockSize)
+	r.NoError(err)
+	r.EqualValues(7, s.Count())
+
+	got, err := s.Read(0)
+	r.NoError(err)
+	r.Equal("abc", string(got))
+	_, err = s.Read(2)
+	r.ErrorIs(err, margaret.ErrNulled)
+	got, err = s.Read(4)
+	r.NoError(err)
+	r.Equal("short", string(bytes.TrimRight(got, "\x00")))
+	got, err = s.Read(6)
+	r.NoError(err)
+	r.Equal(entries[5], got)
+
+	ofst, err = s.Offset(6)
+	r.NoError(err)
+	r.NoError(s.Append([]byte("after reopen")))
+	next, err := s.Offset(7)
+	r.NoError(err)
+	r.EqualValues(ofst+2+20, next)
+	r.NoError(s.Close())
+
+	// a record that was cut off is dropped
+	r.NoError(os.Truncate(path, next+2+5))
+	s, err = Open(path, blockSize)
+	r.NoError(err)
+	r.EqualValues(7, s.Count())
+	r.NoError(s.Close())
+}
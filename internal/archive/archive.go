@@ -0,0 +1,245 @@
+// SPDX-FileCopyrightText: 2021 The Go-SSB Authors
+//
+// SPDX-License-Identifier: MIT
+
+// Package archive reads and writes portable bundles of feeds and blobs.
+// Like the CAR files of IPFS they are a header followed by blocks, each of which is a reference and the data it names:
+//
+//	archive = frame(header) block* end
+//	block   = frame(uvarint(len(ref)) ref data)
+//	frame   = uvarint(len(content)) content
+//	end     = uvarint(0)
+//
+// The header is JSON, see Header. The reference of a block says what it holds:
+// a feed reference starts the messages of that feed, which follow in the order of their sequence.
+// A message reference holds the signed message the way it is sent to other peers, which is what it is verified from.
+// A blob reference holds the content of a blob.
+// The end marker makes archives self-delimiting, so they can be sent over streams that stay open afterwards.
+package archive
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	refs "github.com/ssbc/go-ssb-refs"
+)
+
+// FormatName identifies archives in their header
+const FormatName = "ssb-archive"
+
+// Version is the version of the format the Writer produces
+const Version = 1
+
+// maxHeaderSize limits how much is read for the header and the references of blocks
+const maxHeaderSize = 1 << 20
+
+// ErrInvalid is returned for input that isn't an archive or is damaged
+var ErrInvalid = errors.New("archive: invalid archive")
+
+// Header describes what is in an archive
+type Header struct {
+	Format  string `json:"format"`
+	Version int    `json:"version"`
+
+	// Feeds are the feeds whose messages are in the archive
+	Feeds []refs.FeedRef `json:"feeds"`
+
+	// Blobs says if the blobs the messages reference are included, as far as they were available
+	Blobs bool `json:"blobs"`
+}
+
+// Writer writes the blocks of an archive
+type Writer struct {
+	w *bufio.Writer
+
+	buf [binary.MaxVarintLen64]byte
+}
+
+// NewWriter writes the header to w and returns a Writer for the blocks.
+// The Format and Version of hdr are set by it.
+func NewWriter(w io.Writer, hdr Header) (*Writer, error) {
+	hdr.Format = FormatName
+	hdr.Version = Version
+	data, err := json.Marshal(hdr)
+	if err != nil {
+		return nil, fmt.Errorf("archive: failed to encode header: %w", err)
+	}
+
+	aw := &Writer{w: bufio.NewWriter(w)}
+	if err := aw.writeUvarint(uint64(len(data))); err != nil {
+		return nil, err
+	}
+	if _, err := aw.w.Write(data); err != nil {
+		return nil, fmt.Errorf("archive: failed to write header: %w", err)
+	}
+	return aw, nil
+}
+
+// WriteFeed starts the messages of feed
+func (aw *Writer) WriteFeed(feed refs.FeedRef) error {
+	return aw.writeBlock(feed, 0, nil)
+}
+
+// WriteMessage writes a message, data is what the message is verified from
+func (aw *Writer) WriteMessage(ref refs.MessageRef, data []byte) error {
+	return aw.writeBlock(ref, int64(len(data)), bytes.NewReader(data))
+}
+
+// WriteBlob writes size bytes of the content of the blob ref from r
+func (aw *Writer) WriteBlob(ref refs.BlobRef, size int64, r io.Reader) error {
+	return aw.writeBlock(ref, size, r)
+}
+
+// Close writes the end of the archive and flushes it. It doesn't close the underlying writer.
+func (aw *Writer) Close() error {
+	if err := aw.writeUvarint(0); err != nil {
+		return err
+	}
+	return aw.w.Flush()
+}
+
+func (aw *Writer) writeBlock(ref refs.Ref, size int64, r io.Reader) error {
+	refStr := ref.String()
+	refLen := binary.PutUvarint(aw.buf[:], uint64(len(refStr)))
+	if err := aw.writeUvarint(uint64(refLen + len(refStr) + int(size))); err != nil {
+		return err
+	}
+	if err := aw.writeUvarint(uint64(len(refStr))); err != nil {
+		return err
+	}
+	if _, err := aw.w.WriteString(refStr); err != nil {
+		return fmt.Errorf("archive: failed to write block %s: %w", ref.ShortSigil(), err)
+	}
+	if size == 0 {
+		return nil
+	}
+	n, err := io.Copy(aw.w, io.LimitReader(r, size))
+	if err != nil {
+		return fmt.Errorf("archive: failed to write block %s: %w", ref.ShortSigil(), err)
+	}
+	if n != size {
+		return fmt.Errorf("archive: block %s is %d bytes instead of %d", ref.ShortSigil(), n, size)
+	}
+	return nil
+}
+
+func (aw *Writer) writeUvarint(v uint64) error {
+	n := binary.PutUvarint(aw.buf[:], v)
+	if _, err := aw.w.Write(aw.buf[:n]); err != nil {
+		return fmt.Errorf("archive: failed to write: %w", err)
+	}
+	return nil
+}
+
+// Block is an entry of an archive
+type Block struct {
+	// Ref is a refs.FeedRef, refs.MessageRef or refs.BlobRef
+	Ref refs.Ref
+
+	// Size is the length of Data
+	Size int64
+
+	// Data reads the content of the block until the next call of Reader.Next
+	Data io.Reader
+}
+
+// Reader reads the blocks of an archive
+type Reader struct {
+	r *bufio.Reader
+
+	Header Header
+
+	// the data of the last block, which is skipped if it wasn't read
+	data *io.LimitedReader
+}
+
+// NewReader reads and checks the header of the archive in r
+func NewReader(r io.Reader) (*Reader, error) {
+	ar := &Reader{r: bufio.NewReader(r)}
+
+	hdrLen, err := binary.ReadUvarint(ar.r)
+	if err != nil {
+		return nil, fmt.Errorf("archive: failed to read header: %w", err)
+	}
+	if hdrLen > maxHeaderSize {
+		return nil, fmt.Errorf("header of %d bytes: %w", hdrLen, ErrInvalid)
+	}
+	data := make([]byte, hdrLen)
+	if _, err := io.ReadFull(ar.r, data); err != nil {
+		return nil, fmt.Errorf("archive: failed to read header: %w", err)
+	}
+
+	err = json.Unmarshal(data, &ar.Header)
+	if err != nil {
+		return nil, fmt.Errorf("undecodable header (%s): %w", err, ErrInvalid)
+	}
+	if ar.Header.Format != FormatName {
+		return nil, fmt.Errorf("not an %s: %w", FormatName, ErrInvalid)
+	}
+	if ar.Header.Version != Version {
+		return nil, fmt.Errorf("archive: unsupported version %d", ar.Header.Version)
+	}
+	return ar, nil
+}
+
+// Next returns the next block or io.EOF at the end of the archive
+func (ar *Reader) Next() (Block, error) {
+	if ar.data != nil {
+		if _, err := io.Copy(ioutil.Discard, ar.data); err != nil {
+			return Block{}, fmt.Errorf("archive: failed to skip block: %w", err)
+		}
+		if ar.data.N > 0 {
+			return Block{}, fmt.Errorf("truncated block: %w", ErrInvalid)
+		}
+		ar.data = nil
+	}
+
+	blockLen, err := binary.ReadUvarint(ar.r)
+	if err != nil {
+		if err == io.EOF {
+			return Block{}, fmt.Errorf("archive without end: %w", ErrInvalid)
+		}
+		return Block{}, fmt.Errorf("archive: failed to read block: %w", err)
+	}
+	if blockLen == 0 {
+		return Block{}, io.EOF
+	}
+
+	refLen, err := binary.ReadUvarint(ar.r)
+	if err != nil {
+		return Block{}, fmt.Errorf("truncated block (%s): %w", err, ErrInvalid)
+	}
+	refLenSize := uint64(uvarintSize(refLen))
+	if refLen > maxHeaderSize || refLenSize+refLen > blockLen {
+		return Block{}, fmt.Errorf("reference of %d bytes: %w", refLen, ErrInvalid)
+	}
+	refStr := make([]byte, refLen)
+	if _, err := io.ReadFull(ar.r, refStr); err != nil {
+		return Block{}, fmt.Errorf("truncated block (%s): %w", err, ErrInvalid)
+	}
+
+	ref, err := refs.ParseRef(string(refStr))
+	if err != nil {
+		return Block{}, fmt.Errorf("block reference %q (%s): %w", refStr, err, ErrInvalid)
+	}
+	switch ref.(type) {
+	case refs.FeedRef, refs.MessageRef, refs.BlobRef:
+	default:
+		return Block{}, fmt.Errorf("unexpected block reference %q: %w", refStr, ErrInvalid)
+	}
+
+	size := int64(blockLen - refLenSize - refLen)
+	ar.data = &io.LimitedReader{R: ar.r, N: size}
+	return Block{Ref: ref, Size: size, Data: ar.data}, nil
+}
+
+func uvarintSize(v uint64) int {
+	var buf [binary.MaxVarintLen64]byte
+	return binary.PutUvarint(buf[:], v)
+}
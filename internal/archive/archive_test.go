@@ -0,0 +1,122 @@
+// SPDX-FileCopyrightText: 2021 The Go-SSB Authors
+//
+// SPDX-License-Identifier: MIT
+
+package archive
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	refs "github.com/ssbc/go-ssb-refs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRoundtrip(t *testing.T) {
+	r := require.New(t)
+
+	feed, err := refs.NewFeedRefFromBytes(bytes.Repeat([]byte{1}, 32), refs.RefAlgoFeedSSB1)
+	r.NoError(err)
+	gabby, err := refs.NewFeedRefFromBytes(bytes.Repeat([]byte{2}, 32), refs.RefAlgoFeedGabby)
+	r.NoError(err)
+	msg, err := refs.NewMessageRefFromBytes(bytes.Repeat([]byte{3}, 32), refs.RefAlgoMessageSSB1)
+	r.NoError(err)
+	gabbyMsg, err := refs.NewMessageRefFromBytes(bytes.Repeat([]byte{4}, 32), refs.RefAlgoMessageGabby)
+	r.NoError(err)
+	blob, err := refs.NewBlobRefFromBytes(bytes.Repeat([]byte{5}, 32), refs.RefAlgoBlobSSB1)
+	r.NoError(err)
+
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, Header{Feeds: []refs.FeedRef{feed, gabby}, Blobs: true})
+	r.NoError(err)
+	r.NoError(w.WriteFeed(feed))
+	r.NoError(w.WriteMessage(msg, []byte(`{"hello":"world"}`)))
+	r.NoError(w.WriteFeed(gabby))
+	r.NoError(w.WriteMessage(gabbyMsg, []byte{0xff, 0x00}))
+	content := strings.Repeat("blob", 1000)
+	r.NoError(w.WriteBlob(blob, int64(len(content)), strings.NewReader(content)))
+	r.Error(w.WriteBlob(blob, 10, strings.NewReader("short")))
+	r.NoError(w.Close())
+
+	data := buf.Bytes()
+	rd, err := NewReader(bytes.NewReader(data))
+	r.NoError(err)
+	r.Equal(FormatName, rd.Header.Format)
+	r.Len(rd.Header.Feeds, 2)
+	r.True(rd.Header.Feeds[1].Equal(gabby))
+	r.True(rd.Header.Blobs)
+
+	b, err := rd.Next()
+	r.NoError(err)
+	r.True(b.Ref.(refs.FeedRef).Equal(feed))
+	r.EqualValues(0, b.Size)
+
+	b, err = rd.Next()
+	r.NoError(err)
+	r.True(b.Ref.(refs.MessageRef).Equal(msg))
+	got, err := ioutil.ReadAll(b.Data)
+	r.NoError(err)
+	r.Equal(`{"hello":"world"}`, string(got))
+
+	b, err = rd.Next()
+	r.NoError(err)
+	r.True(b.Ref.(refs.FeedRef).Equal(gabby))
+
+	// blocks that aren't read are skipped
+	b, err = rd.Next()
+	r.NoError(err)
+	r.True(b.Ref.(refs.MessageRef).Equal(gabbyMsg))
+	r.EqualValues(2, b.Size)
+
+	b, err = rd.Next()
+	r.NoError(err)
+	r.True(b.Ref.(refs.BlobRef).Equal(blob))
+	got, err = ioutil.ReadAll(b.Data)
+	r.NoError(err)
+	r.Equal(content, string(got))
+
+	// the failed blob was written partly
+	_, err = rd.Next()
+	r.NoError(err)
+	_, err = rd.Next()
+	r.ErrorIs(err, ErrInvalid)
+
+	// the end is found without reading past it
+	var ended bytes.Buffer
+	w, err = NewWriter(&ended, Header{})
+	r.NoError(err)
+	r.NoError(w.WriteFeed(feed))
+	r.NoError(w.Close())
+	endedData := ended.Bytes()
+	rd, err = NewReader(io.MultiReader(bytes.NewReader(endedData), strings.NewReader("trailing")))
+	r.NoError(err)
+	_, err = rd.Next()
+	r.NoError(err)
+	_, err = rd.Next()
+	r.Equal(io.EOF, err)
+
+	// an archive without the end
+	rd, err = NewReader(bytes.NewReader(endedData[:len(endedData)-1]))
+	r.NoError(err)
+	_, err = rd.Next()
+	r.NoError(err)
+	_, err = rd.Next()
+	r.ErrorIs(err, ErrInvalid)
+
+	// a truncated archive
+	rd, err = NewReader(bytes.NewReader(data[:len(data)-len(content)]))
+	r.NoError(err)
+	for err == nil {
+		_, err = rd.Next()
+	}
+	r.ErrorIs(err, ErrInvalid)
+
+	_, err = NewReader(strings.NewReader("\x0fnot an archive!"))
+	r.ErrorIs(err, ErrInvalid)
+
+	_, err = NewReader(strings.NewReader(""))
+	r.ErrorIs(err, io.EOF)
+}
@@ -0,0 +1,97 @@
+// SPDX-FileCopyrightText: 2021 The Go-SSB Authors
+//
+// SPDX-License-Identifier: MIT
+
+// Package bip39 encodes entropy as words of the english wordlist of BIP39, with its checksum.
+// See https://github.com/bitcoin/bips/blob/master/bip-0039.mediawiki
+// It doesn't derive seeds from the words, like BIP39 does for wallets, the entropy is the secret.
+package bip39
+
+import (
+	"crypto/sha256"
+	_ "embed"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+//go:embed english.txt
+var english string
+
+var (
+	wordlist = strings.Fields(english)
+	wordIdx  = make(map[string]int, len(wordlist))
+)
+
+func init() {
+	for i, w := range wordlist {
+		wordIdx[w] = i
+	}
+}
+
+// ErrChecksum is returned by MnemonicToEntropy if the checksum of the words doesn't match, like after a typo
+var ErrChecksum = errors.New("bip39: invalid checksum, a word is wrong")
+
+// EntropyToMnemonic returns the words for entropy, which has to be 16 to 32 bytes in steps of 4.
+// Each 4 bytes add 3 words, 16 bytes are 12 words.
+func EntropyToMnemonic(entropy []byte) ([]string, error) {
+	n := len(entropy)
+	if n < 16 || n > 32 || n%4 != 0 {
+		return nil, fmt.Errorf("bip39: entropy of %d bytes, it has to be 16 to 32 in steps of 4", n)
+	}
+
+	// the checksum is the first n/4 bits of its hash, appended to the entropy
+	sum := sha256.Sum256(entropy)
+	bits := append(append([]byte{}, entropy...), sum[0])
+
+	words := make([]string, (n*8+n/4)/11)
+	for i := range words {
+		words[i] = wordlist[readBits(bits, i*11)]
+	}
+	return words, nil
+}
+
+// MnemonicToEntropy returns the entropy of the words of EntropyToMnemonic, which are checked with their checksum
+func MnemonicToEntropy(words []string) ([]byte, error) {
+	if n := len(words); n < 12 || n > 24 || n%3 != 0 {
+		return nil, fmt.Errorf("bip39: %d words, it has to be 12 to 24 in steps of 3", n)
+	}
+
+	bits := make([]byte, (len(words)*11+7)/8)
+	for i, w := range words {
+		idx, ok := wordIdx[strings.ToLower(w)]
+		if !ok {
+			return nil, fmt.Errorf("bip39: %q is not a word of the wordlist", w)
+		}
+		writeBits(bits, i*11, idx)
+	}
+
+	csBits := len(words) * 11 / 33
+	entropy := bits[:csBits*4]
+	sum := sha256.Sum256(entropy)
+	mask := byte(0xff) << (8 - csBits)
+	if bits[len(entropy)]&mask != sum[0]&mask {
+		return nil, ErrChecksum
+	}
+	return append([]byte{}, entropy...), nil
+}
+
+// readBits returns the 11 bits of buf that start at bit off, most significant first
+func readBits(buf []byte, off int) int {
+	var v int
+	for i := 0; i < 11; i++ {
+		bit := off + i
+		v = v<<1 | int(buf[bit/8]>>(7-bit%8)&1)
+	}
+	return v
+}
+
+// writeBits sets the 11 bits of buf that start at bit off to v
+func writeBits(buf []byte, off, v int) {
+	for i := 0; i < 11; i++ {
+		if v>>(10-i)&1 == 1 {
+			bit := off + i
+			buf[bit/8] |= 1 << (7 - bit%8)
+		}
+	}
+}
@@ -0,0 +1,350 @@
+// SPDX-FileCopyrightText: 2021 The Go-SSB Authors
+//
+// SPDX-License-Identifier: MIT
+
+// Package bipf encodes and decodes the binary in-place format that ssb-db2 stores its messages in.
+// See https://github.com/ssbc/bipf for the javascript implementation.
+//
+// Every value starts with a varint of its length shifted by three bits, the lower three bits are the type.
+// Objects are a list of string keys, each followed by its value, and keep the order of the keys.
+// That order matters for the signatures of messages, which is why the values are converted from and to JSON bytes
+// instead of go types.
+package bipf
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// The types of bipf values
+const (
+	String   byte = 0
+	Buffer   byte = 1
+	Int      byte = 2 // 32bit, little endian
+	Double   byte = 3 // 64bit float, little endian
+	Array    byte = 4
+	Object   byte = 5
+	BoolNull byte = 6 // one byte for true or false, zero bytes for null
+)
+
+const (
+	tagSize = 3
+	tagMask = 7
+)
+
+// ErrKeyNotFound is returned by Lookup if the object doesn't have the key
+var ErrKeyNotFound = errors.New("bipf: key not found")
+
+// FromJSON encodes a JSON value as bipf, keeping the order of object keys.
+// Numbers that are whole and fit into 32 bits become Int, all the others Double, like the javascript encoder does it.
+func FromJSON(data []byte) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+
+	enc, err := encodeNext(dec)
+	if err != nil {
+		return nil, fmt.Errorf("bipf: failed to encode JSON: %w", err)
+	}
+
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, fmt.Errorf("bipf: unexpected data after the JSON value")
+	}
+	return enc, nil
+}
+
+func encodeNext(dec *json.Decoder) ([]byte, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	switch v := tok.(type) {
+	case json.Delim:
+		switch v {
+		case '{':
+			var body []byte
+			for dec.More() {
+				key, err := dec.Token()
+				if err != nil {
+					return nil, err
+				}
+				body = append(body, EncodeString(key.(string))...)
+
+				val, err := encodeNext(dec)
+				if err != nil {
+					return nil, err
+				}
+				body = append(body, val...)
+			}
+			if _, err := dec.Token(); err != nil { // closing }
+				return nil, err
+			}
+			return withHeader(Object, body), nil
+
+		case '[':
+			var body []byte
+			for dec.More() {
+				val, err := encodeNext(dec)
+				if err != nil {
+					return nil, err
+				}
+				body = append(body, val...)
+			}
+			if _, err := dec.Token(); err != nil { // closing ]
+				return nil, err
+			}
+			return withHeader(Array, body), nil
+
+		default:
+			return nil, fmt.Errorf("unexpected delimiter %q", v)
+		}
+
+	case string:
+		return EncodeString(v), nil
+
+	case json.Number:
+		f, err := strconv.ParseFloat(string(v), 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q: %w", v, err)
+		}
+		return EncodeNumber(f), nil
+
+	case bool:
+		if v {
+			return withHeader(BoolNull, []byte{1}), nil
+		}
+		return withHeader(BoolNull, []byte{0}), nil
+
+	case nil:
+		return withHeader(BoolNull, nil), nil
+
+	default:
+		return nil, fmt.Errorf("unexpected token %T", tok)
+	}
+}
+
+// EncodeString returns the bipf encoding of s
+func EncodeString(s string) []byte {
+	return withHeader(String, []byte(s))
+}
+
+// EncodeNumber returns the bipf encoding of f, as Int if it is a whole 32bit number and as Double otherwise
+func EncodeNumber(f float64) []byte {
+	if f == math.Trunc(f) && f >= math.MinInt32 && f <= math.MaxInt32 && !(f == 0 && math.Signbit(f)) {
+		var b [4]byte
+		binary.LittleEndian.PutUint32(b[:], uint32(int32(f)))
+		return withHeader(Int, b[:])
+	}
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], math.Float64bits(f))
+	return withHeader(Double, b[:])
+}
+
+// EncodeObject returns an object of the already encoded keys and values, which alternate in kv
+func EncodeObject(kv ...[]byte) []byte {
+	return withHeader(Object, bytes.Join(kv, nil))
+}
+
+func withHeader(tipe byte, body []byte) []byte {
+	var hdr [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(hdr[:], uint64(len(body))<<tagSize|uint64(tipe))
+	return append(hdr[:n:n], body...)
+}
+
+// readHeader returns the type of the value at the start of data and its payload
+func readHeader(data []byte) (byte, []byte, int, error) {
+	hdr, n := binary.Uvarint(data)
+	if n <= 0 {
+		return 0, nil, 0, fmt.Errorf("bipf: invalid header")
+	}
+	length := hdr >> tagSize
+	if length > uint64(len(data)-n) {
+		return 0, nil, 0, fmt.Errorf("bipf: value is longer (%d) than the data (%d)", length, len(data)-n)
+	}
+	end := n + int(length)
+	return byte(hdr & tagMask), data[n:end], end, nil
+}
+
+// TypeOf returns the type of the encoded value
+func TypeOf(data []byte) (byte, error) {
+	tipe, _, _, err := readHeader(data)
+	return tipe, err
+}
+
+// Lookup returns the encoded value of key in the encoded object
+func Lookup(data []byte, key string) ([]byte, error) {
+	tipe, body, _, err := readHeader(data)
+	if err != nil {
+		return nil, err
+	}
+	if tipe != Object {
+		return nil, fmt.Errorf("bipf: lookup of %q in a value of type %d", key, tipe)
+	}
+
+	for len(body) > 0 {
+		ktipe, k, kend, err := readHeader(body)
+		if err != nil {
+			return nil, err
+		}
+		_, _, vend, err := readHeader(body[kend:])
+		if err != nil {
+			return nil, err
+		}
+		if ktipe == String && string(k) == key {
+			return body[kend : kend+vend], nil
+		}
+		body = body[kend+vend:]
+	}
+	return nil, ErrKeyNotFound
+}
+
+// DecodeString returns the string of the encoded value
+func DecodeString(data []byte) (string, error) {
+	tipe, body, _, err := readHeader(data)
+	if err != nil {
+		return "", err
+	}
+	if tipe != String {
+		return "", fmt.Errorf("bipf: not a string but type %d", tipe)
+	}
+	return string(body), nil
+}
+
+// DecodeNumber returns the number of the encoded Int or Double value
+func DecodeNumber(data []byte) (float64, error) {
+	tipe, body, _, err := readHeader(data)
+	if err != nil {
+		return 0, err
+	}
+	switch {
+	case tipe == Int && len(body) == 4:
+		return float64(int32(binary.LittleEndian.Uint32(body))), nil
+	case tipe == Double && len(body) == 8:
+		return math.Float64frombits(binary.LittleEndian.Uint64(body)), nil
+	default:
+		return 0, fmt.Errorf("bipf: not a number but type %d", tipe)
+	}
+}
+
+// ToJSON decodes a bipf value to compact JSON, keeping the order of object keys.
+// Numbers are written the way javascript prints them, so that signed messages format to the same bytes again.
+// Buffers become base64 strings.
+func ToJSON(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	end, err := writeJSON(&buf, data)
+	if err != nil {
+		return nil, err
+	}
+	if end != len(data) {
+		return nil, fmt.Errorf("bipf: unexpected data after the value")
+	}
+	return buf.Bytes(), nil
+}
+
+func writeJSON(buf *bytes.Buffer, data []byte) (int, error) {
+	tipe, body, end, err := readHeader(data)
+	if err != nil {
+		return 0, err
+	}
+
+	switch tipe {
+	case String:
+		writeQuoted(buf, string(body))
+
+	case Buffer:
+		writeQuoted(buf, base64.StdEncoding.EncodeToString(body))
+
+	case Int, Double:
+		f, err := DecodeNumber(data)
+		if err != nil {
+			return 0, err
+		}
+		buf.WriteString(formatNumber(f))
+
+	case Array:
+		buf.WriteByte('[')
+		for i := 0; len(body) > 0; i++ {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			n, err := writeJSON(buf, body)
+			if err != nil {
+				return 0, err
+			}
+			body = body[n:]
+		}
+		buf.WriteByte(']')
+
+	case Object:
+		buf.WriteByte('{')
+		for i := 0; len(body) > 0; i++ {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			ktipe, k, kend, err := readHeader(body)
+			if err != nil {
+				return 0, err
+			}
+			if ktipe != String {
+				return 0, fmt.Errorf("bipf: object key of type %d", ktipe)
+			}
+			writeQuoted(buf, string(k))
+			buf.WriteByte(':')
+
+			n, err := writeJSON(buf, body[kend:])
+			if err != nil {
+				return 0, err
+			}
+			body = body[kend+n:]
+		}
+		buf.WriteByte('}')
+
+	case BoolNull:
+		switch {
+		case len(body) == 0:
+			buf.WriteString("null")
+		case body[0] == 1:
+			buf.WriteString("true")
+		default:
+			buf.WriteString("false")
+		}
+
+	default:
+		return 0, fmt.Errorf("bipf: unsupported type %d", tipe)
+	}
+	return end, nil
+}
+
+func writeQuoted(buf *bytes.Buffer, s string) {
+	enc := json.NewEncoder(buf)
+	enc.SetEscapeHTML(false)
+	enc.Encode(s)               // can't fail for strings
+	buf.Truncate(buf.Len() - 1) // the newline of Encode
+}
+
+// formatNumber prints f like javascript's Number.prototype.toString does
+func formatNumber(f float64) string {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return "null" // what JSON.stringify does
+	}
+	if f == 0 {
+		return "0"
+	}
+	if abs := math.Abs(f); abs >= 1e-6 && abs < 1e21 {
+		return strconv.FormatFloat(f, 'f', -1, 64)
+	}
+
+	// javascript doesn't pad the exponent: 1e-7 instead of 1e-07
+	s := strconv.FormatFloat(f, 'e', -1, 64)
+	i := strings.IndexByte(s, 'e')
+	mant, sign, digits := s[:i], s[i+1:i+2], strings.TrimLeft(s[i+2:], "0")
+	return mant + "e" + sign + digits
+}
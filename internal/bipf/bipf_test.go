@@ -0,0 +1,82 @@
+// SPDX-FileCopyrightText: 2021 The Go-SSB Authors
+//
+// SPDX-License-Identifier: MIT
+
+package bipf
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncodeKnown(t *testing.T) {
+	r := require.New(t)
+
+	// bipf.allocAndEncode({ foo: true }) in javascript
+	enc, err := FromJSON([]byte(`{"foo":true}`))
+	r.NoError(err)
+	r.Equal("3518666f6f0e01", hex.EncodeToString(enc))
+
+	// an Int, a Double and null
+	enc, err = FromJSON([]byte(`[1,1.5,null]`))
+	r.NoError(err)
+	r.Equal("7c"+"2201000000"+"43000000000000f83f"+"06", hex.EncodeToString(enc))
+}
+
+func TestRoundtrip(t *testing.T) {
+	r := require.New(t)
+
+	for i, tc := range []string{
+		`{"previous":"%Bk3o8CkUVJq7CydrlnBeUQ9iFyvMKWyhbUMeLcOh9qo=.sha256","author":"@ouL8WCUj8jgBHGvNY4STX7TYNhdyhwi0H0nxeK6K5IA=.ed25519","sequence":2,"timestamp":1534873234935.0066,"hash":"sha256","content":{"type":"post","text":"héllo <world> \"quoted\"\n","mentions":[],"root":null,"ok":false},"signature":"abc==.sig.ed25519"}`,
+		`"just a string"`,
+		`[]`,
+		`{}`,
+		`[-2147483648,2147483647,2147483648,-0.5,1e+21,1e-7,0.000001,123456789012345680000]`,
+		`{"b":1,"a":2,"nested":{"z":[{"y":true}]}}`,
+	} {
+		enc, err := FromJSON([]byte(tc))
+		r.NoError(err, "case %d", i)
+
+		dec, err := ToJSON(enc)
+		r.NoError(err, "case %d", i)
+		r.Equal(tc, string(dec), "case %d", i)
+	}
+}
+
+func TestLookup(t *testing.T) {
+	r := require.New(t)
+
+	enc, err := FromJSON([]byte(`{"key":"%abc","value":{"sequence":3},"timestamp":1612345678901}`))
+	r.NoError(err)
+
+	v, err := Lookup(enc, "key")
+	r.NoError(err)
+	key, err := DecodeString(v)
+	r.NoError(err)
+	r.Equal("%abc", key)
+
+	v, err = Lookup(enc, "timestamp")
+	r.NoError(err)
+	tipe, err := TypeOf(v)
+	r.NoError(err)
+	r.Equal(Double, tipe, "too large for an Int")
+	ts, err := DecodeNumber(v)
+	r.NoError(err)
+	r.EqualValues(1612345678901, ts)
+
+	v, err = Lookup(enc, "value")
+	r.NoError(err)
+	v, err = Lookup(v, "sequence")
+	r.NoError(err)
+	seq, err := DecodeNumber(v)
+	r.NoError(err)
+	r.EqualValues(3, seq)
+
+	_, err = Lookup(enc, "nope")
+	r.ErrorIs(err, ErrKeyNotFound)
+
+	_, err = ToJSON(enc[:len(enc)-1])
+	r.Error(err, "truncated value")
+}
@@ -34,12 +34,22 @@ type SbotConfig struct {
 	WebsocketTLSKey  string `json:"wstlskey,omitempty"`
 	MetricsAddress   string `json:"debuglis,omitempty"`
 
+	OnionProxy         string `json:"onion-proxy,omitempty"`
+	OnionListenAddress string `json:"onion-lis,omitempty"`
+
+	LogFormat     string `json:"log-format,omitempty"`
+	RepoKeyfile   string `json:"repo-keyfile,omitempty"`
+	SecretKeyfile string `json:"secret-keyfile,omitempty"`
+
 	NoUnixSocket        ConfigBool `json:"nounixsock"`
 	EnableAdvertiseUDP  ConfigBool `json:"localadv"`
 	EnableDiscoveryUDP  ConfigBool `json:"localdiscov"`
 	EnableEBT           ConfigBool `json:"enable-ebt"`
 	EnableFirewall      ConfigBool `json:"promisc"`
 	RepairFSBeforeStart ConfigBool `json:"repair"`
+	ConnFirewall        ConfigBool `json:"conn-firewall"`
+	EnableSearch        ConfigBool `json:"enable-search"`
+	FeedSegments        ConfigBool `json:"feed-segments"`
 
 	NumPeer uint `json:"numPeer,omitempty"`
 	NumRepl uint `json:"numRepl,omitempty"`
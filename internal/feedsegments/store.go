@@ -0,0 +1,401 @@
+// SPDX-FileCopyrightText: 2021 The Go-SSB Authors
+//
+// SPDX-License-Identifier: MIT
+
+/*
+Package feedsegments keeps the messages of every author in a segmented log of its own, next to the receive log.
+
+The entry i of the log of an author is the message with the sequence i+1, so reading the history of a feed is sequential
+and deleting a feed removes its files instead of nulling entries all over the receive log.
+A log only covers a feed without gaps from its first message on. Messages that don't continue it, like those of feeds
+that are only partially replicated, are left out and have to be read through the user sublogs.
+
+The store is an index of the receive log. It remembers how far it got in state.json, the logs don't depend on the
+sequences of the receive log, which is why a compaction only has to move that state.
+*/
+package feedsegments
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/keks/persist"
+	refs "github.com/ssbc/go-ssb-refs"
+	"github.com/ssbc/margaret"
+	librarian "github.com/ssbc/margaret/indexes"
+
+	"github.com/ssbc/go-ssb/internal/storedrefs"
+	"github.com/ssbc/go-ssb/message/multimsg"
+	"github.com/ssbc/go-ssb/repo"
+)
+
+// FolderName is where the store is kept in the repo
+const FolderName = "feedsegments"
+
+// StateFile is the file in the folder of the store that has the last entry of the receive log it processed
+const StateFile = "state.json"
+
+// maxIdle is how many logs stay open while nobody uses them
+const maxIdle = 64
+
+// ErrNoSegment is returned by Acquire if there is no log for the feed
+var ErrNoSegment = errors.New("feedsegments: no log for this feed")
+
+var _ librarian.SinkIndex = (*Store)(nil)
+
+// Store has a log for every author
+type Store struct {
+	dir string
+	key *repo.DataKey // encrypts the logs in an encrypted repo
+
+	mu    sync.Mutex
+	state *os.File
+	logs  map[string]*feedLog // the open ones, by the name of their folder
+	tick  uint64              // counts uses, to close the logs that weren't used the longest
+}
+
+type feedLog struct {
+	log multimsg.AlterableLog
+
+	refs     int    // readers that use the log
+	lastUse  uint64 // tick of the last use
+	obsolete bool   // dropped or replaced, it is closed by the last reader
+}
+
+// Open opens the store in dir or creates it. The logs are encrypted with key, unless it is nil.
+func Open(dir string, key *repo.DataKey) (*Store, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("feedsegments: failed to create %s: %w", dir, err)
+	}
+
+	state, err := os.OpenFile(filepath.Join(dir, StateFile), os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("feedsegments: failed to open state file: %w", err)
+	}
+
+	return &Store{
+		dir:   dir,
+		key:   key,
+		state: state,
+		logs:  make(map[string]*feedLog),
+	}, nil
+}
+
+func folderName(author refs.FeedRef) string {
+	return hex.EncodeToString([]byte(storedrefs.Feed(author)))
+}
+
+// open returns the log in the folder name, which has to be released again.
+// If it doesn't exist yet, it is only created if create is set, otherwise the error is ErrNoSegment.
+func (s *Store) open(name string, create bool) (*feedLog, error) {
+	s.tick++
+	if fl, ok := s.logs[name]; ok {
+		fl.refs++
+		fl.lastUse = s.tick
+		return fl, nil
+	}
+
+	path := filepath.Join(s.dir, name)
+	if !create {
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			return nil, ErrNoSegment
+		}
+	}
+
+	log, err := repo.OpenLogAtWithKey(path, repo.LogFormatSegmented, s.key)
+	if err != nil {
+		return nil, fmt.Errorf("feedsegments: %w", err)
+	}
+	fl := &feedLog{log: log, refs: 1, lastUse: s.tick}
+	s.logs[name] = fl
+	s.closeIdle()
+	return fl, nil
+}
+
+func (s *Store) release(fl *feedLog) {
+	fl.refs--
+	if fl.refs == 0 && fl.obsolete {
+		fl.log.Close()
+	}
+}
+
+// closeIdle closes the logs that weren't used the longest if there are too many open ones without readers
+func (s *Store) closeIdle() {
+	var idle int
+	for _, fl := range s.logs {
+		if fl.refs == 0 {
+			idle++
+		}
+	}
+	for ; idle > maxIdle; idle-- {
+		var (
+			oldest string
+			tick   uint64
+		)
+		for name, fl := range s.logs {
+			if fl.refs == 0 && (oldest == "" || fl.lastUse < tick) {
+				oldest, tick = name, fl.lastUse
+			}
+		}
+		s.logs[oldest].log.Close()
+		delete(s.logs, oldest)
+	}
+}
+
+// forget removes the log in the folder name from the open ones, it is closed once its readers are done
+func (s *Store) forget(name string) {
+	fl, ok := s.logs[name]
+	if !ok {
+		return
+	}
+	delete(s.logs, name)
+	if fl.refs == 0 {
+		fl.log.Close()
+	} else {
+		fl.obsolete = true
+	}
+}
+
+// Acquire returns the log of author, where the entry i is the message with the sequence i+1,
+// or ErrNoSegment if there is none. It stays usable until release is called, even if the feed is dropped.
+func (s *Store) Acquire(author refs.FeedRef) (margaret.Log, func(), error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	name := folderName(author)
+	fl, err := s.open(name, false)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var once sync.Once
+	release := func() {
+		once.Do(func() {
+			s.mu.Lock()
+			s.release(fl)
+			s.mu.Unlock()
+		})
+	}
+	return fl.log, release, nil
+}
+
+// QuerySpec returns the query spec that queries the next needed messages from the receive log
+func (s *Store) QuerySpec() margaret.QuerySpec {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var seq int64
+	if err := persist.Load(s.state, &seq); err != nil {
+		if !errors.Is(err, io.EOF) {
+			return margaret.ErrorQuerySpec(err)
+		}
+		seq = margaret.SeqEmpty
+	}
+
+	return margaret.MergeQuerySpec(
+		margaret.Gt(seq),
+		margaret.SeqWrap(true),
+	)
+}
+
+// Pour adds a message of the receive log to the log of its author, if it continues it
+func (s *Store) Pour(ctx context.Context, swv interface{}) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sw, ok := swv.(margaret.SeqWrapper)
+	if !ok {
+		return fmt.Errorf("feedsegments: error casting seq wrapper. got type %T", swv)
+	}
+
+	err := persist.Save(s.state, sw.Seq())
+	if err != nil {
+		return fmt.Errorf("feedsegments: error saving current sequence number: %w", err)
+	}
+
+	v := sw.Value()
+	if err, ok := v.(error); ok {
+		if margaret.IsErrNulled(err) {
+			return nil
+		}
+		return err
+	}
+
+	msg, ok := v.(refs.Message)
+	if !ok {
+		return fmt.Errorf("feedsegments: expected message, got %T", v)
+	}
+
+	// only the first message of a feed starts a log
+	name := folderName(msg.Author())
+	fl, err := s.open(name, msg.Seq() == 1)
+	if errors.Is(err, ErrNoSegment) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer s.release(fl)
+
+	// duplicates and messages after a gap are left out
+	if msg.Seq() != fl.log.Seq()+2 {
+		return nil
+	}
+
+	if _, err := fl.log.Append(v); err != nil {
+		return fmt.Errorf("feedsegments: failed to append message %d of %s: %w", msg.Seq(), msg.Author().ShortSigil(), err)
+	}
+	return nil
+}
+
+// Drop deletes the log of author
+func (s *Store) Drop(author refs.FeedRef) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	name := folderName(author)
+	s.forget(name)
+	if err := os.RemoveAll(filepath.Join(s.dir, name)); err != nil {
+		return fmt.Errorf("feedsegments: failed to delete the log of %s: %w", author.ShortSigil(), err)
+	}
+	return nil
+}
+
+// Truncate cuts the log of author down to its first keep messages.
+// The log can't be shortened in place, the messages are copied into a new one.
+func (s *Store) Truncate(author refs.FeedRef, keep int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	name := folderName(author)
+	fl, err := s.open(name, false)
+	if errors.Is(err, ErrNoSegment) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer s.release(fl)
+
+	if fl.log.Seq()+1 <= keep {
+		return nil
+	}
+
+	path := filepath.Join(s.dir, name)
+	tmpPath := path + ".tmp"
+	if err := os.RemoveAll(tmpPath); err != nil {
+		return err
+	}
+
+	err = copyLog(fl.log, tmpPath, keep, s.key)
+	if err != nil {
+		os.RemoveAll(tmpPath)
+		return fmt.Errorf("feedsegments: failed to truncate the log of %s: %w", author.ShortSigil(), err)
+	}
+
+	s.forget(name)
+	if err := os.RemoveAll(path); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return fmt.Errorf("feedsegments: failed to replace the log of %s: %w", author.ShortSigil(), err)
+	}
+	return nil
+}
+
+func copyLog(from margaret.Log, dir string, n int64, key *repo.DataKey) error {
+	to, err := repo.OpenLogAtWithKey(dir, repo.LogFormatSegmented, key)
+	if err != nil {
+		return err
+	}
+	for seq := int64(0); seq < n; seq++ {
+		v, err := from.Get(seq)
+		if err == nil {
+			_, err = to.Append(v)
+		}
+		if err != nil {
+			to.Close()
+			return err
+		}
+	}
+	return to.Close()
+}
+
+// Replace overwrites the message with the sequence seq of author, like a nulled content.
+// data is encoded like the entries of the receive log in multimsg.MargaretCodec.
+func (s *Store) Replace(author refs.FeedRef, seq int64, data []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	name := folderName(author)
+	fl, err := s.open(name, false)
+	if errors.Is(err, ErrNoSegment) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer s.release(fl)
+
+	if seq-1 > fl.log.Seq() {
+		return nil
+	}
+	if err := fl.log.Replace(seq-1, data); err != nil {
+		return fmt.Errorf("feedsegments: failed to replace message %d of %s: %w", seq, author.ShortSigil(), err)
+	}
+	return nil
+}
+
+// Reset deletes all the logs, so that they are written again from the start of the receive log
+func (s *Store) Reset() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for name := range s.logs {
+		s.forget(name)
+	}
+
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return fmt.Errorf("feedsegments: failed to list the logs: %w", err)
+	}
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(s.dir, e.Name())); err != nil {
+			return fmt.Errorf("feedsegments: failed to delete a log: %w", err)
+		}
+	}
+
+	err = persist.Save(s.state, int64(margaret.SeqEmpty))
+	if err != nil {
+		return fmt.Errorf("feedsegments: error resetting the sequence number: %w", err)
+	}
+	return nil
+}
+
+// Close closes the logs, the ones that are still used are closed once they are released
+func (s *Store) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var firstErr error
+	for name, fl := range s.logs {
+		delete(s.logs, name)
+		if fl.refs > 0 {
+			fl.obsolete = true
+			continue
+		}
+		if err := fl.log.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	if err := s.state.Close(); err != nil && firstErr == nil {
+		firstErr = err
+	}
+	return firstErr
+}
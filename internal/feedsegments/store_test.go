@@ -0,0 +1,152 @@
+// SPDX-FileCopyrightText: 2021 The Go-SSB Authors
+//
+// SPDX-License-Identifier: MIT
+
+package feedsegments
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ssbc/go-luigi"
+	refs "github.com/ssbc/go-ssb-refs"
+	"github.com/ssbc/margaret"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ssbc/go-ssb"
+	"github.com/ssbc/go-ssb/internal/storedrefs"
+	"github.com/ssbc/go-ssb/message/legacy"
+)
+
+func TestStore(t *testing.T) {
+	r := require.New(t)
+	ctx := context.Background()
+
+	dir := filepath.Join("testrun", t.Name())
+	os.RemoveAll(dir)
+
+	type feed struct {
+		kp   ssb.KeyPair
+		prev *refs.MessageRef
+	}
+	newFeed := func() *feed {
+		kp, err := ssb.NewKeyPair(nil, refs.RefAlgoFeedSSB1)
+		r.NoError(err)
+		return &feed{kp: kp}
+	}
+	next := func(f *feed, i int64) *legacy.StoredMessage {
+		lm := legacy.LegacyMessage{
+			Previous:  f.prev,
+			Author:    f.kp.ID().String(),
+			Sequence:  i,
+			Timestamp: 1600000000000 + i,
+			Hash:      "sha256",
+			Content:   map[string]interface{}{"type": "test", "i": i},
+		}
+		key, raw, err := lm.Sign(f.kp.Secret(), nil)
+		r.NoError(err)
+		sm := &legacy.StoredMessage{
+			Author_:   storedrefs.SerialzedFeed{FeedRef: f.kp.ID()},
+			Key_:      storedrefs.SerialzedMessage{MessageRef: key},
+			Sequence_: i,
+			Raw_:      raw,
+		}
+		if f.prev != nil {
+			sm.Previous_ = &storedrefs.SerialzedMessage{MessageRef: *f.prev}
+		}
+		f.prev = &key
+		return sm
+	}
+
+	s, err := Open(dir, nil)
+	r.NoError(err)
+
+	var rxSeq int64 = -1
+	pour := func(v interface{}) {
+		rxSeq++
+		r.NoError(s.Pour(ctx, margaret.WrapWithSeq(v, rxSeq)))
+	}
+
+	arny, bert, cher := newFeed(), newFeed(), newFeed()
+	var arnyKeys []refs.MessageRef
+	for i := int64(1); i <= 3; i++ {
+		msg := next(arny, i)
+		arnyKeys = append(arnyKeys, msg.Key())
+		pour(msg)
+		pour(next(bert, i))
+	}
+	pour(margaret.ErrNulled)
+
+	// cher is only known from the third message on
+	next(cher, 1)
+	next(cher, 2)
+	pour(next(cher, 3))
+
+	// a duplicate doesn't change the log
+	dup := next(newFeed(), 1)
+	pour(dup)
+	pour(dup)
+
+	readAll := func(author refs.FeedRef) []refs.MessageRef {
+		log, release, err := s.Acquire(author)
+		r.NoError(err)
+		defer release()
+
+		src, err := log.Query()
+		r.NoError(err)
+		var keys []refs.MessageRef
+		for {
+			v, err := src.Next(ctx)
+			if luigi.IsEOS(err) {
+				break
+			}
+			r.NoError(err)
+			keys = append(keys, v.(refs.Message).Key())
+		}
+		return keys
+	}
+
+	r.Equal(arnyKeys, readAll(arny.kp.ID()))
+	r.Len(readAll(bert.kp.ID()), 3)
+	r.Equal([]refs.MessageRef{dup.Key()}, readAll(dup.Author()))
+	_, _, err = s.Acquire(cher.kp.ID())
+	r.ErrorIs(err, ErrNoSegment)
+
+	r.NoError(s.Truncate(arny.kp.ID(), 2))
+	r.Equal(arnyKeys[:2], readAll(arny.kp.ID()))
+
+	// readers keep the log of a dropped feed
+	log, release, err := s.Acquire(bert.kp.ID())
+	r.NoError(err)
+	r.NoError(s.Drop(bert.kp.ID()))
+	_, _, err = s.Acquire(bert.kp.ID())
+	r.ErrorIs(err, ErrNoSegment)
+	v, err := log.Get(2)
+	r.NoError(err)
+	r.EqualValues(3, v.(refs.Message).Seq())
+	release()
+
+	r.NoError(s.Close())
+
+	// it continues after the last entry it processed
+	s, err = Open(dir, nil)
+	r.NoError(err)
+	var got testQuery
+	r.NoError(s.QuerySpec()(&got))
+	r.Equal(rxSeq, got.gt)
+
+	pour(next(arny, 4))
+	r.Equal(arnyKeys[:2], readAll(arny.kp.ID()), "messages after a gap are left out")
+	r.NoError(s.Close())
+}
+
+// testQuery records the query spec of the store
+type testQuery struct {
+	margaret.Query
+	gt int64
+}
+
+func (q *testQuery) Gt(seq int64) error { q.gt = seq; return nil }
+func (q *testQuery) SeqWrap(bool) error { return nil }
@@ -0,0 +1,217 @@
+// SPDX-FileCopyrightText: 2021 The Go-SSB Authors
+//
+// SPDX-License-Identifier: MIT
+
+// Package framelog implements a margaret log on top of a store of encoded entries.
+// The on-disk formats of the receive log only need to read, append and overwrite entries,
+// the queries, the live updates and the codec are the same for all of them and behave like offset2 does.
+package framelog
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/ssbc/go-luigi"
+	"github.com/ssbc/margaret"
+)
+
+// Store keeps the encoded entries of a log. The log serializes the calls to it.
+type Store interface {
+	// Count returns the number of entries, the nulled ones included
+	Count() int64
+
+	// Read returns the data of the entry at seq.
+	// It returns margaret.ErrNulled if the entry was nulled and io.EOF if it doesn't exist (yet).
+	Read(seq int64) ([]byte, error)
+
+	// Append adds data as the next entry
+	Append(data []byte) error
+
+	// Null overwrites the entry at seq so that it reads as nulled
+	Null(seq int64) error
+
+	// Replace overwrites the entry at seq with data, which can't be larger than the entry was
+	Replace(seq int64, data []byte) error
+
+	io.Closer
+}
+
+// Log is a margaret log of the entries in a store
+type Log struct {
+	l sync.Mutex
+
+	name  string
+	store Store
+	codec margaret.Codec
+
+	seqCurrent int64
+	seqChanges luigi.Observable
+
+	bcast  luigi.Broadcast
+	bcSink luigi.Sink
+}
+
+var (
+	_ margaret.Log     = (*Log)(nil)
+	_ margaret.Alterer = (*Log)(nil)
+)
+
+// New returns a log of the entries in store, which are encoded with codec.
+// name is what FileName returns.
+func New(name string, store Store, codec margaret.Codec) *Log {
+	log := &Log{
+		name:  name,
+		store: store,
+		codec: codec,
+
+		seqCurrent: store.Count() - 1,
+	}
+	log.seqChanges = luigi.NewObservable(log.seqCurrent)
+	log.bcSink, log.bcast = luigi.NewBroadcast()
+	return log
+}
+
+// Store returns the store of the log, for the things that are special to its format
+func (log *Log) Store() Store { return log.store }
+
+// FileName returns where the log is stored
+func (log *Log) FileName() string { return log.name }
+
+func (log *Log) Seq() int64 {
+	log.l.Lock()
+	defer log.l.Unlock()
+	return log.seqCurrent
+}
+
+func (log *Log) Changes() luigi.Observable {
+	return log.seqChanges
+}
+
+func (log *Log) Get(seq int64) (interface{}, error) {
+	log.l.Lock()
+	defer log.l.Unlock()
+
+	v, err := log.readFrame(seq)
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return v, luigi.EOS{}
+		}
+		if errors.Is(err, margaret.ErrNulled) {
+			return nil, margaret.ErrNulled
+		}
+		return nil, err
+	}
+	return v, nil
+}
+
+// readFrame reads and decodes an entry, the log has to be locked
+func (log *Log) readFrame(seq int64) (interface{}, error) {
+	if seq < 0 {
+		return nil, fmt.Errorf("framelog: invalid sequence %d", seq)
+	}
+
+	data, err := log.store.Read(seq)
+	if err != nil {
+		return nil, fmt.Errorf("framelog: failed to read seq(%d): %w", seq, err)
+	}
+
+	v, err := log.codec.Unmarshal(data)
+	if err != nil {
+		return nil, fmt.Errorf("framelog: error decoding data for seq(%d): %w", seq, err)
+	}
+	return v, nil
+}
+
+func (log *Log) Append(v interface{}) (int64, error) {
+	data, err := log.codec.Marshal(v)
+	if err != nil {
+		return margaret.SeqEmpty, fmt.Errorf("framelog: error marshaling value: %w", err)
+	}
+
+	log.l.Lock()
+	defer log.l.Unlock()
+
+	if err := log.store.Append(data); err != nil {
+		return margaret.SeqEmpty, fmt.Errorf("framelog: error appending data: %w", err)
+	}
+	seq := log.seqCurrent + 1
+
+	err = log.bcSink.Pour(context.TODO(), margaret.WrapWithSeq(v, seq))
+	log.seqCurrent = seq
+	log.seqChanges.Set(seq)
+
+	if err != nil {
+		return margaret.SeqEmpty, fmt.Errorf("framelog: error while updating registerd broadcasts with new value: %w", err)
+	}
+	return seq, nil
+}
+
+// Null overwrites the entry at seq, reading it returns margaret.ErrNulled afterwards
+func (log *Log) Null(seq int64) error {
+	log.l.Lock()
+	defer log.l.Unlock()
+
+	if seq < 0 || seq > log.seqCurrent {
+		return fmt.Errorf("framelog: can't null seq(%d): %w", seq, margaret.OOB)
+	}
+	if err := log.store.Null(seq); err != nil {
+		return fmt.Errorf("framelog: failed to null seq(%d): %w", seq, err)
+	}
+	return nil
+}
+
+// Replace overwrites the entry at seq with data, which has to be encoded with the codec of the log
+func (log *Log) Replace(seq int64, data []byte) error {
+	log.l.Lock()
+	defer log.l.Unlock()
+
+	if seq < 0 || seq > log.seqCurrent {
+		return fmt.Errorf("framelog: can't replace seq(%d): %w", seq, margaret.OOB)
+	}
+	if err := log.store.Replace(seq, data); err != nil {
+		return fmt.Errorf("framelog: failed to replace seq(%d): %w", seq, err)
+	}
+	return nil
+}
+
+func (log *Log) Close() error {
+	if err := log.store.Close(); err != nil {
+		return fmt.Errorf("framelog: store close failed: %w", err)
+	}
+
+	if err := log.bcSink.Close(); err != nil {
+		return fmt.Errorf("framelog: log broadcast close failed: %w", err)
+	}
+	return nil
+}
+
+func (log *Log) Query(specs ...margaret.QuerySpec) (luigi.Source, error) {
+	log.l.Lock()
+	defer log.l.Unlock()
+
+	qry := &query{
+		log: log,
+
+		nextSeq: margaret.SeqEmpty,
+		lt:      margaret.SeqEmpty,
+
+		limit: -1, //i.e. no limit
+		close: make(chan struct{}),
+	}
+
+	for _, spec := range specs {
+		err := spec(qry)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if qry.reverse && qry.live {
+		return nil, fmt.Errorf("framelog: can't do reverse and live")
+	}
+
+	return qry, nil
+}
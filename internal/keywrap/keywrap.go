@@ -0,0 +1,106 @@
+// SPDX-FileCopyrightText: 2021 The Go-SSB Authors
+//
+// SPDX-License-Identifier: MIT
+
+// Package keywrap encrypts small secrets, like keys, with a key derived from a passphrase or the contents of a keyfile.
+// The parameters of the derivation are stored next to the encrypted data, as JSON fields of the file that has them.
+package keywrap
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/nacl/secretbox"
+	"golang.org/x/crypto/scrypt"
+)
+
+// KDFScrypt is the only key derivation that is supported so far
+const KDFScrypt = "scrypt"
+
+// the scrypt parameters of new secrets, the ones recommended for interactive logins in 2017
+const (
+	scryptN = 1 << 15
+	scryptR = 8
+	scryptP = 1
+
+	saltLen  = 32
+	nonceLen = 24
+)
+
+// ErrWrongSecret is returned by Open if the secret doesn't decrypt the data
+var ErrWrongSecret = errors.New("keywrap: wrong passphrase or keyfile")
+
+// Params derive the key from the secret
+type Params struct {
+	KDF  string `json:"kdf"`
+	N    int    `json:"n"`
+	R    int    `json:"r"`
+	P    int    `json:"p"`
+	Salt []byte `json:"salt"`
+}
+
+// NewParams returns the default parameters with a new random salt
+func NewParams() (Params, error) {
+	p := Params{
+		KDF:  KDFScrypt,
+		N:    scryptN,
+		R:    scryptR,
+		P:    scryptP,
+		Salt: make([]byte, saltLen),
+	}
+	if _, err := io.ReadFull(rand.Reader, p.Salt); err != nil {
+		return p, fmt.Errorf("keywrap: failed to create a salt: %w", err)
+	}
+	return p, nil
+}
+
+func (p Params) deriveKey(secret []byte) (*[32]byte, error) {
+	if p.KDF != KDFScrypt {
+		return nil, fmt.Errorf("keywrap: unsupported key derivation %q", p.KDF)
+	}
+	if len(secret) == 0 {
+		return nil, fmt.Errorf("keywrap: the secret is empty")
+	}
+	derived, err := scrypt.Key(secret, p.Salt, p.N, p.R, p.P, 32)
+	if err != nil {
+		return nil, fmt.Errorf("keywrap: failed to derive a key from the secret: %w", err)
+	}
+	var key [32]byte
+	copy(key[:], derived)
+	return &key, nil
+}
+
+// Seal encrypts plain with the key derived from secret, the result is the nonce followed by the secretbox
+func (p Params) Seal(secret, plain []byte) ([]byte, error) {
+	key, err := p.deriveKey(secret)
+	if err != nil {
+		return nil, err
+	}
+
+	var nonce [nonceLen]byte
+	if _, err := io.ReadFull(rand.Reader, nonce[:]); err != nil {
+		return nil, fmt.Errorf("keywrap: failed to create a nonce: %w", err)
+	}
+	return secretbox.Seal(nonce[:], plain, &nonce, key), nil
+}
+
+// Open decrypts the result of Seal
+func (p Params) Open(secret, sealed []byte) ([]byte, error) {
+	key, err := p.deriveKey(secret)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < nonceLen+secretbox.Overhead {
+		return nil, ErrWrongSecret
+	}
+
+	var nonce [nonceLen]byte
+	copy(nonce[:], sealed)
+	plain, ok := secretbox.Open(nil, sealed[nonceLen:], &nonce, key)
+	if !ok {
+		return nil, ErrWrongSecret
+	}
+	return plain, nil
+}
@@ -50,6 +50,19 @@ func (il indirectLog) Get(seq int64) (interface{}, error) {
 	return rv, nil
 }
 
+// RootSeq returns the sequence in the root log of the entry seq
+func (il indirectLog) RootSeq(seq int64) (int64, error) {
+	v, err := il.indirect.Get(seq)
+	if err != nil {
+		return -1, fmt.Errorf("indirect: lookup failed: %w", err)
+	}
+	rootSeq, ok := v.(int64)
+	if !ok {
+		return -1, fmt.Errorf("indirect: not a sequence: %T", v)
+	}
+	return rootSeq, nil
+}
+
 // Query returns a stream that is constrained by the passed query specification
 func (il indirectLog) Query(args ...margaret.QuerySpec) (luigi.Source, error) {
 	src, err := il.indirect.Query(args...)
@@ -0,0 +1,18 @@
+// SPDX-FileCopyrightText: 2021 The Go-SSB Authors
+//
+// SPDX-License-Identifier: MIT
+
+//go:build !linux && !darwin && !dragonfly && !freebsd && !netbsd && !openbsd
+// +build !linux,!darwin,!dragonfly,!freebsd,!netbsd,!openbsd
+
+package passphrase
+
+import (
+	"errors"
+	"os"
+)
+
+// disableEcho isn't supported here, the secret has to come from a keyfile or the environment
+func disableEcho(tty *os.File) (restore func(), err error) {
+	return nil, errors.New("prompting for a passphrase is not supported on this platform")
+}
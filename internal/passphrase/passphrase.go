@@ -0,0 +1,87 @@
+// SPDX-FileCopyrightText: 2021 The Go-SSB Authors
+//
+// SPDX-License-Identifier: MIT
+
+// Package passphrase reads the secrets the commands unlock things with: from a keyfile, an environment variable or a prompt on the terminal.
+package passphrase
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// ErrNoSecret is returned by Read if there is neither a keyfile, the environment variable nor a terminal
+var ErrNoSecret = errors.New("passphrase: no keyfile, environment variable or terminal to get the secret from")
+
+// Read returns the contents of keyfile if it is set, otherwise the environment variable envName if it is set,
+// otherwise it asks for a passphrase on the terminal with prompt.
+func Read(keyfile, envName, prompt string) ([]byte, error) {
+	if keyfile != "" {
+		secret, err := os.ReadFile(keyfile)
+		if err != nil {
+			return nil, fmt.Errorf("passphrase: failed to read keyfile: %w", err)
+		}
+		if len(secret) == 0 {
+			return nil, fmt.Errorf("passphrase: keyfile %s is empty", keyfile)
+		}
+		return secret, nil
+	}
+
+	if envName != "" {
+		if val, ok := os.LookupEnv(envName); ok && val != "" {
+			return []byte(val), nil
+		}
+	}
+
+	return Prompt(prompt)
+}
+
+// ReadNew is like Read but asks twice on the terminal, to make sure a new passphrase wasn't mistyped
+func ReadNew(keyfile, envName, prompt string) ([]byte, error) {
+	if keyfile != "" || (envName != "" && os.Getenv(envName) != "") {
+		return Read(keyfile, envName, prompt)
+	}
+
+	secret, err := Prompt(prompt)
+	if err != nil {
+		return nil, err
+	}
+	again, err := Prompt("repeat: " + prompt)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(secret, again) {
+		return nil, fmt.Errorf("passphrase: the passphrases don't match")
+	}
+	return secret, nil
+}
+
+// Prompt asks for a passphrase on the terminal without echoing it
+func Prompt(prompt string) ([]byte, error) {
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		return nil, ErrNoSecret
+	}
+	defer tty.Close()
+
+	restore, err := disableEcho(tty)
+	if err != nil {
+		return nil, fmt.Errorf("passphrase: %w", err)
+	}
+	fmt.Fprint(tty, prompt)
+	line, err := bufio.NewReader(tty).ReadBytes('\n')
+	restore()
+	fmt.Fprintln(tty)
+	if err != nil {
+		return nil, fmt.Errorf("passphrase: failed to read from the terminal: %w", err)
+	}
+
+	secret := bytes.TrimRight(line, "\r\n")
+	if len(secret) == 0 {
+		return nil, fmt.Errorf("passphrase: the passphrase is empty")
+	}
+	return secret, nil
+}
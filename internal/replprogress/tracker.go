@@ -0,0 +1,217 @@
+// SPDX-FileCopyrightText: 2021 The Go-SSB Authors
+//
+// SPDX-License-Identifier: MIT
+
+// Package replprogress keeps track of what the replication plugins received and from whom,
+// to tell how far we are behind the rest of the network.
+package replprogress
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ssbc/go-ssb"
+	refs "github.com/ssbc/go-ssb-refs"
+	"github.com/ssbc/go-ssb/internal/statematrix"
+)
+
+// rateWindow is the number of seconds the message rate is averaged over
+const rateWindow = 60
+
+// Tracker collects the received messages of legacy gossip and EBT.
+// It is safe for concurrent use.
+type Tracker struct {
+	now func() time.Time
+
+	mu      sync.Mutex
+	started time.Time
+	peers   map[string]*peerState
+
+	messages uint64
+	bytes    uint64
+
+	// messages received per second, indexed by unix time modulo rateWindow
+	buckets [rateWindow]bucket
+}
+
+type bucket struct {
+	sec int64
+	n   uint64
+}
+
+type peerState struct {
+	ref refs.FeedRef
+
+	messages uint64
+	bytes    uint64
+
+	// the highest sequence seen per feed
+	known map[string]int64
+}
+
+// New returns an empty Tracker
+func New() *Tracker {
+	return &Tracker{
+		now:     time.Now,
+		started: time.Now(),
+		peers:   make(map[string]*peerState),
+	}
+}
+
+// Received records a message of size bytes with the sequence seq of feed, received from peer.
+func (t *Tracker) Received(peer, feed refs.FeedRef, seq int64, size int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	ps := t.peer(peer)
+	ps.messages++
+	ps.bytes += uint64(size)
+	if seq > ps.known[feed.String()] {
+		ps.known[feed.String()] = seq
+	}
+
+	t.messages++
+	t.bytes += uint64(size)
+
+	sec := t.now().Unix()
+	b := &t.buckets[sec%rateWindow]
+	if b.sec != sec {
+		b.sec = sec
+		b.n = 0
+	}
+	b.n++
+}
+
+func (t *Tracker) peer(ref refs.FeedRef) *peerState {
+	ps, has := t.peers[ref.String()]
+	if !has {
+		ps = &peerState{
+			ref:   ref,
+			known: make(map[string]int64),
+		}
+		t.peers[ref.String()] = ps
+	}
+	return ps
+}
+
+// rate returns the messages per second over the last rateWindow seconds (or since the start, if that was less)
+func (t *Tracker) rate(now time.Time) float64 {
+	var sum uint64
+	for _, b := range t.buckets {
+		if now.Unix()-b.sec < rateWindow {
+			sum += b.n
+		}
+	}
+
+	window := now.Sub(t.started).Seconds()
+	if window > rateWindow {
+		window = rateWindow
+	}
+	if window < 1 {
+		window = 1
+	}
+	return float64(sum) / window
+}
+
+// Progress compares the local sequences of the wanted feeds with what the peers told us about.
+// longer is the result of statematrix.HasLonger and adds what we know from the EBT frontiers of the peers.
+func (t *Tracker) Progress(wanted ssb.ReplicateUpToResponseSet, longer []statematrix.HasLongerResult) ssb.ReplicationProgress {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	// merge the ebt frontiers with what we received
+	remote := make(map[string]map[string]int64, len(t.peers))
+	refsByPeer := make(map[string]refs.FeedRef, len(t.peers))
+	for p, ps := range t.peers {
+		known := make(map[string]int64, len(ps.known))
+		for f, seq := range ps.known {
+			known[f] = seq
+		}
+		remote[p] = known
+		refsByPeer[p] = ps.ref
+	}
+	for _, hl := range longer {
+		p := hl.Peer.String()
+		known, has := remote[p]
+		if !has {
+			known = make(map[string]int64)
+			remote[p] = known
+			refsByPeer[p] = hl.Peer
+		}
+		if seq := int64(hl.Len); seq > known[hl.Feed.String()] {
+			known[hl.Feed.String()] = seq
+		}
+	}
+
+	var prog ssb.ReplicationProgress
+	prog.Feeds = len(wanted)
+	prog.Messages = t.messages
+	prog.Bytes = t.bytes
+
+	// the highest sequence any peer knows about, per feed
+	highest := make(map[string]int64, len(wanted))
+
+	for p, known := range remote {
+		var pp ssb.PeerProgress
+		pp.Peer = refsByPeer[p]
+		if ps, has := t.peers[p]; has {
+			pp.Messages = ps.messages
+			pp.Bytes = ps.bytes
+		}
+
+		for f, seq := range known {
+			local, isWanted := wanted[f]
+			if !isWanted {
+				continue
+			}
+			if seq > highest[f] {
+				highest[f] = seq
+			}
+			if seq > local.Sequence {
+				pp.Behind += seq - local.Sequence
+				pp.Feeds = append(pp.Feeds, ssb.FeedProgress{
+					Feed:   local.ID,
+					Local:  local.Sequence,
+					Remote: seq,
+				})
+			}
+		}
+		sort.Slice(pp.Feeds, func(i, j int) bool {
+			return pp.Feeds[i].Feed.String() < pp.Feeds[j].Feed.String()
+		})
+
+		prog.Peers = append(prog.Peers, pp)
+	}
+	sort.Slice(prog.Peers, func(i, j int) bool {
+		return prog.Peers[i].Peer.String() < prog.Peers[j].Peer.String()
+	})
+
+	for f, local := range wanted {
+		prog.Local += local.Sequence
+		if h := highest[f]; h > local.Sequence {
+			prog.Known += h
+		} else {
+			prog.Known += local.Sequence
+			prog.InSync++
+		}
+	}
+
+	prog.Percent = 100
+	if prog.Known > 0 {
+		prog.Percent = float64(prog.Local) / float64(prog.Known) * 100
+	}
+
+	now := t.now()
+	prog.Rate = t.rate(now)
+	switch remaining := prog.Known - prog.Local; {
+	case remaining == 0:
+		prog.ETA = 0
+	case prog.Rate > 0:
+		prog.ETA = float64(remaining) / prog.Rate
+	default:
+		prog.ETA = -1
+	}
+
+	return prog
+}
@@ -0,0 +1,113 @@
+// SPDX-FileCopyrightText: 2021 The Go-SSB Authors
+//
+// SPDX-License-Identifier: MIT
+
+package replprogress
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/ssbc/go-ssb"
+	refs "github.com/ssbc/go-ssb-refs"
+	"github.com/ssbc/go-ssb/internal/statematrix"
+)
+
+func TestProgress(t *testing.T) {
+	r := require.New(t)
+
+	mkRef := func(b byte) refs.FeedRef {
+		ref, err := refs.NewFeedRefFromBytes(bytes.Repeat([]byte{b}, 32), refs.RefAlgoFeedSSB1)
+		r.NoError(err)
+		return ref
+	}
+
+	var (
+		alice = mkRef(1) // wanted feeds
+		bob   = mkRef(2)
+		carl  = mkRef(3)
+
+		peerA = mkRef(10) // legacy gossip
+		peerB = mkRef(11) // ebt
+
+		notWanted = mkRef(20)
+	)
+
+	now := time.Unix(1600000000, 0)
+	tr := New()
+	tr.now = func() time.Time { return now }
+	tr.started = now.Add(-time.Hour)
+
+	wanted := ssb.ReplicateUpToResponseSet{
+		alice.String(): {ID: alice, Sequence: 10},
+		bob.String():   {ID: bob, Sequence: 0},
+		carl.String():  {ID: carl, Sequence: 5},
+	}
+
+	// nothing known yet
+	prog := tr.Progress(wanted, nil)
+	r.Equal(3, prog.Feeds)
+	r.Equal(3, prog.InSync)
+	r.EqualValues(15, prog.Local)
+	r.EqualValues(15, prog.Known)
+	r.Equal(100.0, prog.Percent)
+	r.Equal(0.0, prog.ETA)
+
+	// peerA sent us some of alice (11 and 12) and something we don't want
+	tr.Received(peerA, alice, 11, 100)
+	tr.Received(peerA, alice, 12, 100)
+	tr.Received(peerA, notWanted, 1, 50)
+
+	// peerB has 20 of bob, 30 of alice and 5 of carl
+	longer := []statematrix.HasLongerResult{
+		{Peer: peerB, Feed: bob, Len: 20},
+		{Peer: peerB, Feed: alice, Len: 30},
+		{Peer: peerB, Feed: carl, Len: 5},
+	}
+
+	// the local sequences are still the old ones, as if the messages weren't indexed yet
+	prog = tr.Progress(wanted, longer)
+	r.EqualValues(3, prog.Messages)
+	r.EqualValues(250, prog.Bytes)
+	r.Equal(1, prog.InSync) // carl
+	r.EqualValues(15, prog.Local)
+	r.EqualValues(30+20+5, prog.Known)
+	r.InDelta(15.0/55.0*100, prog.Percent, 0.001)
+
+	// three messages in the last minute
+	r.InDelta(3.0/60, prog.Rate, 0.001)
+	r.InDelta(40/(3.0/60), prog.ETA, 0.1)
+
+	r.Len(prog.Peers, 2)
+	byPeer := make(map[string]ssb.PeerProgress)
+	for _, pp := range prog.Peers {
+		byPeer[pp.Peer.String()] = pp
+	}
+
+	ppA := byPeer[peerA.String()]
+	r.EqualValues(3, ppA.Messages)
+	r.EqualValues(2, ppA.Behind)
+	r.Equal([]ssb.FeedProgress{{Feed: alice, Local: 10, Remote: 12}}, ppA.Feeds)
+
+	ppB := byPeer[peerB.String()]
+	r.EqualValues(0, ppB.Messages)
+	r.EqualValues(20+20, ppB.Behind)
+	r.Len(ppB.Feeds, 2)
+
+	// without new messages the rate drops and the eta becomes unknown
+	now = now.Add(2 * time.Minute)
+	prog = tr.Progress(wanted, longer)
+	r.Equal(0.0, prog.Rate)
+	r.Equal(-1.0, prog.ETA)
+
+	// once everything is stored we are in sync
+	wanted[alice.String()] = ssb.ReplicateUpToResponse{ID: alice, Sequence: 30}
+	wanted[bob.String()] = ssb.ReplicateUpToResponse{ID: bob, Sequence: 20}
+	prog = tr.Progress(wanted, nil)
+	r.Equal(3, prog.InSync)
+	r.Equal(100.0, prog.Percent)
+	r.Equal(0.0, prog.ETA)
+}
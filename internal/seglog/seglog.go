@@ -0,0 +1,518 @@
+// SPDX-FileCopyrightText: 2021 The Go-SSB Authors
+//
+// SPDX-License-Identifier: MIT
+
+/*
+Package seglog stores the entries of a log in segments of a fixed number of entries each,
+so that whole segments can be deleted once the entries in them aren't needed anymore.
+
+Every segment has two files in the directory of the log, named by the number of the segment:
+
+* NNNNNNNNNN.data: the entries, each prefixed with its length as a uint32.
+
+* NNNNNNNNNN.ofst: a uint64 per entry, where it starts in the data file.
+
+The number of entries per segment is in the file entries-per-segment, it can't change once the log has entries.
+Nulled entries are overwritten with zeros and the entries of a deleted segment read as nulled.
+All integers are encoded in BigEndian.
+*/
+package seglog
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/ssbc/margaret"
+
+	"github.com/ssbc/go-ssb/internal/framelog"
+)
+
+// DefaultEntriesPerSegment is how many entries a segment gets if the log doesn't exist yet
+const DefaultEntriesPerSegment = 1 << 16
+
+const perSegmentFile = "entries-per-segment"
+
+// Store is a framelog.Store of segments
+type Store struct {
+	mu sync.Mutex
+
+	dir        string
+	perSegment int64
+
+	segments map[int64]*segment // the ones that weren't deleted
+	last     int64              // number of the last segment, -1 if there is none
+	count    int64              // entries in the last segment
+}
+
+type segment struct {
+	data, ofst *os.File
+	size       int64 // of data
+}
+
+// SegmentInfo describes a segment of the log
+type SegmentInfo struct {
+	Number int64 `json:"number"`
+
+	// First and Last are the sequences of the entries in the segment
+	First int64 `json:"first"`
+	Last  int64 `json:"last"`
+
+	Nulled  int64 `json:"nulled"` // how many of the entries are nulled
+	Size    int64 `json:"size"`   // of the files in bytes
+	Deleted bool  `json:"deleted"`
+}
+
+var _ framelog.Store = (*Store)(nil)
+
+// Open opens the segments in dir or creates the directory.
+// perSegment is only used if the log is new, an existing one keeps its number of entries per segment.
+func Open(dir string, perSegment int64) (*Store, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("seglog: failed to create %s: %w", dir, err)
+	}
+
+	s := &Store{
+		dir:      dir,
+		segments: make(map[int64]*segment),
+		last:     -1,
+	}
+
+	var err error
+	s.perSegment, err = loadPerSegment(dir, perSegment)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.openSegments(); err != nil {
+		s.Close()
+		return nil, fmt.Errorf("seglog: failed to open the segments in %s: %w", dir, err)
+	}
+	return s, nil
+}
+
+func loadPerSegment(dir string, perSegment int64) (int64, error) {
+	fname := filepath.Join(dir, perSegmentFile)
+
+	data, err := os.ReadFile(fname)
+	if errors.Is(err, os.ErrNotExist) {
+		if perSegment < 1 {
+			return 0, fmt.Errorf("seglog: invalid number of entries per segment: %d", perSegment)
+		}
+		err = os.WriteFile(fname, []byte(strconv.FormatInt(perSegment, 10)), 0600)
+		if err != nil {
+			return 0, fmt.Errorf("seglog: failed to write %s: %w", fname, err)
+		}
+		return perSegment, nil
+	} else if err != nil {
+		return 0, fmt.Errorf("seglog: failed to read %s: %w", fname, err)
+	}
+
+	n, err := strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+	if err != nil || n < 1 {
+		return 0, fmt.Errorf("seglog: invalid number of entries per segment in %s: %q", fname, data)
+	}
+	return n, nil
+}
+
+func (s *Store) openSegments() error {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return err
+	}
+
+	for _, e := range entries {
+		name := e.Name()
+		if !strings.HasSuffix(name, ".data") {
+			continue
+		}
+		num, err := strconv.ParseInt(strings.TrimSuffix(name, ".data"), 10, 64)
+		if err != nil {
+			continue
+		}
+
+		seg, err := s.openSegment(num)
+		if err != nil {
+			return err
+		}
+		s.segments[num] = seg
+		if num > s.last {
+			s.last = num
+		}
+	}
+
+	for num, seg := range s.segments {
+		fi, err := seg.ofst.Stat()
+		if err != nil {
+			return err
+		}
+		count := fi.Size() / 8
+
+		if num != s.last {
+			if count != s.perSegment {
+				return fmt.Errorf("segment %d has %d instead of %d entries", num, count, s.perSegment)
+			}
+			continue
+		}
+
+		if s.count, err = seg.repair(count); err != nil {
+			return fmt.Errorf("failed to repair the last segment %d: %w", num, err)
+		}
+	}
+	return nil
+}
+
+func (s *Store) segmentPath(num int64, ext string) string {
+	return filepath.Join(s.dir, fmt.Sprintf("%010d.%s", num, ext))
+}
+
+func (s *Store) openSegment(num int64) (*segment, error) {
+	data, err := os.OpenFile(s.segmentPath(num, "data"), os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, err
+	}
+	ofst, err := os.OpenFile(s.segmentPath(num, "ofst"), os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		data.Close()
+		return nil, err
+	}
+
+	fi, err := data.Stat()
+	if err != nil {
+		data.Close()
+		ofst.Close()
+		return nil, err
+	}
+	return &segment{data: data, ofst: ofst, size: fi.Size()}, nil
+}
+
+// repair cuts off an entry that wasn't written completely and returns how many entries the segment has
+func (seg *segment) repair(count int64) (int64, error) {
+	// the offset is written last, so only the last entry can be incomplete
+	for ; count > 0; count-- {
+		start, err := seg.offset(count - 1)
+		if err != nil {
+			return 0, err
+		}
+		length, err := seg.length(start)
+		if err == nil && start+4+length <= seg.size {
+			break
+		}
+	}
+
+	end := int64(0)
+	if count > 0 {
+		start, err := seg.offset(count - 1)
+		if err != nil {
+			return 0, err
+		}
+		length, err := seg.length(start)
+		if err != nil {
+			return 0, err
+		}
+		end = start + 4 + length
+	}
+
+	if err := seg.ofst.Truncate(count * 8); err != nil {
+		return 0, err
+	}
+	if err := seg.data.Truncate(end); err != nil {
+		return 0, err
+	}
+	seg.size = end
+	return count, nil
+}
+
+func (seg *segment) offset(i int64) (int64, error) {
+	var b [8]byte
+	if _, err := seg.ofst.ReadAt(b[:], i*8); err != nil {
+		return 0, err
+	}
+	return int64(binary.BigEndian.Uint64(b[:])), nil
+}
+
+func (seg *segment) length(start int64) (int64, error) {
+	var b [4]byte
+	if _, err := seg.data.ReadAt(b[:], start); err != nil {
+		return 0, err
+	}
+	return int64(binary.BigEndian.Uint32(b[:])), nil
+}
+
+// entry returns where the data of the i-th entry of the segment starts and how long it is
+func (seg *segment) entry(i int64) (int64, int64, error) {
+	start, err := seg.offset(i)
+	if err != nil {
+		return 0, 0, err
+	}
+	length, err := seg.length(start)
+	if err != nil {
+		return 0, 0, err
+	}
+	return start + 4, length, nil
+}
+
+func (seg *segment) read(i int64) ([]byte, error) {
+	start, length, err := seg.entry(i)
+	if err != nil {
+		return nil, err
+	}
+	data := make([]byte, length)
+	if _, err := seg.data.ReadAt(data, start); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+func (seg *segment) close() error {
+	err := seg.data.Close()
+	if ofstErr := seg.ofst.Close(); err == nil {
+		err = ofstErr
+	}
+	return err
+}
+
+// EntriesPerSegment returns how many entries a segment holds
+func (s *Store) EntriesPerSegment() int64 { return s.perSegment }
+
+func (s *Store) Count() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.countLocked()
+}
+
+func (s *Store) countLocked() int64 {
+	if s.last < 0 {
+		return 0
+	}
+	return s.last*s.perSegment + s.count
+}
+
+// locate returns the segment of seq and the position in it.
+// The segment is nil if it was deleted.
+func (s *Store) locate(seq int64) (*segment, int64, error) {
+	if seq < 0 {
+		return nil, 0, fmt.Errorf("seglog: invalid sequence %d", seq)
+	}
+	if seq >= s.countLocked() {
+		return nil, 0, io.EOF
+	}
+	return s.segments[seq/s.perSegment], seq % s.perSegment, nil
+}
+
+func (s *Store) Read(seq int64) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	seg, i, err := s.locate(seq)
+	if err != nil {
+		return nil, err
+	}
+	if seg == nil {
+		return nil, margaret.ErrNulled
+	}
+
+	data, err := seg.read(i)
+	if err != nil {
+		return nil, fmt.Errorf("seglog: failed to read seq(%d): %w", seq, err)
+	}
+	if isZero(data) {
+		return nil, margaret.ErrNulled
+	}
+	return data, nil
+}
+
+func (s *Store) Append(data []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(data) == 0 || int64(len(data)) > 1<<32-1 {
+		return fmt.Errorf("seglog: invalid entry size %d", len(data))
+	}
+
+	if s.last < 0 || s.count == s.perSegment {
+		seg, err := s.openSegment(s.last + 1)
+		if err != nil {
+			return fmt.Errorf("seglog: failed to create segment %d: %w", s.last+1, err)
+		}
+		s.last++
+		s.count = 0
+		s.segments[s.last] = seg
+	}
+	seg := s.segments[s.last]
+
+	rec := make([]byte, 4+len(data))
+	binary.BigEndian.PutUint32(rec, uint32(len(data)))
+	copy(rec[4:], data)
+	if _, err := seg.data.WriteAt(rec, seg.size); err != nil {
+		return fmt.Errorf("seglog: failed to write entry: %w", err)
+	}
+
+	var ofst [8]byte
+	binary.BigEndian.PutUint64(ofst[:], uint64(seg.size))
+	if _, err := seg.ofst.WriteAt(ofst[:], s.count*8); err != nil {
+		return fmt.Errorf("seglog: failed to write offset: %w", err)
+	}
+
+	seg.size += int64(len(rec))
+	s.count++
+	return nil
+}
+
+func (s *Store) Null(seq int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	seg, i, err := s.locate(seq)
+	if err != nil || seg == nil {
+		return err
+	}
+	start, length, err := seg.entry(i)
+	if err != nil {
+		return err
+	}
+	_, err = seg.data.WriteAt(make([]byte, length), start)
+	return err
+}
+
+func (s *Store) Replace(seq int64, data []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	seg, i, err := s.locate(seq)
+	if err != nil {
+		return err
+	}
+	if seg == nil {
+		return margaret.ErrNulled
+	}
+	start, length, err := seg.entry(i)
+	if err != nil {
+		return err
+	}
+	if int64(len(data)) > length {
+		return fmt.Errorf("seglog: can't overwrite entry with larger data (diff:%d)", int64(len(data))-length)
+	}
+
+	padded := make([]byte, length)
+	copy(padded, data)
+	_, err = seg.data.WriteAt(padded, start)
+	return err
+}
+
+// Segments lists all the segments, including the deleted ones
+func (s *Store) Segments() ([]SegmentInfo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var infos []SegmentInfo
+	for num := int64(0); num <= s.last; num++ {
+		info, err := s.segmentInfo(num)
+		if err != nil {
+			return nil, err
+		}
+		infos = append(infos, info)
+	}
+	return infos, nil
+}
+
+// Segment describes the segment num
+func (s *Store) Segment(num int64) (SegmentInfo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if num < 0 || num > s.last {
+		return SegmentInfo{}, fmt.Errorf("seglog: no segment %d", num)
+	}
+	return s.segmentInfo(num)
+}
+
+func (s *Store) segmentInfo(num int64) (SegmentInfo, error) {
+	info := SegmentInfo{
+		Number: num,
+		First:  num * s.perSegment,
+		Last:   (num+1)*s.perSegment - 1,
+	}
+	if num == s.last {
+		info.Last = info.First + s.count - 1
+	}
+
+	seg, ok := s.segments[num]
+	if !ok {
+		info.Deleted = true
+		info.Nulled = info.Last - info.First + 1
+		return info, nil
+	}
+
+	for i := int64(0); i <= info.Last-info.First; i++ {
+		data, err := seg.read(i)
+		if err != nil {
+			return info, fmt.Errorf("seglog: failed to read entry %d of segment %d: %w", i, num, err)
+		}
+		if isZero(data) {
+			info.Nulled++
+		}
+	}
+	info.Size = seg.size + (info.Last-info.First+1)*8
+	return info, nil
+}
+
+// DeleteSegment removes the files of a segment, its entries read as nulled afterwards.
+// The last segment, which new entries are appended to, can't be deleted.
+func (s *Store) DeleteSegment(num int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if num == s.last {
+		return fmt.Errorf("seglog: can't delete the last segment %d", num)
+	}
+	seg, ok := s.segments[num]
+	if !ok {
+		return nil
+	}
+
+	if err := seg.close(); err != nil {
+		return fmt.Errorf("seglog: failed to close segment %d: %w", num, err)
+	}
+	delete(s.segments, num)
+
+	// the data goes first, without it the segment is deleted
+	for _, ext := range []string{"data", "ofst"} {
+		if err := os.Remove(s.segmentPath(num, ext)); err != nil {
+			return fmt.Errorf("seglog: failed to delete segment %d: %w", num, err)
+		}
+	}
+	return nil
+}
+
+func (s *Store) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var firstErr error
+	for num, seg := range s.segments {
+		if err := seg.data.Sync(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+		if err := seg.close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+		delete(s.segments, num)
+	}
+	return firstErr
+}
+
+func isZero(data []byte) bool {
+	for _, b := range data {
+		if b != 0 {
+			return false
+		}
+	}
+	return true
+}
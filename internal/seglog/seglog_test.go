@@ -0,0 +1,118 @@
+// SPDX-FileCopyrightText: 2021 The Go-SSB Authors
+//
+// SPDX-License-Identifier: MIT
+
+package seglog
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ssbc/margaret"
+	mjson "github.com/ssbc/margaret/codec/json"
+	o2test "github.com/ssbc/margaret/offset2/test"
+	mtest "github.com/ssbc/margaret/test"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ssbc/go-ssb/internal/framelog"
+)
+
+func TestLogConformance(t *testing.T) {
+	newLog := func(name string, tipe interface{}) (margaret.Log, error) {
+		dir := filepath.Join("testrun", name)
+		os.RemoveAll(dir)
+		// small segments so that the tests cross them
+		s, err := Open(dir, 2)
+		if err != nil {
+			return nil, err
+		}
+		return framelog.New(dir, s, mjson.New(tipe)), nil
+	}
+
+	t.Run("margaret", mtest.LogTest(newLog))
+	t.Run("pump", o2test.LogTestPump(newLog))
+	t.Run("pumplive", o2test.LogTestPumpLive(newLog))
+}
+
+func TestSegments(t *testing.T) {
+	r := require.New(t)
+
+	dir := filepath.Join("testrun", t.Name())
+	os.RemoveAll(dir)
+
+	s, err := Open(dir, 3)
+	r.NoError(err)
+
+	for i := 0; i < 8; i++ {
+		r.NoError(s.Append([]byte(fmt.Sprintf("entry %d", i))))
+	}
+	r.EqualValues(8, s.Count())
+
+	r.NoError(s.Null(4))
+	segs, err := s.Segments()
+	r.NoError(err)
+	r.Len(segs, 3)
+	r.EqualValues(SegmentInfo{Number: 1, First: 3, Last: 5, Nulled: 1, Size: 3*(4+7) + 3*8}, segs[1])
+	r.EqualValues(6, segs[2].First)
+	r.EqualValues(7, segs[2].Last)
+	seg, err := s.Segment(1)
+	r.NoError(err)
+	r.Equal(segs[1], seg)
+	_, err = s.Segment(3)
+	r.Error(err)
+
+	r.Error(s.DeleteSegment(2), "the last segment is still written to")
+	r.NoError(s.DeleteSegment(0))
+	_, err = s.Read(1)
+	r.ErrorIs(err, margaret.ErrNulled)
+	_, err = os.Stat(filepath.Join(dir, "0000000000.data"))
+	r.True(os.IsNotExist(err))
+	r.NoError(s.Close())
+
+	// a different size doesn't change an existing log
+	s, err = Open(dir, 100)
+	r.NoError(err)
+	r.EqualValues(3, s.EntriesPerSegment())
+	r.EqualValues(8, s.Count())
+
+	_, err = s.Read(0)
+	r.ErrorIs(err, margaret.ErrNulled)
+	_, err = s.Read(4)
+	r.ErrorIs(err, margaret.ErrNulled)
+	got, err := s.Read(7)
+	r.NoError(err)
+	r.Equal("entry 7", string(got))
+	_, err = s.Read(8)
+	r.ErrorIs(err, io.EOF)
+
+	segs, err = s.Segments()
+	r.NoError(err)
+	r.True(segs[0].Deleted)
+	r.EqualValues(3, segs[0].Nulled)
+
+	// a new segment is started when the last one is full
+	r.NoError(s.Append([]byte("entry 8")))
+	r.NoError(s.Append([]byte("entry 9")))
+	got, err = s.Read(9)
+	r.NoError(err)
+	r.Equal("entry 9", string(got))
+	r.NoError(s.Close())
+
+	// an entry that was only partly written is cut off
+	last := filepath.Join(dir, "0000000003.data")
+	fi, err := os.Stat(last)
+	r.NoError(err)
+	r.NoError(os.Truncate(last, fi.Size()-2))
+
+	s, err = Open(dir, 3)
+	r.NoError(err)
+	r.EqualValues(9, s.Count())
+	r.NoError(s.Append([]byte("entry 9 again")))
+	got, err = s.Read(9)
+	r.NoError(err)
+	r.Equal("entry 9 again", string(got))
+	r.NoError(s.Close())
+}
@@ -5,6 +5,7 @@
 package ssb
 
 import (
+	"bytes"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
@@ -65,6 +66,9 @@ func IsValidFeedFormat(r refs.FeedRef) error {
 	return nil
 }
 
+// metafeedSeedLabel is the label the key pair of a metafeed is derived from its seed with
+const metafeedSeedLabel = "go-ssb-metafeed"
+
 // NewKeyPair generates a fresh KeyPair using the passed io.Reader as a seed.
 // Passing nil is fine and will use crypto/rand.
 func NewKeyPair(r io.Reader, algo refs.RefAlgo) (KeyPair, error) {
@@ -79,7 +83,7 @@ func NewKeyPair(r io.Reader, algo refs.RefAlgo) (KeyPair, error) {
 			return nil, err
 		}
 
-		keyPair, err = metakeys.DeriveFromSeed(seed, "go-ssb-metafeed", refs.RefAlgoFeedBendyButt)
+		keyPair, err = metakeys.DeriveFromSeed(seed, metafeedSeedLabel, refs.RefAlgoFeedBendyButt)
 		if err != nil {
 			return nil, err
 		}
@@ -107,6 +111,10 @@ func NewKeyPair(r io.Reader, algo refs.RefAlgo) (KeyPair, error) {
 // SaveKeyPair serializes the passed KeyPair to path.
 // It errors if path already exists.
 func SaveKeyPair(kp KeyPair, path string) error {
+	return saveKeyPair(kp, path, nil)
+}
+
+func saveKeyPair(kp KeyPair, path string, secret []byte) error {
 	if err := IsValidFeedFormat(kp.ID()); err != nil {
 		return err
 	}
@@ -120,31 +128,43 @@ func SaveKeyPair(kp KeyPair, path string) error {
 		return fmt.Errorf("failed to create folder for keypair: %w", err)
 	}
 
-	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, SecretPerms)
-	if err != nil {
-		return fmt.Errorf("ssb.SaveKeyPair: failed to create file: %w", err)
-	}
-
+	var buf bytes.Buffer
 	if enc, ok := kp.(json.Marshaler); ok {
 		data, err := enc.MarshalJSON()
 		if err != nil {
 			return err
 		}
-
-		n, err := f.Write(data)
-		if err != nil {
+		buf.Write(data)
+	} else {
+		if err := EncodeKeyPairAsJSON(kp, &buf); err != nil {
 			return err
 		}
+	}
 
-		if n != len(data) {
-			return fmt.Errorf("ssb.SaveKeyPair: failed to save all encoded bytes of the keypair")
-		}
-	} else {
-		if err := EncodeKeyPairAsJSON(kp, f); err != nil {
-			return err
+	data := buf.Bytes()
+	if secret != nil {
+		data, err = sealKeyPair(kp.ID(), data, secret)
+		if err != nil {
+			return fmt.Errorf("ssb.SaveKeyPair: %w", err)
 		}
 	}
 
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, SecretPerms)
+	if err != nil {
+		return fmt.Errorf("ssb.SaveKeyPair: failed to create file: %w", err)
+	}
+
+	n, err := f.Write(data)
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	if n != len(data) {
+		f.Close()
+		return fmt.Errorf("ssb.SaveKeyPair: failed to save all encoded bytes of the keypair")
+	}
+
 	if err := f.Close(); err != nil {
 		return fmt.Errorf("ssb.SaveKeyPair: failed to close file: %w", err)
 	}
@@ -167,8 +187,36 @@ func EncodeKeyPairAsJSON(kp KeyPair, w io.Writer) error {
 	return nil
 }
 
-// LoadKeyPair opens fname, ignores any line starting with # and passes it ParseKeyPair
+// LoadKeyPair opens fname, ignores any line starting with # and passes it ParseKeyPair.
+// It returns ErrKeyPairLocked if the file is encrypted, see LoadKeyPairWithSecret.
 func LoadKeyPair(fname string) (KeyPair, error) {
+	return LoadKeyPairWithSecret(fname, nil)
+}
+
+// LoadKeyPairWithSecret is LoadKeyPair for files that might be encrypted with secret, see SaveEncryptedKeyPair.
+// The secret is not needed for files that aren't encrypted.
+func LoadKeyPairWithSecret(fname string, secret []byte) (KeyPair, error) {
+	keyData, err := readKeyPairFile(fname, secret)
+	if err != nil {
+		return nil, err
+	}
+
+	kp, err := ParseKeyPair(nocomment.NewReader(bytes.NewReader(keyData)))
+	if err == nil {
+		return kp, nil
+	}
+
+	var mkp metakeys.KeyPair
+	err = mkp.UnmarshalJSON(keyData)
+	if err != nil {
+		return nil, err
+	}
+
+	return mkp, nil
+}
+
+// readKeyPairFile returns the plaintext of the key file fname, decrypted with secret if it is encrypted
+func readKeyPairFile(fname string, secret []byte) ([]byte, error) {
 	f, err := os.Open(fname)
 	if err != nil {
 		if os.IsNotExist(err) {
@@ -193,30 +241,19 @@ func LoadKeyPair(fname string) (KeyPair, error) {
 		}
 	}
 
-	kp, err := ParseKeyPair(nocomment.NewReader(f))
-	if err == nil {
-		return kp, nil
-	}
-
-	// roll back and read again
-	_, err = f.Seek(0, io.SeekStart)
-	if err != nil {
-		return nil, err
-	}
-
 	keyData, err := ioutil.ReadAll(f)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("ssb.LoadKeyPair: could not read key file %s: %w", fname, err)
 	}
 
-	var mkp metakeys.KeyPair
-	err = mkp.UnmarshalJSON(keyData)
-	if err != nil {
-		return nil, err
+	sealed, ok := parseEncryptedKeyPair(keyData)
+	if !ok {
+		return keyData, nil
 	}
-
-	return mkp, nil
-
+	if secret == nil {
+		return nil, fmt.Errorf("ssb.LoadKeyPair: %s: %w", fname, ErrKeyPairLocked)
+	}
+	return sealed.open(secret)
 }
 
 // ParseKeyPair json decodes an object from the reader.
@@ -0,0 +1,158 @@
+// SPDX-FileCopyrightText: 2021 The Go-SSB Authors
+//
+// SPDX-License-Identifier: MIT
+
+package ssb
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	refs "github.com/ssbc/go-ssb-refs"
+
+	"github.com/ssbc/go-ssb/internal/keywrap"
+)
+
+/* An encrypted secret file is JSON with the public id of the key pair and the contents of the plaintext file,
+encrypted with a key derived from a secret: a passphrase or the contents of a keyfile.
+This works for the ed25519 secrets of the js implementations as well as for the seeds of metafeeds.
+*/
+
+// encryptedKeyPairVersion is the version of encrypted secret files that is written
+const encryptedKeyPairVersion = 1
+
+// encryptedKeyPairCipher marks a secret file as encrypted
+const encryptedKeyPairCipher = "secretbox"
+
+var (
+	// ErrKeyPairLocked is returned when an encrypted secret file is loaded without a secret
+	ErrKeyPairLocked = errors.New("ssb: the secret file is encrypted, it needs its passphrase or keyfile")
+
+	// ErrKeyPairWrongSecret is returned if the secret doesn't decrypt the secret file
+	ErrKeyPairWrongSecret = errors.New("ssb: wrong passphrase or keyfile for the secret file")
+)
+
+// encryptedKeyPair is the format of an encrypted secret file
+type encryptedKeyPair struct {
+	Version int          `json:"version"`
+	ID      refs.FeedRef `json:"id"`
+	Cipher  string       `json:"cipher"`
+
+	keywrap.Params
+
+	// Sealed is the plaintext secret file, sealed with the key derived from the secret
+	Sealed []byte `json:"sealed"`
+}
+
+// parseEncryptedKeyPair returns false if data isn't an encrypted secret file
+func parseEncryptedKeyPair(data []byte) (encryptedKeyPair, bool) {
+	var ekp encryptedKeyPair
+	if err := json.Unmarshal(data, &ekp); err != nil {
+		return ekp, false
+	}
+	return ekp, ekp.Cipher != ""
+}
+
+func (ekp encryptedKeyPair) open(secret []byte) ([]byte, error) {
+	if ekp.Version != encryptedKeyPairVersion || ekp.Cipher != encryptedKeyPairCipher {
+		return nil, fmt.Errorf("ssb: unsupported secret file encryption %d (%s)", ekp.Version, ekp.Cipher)
+	}
+	plain, err := ekp.Open(secret, ekp.Sealed)
+	if errors.Is(err, keywrap.ErrWrongSecret) {
+		return nil, ErrKeyPairWrongSecret
+	} else if err != nil {
+		return nil, fmt.Errorf("ssb: failed to decrypt the secret file: %w", err)
+	}
+	return plain, nil
+}
+
+// sealKeyPair returns an encrypted secret file for the plaintext secret file of id
+func sealKeyPair(id refs.FeedRef, plain, secret []byte) ([]byte, error) {
+	if len(secret) == 0 {
+		return nil, fmt.Errorf("the secret is empty")
+	}
+	params, err := keywrap.NewParams()
+	if err != nil {
+		return nil, err
+	}
+	ekp := encryptedKeyPair{
+		Version: encryptedKeyPairVersion,
+		ID:      id,
+		Cipher:  encryptedKeyPairCipher,
+		Params:  params,
+	}
+	ekp.Sealed, err = params.Seal(secret, plain)
+	if err != nil {
+		return nil, err
+	}
+	return json.MarshalIndent(ekp, "", "  ")
+}
+
+// SaveEncryptedKeyPair is SaveKeyPair with the file encrypted with secret, a passphrase or the contents of a keyfile.
+// LoadKeyPairWithSecret loads it again.
+func SaveEncryptedKeyPair(kp KeyPair, path string, secret []byte) error {
+	if len(secret) == 0 {
+		return fmt.Errorf("ssb.SaveEncryptedKeyPair: the secret is empty")
+	}
+	return saveKeyPair(kp, path, secret)
+}
+
+// IsEncryptedKeyPair returns true if the secret file at path is encrypted
+func IsEncryptedKeyPair(path string) (bool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return false, err
+	}
+	_, ok := parseEncryptedKeyPair(data)
+	return ok, nil
+}
+
+// ReencryptKeyPair replaces the secret file at path, which is decrypted with oldSecret, with one that is encrypted with newSecret.
+// A nil oldSecret is for a file that isn't encrypted yet and a nil newSecret writes the file in plaintext again.
+// The contents of the plaintext file, like its comments, stay as they are.
+func ReencryptKeyPair(path string, oldSecret, newSecret []byte) error {
+	plain, err := readKeyPairFile(path, oldSecret)
+	if err != nil {
+		return err
+	}
+
+	// check that it is a key pair before it is written again
+	kp, err := LoadKeyPairWithSecret(path, oldSecret)
+	if err != nil {
+		return err
+	}
+
+	data := plain
+	if newSecret != nil {
+		data, err = sealKeyPair(kp.ID(), plain, newSecret)
+		if err != nil {
+			return fmt.Errorf("ssb.ReencryptKeyPair: %w", err)
+		}
+	}
+
+	// the secret file is read-only, it is replaced instead of overwritten
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("ssb.ReencryptKeyPair: failed to create file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("ssb.ReencryptKeyPair: failed to write file: %w", err)
+	}
+	if err := tmp.Chmod(SecretPerms); err != nil {
+		tmp.Close()
+		return fmt.Errorf("ssb.ReencryptKeyPair: failed to set permissions: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("ssb.ReencryptKeyPair: failed to close file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("ssb.ReencryptKeyPair: failed to replace %s: %w", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+// SPDX-FileCopyrightText: 2021 The Go-SSB Authors
+//
+// SPDX-License-Identifier: MIT
+
+package ssb
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/ssbc/go-metafeed/metakeys"
+	"github.com/ssbc/go-secretstream/secrethandshake"
+	"golang.org/x/crypto/ed25519"
+
+	refs "github.com/ssbc/go-ssb-refs"
+
+	"github.com/ssbc/go-ssb/internal/bip39"
+)
+
+// mnemonicChunk is how many bytes of a seed are one group of 12 words.
+// ssb-keys-mnemonic encodes the 32 byte seed of an ed25519 key pair as two of them.
+const (
+	mnemonicChunk = 16
+	mnemonicWords = 12
+)
+
+// KeyPairToMnemonic returns the seed of kp as words of the english BIP39 wordlist, which KeyPairFromMnemonic recovers it from.
+// An ed25519 key pair is 24 words, like ssb-keys-mnemonic of the js implementations writes them.
+// The seed of a metafeed is 48 words.
+func KeyPairToMnemonic(kp KeyPair) (string, error) {
+	var seed []byte
+	switch tkp := kp.(type) {
+	case metakeys.KeyPair:
+		seed = tkp.Seed
+	default:
+		seed = kp.Secret().Seed()
+	}
+	if len(seed) == 0 || len(seed)%mnemonicChunk != 0 {
+		return "", fmt.Errorf("ssb: a seed of %d bytes can't be written as words", len(seed))
+	}
+
+	var words []string
+	for i := 0; i < len(seed); i += mnemonicChunk {
+		chunk, err := bip39.EntropyToMnemonic(seed[i : i+mnemonicChunk])
+		if err != nil {
+			return "", err
+		}
+		words = append(words, chunk...)
+	}
+	return strings.Join(words, " "), nil
+}
+
+// KeyPairFromMnemonic returns the key pair of the words of KeyPairToMnemonic.
+// With refs.RefAlgoFeedBendyButt the seed is the one of a metafeed, otherwise it has to be the 24 words of an ed25519 key pair.
+func KeyPairFromMnemonic(words string, algo refs.RefAlgo) (KeyPair, error) {
+	fields := strings.Fields(words)
+	if len(fields) == 0 || len(fields)%mnemonicWords != 0 {
+		return nil, fmt.Errorf("ssb: %d words, they have to be groups of %d", len(fields), mnemonicWords)
+	}
+
+	var seed []byte
+	for i := 0; i < len(fields); i += mnemonicWords {
+		chunk, err := bip39.MnemonicToEntropy(fields[i : i+mnemonicWords])
+		if err != nil {
+			return nil, fmt.Errorf("ssb: words %d to %d: %w", i+1, i+mnemonicWords, err)
+		}
+		seed = append(seed, chunk...)
+	}
+
+	if algo == refs.RefAlgoFeedBendyButt {
+		return metakeys.DeriveFromSeed(seed, metafeedSeedLabel, refs.RefAlgoFeedBendyButt)
+	}
+
+	if len(seed) != ed25519.SeedSize {
+		return nil, fmt.Errorf("ssb: the words have a seed of %d bytes, an ed25519 key pair has %d", len(seed), ed25519.SeedSize)
+	}
+	secret := ed25519.NewKeyFromSeed(seed)
+	public := secret.Public().(ed25519.PublicKey)
+
+	feed, err := refs.NewFeedRefFromBytes(public, algo)
+	if err != nil {
+		return nil, err
+	}
+	return LegacyKeyPair{
+		Feed: feed,
+		Pair: secrethandshake.EdKeyPair{
+			Public: public,
+			Secret: secret,
+		},
+	}, nil
+}
@@ -5,8 +5,10 @@
 package ssb
 
 import (
+	"encoding/base64"
 	"os"
 	"path"
+	"strings"
 	"testing"
 
 	refs "github.com/ssbc/go-ssb-refs"
@@ -69,3 +71,97 @@ func TestLoadKeyPair(t *testing.T) {
 		})
 	}
 }
+
+func TestEncryptedKeyPair(t *testing.T) {
+	r := require.New(t)
+
+	dir := t.TempDir()
+	secret := []byte("correct horse battery staple")
+
+	for _, algo := range []refs.RefAlgo{refs.RefAlgoFeedSSB1, refs.RefAlgoFeedBendyButt} {
+		fname := path.Join(dir, string(algo))
+
+		keys, err := NewKeyPair(nil, algo)
+		r.NoError(err)
+		r.Error(SaveEncryptedKeyPair(keys, fname, nil))
+		r.NoError(SaveEncryptedKeyPair(keys, fname, secret))
+
+		data, err := os.ReadFile(fname)
+		r.NoError(err)
+		r.NotContains(string(data), base64.StdEncoding.EncodeToString(keys.Secret()))
+		enc, err := IsEncryptedKeyPair(fname)
+		r.NoError(err)
+		r.True(enc)
+
+		_, err = LoadKeyPair(fname)
+		r.ErrorIs(err, ErrKeyPairLocked)
+		_, err = LoadKeyPairWithSecret(fname, []byte("wrong"))
+		r.ErrorIs(err, ErrKeyPairWrongSecret)
+		loaded, err := LoadKeyPairWithSecret(fname, secret)
+		r.NoError(err)
+		r.True(loaded.ID().Equal(keys.ID()))
+		r.Equal(keys.Secret(), loaded.Secret())
+
+		// changing the secret
+		r.ErrorIs(ReencryptKeyPair(fname, []byte("wrong"), []byte("new")), ErrKeyPairWrongSecret)
+		r.NoError(ReencryptKeyPair(fname, secret, []byte("new")))
+		_, err = LoadKeyPairWithSecret(fname, secret)
+		r.ErrorIs(err, ErrKeyPairWrongSecret)
+		loaded, err = LoadKeyPairWithSecret(fname, []byte("new"))
+		r.NoError(err)
+		r.True(loaded.ID().Equal(keys.ID()))
+
+		// exporting it in plaintext and encrypting it again
+		r.NoError(ReencryptKeyPair(fname, []byte("new"), nil))
+		enc, err = IsEncryptedKeyPair(fname)
+		r.NoError(err)
+		r.False(enc)
+		loaded, err = LoadKeyPair(fname)
+		r.NoError(err)
+		r.True(loaded.ID().Equal(keys.ID()))
+		stat, err := os.Stat(fname)
+		r.NoError(err)
+		r.Equal(SecretPerms, stat.Mode())
+
+		r.NoError(ReencryptKeyPair(fname, nil, secret))
+		loaded, err = LoadKeyPairWithSecret(fname, secret)
+		r.NoError(err)
+		r.True(loaded.ID().Equal(keys.ID()))
+	}
+}
+
+func TestKeyPairMnemonic(t *testing.T) {
+	r := require.New(t)
+
+	// the seed of zeros, which is 24 words like ssb-keys-mnemonic writes them
+	zeros := strings.Repeat("abandon ", 11) + "about"
+	kp, err := KeyPairFromMnemonic(zeros+" "+zeros, refs.RefAlgoFeedSSB1)
+	r.NoError(err)
+	r.Equal("@O2onvM62pC1io6jQKm8Nc2UyFXcd4kOmOsBIoYtZ2ik=.ed25519", kp.ID().String())
+	words, err := KeyPairToMnemonic(kp)
+	r.NoError(err)
+	r.Equal(zeros+" "+zeros, words)
+
+	for _, algo := range []refs.RefAlgo{refs.RefAlgoFeedSSB1, refs.RefAlgoFeedGabby, refs.RefAlgoFeedBendyButt} {
+		keys, err := NewKeyPair(nil, algo)
+		r.NoError(err)
+		words, err := KeyPairToMnemonic(keys)
+		r.NoError(err)
+		if algo == refs.RefAlgoFeedBendyButt {
+			r.Len(strings.Fields(words), 48)
+		} else {
+			r.Len(strings.Fields(words), 24)
+		}
+
+		recovered, err := KeyPairFromMnemonic(words, algo)
+		r.NoError(err)
+		r.True(recovered.ID().Equal(keys.ID()), algo)
+		r.Equal(keys.Secret(), recovered.Secret())
+	}
+
+	// a typo is caught by the checksum
+	_, err = KeyPairFromMnemonic(zeros+" "+strings.Replace(zeros, "about", "abandon", 1), refs.RefAlgoFeedSSB1)
+	r.Error(err)
+	_, err = KeyPairFromMnemonic(zeros, refs.RefAlgoFeedSSB1)
+	r.Error(err, "only 12 words")
+}
@@ -37,35 +37,45 @@ type SaveMessager interface {
 // TODO: needs configuration for hmac and what not..
 // => maybe construct those from a (global) ref register where all the suffixes live with their corresponding network configuration?
 func NewVerifySink(who refs.FeedRef, latest refs.Message, saver SaveMessager, hmacKey *[32]byte) (SequencedVerificationSink, error) {
+	verify, err := NewVerifier(who, hmacKey)
+	if err != nil {
+		return nil, fmt.Errorf("NewVerifySink: %w", err)
+	}
 	drain := &generalVerifyDrain{
+		verify:    verify,
 		who:       who,
 		latestSeq: int64(latest.Seq()),
 		latestMsg: latest,
 		storage:   saver,
 	}
+	return drain, nil
+}
+
+// Verifier checks the signature of a single message in the wire format of its feed.
+type Verifier interface {
+	// Verify checks if a message is valid and returns it or an error if it isn't
+	Verify([]byte) (refs.Message, error)
+}
+
+// NewVerifier returns the Verifier for the feed format of who, which is what the sinks of NewVerifySink use.
+// It isn't safe for concurrent use.
+func NewVerifier(who refs.FeedRef, hmacKey *[32]byte) (Verifier, error) {
 	switch who.Algo() {
 	case refs.RefAlgoFeedSSB1:
-		drain.verify = &legacyVerify{
+		return &legacyVerify{
 			hmacKey: hmacKey,
 			buf:     new(bytes.Buffer),
-		}
+		}, nil
 
 	case refs.RefAlgoFeedGabby:
-		drain.verify = &gabbyVerify{hmacKey: hmacKey}
+		return &gabbyVerify{hmacKey: hmacKey}, nil
 
 	case refs.RefAlgoFeedBendyButt:
-		drain.verify = &metafeedVerify{hmacKey: hmacKey}
+		return &metafeedVerify{hmacKey: hmacKey}, nil
 
 	default:
-		return nil, fmt.Errorf("NewVerifySink: unsupported feed algorithm %s", who.Algo())
-
+		return nil, fmt.Errorf("unsupported feed algorithm %s", who.Algo())
 	}
-	return drain, nil
-}
-
-type verifier interface {
-	// Verify checks if a message is valid and returns it or an error if it isn't
-	Verify([]byte) (refs.Message, error)
 }
 
 type legacyVerify struct {
@@ -140,7 +150,7 @@ func (mv metafeedVerify) Verify(trBytes []byte) (refs.Message, error) {
 
 type generalVerifyDrain struct {
 	// gets the input from the screen and returns the next decoded message, if it is valid
-	verify verifier
+	verify Verifier
 
 	who refs.FeedRef // which feed is pulled
 
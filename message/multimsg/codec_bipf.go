@@ -0,0 +1,178 @@
+// SPDX-FileCopyrightText: 2021 The Go-SSB Authors
+//
+// SPDX-License-Identifier: MIT
+
+package multimsg
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"time"
+
+	refs "github.com/ssbc/go-ssb-refs"
+	"github.com/ssbc/margaret"
+
+	"github.com/ssbc/go-ssb/internal/bipf"
+	"github.com/ssbc/go-ssb/internal/storedrefs"
+	"github.com/ssbc/go-ssb/message/legacy"
+)
+
+// BIPFCodec encodes messages the way ssb-db2 keeps them in its log.bipf:
+// a bipf object of the key, the signed value and the timestamp of when it was received.
+//
+// Only classic messages can be stored like this. Records of other feed formats,
+// which ssb-db2 might have, decode to an error that wraps margaret.ErrNulled so that they are skipped like nulled entries.
+type BIPFCodec struct{}
+
+var _ margaret.Codec = BIPFCodec{}
+
+// ErrUnsupportedFormat is returned by codecs for messages they can't store
+var ErrUnsupportedFormat = errors.New("multimsg: message format not supported by the codec")
+
+var (
+	bipfKey       = bipf.EncodeString("key")
+	bipfValue     = bipf.EncodeString("value")
+	bipfTimestamp = bipf.EncodeString("timestamp")
+)
+
+func (c BIPFCodec) Marshal(v interface{}) ([]byte, error) {
+	mm, ok := v.(MultiMessage)
+	if !ok {
+		return nil, fmt.Errorf("bipfCodec: wrong type: %T", v)
+	}
+
+	sm, ok := mm.AsLegacy()
+	if !ok {
+		return nil, fmt.Errorf("bipfCodec: only classic messages can be stored, not %s: %w", mm.Author().Algo(), ErrUnsupportedFormat)
+	}
+
+	value, err := bipf.FromJSON(sm.Raw_)
+	if err != nil {
+		return nil, fmt.Errorf("bipfCodec: failed to encode the value of %s: %w", sm.Key_.String(), err)
+	}
+
+	// in milliseconds, like Date.now()
+	received := float64(sm.Timestamp_.UnixNano() / int64(time.Millisecond))
+	return bipf.EncodeObject(
+		bipfKey, bipf.EncodeString(sm.Key_.String()),
+		bipfValue, value,
+		bipfTimestamp, bipf.EncodeNumber(received),
+	), nil
+}
+
+func (c BIPFCodec) Unmarshal(data []byte) (interface{}, error) {
+	field := func(obj []byte, name string) ([]byte, error) {
+		v, err := bipf.Lookup(obj, name)
+		if err != nil {
+			return nil, fmt.Errorf("bipfCodec: failed to get %s: %w", name, err)
+		}
+		return v, nil
+	}
+
+	keyField, err := field(data, "key")
+	if err != nil {
+		return nil, err
+	}
+	keyStr, err := bipf.DecodeString(keyField)
+	if err != nil {
+		return nil, err
+	}
+	key, err := refs.ParseMessageRef(keyStr)
+	if err != nil {
+		return nil, fmt.Errorf("bipfCodec: invalid key: %w", err)
+	}
+
+	value, err := field(data, "value")
+	if err != nil {
+		return nil, err
+	}
+
+	authorField, err := field(value, "author")
+	if err != nil {
+		return nil, err
+	}
+	authorStr, err := bipf.DecodeString(authorField)
+	if err != nil {
+		return nil, err
+	}
+	author, err := refs.ParseFeedRef(authorStr)
+	if err != nil || author.Algo() != refs.RefAlgoFeedSSB1 {
+		return nil, fmt.Errorf("bipfCodec: %s is not a classic message: %w", keyStr, margaret.ErrNulled)
+	}
+
+	seqField, err := field(value, "sequence")
+	if err != nil {
+		return nil, err
+	}
+	seq, err := bipf.DecodeNumber(seqField)
+	if err != nil {
+		return nil, err
+	}
+
+	sm := &legacy.StoredMessage{
+		Author_:   storedrefs.SerialzedFeed{FeedRef: author},
+		Key_:      storedrefs.SerialzedMessage{MessageRef: key},
+		Sequence_: int64(seq),
+	}
+
+	prevField, err := field(value, "previous")
+	if err != nil {
+		return nil, err
+	}
+	if tipe, _ := bipf.TypeOf(prevField); tipe == bipf.String {
+		prevStr, _ := bipf.DecodeString(prevField)
+		prev, err := refs.ParseMessageRef(prevStr)
+		if err != nil {
+			return nil, fmt.Errorf("bipfCodec: invalid previous of %s: %w", keyStr, err)
+		}
+		sm.Previous_ = &storedrefs.SerialzedMessage{MessageRef: prev}
+	}
+
+	if tsField, err := bipf.Lookup(data, "timestamp"); err == nil {
+		if ms, err := bipf.DecodeNumber(tsField); err == nil {
+			sec := math.Floor(ms / 1000)
+			sm.Timestamp_ = time.Unix(int64(sec), int64((ms-sec*1000)*float64(time.Millisecond)))
+		}
+	}
+
+	sm.Raw_, err = bipf.ToJSON(value)
+	if err != nil {
+		return nil, fmt.Errorf("bipfCodec: failed to decode the value of %s: %w", keyStr, err)
+	}
+
+	mm := NewMultiMessageFromLegacy(sm)
+	mm.received = sm.Timestamp_
+	return mm, nil
+}
+
+func (c BIPFCodec) NewEncoder(w io.Writer) margaret.Encoder {
+	return bipfEncoder{w: w}
+}
+
+func (c BIPFCodec) NewDecoder(r io.Reader) margaret.Decoder {
+	return bipfDecoder{r: r}
+}
+
+type bipfEncoder struct{ w io.Writer }
+
+func (enc bipfEncoder) Encode(v interface{}) error {
+	data, err := BIPFCodec{}.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = enc.w.Write(data)
+	return err
+}
+
+type bipfDecoder struct{ r io.Reader }
+
+func (dec bipfDecoder) Decode() (interface{}, error) {
+	data, err := ioutil.ReadAll(dec.r)
+	if err != nil {
+		return nil, err
+	}
+	return BIPFCodec{}.Unmarshal(data)
+}
@@ -0,0 +1,100 @@
+// SPDX-FileCopyrightText: 2021 The Go-SSB Authors
+//
+// SPDX-License-Identifier: MIT
+
+package multimsg
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	refs "github.com/ssbc/go-ssb-refs"
+	"github.com/ssbc/margaret"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ssbc/go-ssb"
+	"github.com/ssbc/go-ssb/internal/bipf"
+	"github.com/ssbc/go-ssb/internal/storedrefs"
+	"github.com/ssbc/go-ssb/message/legacy"
+)
+
+func TestBIPFCodec(t *testing.T) {
+	r := require.New(t)
+
+	kp, err := ssb.NewKeyPair(bytes.NewReader(bytes.Repeat([]byte("bipf"), 8)), refs.RefAlgoFeedSSB1)
+	r.NoError(err)
+
+	var prev *refs.MessageRef
+	for i := int64(1); i < 3; i++ {
+		lm := legacy.LegacyMessage{
+			Previous:  prev,
+			Author:    kp.ID().String(),
+			Sequence:  i,
+			Timestamp: 1612345678901 + i,
+			Hash:      "sha256",
+			Content: map[string]interface{}{
+				"type": "post",
+				"text": "héllo \"wörld\" <&> 🦄\n",
+				"n":    1.5,
+			},
+		}
+		key, raw, err := lm.Sign(kp.Secret(), nil)
+		r.NoError(err)
+
+		sm := &legacy.StoredMessage{
+			Author_:    storedrefs.SerialzedFeed{FeedRef: kp.ID()},
+			Key_:       storedrefs.SerialzedMessage{MessageRef: key},
+			Sequence_:  i,
+			Timestamp_: time.Unix(1612345679, 123000000),
+			Raw_:       raw,
+		}
+		if prev != nil {
+			sm.Previous_ = &storedrefs.SerialzedMessage{MessageRef: *prev}
+		}
+
+		data, err := BIPFCodec{}.Marshal(*NewMultiMessageFromLegacy(sm))
+		r.NoError(err)
+
+		// the fields ssb-db2 looks at
+		keyField, err := bipf.Lookup(data, "key")
+		r.NoError(err)
+		keyStr, err := bipf.DecodeString(keyField)
+		r.NoError(err)
+		r.Equal(key.String(), keyStr)
+
+		v, err := BIPFCodec{}.Unmarshal(data)
+		r.NoError(err)
+		mm := v.(*MultiMessage)
+		r.True(mm.Key().Equal(key))
+		r.True(mm.Author().Equal(kp.ID()))
+		r.EqualValues(i, mm.Seq())
+		r.WithinDuration(sm.Timestamp_, mm.Received(), time.Millisecond)
+		if prev == nil {
+			r.Nil(mm.Previous())
+		} else {
+			r.True(mm.Previous().Equal(*prev))
+		}
+
+		// the value still has the same signature and hash
+		got, ok := mm.AsLegacy()
+		r.True(ok)
+		verifiedKey, _, err := legacy.Verify(got.Raw_, nil)
+		r.NoError(err)
+		r.True(verifiedKey.Equal(key))
+
+		prev = &key
+	}
+
+	// other feed formats are skipped
+	gabbyKP, err := ssb.NewKeyPair(nil, refs.RefAlgoFeedGabby)
+	r.NoError(err)
+	record := bipf.EncodeObject(
+		bipf.EncodeString("key"), bipf.EncodeString(prev.String()),
+		bipf.EncodeString("value"), bipf.EncodeObject(
+			bipf.EncodeString("author"), bipf.EncodeString(gabbyKP.ID().String()),
+		),
+	)
+	_, err = BIPFCodec{}.Unmarshal(record)
+	r.ErrorIs(err, margaret.ErrNulled)
+}
@@ -0,0 +1,118 @@
+// SPDX-FileCopyrightText: 2021 The Go-SSB Authors
+//
+// SPDX-License-Identifier: MIT
+
+package multimsg
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"time"
+
+	refs "github.com/ssbc/go-ssb-refs"
+	"github.com/ssbc/margaret"
+
+	"github.com/ssbc/go-ssb/message/legacy"
+)
+
+// FlumeCodec encodes messages the way ssb-db keeps them in its flumelog-offset (~/.ssb/flume/log.offset):
+// the JSON of an object with the key, the signed value and the timestamp of when it was received.
+//
+// Like the BIPFCodec it can only store classic messages.
+type FlumeCodec struct{}
+
+var _ margaret.Codec = FlumeCodec{}
+
+type flumeRecord struct {
+	Key       refs.MessageRef `json:"key"`
+	Value     json.RawMessage `json:"value"`
+	Timestamp float64         `json:"timestamp"`
+}
+
+func (c FlumeCodec) Marshal(v interface{}) ([]byte, error) {
+	var mm MultiMessage
+	switch tv := v.(type) {
+	case MultiMessage:
+		mm = tv
+	case *MultiMessage:
+		mm = *tv
+	default:
+		return nil, fmt.Errorf("flumeCodec: wrong type: %T", v)
+	}
+
+	sm, ok := mm.AsLegacy()
+	if !ok {
+		return nil, fmt.Errorf("flumeCodec: only classic messages can be stored, not %s: %w", mm.Author().Algo(), ErrUnsupportedFormat)
+	}
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	err := enc.Encode(flumeRecord{
+		Key:   sm.Key_.MessageRef,
+		Value: sm.Raw_,
+		// in milliseconds, like Date.now()
+		Timestamp: float64(sm.Timestamp_.UnixNano() / int64(time.Millisecond)),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("flumeCodec: failed to encode %s: %w", sm.Key_.String(), err)
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
+
+func (c FlumeCodec) Unmarshal(data []byte) (interface{}, error) {
+	var msg refs.KeyValueRaw
+	err := json.Unmarshal(data, &msg)
+	if err != nil {
+		return nil, fmt.Errorf("flumeCodec: failed to decode message: %w", err)
+	}
+
+	// the value is decoded a second time as it is, so that its signature can still be verified
+	var rec flumeRecord
+	err = json.Unmarshal(data, &rec)
+	if err != nil {
+		return nil, fmt.Errorf("flumeCodec: failed to decode message: %w", err)
+	}
+
+	mm := NewMultiMessageFromKeyValRaw(msg, rec.Value)
+	sec := math.Floor(rec.Timestamp / 1000)
+	mm.received = time.Unix(int64(sec), int64((rec.Timestamp-sec*1000)*float64(time.Millisecond)))
+	mm.Message.(*legacy.StoredMessage).Timestamp_ = mm.received
+	return mm, nil
+}
+
+func (c FlumeCodec) NewEncoder(w io.Writer) margaret.Encoder {
+	return flumeEncoder{w: w}
+}
+
+func (c FlumeCodec) NewDecoder(r io.Reader) margaret.Decoder {
+	return flumeDecoder{r: r}
+}
+
+type flumeEncoder struct{ w io.Writer }
+
+func (enc flumeEncoder) Encode(v interface{}) error {
+	data, err := FlumeCodec{}.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = enc.w.Write(data)
+	return err
+}
+
+type flumeDecoder struct{ r io.Reader }
+
+func (dec flumeDecoder) Decode() (interface{}, error) {
+	data, err := ioutil.ReadAll(dec.r)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) == 0 {
+		return nil, io.EOF
+	}
+	return FlumeCodec{}.Unmarshal(data)
+}
@@ -0,0 +1,84 @@
+// SPDX-FileCopyrightText: 2021 The Go-SSB Authors
+//
+// SPDX-License-Identifier: MIT
+
+package multimsg
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+
+	refs "github.com/ssbc/go-ssb-refs"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ssbc/go-ssb"
+	"github.com/ssbc/go-ssb/internal/storedrefs"
+	"github.com/ssbc/go-ssb/message/legacy"
+)
+
+func TestFlumeCodec(t *testing.T) {
+	r := require.New(t)
+
+	kp, err := ssb.NewKeyPair(bytes.NewReader(bytes.Repeat([]byte("flum"), 8)), refs.RefAlgoFeedSSB1)
+	r.NoError(err)
+
+	lm := legacy.LegacyMessage{
+		Author:    kp.ID().String(),
+		Sequence:  1,
+		Timestamp: 1612345678901,
+		Hash:      "sha256",
+		Content:   map[string]interface{}{"type": "post", "text": "<&> 🦄"},
+	}
+	key, raw, err := lm.Sign(kp.Secret(), nil)
+	r.NoError(err)
+
+	sm := &legacy.StoredMessage{
+		Author_:    storedrefs.SerialzedFeed{FeedRef: kp.ID()},
+		Key_:       storedrefs.SerialzedMessage{MessageRef: key},
+		Sequence_:  1,
+		Timestamp_: time.Unix(1612345679, 123000000),
+		Raw_:       raw,
+	}
+
+	data, err := FlumeCodec{}.Marshal(NewMultiMessageFromLegacy(sm))
+	r.NoError(err)
+	r.Contains(string(data), `"<&> 🦄"`, "no HTML escaping")
+
+	// the record of ssb-db
+	var rec struct {
+		Key       string
+		Value     map[string]interface{}
+		Timestamp int64
+	}
+	r.NoError(json.Unmarshal(data, &rec))
+	r.Equal(key.String(), rec.Key)
+	r.EqualValues(1, rec.Value["sequence"])
+	r.EqualValues(1612345679123, rec.Timestamp)
+
+	v, err := FlumeCodec{}.Unmarshal(data)
+	r.NoError(err)
+	mm := v.(MultiMessage)
+	r.True(mm.Key().Equal(key))
+	r.True(mm.Author().Equal(kp.ID()))
+	r.Nil(mm.Previous())
+	r.True(sm.Timestamp_.Equal(mm.Received()))
+
+	// the value still has the same signature and hash
+	got, ok := mm.AsLegacy()
+	r.True(ok)
+	verifiedKey, _, err := legacy.Verify(got.Raw_, nil)
+	r.NoError(err)
+	r.True(verifiedKey.Equal(key))
+
+	// other feed formats can't be stored
+	gabbyKP, err := ssb.NewKeyPair(nil, refs.RefAlgoFeedGabby)
+	r.NoError(err)
+	gabby := NewMultiMessageFromLegacy(&legacy.StoredMessage{
+		Author_: storedrefs.SerialzedFeed{FeedRef: gabbyKP.ID()},
+	})
+	gabby.tipe = Gabby
+	_, err = FlumeCodec{}.Marshal(gabby)
+	r.ErrorIs(err, ErrUnsupportedFormat)
+}
@@ -38,8 +38,11 @@ type WrappedLog struct {
 }
 
 func (wl WrappedLog) Append(val interface{}) (int64, error) {
-	if mm, ok := val.(*MultiMessage); ok {
+	switch mm := val.(type) {
+	case *MultiMessage:
 		return wl.AlterableLog.Append(*mm)
+	case MultiMessage:
+		return wl.AlterableLog.Append(mm)
 	}
 
 	var mm MultiMessage
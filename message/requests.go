@@ -83,6 +83,36 @@ type TanglesArgs struct {
 	Name string `json:"name"`
 }
 
+// LinksArgs defines the query parameters for the links rpc call
+type LinksArgs struct {
+	// Dest is the feed, message or blob that is linked to
+	Dest string `json:"dest"`
+
+	// Source only returns the links of this author
+	Source *refs.FeedRef `json:"source,omitempty"`
+
+	// Rel only returns the links in this field of the content, like mentions or vote
+	Rel string `json:"rel,omitempty"`
+
+	Keys   bool `json:"keys"`
+	Values bool `json:"values,omitempty"`
+
+	Live bool `json:"live,omitempty"`
+	Old  bool `json:"old"`
+
+	Reverse bool  `json:"reverse,omitempty"`
+	Limit   int64 `json:"limit,omitempty"`
+}
+
+// NewLinksArgs returns the defaults for links, which are the existing links with their keys
+func NewLinksArgs() LinksArgs {
+	return LinksArgs{
+		Keys:  true,
+		Old:   true,
+		Limit: -1,
+	}
+}
+
 type InviteCreateArgs struct {
 	Uses int
 }
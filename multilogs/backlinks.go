@@ -0,0 +1,82 @@
+// SPDX-FileCopyrightText: 2021 The Go-SSB Authors
+//
+// SPDX-License-Identifier: MIT
+
+package multilogs
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+
+	refs "github.com/ssbc/go-ssb-refs"
+	librarian "github.com/ssbc/margaret/indexes"
+)
+
+// IndexNameBacklinks is the multilog of the messages that link to a feed, message or blob, filled by the combined index
+const IndexNameBacklinks = "backlinks"
+
+// BacklinkAddr returns the sublog address of all the messages that link to dest
+func BacklinkAddr(dest refs.Ref) librarian.Addr {
+	return librarian.Addr("link:" + dest.String())
+}
+
+// BacklinkRelAddr returns the sublog address of the messages that link to dest under the content field rel, like root, branch, vote or mentions
+func BacklinkRelAddr(rel string, dest refs.Ref) librarian.Addr {
+	return librarian.Addr("rel:" + rel + ":" + dest.String())
+}
+
+// Link is a reference from the content of a message to dest.
+// Rel is the top-level field of the content it was found in.
+type Link struct {
+	Rel  string
+	Dest refs.Ref
+}
+
+// sigilRef matches the sigil form of feeds, messages and blobs, wherever they appear in the content
+var sigilRef = regexp.MustCompile(`[@%&][A-Za-z0-9/+]{43}=\.[a-z0-9-]+`)
+
+// ExtractLinks returns every @feed, %msg and &blob sigil of a JSON content object, including the ones in text fields.
+// Every destination is only returned once per field.
+func ExtractLinks(content []byte) []Link {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(content, &fields); err != nil {
+		return nil
+	}
+
+	var links []Link
+	for rel, value := range fields {
+		seen := make(map[string]struct{})
+		for _, match := range sigilRef.FindAll(value, -1) {
+			ref, err := refs.ParseRef(string(match))
+			if err != nil {
+				continue
+			}
+
+			dest := ref.String()
+			if _, has := seen[dest]; has {
+				continue
+			}
+			seen[dest] = struct{}{}
+
+			links = append(links, Link{Rel: rel, Dest: ref})
+		}
+	}
+	return links
+}
+
+// updateBacklinks adds rxSeq to the link: and rel: sublogs of every destination the (decrypted) content links to
+func (idx *CombinedIndex) updateBacklinks(rxSeq int64, content []byte) error {
+	for _, link := range ExtractLinks(content) {
+		for _, addr := range []librarian.Addr{BacklinkAddr(link.Dest), BacklinkRelAddr(link.Rel, link.Dest)} {
+			sublog, err := idx.backlinks.Get(addr)
+			if err != nil {
+				return fmt.Errorf("error opening sublog: %w", err)
+			}
+			if _, err := sublog.Append(rxSeq); err != nil {
+				return fmt.Errorf("error updating backlinks sublog: %w", err)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,52 @@
+// SPDX-FileCopyrightText: 2021 The Go-SSB Authors
+//
+// SPDX-License-Identifier: MIT
+
+package multilogs
+
+import (
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestExtractLinks(t *testing.T) {
+	const (
+		feed = "@AQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQE=.ed25519"
+		msg  = "%AgICAgICAgICAgICAgICAgICAgICAgICAgICAgICAgI=.sha256"
+		blob = "&AwMDAwMDAwMDAwMDAwMDAwMDAwMDAwMDAwMDAwMDAwM=.sha256"
+	)
+
+	type relDest struct{ rel, dest string }
+
+	tcs := []struct {
+		name    string
+		content string
+		want    []relDest
+	}{
+		{"not json", `"boxed.box"`, nil},
+		{"no links", `{"type":"post","text":"hello, world!"}`, nil},
+		{"root", `{"type":"post","root":"` + msg + `","branch":["` + msg + `"]}`,
+			[]relDest{{"branch", msg}, {"root", msg}}},
+		{"mentions and text", `{"type":"post","text":"hi ` + feed + `, look at ` + blob + ` ` + blob + `","mentions":[{"link":"` + feed + `","name":"arny"}]}`,
+			[]relDest{{"mentions", feed}, {"text", blob}, {"text", feed}}},
+		{"unknown algo", `{"type":"vote","vote":{"link":"%AgICAgICAgICAgICAgICAgICAgICAgICAgICAgICAgI=.nope"}}`, nil},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			var got []relDest
+			for _, l := range ExtractLinks([]byte(tc.content)) {
+				got = append(got, relDest{l.Rel, l.Dest.String()})
+			}
+			sort.Slice(got, func(i, j int) bool {
+				if got[i].rel != got[j].rel {
+					return got[i].rel < got[j].rel
+				}
+				return got[i].dest < got[j].dest
+			})
+			require.Equal(t, tc.want, got)
+		})
+	}
+}
@@ -33,7 +33,7 @@ import (
 	"github.com/ssbc/go-ssb/repo"
 )
 
-// NewCombinedIndex creates one big index which updates the multilogs users, byType, private and tangles.
+// NewCombinedIndex creates one big index which updates the multilogs users, byType, private, tangles and backlinks.
 // Compared to the "old" fatbot approach of just having 4 independant indexes,
 // this one updates all 4 of them, resulting in less read-overhead
 // while also being able to index private massages by tangle and type.
@@ -42,17 +42,32 @@ func NewCombinedIndex(
 	box *private.Manager,
 	self refs.FeedRef,
 	rxlog margaret.Log,
-	u, p, bt, tan *roaring.MultiLog,
+	u, p, bt, tan, bl *roaring.MultiLog,
 	oh multilog.MultiLog,
 	sm *statematrix.StateMatrix,
 ) (*CombinedIndex, error) {
 	r := repo.New(repoPath)
 	statePath := r.GetPath(repo.PrefixMultiLog, "combined-state.json")
 	mode := os.O_RDWR | os.O_EXCL
-	if _, err := os.Stat(statePath); os.IsNotExist(err) {
+	_, err := os.Stat(statePath)
+	existed := err == nil
+	if os.IsNotExist(err) {
 		mode |= os.O_CREATE
 	}
 	os.MkdirAll(filepath.Dir(statePath), 0700)
+
+	// repos from before the backlinks need to go through all the messages once more to fill them.
+	// appending to the other sublogs again doesn't change them.
+	markerPath := r.GetPath(repo.PrefixMultiLog, "combined-backlinks")
+	if _, err := os.Stat(markerPath); os.IsNotExist(err) {
+		if existed {
+			mode |= os.O_TRUNC
+		}
+		if err := os.WriteFile(markerPath, nil, 0700); err != nil {
+			return nil, fmt.Errorf("error creating backlinks marker: %w", err)
+		}
+	}
+
 	idxStateFile, err := os.OpenFile(statePath, mode, 0700)
 	if err != nil {
 		return nil, fmt.Errorf("error opening state file: %w", err)
@@ -66,7 +81,8 @@ func NewCombinedIndex(
 		users:   u,
 		private: p,
 		byType:  bt,
-		tangles: tan,
+		tangles:   tan,
+		backlinks: bl,
 
 		ebtState: sm,
 
@@ -91,7 +107,8 @@ type CombinedIndex struct {
 	users   *roaring.MultiLog
 	private *roaring.MultiLog
 	byType  *roaring.MultiLog
-	tangles *roaring.MultiLog
+	tangles   *roaring.MultiLog
+	backlinks *roaring.MultiLog
 
 	orderdHelper multilog.MultiLog
 
@@ -250,6 +267,10 @@ func (idx *CombinedIndex) update(rxSeq int64, msg refs.Message) error {
 		content = cleartext
 	}
 
+	if err := idx.updateBacklinks(rxSeq, content); err != nil {
+		return err
+	}
+
 	// by type:...  and tangles (v1 & v2)
 	var jsonContent struct {
 		Type    string
@@ -109,6 +109,7 @@ func setupCombinedIndex(t testing.TB, rxlog margaret.Log, mkMlog makeMultilog) (
 	private := mkMlog(t, testRepo, "private", &mc)
 	byType := mkMlog(t, testRepo, "byType", &mc)
 	groupMembers := mkMlog(t, testRepo, "groupMembers", &mc)
+	backlinks := mkMlog(t, testRepo, "backlinks", &mc)
 
 	snk, err := NewCombinedIndex(filepath.Join(testPath, "combined"),
 		gm,
@@ -119,6 +120,7 @@ func setupCombinedIndex(t testing.TB, rxlog margaret.Log, mkMlog makeMultilog) (
 		private,
 		byType,
 		tangles,
+		backlinks,
 		groupMembers,
 
 		sm,
@@ -55,6 +55,15 @@ func (mc MembershipStore) Close() error {
 	return mc.idx.Close()
 }
 
+// Flush writes the batched updates of the index
+func (mc MembershipStore) Flush() error {
+	f, ok := mc.idx.(interface{ Flush() error })
+	if !ok {
+		return nil
+	}
+	return f.Flush()
+}
+
 func (mc MembershipStore) updateFn(ctx context.Context, seq int64, val interface{}, idx librarian.SetterIndex) error {
 	msg, ok := val.(refs.Message)
 	if !ok {
@@ -0,0 +1,134 @@
+// SPDX-FileCopyrightText: 2021 The Go-SSB Authors
+//
+// SPDX-License-Identifier: MIT
+
+package multilogs
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ssbc/margaret"
+	librarian "github.com/ssbc/margaret/indexes"
+	"github.com/ssbc/margaret/multilog"
+
+	refs "github.com/ssbc/go-ssb-refs"
+	"github.com/ssbc/go-ssb/repo"
+)
+
+// IndexNameQuery is the multilog of the mentions and channels of public messages, as used by the query engine.
+const IndexNameQuery = "query"
+
+// MentionAddr returns the sublog address of the messages that mention ref
+func MentionAddr(ref refs.Ref) librarian.Addr {
+	return librarian.Addr("mention:" + ref.String())
+}
+
+// ChannelAddr returns the sublog address of the messages posted in a channel.
+// Names are compared case-insensitive and without a leading '#'.
+func ChannelAddr(name string) librarian.Addr {
+	return librarian.Addr("channel:" + NormalizeChannel(name))
+}
+
+// NormalizeChannel returns the form of a channel name that is indexed
+func NormalizeChannel(name string) string {
+	return strings.ToLower(strings.TrimPrefix(strings.TrimSpace(name), "#"))
+}
+
+// OpenQueryIndex returns the sink that fills mlog with QueryUpdate.
+// Its state is kept next to the other multilogs of the repo.
+func OpenQueryIndex(r repo.Interface, mlog multilog.MultiLog) (librarian.SinkIndex, error) {
+	statePath := r.GetPath(repo.PrefixMultiLog, IndexNameQuery+"-state.json")
+	mode := os.O_RDWR | os.O_EXCL
+	if _, err := os.Stat(statePath); os.IsNotExist(err) {
+		mode |= os.O_CREATE
+	}
+	os.MkdirAll(filepath.Dir(statePath), 0700)
+	idxStateFile, err := os.OpenFile(statePath, mode, 0700)
+	if err != nil {
+		return nil, fmt.Errorf("error opening state file: %w", err)
+	}
+
+	return fileSink{
+		Sink: multilog.NewSink(idxStateFile, mlog, QueryUpdate),
+		file: idxStateFile,
+	}, nil
+}
+
+// fileSink closes the state file of the sink
+type fileSink struct {
+	multilog.Sink
+	file *os.File
+}
+
+func (fs fileSink) Close() error { return fs.file.Close() }
+
+// QueryUpdate adds a message to the mention:<ref> sublog of every ref it mentions and to the channel:<name> sublog of its channel.
+// Encrypted messages are skipped, so that the sublogs don't leak what they contain.
+func QueryUpdate(ctx context.Context, seq int64, value interface{}, mlog multilog.MultiLog) error {
+	if nulled, ok := value.(error); ok {
+		if margaret.IsErrNulled(nulled) {
+			return nil
+		}
+		return nulled
+	}
+
+	msg, ok := value.(refs.Message)
+	if !ok {
+		return fmt.Errorf("error casting message. got type %T", value)
+	}
+
+	content := msg.ContentBytes()
+	if len(content) == 0 || content[0] != '{' {
+		return nil
+	}
+
+	var jsonContent struct {
+		Channel  string
+		Mentions json.RawMessage
+	}
+	if err := json.Unmarshal(content, &jsonContent); err != nil {
+		// like the combined index, broken content must not stop the indexing
+		return nil
+	}
+
+	var addrs []librarian.Addr
+	if name := NormalizeChannel(jsonContent.Channel); name != "" {
+		addrs = append(addrs, ChannelAddr(name))
+	}
+
+	// mentions that are not a list of links, like the old object form, are skipped
+	var mentions []struct {
+		Link string `json:"link"`
+	}
+	if len(jsonContent.Mentions) > 0 && json.Unmarshal(jsonContent.Mentions, &mentions) == nil {
+		seen := make(map[librarian.Addr]struct{})
+		for _, m := range mentions {
+			ref, err := refs.ParseRef(m.Link)
+			if err != nil {
+				continue
+			}
+			addr := MentionAddr(ref)
+			if _, has := seen[addr]; has {
+				continue
+			}
+			seen[addr] = struct{}{}
+			addrs = append(addrs, addr)
+		}
+	}
+
+	for _, addr := range addrs {
+		sublog, err := mlog.Get(addr)
+		if err != nil {
+			return fmt.Errorf("error opening sublog: %w", err)
+		}
+		if _, err := sublog.Append(seq); err != nil {
+			return fmt.Errorf("error updating query sublog: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+// SPDX-FileCopyrightText: 2021 The Go-SSB Authors
+//
+// SPDX-License-Identifier: MIT
+
+package network
+
+import (
+	"encoding/base64"
+	"net"
+	"strings"
+
+	"github.com/ssbc/go-netwrap"
+	"github.com/ssbc/go-secretstream"
+
+	multiserver "github.com/ssbc/go-ssb-multiserver"
+	refs "github.com/ssbc/go-ssb-refs"
+)
+
+// ParseAddress parses a multiserver address and returns an address that can be passed to Node.Connect.
+// Besides the net:host:port~shs:key form it understands onion:xyz.onion:port~shs:key
+// and the websocket forms ws://host:port~shs:key and wss://host:port/path~shs:key.
+// Alternatives separated by ; are tried in order and the first one that parses is used.
+func ParseAddress(input string) (net.Addr, error) {
+	for _, p := range strings.Split(input, ";") {
+		switch {
+		case strings.HasPrefix(p, "net:"):
+			na, err := multiserver.ParseNetAddress([]byte(p))
+			if err != nil {
+				return nil, err
+			}
+			return na.WrappedAddr(), nil
+
+		case strings.HasPrefix(p, "onion:"):
+			return parseOnionAddress(p)
+
+		case strings.HasPrefix(p, "ws://"), strings.HasPrefix(p, "wss://"):
+			return parseWebsocketAddress(p)
+		}
+	}
+	return nil, multiserver.ErrNoNetAddr
+}
+
+// MultiserverAddress formats the transport and secret-handshake parts of addr as a multiserver address.
+// It returns an empty string if either of them is missing.
+func MultiserverAddress(addr net.Addr) string {
+	shsAddr, ok := netwrap.GetAddr(addr, secretstream.NetworkString).(secretstream.Addr)
+	if !ok {
+		return ""
+	}
+	ref, err := refs.NewFeedRefFromBytes(shsAddr.PubKey, refs.RefAlgoFeedSSB1)
+	if err != nil {
+		return ""
+	}
+
+	if onion, ok := netwrap.GetAddr(addr, OnionNetwork).(OnionAddr); ok {
+		return "onion:" + onion.String() + "~shs:" + base64.StdEncoding.EncodeToString(ref.PubKey())
+	}
+
+	if ws, ok := netwrap.GetAddr(addr, WebsocketNetwork).(WebsocketAddr); ok {
+		return ws.URL + "~shs:" + base64.StdEncoding.EncodeToString(ref.PubKey())
+	}
+
+	var ms multiserver.NetAddress
+	ms.Ref = ref
+	if tcpAddr, ok := netwrap.GetAddr(addr, "tcp").(*net.TCPAddr); ok {
+		ms.Addr = *tcpAddr
+	}
+	return ms.String()
+}
@@ -0,0 +1,57 @@
+// SPDX-FileCopyrightText: 2021 The Go-SSB Authors
+//
+// SPDX-License-Identifier: MIT
+
+package network_test
+
+import (
+	"bytes"
+	"encoding/base64"
+	"testing"
+
+	"github.com/ssbc/go-netwrap"
+	"github.com/ssbc/go-secretstream"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ssbc/go-ssb/network"
+)
+
+func TestParseAddress(t *testing.T) {
+	r := require.New(t)
+
+	key := bytes.Repeat([]byte{1}, 32)
+	b64Key := base64.StdEncoding.EncodeToString(key)
+
+	addr, err := network.ParseAddress("onion:" + testOnionHost + ":8008~shs:" + b64Key)
+	r.NoError(err)
+	r.Equal(network.OnionAddr{Host: testOnionHost, Port: 8008}, netwrap.GetAddr(addr, network.OnionNetwork))
+	r.Equal(key, []byte(netwrap.GetAddr(addr, secretstream.NetworkString).(secretstream.Addr).PubKey))
+	r.Nil(netwrap.GetAddr(addr, "tcp"))
+	r.Equal("onion:"+testOnionHost+":8008~shs:"+b64Key, network.MultiserverAddress(addr))
+
+	// the first alternative that is understood is used
+	addr, err = network.ParseAddress("dht:abc~noauth;net:127.0.0.1:8008~shs:" + b64Key + ";ws://127.0.0.1:8989~shs:" + b64Key)
+	r.NoError(err)
+	r.Equal("127.0.0.1:8008", netwrap.GetAddr(addr, "tcp").String())
+	r.Equal("net:127.0.0.1:8008~shs:"+b64Key, network.MultiserverAddress(addr))
+
+	addr, err = network.ParseAddress("wss://example.com/ssb~shs:" + b64Key)
+	r.NoError(err)
+	r.Equal(network.WebsocketAddr{URL: "wss://example.com/ssb"}, netwrap.GetAddr(addr, network.WebsocketNetwork))
+	r.Nil(netwrap.GetAddr(addr, "tcp"))
+	r.Equal("wss://example.com/ssb~shs:"+b64Key, network.MultiserverAddress(addr))
+
+	for i, bad := range []string{
+		"",
+		"onion:example.com:8008~shs:" + b64Key,
+		"onion:" + testOnionHost + "~shs:" + b64Key,
+		"onion:" + testOnionHost + ":nope~shs:" + b64Key,
+		"onion:" + testOnionHost + ":8008",
+		"onion:" + testOnionHost + ":8008~shs:AAAA",
+		"ws://~shs:" + b64Key,
+		"ws://example.com:8989",
+	} {
+		_, err := network.ParseAddress(bad)
+		r.Error(err, "bad address %d", i)
+	}
+}
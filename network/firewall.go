@@ -0,0 +1,378 @@
+// SPDX-FileCopyrightText: 2021 The Go-SSB Authors
+//
+// SPDX-License-Identifier: MIT
+
+package network
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/ssbc/go-netwrap"
+	"go.mindeco.de/log"
+	"go.mindeco.de/log/level"
+
+	"github.com/ssbc/go-ssb"
+	refs "github.com/ssbc/go-ssb-refs"
+)
+
+// FailureReporter is an optional interface for ssb.ConnTracker implementations.
+// The Node calls it for incoming connections that didn't make it to a muxrpc session.
+type FailureReporter interface {
+	// HandshakeFailed is called for connections that didn't complete the secret-handshake, for instance because of a wrong app key.
+	HandshakeFailed(remote net.Addr, err error)
+
+	// Unauthorized is called if the remote feed was denied by the handler creation.
+	Unauthorized(remote net.Addr, err error)
+}
+
+// ListenerExempter is an optional interface for ssb.ConnTracker implementations.
+// The Node passes it the address of the hidden-service listener, whose connections all come from the local tor daemon.
+type ListenerExempter interface {
+	ExemptListener(local net.Addr)
+}
+
+// ErrFirewalled is returned by the Firewall connection wrapper for connections it refuses
+var ErrFirewalled = errors.New("network: connection refused by firewall")
+
+// FirewallConfig sets the limits for NewFirewallTracker. Zero values disable the corresponding limit.
+type FirewallConfig struct {
+	// HandshakesPerMinute is how many new connections a single IP can open per minute (on average)
+	HandshakesPerMinute uint
+	// HandshakeBurst is how many new connections a single IP can open at once. Defaults to HandshakesPerMinute.
+	HandshakeBurst uint
+
+	// MaxFailures is the number of failed handshakes or unauthorized connections within FailureWindow,
+	// after which an IP is banned for BanDuration.
+	MaxFailures   uint
+	FailureWindow time.Duration
+	BanDuration   time.Duration
+
+	// MaxConnsPerIP limits the number of open connections (including those still in the handshake) per IP
+	MaxConnsPerIP uint
+	// MaxConns limits the number of open connections overall
+	MaxConns uint
+
+	// IsBlocked is checked after the handshake, before any muxrpc traffic.
+	IsBlocked func(refs.FeedRef) bool
+}
+
+// DefaultFirewallConfig returns limits that should be fine for most pubs.
+func DefaultFirewallConfig() FirewallConfig {
+	return FirewallConfig{
+		HandshakesPerMinute: 30,
+		HandshakeBurst:      10,
+
+		MaxFailures:   5,
+		FailureWindow: 10 * time.Minute,
+		BanDuration:   30 * time.Minute,
+
+		MaxConnsPerIP: 10,
+		MaxConns:      500,
+	}
+}
+
+// Firewall is a ssb.ConnTracker which rate-limits, caps and bans connections by IP.
+// Its PreSecureWrapper needs to be installed as one of the Options.BefreCryptoWrappers so that it sees connections before the secret-handshake.
+// Since those wrappers are also used for dialing, the limits apply to outgoing connections, too.
+// The connections of exempted listeners (see ExemptListener) are neither limited nor counted.
+type Firewall struct {
+	ssb.ConnTracker // the wrapped tracker, which dedupes the established connections
+
+	logger log.Logger
+	cfg    FirewallConfig
+	now    func() time.Time
+
+	mu    sync.Mutex
+	ips   map[string]*ipState
+	conns map[string]string // open connections (remote ip:port) to their ip
+
+	exempt      []*net.TCPAddr      // local addresses of exempted listeners
+	exemptConns map[string]struct{} // open connections (remote ip:port) of those listeners
+}
+
+type ipState struct {
+	tokens   float64
+	lastFill time.Time
+
+	failures    []time.Time
+	bannedUntil time.Time
+
+	open uint
+}
+
+var (
+	_ ssb.ConnTracker = (*Firewall)(nil)
+	_ FailureReporter = (*Firewall)(nil)
+)
+
+// NewFirewallTracker wraps the passed tracker (or a LastWinsTracker, if it is nil) with the limits from the config.
+func NewFirewallTracker(logger log.Logger, root ssb.ConnTracker, cfg FirewallConfig) *Firewall {
+	if root == nil {
+		root = NewLastWinsTracker()
+	}
+	if cfg.HandshakeBurst == 0 {
+		cfg.HandshakeBurst = cfg.HandshakesPerMinute
+	}
+	if logger == nil {
+		logger = log.NewNopLogger()
+	}
+	return &Firewall{
+		ConnTracker: root,
+
+		logger: log.With(logger, "unit", "firewall"),
+		cfg:    cfg,
+		now:    time.Now,
+
+		ips:   make(map[string]*ipState),
+		conns: make(map[string]string),
+
+		exemptConns: make(map[string]struct{}),
+	}
+}
+
+// PreSecureWrapper returns the connection wrapper that rejects connections from banned IPs
+// and enforces the rate and connection limits.
+func (fw *Firewall) PreSecureWrapper() netwrap.ConnWrapper {
+	return func(c net.Conn) (net.Conn, error) {
+		remote := c.RemoteAddr()
+		ip := remoteIP(remote)
+
+		fw.mu.Lock()
+		defer fw.mu.Unlock()
+
+		if len(fw.exempt) > 0 && fw.isExempt(c.LocalAddr()) {
+			fw.exemptConns[connKey(remote)] = struct{}{}
+			return &firewalledConn{Conn: c, fw: fw}, nil
+		}
+
+		now := fw.now()
+		st := fw.state(ip, now)
+
+		if now.Before(st.bannedUntil) {
+			return nil, fmt.Errorf("%w: %s is banned", ErrFirewalled, ip)
+		}
+
+		if fw.cfg.HandshakesPerMinute > 0 {
+			fw.refill(st, now)
+			if st.tokens < 1 {
+				level.Debug(fw.logger).Log("event", "rate limited", "ip", ip)
+				return nil, fmt.Errorf("%w: %s exceeded the handshake rate", ErrFirewalled, ip)
+			}
+			st.tokens--
+		}
+
+		if max := fw.cfg.MaxConnsPerIP; max > 0 && st.open >= max {
+			return nil, fmt.Errorf("%w: %s has too many open connections", ErrFirewalled, ip)
+		}
+
+		if max := fw.cfg.MaxConns; max > 0 && uint(len(fw.conns)) >= max {
+			return nil, fmt.Errorf("%w: too many open connections", ErrFirewalled)
+		}
+
+		st.open++
+		fw.conns[connKey(remote)] = ip
+		return &firewalledConn{Conn: c, fw: fw}, nil
+	}
+}
+
+// OnAccept rejects blocked feeds before passing the connection on to the wrapped tracker.
+func (fw *Firewall) OnAccept(ctx context.Context, conn net.Conn) (bool, context.Context) {
+	if fw.cfg.IsBlocked != nil {
+		remote, err := ssb.GetFeedRefFromAddr(conn.RemoteAddr())
+		if err != nil || fw.cfg.IsBlocked(remote) {
+			fw.Unauthorized(conn.RemoteAddr(), fmt.Errorf("blocked feed"))
+			return false, nil
+		}
+	}
+	return fw.ConnTracker.OnAccept(ctx, conn)
+}
+
+// HandshakeFailed counts the failure and releases the connection slot, since the connection wasn't closed through our wrapper.
+func (fw *Firewall) HandshakeFailed(remote net.Addr, err error) {
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+	_, exempt := fw.exemptConns[connKey(remote)]
+	fw.release(remote)
+	if !exempt {
+		fw.failed(remoteIP(remote), "handshake", err)
+	}
+}
+
+// Unauthorized counts the failure towards a ban of the IP.
+func (fw *Firewall) Unauthorized(remote net.Addr, err error) {
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+	if _, exempt := fw.exemptConns[connKey(remote)]; exempt {
+		return
+	}
+	fw.failed(remoteIP(remote), "unauthorized", err)
+}
+
+// ExemptListener stops limiting and counting the connections that are accepted on the listener with the local address.
+// All the connections of a tor hidden service come from 127.0.0.1, they would share the limits of a single IP.
+func (fw *Firewall) ExemptListener(local net.Addr) {
+	tcp, ok := netwrap.GetAddr(local, "tcp").(*net.TCPAddr)
+	if !ok {
+		return
+	}
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+	fw.exempt = append(fw.exempt, tcp)
+}
+
+// Banned returns the currently banned IPs and until when they are banned.
+func (fw *Firewall) Banned() map[string]time.Time {
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+
+	now := fw.now()
+	banned := make(map[string]time.Time)
+	for ip, st := range fw.ips {
+		if now.Before(st.bannedUntil) {
+			banned[ip] = st.bannedUntil
+		}
+	}
+	return banned
+}
+
+// Unban lifts the ban of an IP and forgets its failures.
+func (fw *Firewall) Unban(ip string) {
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+	if st, has := fw.ips[ip]; has {
+		st.bannedUntil = time.Time{}
+		st.failures = nil
+	}
+}
+
+// the following helpers assume fw.mu is held
+
+func (fw *Firewall) state(ip string, now time.Time) *ipState {
+	st, has := fw.ips[ip]
+	if !has {
+		if len(fw.ips) > 4096 {
+			fw.prune(now)
+		}
+		st = &ipState{
+			tokens:   float64(fw.cfg.HandshakeBurst),
+			lastFill: now,
+		}
+		fw.ips[ip] = st
+	}
+	return st
+}
+
+func (fw *Firewall) refill(st *ipState, now time.Time) {
+	perSecond := float64(fw.cfg.HandshakesPerMinute) / 60
+	st.tokens += now.Sub(st.lastFill).Seconds() * perSecond
+	if burst := float64(fw.cfg.HandshakeBurst); st.tokens > burst {
+		st.tokens = burst
+	}
+	st.lastFill = now
+}
+
+func (fw *Firewall) failed(ip, reason string, err error) {
+	if fw.cfg.MaxFailures == 0 {
+		return
+	}
+
+	now := fw.now()
+	st := fw.state(ip, now)
+
+	// only keep the failures of the window
+	recent := st.failures[:0]
+	for _, f := range st.failures {
+		if fw.cfg.FailureWindow == 0 || now.Sub(f) < fw.cfg.FailureWindow {
+			recent = append(recent, f)
+		}
+	}
+	st.failures = append(recent, now)
+
+	if uint(len(st.failures)) >= fw.cfg.MaxFailures {
+		st.bannedUntil = now.Add(fw.cfg.BanDuration)
+		st.failures = nil
+		level.Warn(fw.logger).Log("event", "banned", "ip", ip, "reason", reason, "until", st.bannedUntil, "err", err)
+	}
+}
+
+func (fw *Firewall) isExempt(local net.Addr) bool {
+	tcp, ok := netwrap.GetAddr(local, "tcp").(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+	for _, ex := range fw.exempt {
+		if ex.Port == tcp.Port && (ex.IP.IsUnspecified() || ex.IP.Equal(tcp.IP)) {
+			return true
+		}
+	}
+	return false
+}
+
+func (fw *Firewall) release(remote net.Addr) {
+	k := connKey(remote)
+	delete(fw.exemptConns, k)
+	ip, has := fw.conns[k]
+	if !has {
+		return
+	}
+	delete(fw.conns, k)
+	if st, has := fw.ips[ip]; has && st.open > 0 {
+		st.open--
+	}
+}
+
+// prune drops the entries that don't hold any state anymore
+func (fw *Firewall) prune(now time.Time) {
+	for ip, st := range fw.ips {
+		if st.open > 0 || now.Before(st.bannedUntil) || len(st.failures) > 0 {
+			continue
+		}
+		fw.refill(st, now)
+		if st.tokens < float64(fw.cfg.HandshakeBurst) {
+			continue
+		}
+		delete(fw.ips, ip)
+	}
+}
+
+// firewalledConn releases the connection slot on close
+type firewalledConn struct {
+	net.Conn
+
+	fw   *Firewall
+	once sync.Once
+}
+
+func (c *firewalledConn) Close() error {
+	c.once.Do(func() {
+		c.fw.mu.Lock()
+		c.fw.release(c.Conn.RemoteAddr())
+		c.fw.mu.Unlock()
+	})
+	return c.Conn.Close()
+}
+
+// connKey returns the ip:port of the tcp address, which the connection is known by after the handshake wrapped its address
+func connKey(a net.Addr) string {
+	if tcp, ok := netwrap.GetAddr(a, "tcp").(*net.TCPAddr); ok {
+		return tcp.String()
+	}
+	return a.String()
+}
+
+// remoteIP returns the host part of the tcp address or the whole address if it isn't one
+func remoteIP(a net.Addr) string {
+	if tcp, ok := netwrap.GetAddr(a, "tcp").(*net.TCPAddr); ok {
+		return tcp.IP.String()
+	}
+	host, _, err := net.SplitHostPort(a.String())
+	if err != nil {
+		return a.String()
+	}
+	return host
+}
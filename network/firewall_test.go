@@ -0,0 +1,353 @@
+// SPDX-FileCopyrightText: 2021 The Go-SSB Authors
+//
+// SPDX-License-Identifier: MIT
+
+package network
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/ssbc/go-muxrpc/v2"
+	"github.com/ssbc/go-netwrap"
+	"github.com/ssbc/go-secretstream"
+	"github.com/stretchr/testify/require"
+	"go.mindeco.de/log"
+
+	"github.com/ssbc/go-ssb"
+	refs "github.com/ssbc/go-ssb-refs"
+)
+
+type fakeConn struct {
+	net.Conn // nil, panics if anything else is used
+
+	remote, local net.Addr
+}
+
+func (c fakeConn) RemoteAddr() net.Addr { return c.remote }
+func (c fakeConn) LocalAddr() net.Addr  { return c.local }
+func (c fakeConn) Close() error         { return nil }
+
+func newFakeConn(ip string, port int) net.Conn {
+	return fakeConn{remote: &net.TCPAddr{IP: net.ParseIP(ip), Port: port}}
+}
+
+type testClock struct{ t time.Time }
+
+func (c *testClock) now() time.Time          { return c.t }
+func (c *testClock) advance(d time.Duration) { c.t = c.t.Add(d) }
+
+func newTestFirewall(cfg FirewallConfig) (*Firewall, *testClock) {
+	fw := NewFirewallTracker(nil, NewAcceptAllTracker(), cfg)
+	clock := &testClock{t: time.Unix(1600000000, 0)}
+	fw.now = clock.now
+	return fw, clock
+}
+
+func TestFirewallRateLimit(t *testing.T) {
+	r := require.New(t)
+
+	fw, clock := newTestFirewall(FirewallConfig{
+		HandshakesPerMinute: 60,
+		HandshakeBurst:      2,
+	})
+	wrap := fw.PreSecureWrapper()
+
+	for i := 0; i < 2; i++ {
+		c, err := wrap(newFakeConn("10.0.0.1", 1000+i))
+		r.NoError(err, "burst conn %d", i)
+		r.NoError(c.Close())
+	}
+
+	_, err := wrap(newFakeConn("10.0.0.1", 1010))
+	r.True(errors.Is(err, ErrFirewalled), "expected rate limit: %v", err)
+
+	// other IPs are not affected
+	_, err = wrap(newFakeConn("10.0.0.2", 1000))
+	r.NoError(err)
+
+	// one token per second
+	clock.advance(time.Second)
+	_, err = wrap(newFakeConn("10.0.0.1", 1011))
+	r.NoError(err)
+	_, err = wrap(newFakeConn("10.0.0.1", 1012))
+	r.Error(err)
+}
+
+func TestFirewallConnLimits(t *testing.T) {
+	r := require.New(t)
+
+	fw, _ := newTestFirewall(FirewallConfig{
+		MaxConnsPerIP: 2,
+		MaxConns:      3,
+	})
+	wrap := fw.PreSecureWrapper()
+
+	a1, err := wrap(newFakeConn("10.0.0.1", 1))
+	r.NoError(err)
+	_, err = wrap(newFakeConn("10.0.0.1", 2))
+	r.NoError(err)
+	_, err = wrap(newFakeConn("10.0.0.1", 3))
+	r.True(errors.Is(err, ErrFirewalled), "expected per IP limit: %v", err)
+
+	_, err = wrap(newFakeConn("10.0.0.2", 1))
+	r.NoError(err)
+	_, err = wrap(newFakeConn("10.0.0.3", 1))
+	r.True(errors.Is(err, ErrFirewalled), "expected overall limit: %v", err)
+
+	// closing frees the slot, closing twice doesn't free two
+	r.NoError(a1.Close())
+	r.NoError(a1.Close())
+	_, err = wrap(newFakeConn("10.0.0.3", 1))
+	r.NoError(err)
+	_, err = wrap(newFakeConn("10.0.0.4", 1))
+	r.Error(err)
+
+	// failed handshakes don't close through our wrapper
+	fw.HandshakeFailed(&net.TCPAddr{IP: net.ParseIP("10.0.0.3"), Port: 1}, fmt.Errorf("wrong app key"))
+	_, err = wrap(newFakeConn("10.0.0.4", 1))
+	r.NoError(err)
+}
+
+func TestFirewallExemptListener(t *testing.T) {
+	r := require.New(t)
+
+	fw, _ := newTestFirewall(FirewallConfig{
+		MaxConnsPerIP: 1,
+		MaxFailures:   1,
+		BanDuration:   time.Hour,
+	})
+	wrap := fw.PreSecureWrapper()
+	fw.ExemptListener(&net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 9050})
+
+	// the tor daemon forwards all hidden-service connections from localhost
+	viaTor := func(port int) net.Conn {
+		return fakeConn{
+			remote: &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: port},
+			local:  &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 9050},
+		}
+	}
+	for port := 1; port <= 3; port++ {
+		_, err := wrap(viaTor(port))
+		r.NoError(err, "hidden-service connection %d", port)
+	}
+	fw.HandshakeFailed(viaTor(1).RemoteAddr(), fmt.Errorf("wrong app key"))
+	fw.Unauthorized(viaTor(2).RemoteAddr(), fmt.Errorf("blocked feed"))
+	r.Empty(fw.Banned())
+
+	// the other connections from localhost are still limited
+	local := func(port int) net.Conn {
+		return fakeConn{
+			remote: &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: port},
+			local:  &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 8008},
+		}
+	}
+	_, err := wrap(local(10))
+	r.NoError(err)
+	_, err = wrap(local(11))
+	r.True(errors.Is(err, ErrFirewalled), "expected per IP limit: %v", err)
+}
+
+func TestFirewallBan(t *testing.T) {
+	r := require.New(t)
+
+	fw, clock := newTestFirewall(FirewallConfig{
+		MaxFailures:   3,
+		FailureWindow: time.Minute,
+		BanDuration:   time.Hour,
+	})
+	wrap := fw.PreSecureWrapper()
+
+	remote := &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 1}
+	wrongKey := fmt.Errorf("wrong app key")
+
+	// failures outside of the window are forgotten
+	fw.HandshakeFailed(remote, wrongKey)
+	fw.HandshakeFailed(remote, wrongKey)
+	clock.advance(2 * time.Minute)
+	fw.Unauthorized(remote, fmt.Errorf("not in reach"))
+	_, err := wrap(newFakeConn("10.0.0.1", 2))
+	r.NoError(err)
+	r.Len(fw.Banned(), 0)
+
+	fw.HandshakeFailed(remote, wrongKey)
+	fw.HandshakeFailed(remote, wrongKey)
+	_, err = wrap(newFakeConn("10.0.0.1", 3))
+	r.True(errors.Is(err, ErrFirewalled), "expected ban: %v", err)
+
+	banned := fw.Banned()
+	r.Len(banned, 1)
+	r.Equal(clock.t.Add(time.Hour), banned["10.0.0.1"])
+
+	clock.advance(time.Hour)
+	_, err = wrap(newFakeConn("10.0.0.1", 4))
+	r.NoError(err)
+
+	// manual unban
+	for i := 0; i < 3; i++ {
+		fw.Unauthorized(remote, fmt.Errorf("not in reach"))
+	}
+	r.Len(fw.Banned(), 1)
+	fw.Unban("10.0.0.1")
+	r.Len(fw.Banned(), 0)
+}
+
+func TestFirewallBlocked(t *testing.T) {
+	r := require.New(t)
+
+	blocked, err := refs.NewFeedRefFromBytes(bytes.Repeat([]byte{1}, 32), refs.RefAlgoFeedSSB1)
+	r.NoError(err)
+	friend, err := refs.NewFeedRefFromBytes(bytes.Repeat([]byte{2}, 32), refs.RefAlgoFeedSSB1)
+	r.NoError(err)
+
+	fw, _ := newTestFirewall(FirewallConfig{
+		MaxFailures: 1,
+		BanDuration: time.Hour,
+		IsBlocked: func(ref refs.FeedRef) bool {
+			return ref.Equal(blocked)
+		},
+	})
+
+	shsConn := func(ip string, who refs.FeedRef) net.Conn {
+		tcp := &net.TCPAddr{IP: net.ParseIP(ip), Port: 1}
+		return fakeConn{remote: netwrap.WrapAddr(tcp, secretstream.Addr{PubKey: who.PubKey()})}
+	}
+
+	ok, ctx := fw.OnAccept(context.TODO(), shsConn("10.0.0.2", friend))
+	r.True(ok)
+	r.NotNil(ctx)
+
+	ok, _ = fw.OnAccept(context.TODO(), shsConn("10.0.0.1", blocked))
+	r.False(ok)
+
+	// blocked feeds count as failures
+	r.Contains(fw.Banned(), "10.0.0.1")
+}
+
+func TestFirewallWrongAppKey(t *testing.T) {
+	r := require.New(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	logger := log.NewLogfmtLogger(os.Stderr)
+
+	kpClient, err := ssb.NewKeyPair(nil, refs.RefAlgoFeedSSB1)
+	r.NoError(err)
+
+	kpServ, err := ssb.NewKeyPair(nil, refs.RefAlgoFeedSSB1)
+	r.NoError(err)
+
+	fw := NewFirewallTracker(logger, nil, FirewallConfig{
+		MaxFailures: 2,
+		BanDuration: time.Hour,
+	})
+
+	noHandler := func(net.Conn) (muxrpc.Handler, error) {
+		return nil, fmt.Errorf("should not get here")
+	}
+
+	server, err := New(Options{
+		Logger:  logger,
+		AppKey:  bytes.Repeat([]byte("A"), 32),
+		KeyPair: kpServ,
+
+		ListenAddr: &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 0},
+
+		ConnTracker:         fw,
+		BefreCryptoWrappers: []netwrap.ConnWrapper{fw.PreSecureWrapper()},
+
+		MakeHandler: noHandler,
+	})
+	r.NoError(err)
+
+	go server.Serve(ctx)
+	srvAddr := server.GetListenAddr()
+
+	client, err := New(Options{
+		Logger:  logger,
+		AppKey:  bytes.Repeat([]byte("B"), 32),
+		KeyPair: kpClient,
+
+		MakeHandler: noHandler,
+	})
+	r.NoError(err)
+
+	for i := 0; i < 2; i++ {
+		err = client.Connect(ctx, srvAddr)
+		r.Error(err, "connect %d with the wrong app key worked", i)
+	}
+
+	r.Eventually(func() bool {
+		_, banned := fw.Banned()["127.0.0.1"]
+		return banned
+	}, 5*time.Second, 50*time.Millisecond)
+
+	client.Close()
+	server.Close()
+}
+
+func TestFirewallFailedDial(t *testing.T) {
+	r := require.New(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	logger := log.NewNopLogger()
+
+	kpClient, err := ssb.NewKeyPair(nil, refs.RefAlgoFeedSSB1)
+	r.NoError(err)
+
+	kpServ, err := ssb.NewKeyPair(nil, refs.RefAlgoFeedSSB1)
+	r.NoError(err)
+
+	noHandler := func(net.Conn) (muxrpc.Handler, error) {
+		return nil, fmt.Errorf("should not get here")
+	}
+
+	server, err := New(Options{
+		Logger:  logger,
+		AppKey:  bytes.Repeat([]byte("A"), 32),
+		KeyPair: kpServ,
+
+		ListenAddr: &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 0},
+
+		MakeHandler: noHandler,
+	})
+	r.NoError(err)
+
+	go server.Serve(ctx)
+	srvAddr := server.GetListenAddr()
+
+	// the dialing side has a firewall, too
+	fw := NewFirewallTracker(logger, nil, FirewallConfig{
+		MaxConnsPerIP: 1,
+	})
+	client, err := New(Options{
+		Logger:  logger,
+		AppKey:  bytes.Repeat([]byte("B"), 32),
+		KeyPair: kpClient,
+
+		ConnTracker:         fw,
+		BefreCryptoWrappers: []netwrap.ConnWrapper{fw.PreSecureWrapper()},
+
+		MakeHandler: noHandler,
+	})
+	r.NoError(err)
+
+	// the failed handshakes release their slot
+	for i := 0; i < 3; i++ {
+		err = client.Connect(ctx, srvAddr)
+		r.Error(err, "connect %d with the wrong app key worked", i)
+		r.False(errors.Is(err, ErrFirewalled), "connect %d was firewalled: %v", i, err)
+	}
+
+	client.Close()
+	server.Close()
+}
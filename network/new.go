@@ -35,6 +35,12 @@ type Options struct {
 	Dialer     netwrap.Dialer
 	ListenAddr net.Addr
 
+	// OnionDialer is used for onion addresses (see ParseAddress), usually one from NewSOCKS5Dialer.
+	OnionDialer netwrap.Dialer
+	// OnionListenAddr is an optional, additional listener for the local end of a tor hidden service.
+	// All connections through it come from the tor daemon, which means they share one IP for the firewall limits.
+	OnionListenAddr net.Addr
+
 	AdvertsSend      bool
 	AdvertsConnectTo bool
 
@@ -71,6 +77,7 @@ type Node struct {
 	listenerLock sync.Mutex
 	lisClose     sync.Once
 	lis          net.Listener
+	onionLis     net.Listener
 
 	dialer        netwrap.Dialer
 	localDiscovRx *Discoverer
@@ -79,6 +86,9 @@ type Node struct {
 	secretClient  *secretstream.Client
 	connTracker   ssb.ConnTracker
 
+	// set if the configured ConnTracker wants to know about failed connections
+	failureReporter FailureReporter
+
 	beforeCryptoConnWrappers []netwrap.ConnWrapper
 	afterSecureConnWrappers  []netwrap.ConnWrapper
 
@@ -105,6 +115,7 @@ func New(opts Options) (*Node, error) {
 		opts.ConnTracker = NewLastWinsTracker()
 	}
 	n.connTracker = opts.ConnTracker
+	n.failureReporter, _ = opts.ConnTracker.(FailureReporter)
 
 	var err error
 
@@ -306,6 +317,9 @@ func (n *Node) handleConnection(ctx context.Context, origConn net.Conn, isServer
 
 	h, err := n.opts.MakeHandler(conn)
 	if err != nil {
+		if isServer && n.failureReporter != nil {
+			n.failureReporter.Unauthorized(conn.RemoteAddr(), err)
+		}
 		var eOOR ssb.ErrOutOfReach
 		if errors.As(err, &eOOR) {
 			return // ignore silently
@@ -353,7 +367,11 @@ func (n *Node) handleConnection(ctx context.Context, origConn net.Conn, isServer
 func (n *Node) Serve(ctx context.Context, wrappers ...muxrpc.HandlerWrapper) error {
 	evtLog := log.With(n.log, "event", "network.Serve")
 	// TODO: make multiple listeners (localhost:8008 should not restrict or kill connections)
-	lisWrap := netwrap.NewListenerWrapper(n.secretServer.Addr(), append(n.opts.BefreCryptoWrappers, n.secretServer.ConnWrapper())...)
+	shsWrapper := n.secretServer.ConnWrapper()
+	if fr := n.failureReporter; fr != nil {
+		shsWrapper = reportHandshakeFailures(shsWrapper, fr)
+	}
+	lisWrap := netwrap.NewListenerWrapper(n.secretServer.Addr(), append(n.opts.BefreCryptoWrappers, shsWrapper)...)
 	var err error
 
 	n.listenerLock.Lock()
@@ -363,6 +381,19 @@ func (n *Node) Serve(ctx context.Context, wrappers ...muxrpc.HandlerWrapper) err
 		return fmt.Errorf("error creating listener: %w", err)
 	}
 	n.lisClose = sync.Once{} // reset once
+	if n.opts.OnionListenAddr != nil {
+		n.onionLis, err = netwrap.Listen(n.opts.OnionListenAddr, lisWrap)
+		if err != nil {
+			n.lis.Close()
+			n.lis = nil
+			n.listenerLock.Unlock()
+			return fmt.Errorf("error creating hidden-service listener: %w", err)
+		}
+		if ex, ok := n.opts.ConnTracker.(ListenerExempter); ok {
+			ex.ExemptListener(n.onionLis.Addr())
+		}
+		go n.acceptHiddenService(ctx, n.onionLis, wrappers)
+	}
 	close(n.listening)
 	n.listenerLock.Unlock()
 
@@ -371,6 +402,10 @@ func (n *Node) Serve(ctx context.Context, wrappers ...muxrpc.HandlerWrapper) err
 			n.listenerLock.Lock()
 			n.lis.Close()
 			n.lis = nil
+			if n.onionLis != nil {
+				n.onionLis.Close()
+				n.onionLis = nil
+			}
 			n.listenerLock.Unlock()
 		})
 		n.listening = make(chan struct{})
@@ -440,6 +475,45 @@ func (n *Node) Serve(ctx context.Context, wrappers ...muxrpc.HandlerWrapper) err
 	}
 }
 
+// acceptHiddenService handles the connections the tor daemon forwards to the local hidden-service listener, until it is closed.
+func (n *Node) acceptHiddenService(ctx context.Context, lis net.Listener, wrappers []muxrpc.HandlerWrapper) {
+	for {
+		conn, err := lis.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			continue
+		}
+		go n.handleConnection(ctx, conn, true, wrappers...)
+	}
+}
+
+// reportHandshakeFailures passes the errors of the secret-handshake wrapper to the reporter, before the listener drops them.
+func reportHandshakeFailures(shs netwrap.ConnWrapper, fr FailureReporter) netwrap.ConnWrapper {
+	return func(c net.Conn) (net.Conn, error) {
+		boxed, err := shs(c)
+		if err != nil {
+			fr.HandshakeFailed(c.RemoteAddr(), err)
+			return nil, err
+		}
+		return boxed, nil
+	}
+}
+
+// closeOnFailure closes the connection that the secret-handshake wrapper got if the handshake fails.
+// The dialer only closes the connection it dialed, not the ones the wrappers before it returned, like the one of the Firewall that holds a connection slot.
+func closeOnFailure(shs netwrap.ConnWrapper) netwrap.ConnWrapper {
+	return func(c net.Conn) (net.Conn, error) {
+		boxed, err := shs(c)
+		if err != nil {
+			c.Close()
+			return nil, err
+		}
+		return boxed, nil
+	}
+}
+
 func (n *Node) Connect(ctx context.Context, addr net.Addr) error {
 	select {
 	case <-ctx.Done():
@@ -458,8 +532,18 @@ func (n *Node) Connect(ctx context.Context, addr net.Addr) error {
 		return errors.New("node/connect: expected shs-bs address to be of type secretstream.Addr")
 	}
 
-	conn, err := n.dialer(netwrap.GetAddr(addr, "tcp"), append(n.beforeCryptoConnWrappers,
-		n.secretClient.ConnWrapper(pubKey))...)
+	dial, dialAddr := n.dialer, netwrap.GetAddr(addr, "tcp")
+	if onion := netwrap.GetAddr(addr, OnionNetwork); onion != nil {
+		if n.opts.OnionDialer == nil {
+			return fmt.Errorf("node/connect: %w", ErrNoOnionDialer)
+		}
+		dial, dialAddr = n.opts.OnionDialer, onion
+	} else if ws := netwrap.GetAddr(addr, WebsocketNetwork); ws != nil {
+		dial, dialAddr = DialWebsocket, ws
+	}
+
+	conn, err := dial(dialAddr, append(n.beforeCryptoConnWrappers,
+		closeOnFailure(n.secretClient.ConnWrapper(pubKey)))...)
 	if err != nil {
 		if conn != nil {
 			conn.Close()
@@ -516,6 +600,11 @@ func (n *Node) Close() error {
 			return fmt.Errorf("ssb: network node failed to close it's listener: %w", closeErr)
 		}
 	}
+	if n.onionLis != nil {
+		if err := n.onionLis.Close(); err != nil && !errors.Is(err, net.ErrClosed) {
+			return fmt.Errorf("ssb: network node failed to close the hidden-service listener: %w", err)
+		}
+	}
 
 	n.remotesLock.Lock()
 	defer n.remotesLock.Unlock()
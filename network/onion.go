@@ -0,0 +1,67 @@
+// SPDX-FileCopyrightText: 2021 The Go-SSB Authors
+//
+// SPDX-License-Identifier: MIT
+
+package network
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/ssbc/go-netwrap"
+	"github.com/ssbc/go-secretstream"
+
+	multiserver "github.com/ssbc/go-ssb-multiserver"
+)
+
+// OnionNetwork is the network name of OnionAddr
+const OnionNetwork = "onion"
+
+// ErrNoOnionDialer is returned by Node.Connect for onion addresses if no Options.OnionDialer is set
+var ErrNoOnionDialer = errors.New("network: no dialer for onion addresses configured")
+
+// OnionAddr is the address of a tor hidden service.
+// It can't be resolved locally and needs to be dialed through a SOCKS5 proxy (see NewSOCKS5Dialer).
+type OnionAddr struct {
+	Host string // xyz.onion
+	Port int
+}
+
+func (a OnionAddr) Network() string { return OnionNetwork }
+
+func (a OnionAddr) String() string {
+	return net.JoinHostPort(a.Host, strconv.Itoa(a.Port))
+}
+
+func parseOnionAddress(p string) (net.Addr, error) {
+	keyStart := strings.Index(p, "~shs:")
+	if keyStart == -1 {
+		return nil, multiserver.ErrNoSHSKey
+	}
+
+	host, portStr, err := net.SplitHostPort(p[len("onion:"):keyStart])
+	if err != nil {
+		return nil, fmt.Errorf("network: invalid onion host and port: %w", err)
+	}
+	if !strings.HasSuffix(host, ".onion") {
+		return nil, fmt.Errorf("network: not an onion host: %q", host)
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil || port <= 0 || port > 65535 {
+		return nil, fmt.Errorf("network: invalid onion port: %q", portStr)
+	}
+
+	key, err := base64.StdEncoding.DecodeString(p[keyStart+len("~shs:"):])
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid pubkey formatting: %s", multiserver.ErrNoSHSKey, err)
+	}
+	if len(key) != 32 {
+		return nil, fmt.Errorf("%w: pubkey not 32bytes long", multiserver.ErrNoSHSKey)
+	}
+
+	return netwrap.WrapAddr(OnionAddr{Host: host, Port: port}, secretstream.Addr{PubKey: key}), nil
+}
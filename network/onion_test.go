@@ -0,0 +1,207 @@
+// SPDX-FileCopyrightText: 2021 The Go-SSB Authors
+//
+// SPDX-License-Identifier: MIT
+
+package network_test
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/ssbc/go-muxrpc/v2"
+	"github.com/stretchr/testify/require"
+	"go.mindeco.de/log"
+
+	"github.com/ssbc/go-ssb"
+	refs "github.com/ssbc/go-ssb-refs"
+	"github.com/ssbc/go-ssb/network"
+)
+
+const testOnionHost = "vww6ybal4bd7szmgncyruucpgfkqahzddi37ktceo3ah7ngmcopnpyyd.onion"
+
+func TestOnionConnect(t *testing.T) {
+	r := require.New(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var appkey = make([]byte, 32)
+	rand.Read(appkey)
+
+	logger := log.NewLogfmtLogger(os.Stderr)
+
+	kpClient, err := ssb.NewKeyPair(nil, refs.RefAlgoFeedSSB1)
+	r.NoError(err)
+
+	kpServ, err := ssb.NewKeyPair(nil, refs.RefAlgoFeedSSB1)
+	r.NoError(err)
+
+	// find a free port for the hidden-service listener
+	tmpLis, err := net.Listen("tcp", "127.0.0.1:0")
+	r.NoError(err)
+	hiddenServiceAddr := tmpLis.Addr()
+	r.NoError(tmpLis.Close())
+
+	serverConns := make(chan net.Addr, 1)
+	server, err := network.New(network.Options{
+		Logger:  logger,
+		AppKey:  appkey,
+		KeyPair: kpServ,
+
+		ListenAddr:      &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 0},
+		OnionListenAddr: hiddenServiceAddr,
+
+		MakeHandler: func(c net.Conn) (muxrpc.Handler, error) {
+			serverConns <- c.RemoteAddr()
+			return testHandler{t, false}, nil
+		},
+	})
+	r.NoError(err)
+
+	go server.Serve(ctx)
+	server.GetListenAddr() // wait for the listeners
+
+	// the stand-in for tor forwards our onion address to the hidden-service listener
+	proxy := newTestSOCKS5Proxy(t, map[string]string{
+		testOnionHost + ":8008": hiddenServiceAddr.String(),
+	})
+	defer proxy.Close()
+
+	clientConns := make(chan net.Addr, 1)
+	client, err := network.New(network.Options{
+		Logger:  logger,
+		AppKey:  appkey,
+		KeyPair: kpClient,
+
+		OnionDialer: network.NewSOCKS5Dialer(proxy.Addr().String()),
+
+		MakeHandler: func(c net.Conn) (muxrpc.Handler, error) {
+			clientConns <- c.RemoteAddr()
+			return testHandler{t, true}, nil
+		},
+	})
+	r.NoError(err)
+
+	onionAddr := fmt.Sprintf("onion:%s:8008~shs:%s", testOnionHost, base64.StdEncoding.EncodeToString(kpServ.ID().PubKey()))
+	addr, err := network.ParseAddress(onionAddr)
+	r.NoError(err)
+
+	r.NoError(client.Connect(ctx, addr))
+
+	select {
+	case remote := <-clientConns:
+		r.Equal(onionAddr, network.MultiserverAddress(remote), "should see the onion address, not the proxy")
+	case <-time.After(5 * time.Second):
+		r.Fail("client side of the connection not established")
+	}
+
+	select {
+	case remote := <-serverConns:
+		ref, err := ssb.GetFeedRefFromAddr(remote)
+		r.NoError(err)
+		r.True(ref.Equal(kpClient.ID()))
+	case <-time.After(5 * time.Second):
+		r.Fail("server side of the connection not established")
+	}
+
+	// unknown onion hosts are reported by the proxy
+	other := fmt.Sprintf("onion:%s:9009~shs:%s", testOnionHost, base64.StdEncoding.EncodeToString(kpServ.ID().PubKey()))
+	addr, err = network.ParseAddress(other)
+	r.NoError(err)
+	r.Error(client.Connect(ctx, addr))
+
+	// without a proxy onion addresses can't be dialed
+	noProxy, err := network.New(network.Options{
+		Logger:      logger,
+		AppKey:      appkey,
+		KeyPair:     kpClient,
+		MakeHandler: makeServerHandler(t, true),
+	})
+	r.NoError(err)
+	err = noProxy.Connect(ctx, addr)
+	r.True(errors.Is(err, network.ErrNoOnionDialer), "wrong error: %v", err)
+
+	noProxy.Close()
+	client.Close()
+	server.Close()
+}
+
+// newTestSOCKS5Proxy starts a minimal SOCKS5 server which only knows the host:port pairs of routes
+func newTestSOCKS5Proxy(t *testing.T, routes map[string]string) net.Listener {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	go func() {
+		for {
+			c, err := lis.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				if err := serveSOCKS5(c, routes); err != nil {
+					t.Log("socks5 stand-in:", err)
+				}
+			}()
+		}
+	}()
+	return lis
+}
+
+func serveSOCKS5(c net.Conn, routes map[string]string) error {
+	defer c.Close()
+
+	var greeting [2]byte
+	if _, err := io.ReadFull(c, greeting[:]); err != nil {
+		return err
+	}
+	methods := make([]byte, greeting[1])
+	if _, err := io.ReadFull(c, methods); err != nil {
+		return err
+	}
+	if _, err := c.Write([]byte{5, 0}); err != nil {
+		return err
+	}
+
+	var req [5]byte
+	if _, err := io.ReadFull(c, req[:]); err != nil {
+		return err
+	}
+	if req[1] != 1 || req[3] != 3 {
+		return fmt.Errorf("expected CONNECT with domain name, got %v", req)
+	}
+	hostAndPort := make([]byte, int(req[4])+2)
+	if _, err := io.ReadFull(c, hostAndPort); err != nil {
+		return err
+	}
+	host := string(hostAndPort[:req[4]])
+	port := int(hostAndPort[req[4]])<<8 | int(hostAndPort[req[4]+1])
+
+	target, has := routes[fmt.Sprintf("%s:%d", host, port)]
+	if !has {
+		c.Write([]byte{5, 4, 0, 1, 0, 0, 0, 0, 0, 0}) // host unreachable
+		return fmt.Errorf("no route to %s:%d", host, port)
+	}
+
+	fwd, err := net.Dial("tcp", target)
+	if err != nil {
+		c.Write([]byte{5, 1, 0, 1, 0, 0, 0, 0, 0, 0})
+		return err
+	}
+	defer fwd.Close()
+
+	if _, err := c.Write([]byte{5, 0, 0, 1, 127, 0, 0, 1, 0, 0}); err != nil {
+		return err
+	}
+
+	go io.Copy(fwd, c)
+	_, err = io.Copy(c, fwd)
+	return err
+}
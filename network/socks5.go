@@ -0,0 +1,127 @@
+// SPDX-FileCopyrightText: 2021 The Go-SSB Authors
+//
+// SPDX-License-Identifier: MIT
+
+package network
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/ssbc/go-netwrap"
+)
+
+// NewSOCKS5Dialer returns a dialer which opens connections through the SOCKS5 proxy at proxyAddr, like the one tor offers on localhost:9050.
+// Host names are passed to the proxy as is, so that onion addresses are resolved by it.
+// The returned connections report the dialed address as their remote address, not the one of the proxy.
+func NewSOCKS5Dialer(proxyAddr string) netwrap.Dialer {
+	return func(addr net.Addr, wrappers ...netwrap.ConnWrapper) (net.Conn, error) {
+		if addr == nil {
+			return nil, errors.New("socks5: no address to dial")
+		}
+
+		host, portStr, err := net.SplitHostPort(addr.String())
+		if err != nil {
+			return nil, fmt.Errorf("socks5: invalid address %q: %w", addr, err)
+		}
+		port, err := strconv.Atoi(portStr)
+		if err != nil {
+			return nil, fmt.Errorf("socks5: invalid port %q: %w", portStr, err)
+		}
+
+		proxyConn, err := net.DialTimeout("tcp", proxyAddr, 30*time.Second)
+		if err != nil {
+			return nil, fmt.Errorf("socks5: failed to reach proxy: %w", err)
+		}
+
+		// tor can take a while to build the circuit but the handshake shouldn't take forever
+		proxyConn.SetDeadline(time.Now().Add(2 * time.Minute))
+		if err := socks5Connect(proxyConn, host, port); err != nil {
+			proxyConn.Close()
+			return nil, err
+		}
+		proxyConn.SetDeadline(time.Time{})
+
+		var conn net.Conn = &proxiedConn{Conn: proxyConn, remote: addr}
+		for i, w := range wrappers {
+			wrapped, err := w(conn)
+			if err != nil {
+				conn.Close()
+				return nil, fmt.Errorf("socks5: error applying connection wrapper #%d: %w", i, err)
+			}
+			conn = wrapped
+		}
+		return conn, nil
+	}
+}
+
+// socks5Connect does the unauthenticated CONNECT exchange of RFC 1928 with the host name address type
+func socks5Connect(rw io.ReadWriter, host string, port int) error {
+	if len(host) > 255 {
+		return fmt.Errorf("socks5: host name too long: %d", len(host))
+	}
+
+	// version 5, one method: no authentication
+	if _, err := rw.Write([]byte{5, 1, 0}); err != nil {
+		return fmt.Errorf("socks5: failed to send greeting: %w", err)
+	}
+	var greeting [2]byte
+	if _, err := io.ReadFull(rw, greeting[:]); err != nil {
+		return fmt.Errorf("socks5: failed to read greeting: %w", err)
+	}
+	if greeting[0] != 5 {
+		return fmt.Errorf("socks5: unexpected protocol version %d", greeting[0])
+	}
+	if greeting[1] != 0 {
+		return fmt.Errorf("socks5: proxy requires authentication (method %d)", greeting[1])
+	}
+
+	req := []byte{5, 1, 0, 3, byte(len(host))} // CONNECT to a domain name
+	req = append(req, host...)
+	req = append(req, byte(port>>8), byte(port))
+	if _, err := rw.Write(req); err != nil {
+		return fmt.Errorf("socks5: failed to send connect request: %w", err)
+	}
+
+	var reply [4]byte
+	if _, err := io.ReadFull(rw, reply[:]); err != nil {
+		return fmt.Errorf("socks5: failed to read connect reply: %w", err)
+	}
+	if reply[1] != 0 {
+		return fmt.Errorf("socks5: proxy failed to connect to %s: reply code %d", host, reply[1])
+	}
+
+	// skip the bound address
+	var skip int
+	switch reply[3] {
+	case 1:
+		skip = net.IPv4len
+	case 4:
+		skip = net.IPv6len
+	case 3:
+		var l [1]byte
+		if _, err := io.ReadFull(rw, l[:]); err != nil {
+			return fmt.Errorf("socks5: failed to read bound address: %w", err)
+		}
+		skip = int(l[0])
+	default:
+		return fmt.Errorf("socks5: unknown address type %d in reply", reply[3])
+	}
+	if _, err := io.CopyN(io.Discard, rw, int64(skip+2)); err != nil {
+		return fmt.Errorf("socks5: failed to read bound address: %w", err)
+	}
+	return nil
+}
+
+// proxiedConn reports the address that was dialed through the proxy as the remote address
+type proxiedConn struct {
+	net.Conn
+
+	remote net.Addr
+}
+
+func (c *proxiedConn) RemoteAddr() net.Addr { return c.remote }
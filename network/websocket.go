@@ -10,6 +10,7 @@ import (
 	"io"
 	"net"
 	"net/http"
+	"sync"
 	"time"
 
 	"github.com/gorilla/websocket"
@@ -99,6 +100,9 @@ type wrappedConn struct {
 
 	r   io.Reader
 	wsc *websocket.Conn
+
+	// websocket.Conn supports only one concurrent writer
+	wmu sync.Mutex
 }
 
 func (conn *wrappedConn) Read(data []byte) (int, error) {
@@ -133,7 +137,10 @@ func (wc *wrappedConn) renewReader() error {
 	return nil
 }
 
-func (conn wrappedConn) Write(data []byte) (int, error) {
+func (conn *wrappedConn) Write(data []byte) (int, error) {
+	conn.wmu.Lock()
+	defer conn.wmu.Unlock()
+
 	writeCloser, err := conn.wsc.NextWriter(websocket.BinaryMessage)
 	if err != nil {
 		return -1, fmt.Errorf("wsConn: failed to create Reader: %w", err)
@@ -146,18 +153,18 @@ func (conn wrappedConn) Write(data []byte) (int, error) {
 	return int(n), writeCloser.Close()
 }
 
-func (conn wrappedConn) Close() error {
+func (conn *wrappedConn) Close() error {
 	return conn.wsc.Close()
 }
 
-func (c wrappedConn) LocalAddr() net.Addr  { return c.local }
-func (c wrappedConn) RemoteAddr() net.Addr { return c.remote }
-func (c wrappedConn) SetDeadline(t time.Time) error {
+func (c *wrappedConn) LocalAddr() net.Addr  { return c.local }
+func (c *wrappedConn) RemoteAddr() net.Addr { return c.remote }
+func (c *wrappedConn) SetDeadline(t time.Time) error {
 	return nil // c.conn.SetDeadline(t)
 }
-func (c wrappedConn) SetReadDeadline(t time.Time) error {
+func (c *wrappedConn) SetReadDeadline(t time.Time) error {
 	return nil // c.conn.SetReadDeadline(t)
 }
-func (c wrappedConn) SetWriteDeadline(t time.Time) error {
+func (c *wrappedConn) SetWriteDeadline(t time.Time) error {
 	return nil // c.conn.SetWriteDeadline(t)
 }
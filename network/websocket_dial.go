@@ -0,0 +1,88 @@
+// SPDX-FileCopyrightText: 2021 The Go-SSB Authors
+//
+// SPDX-License-Identifier: MIT
+
+package network
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+
+	"github.com/gorilla/websocket"
+	"github.com/ssbc/go-netwrap"
+	"github.com/ssbc/go-secretstream"
+
+	multiserver "github.com/ssbc/go-ssb-multiserver"
+)
+
+// WebsocketNetwork is the network name of WebsocketAddr
+const WebsocketNetwork = "ws"
+
+// WebsocketAddr is the URL of an ssb-ws endpoint, like ws://example.com:8989 or wss://example.com/ssb.
+type WebsocketAddr struct {
+	URL string
+}
+
+func (a WebsocketAddr) Network() string { return WebsocketNetwork }
+func (a WebsocketAddr) String() string  { return a.URL }
+
+func parseWebsocketAddress(p string) (net.Addr, error) {
+	keyStart := strings.Index(p, "~shs:")
+	if keyStart == -1 {
+		return nil, multiserver.ErrNoSHSKey
+	}
+
+	u, err := url.Parse(p[:keyStart])
+	if err != nil {
+		return nil, fmt.Errorf("network: invalid websocket url: %w", err)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("network: websocket url without host: %q", p[:keyStart])
+	}
+
+	key, err := base64.StdEncoding.DecodeString(p[keyStart+len("~shs:"):])
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid pubkey formatting: %s", multiserver.ErrNoSHSKey, err)
+	}
+	if len(key) != 32 {
+		return nil, fmt.Errorf("%w: pubkey not 32bytes long", multiserver.ErrNoSHSKey)
+	}
+
+	return netwrap.WrapAddr(WebsocketAddr{URL: u.String()}, secretstream.Addr{PubKey: key}), nil
+}
+
+// DialWebsocket is a netwrap.Dialer for WebsocketAddr.
+// Like the server side in websockHandler, every write is sent as one binary message.
+func DialWebsocket(addr net.Addr, wrappers ...netwrap.ConnWrapper) (net.Conn, error) {
+	wsAddr, ok := addr.(WebsocketAddr)
+	if !ok {
+		return nil, fmt.Errorf("websocket: can't dial %s address", addr.Network())
+	}
+
+	wsConn, resp, err := websocket.DefaultDialer.Dial(wsAddr.URL, nil)
+	if err != nil {
+		if errors.Is(err, websocket.ErrBadHandshake) && resp != nil {
+			return nil, fmt.Errorf("websocket: upgrade of %s failed with %s", wsAddr.URL, resp.Status)
+		}
+		return nil, fmt.Errorf("websocket: failed to dial %s: %w", wsAddr.URL, err)
+	}
+
+	var conn net.Conn = &wrappedConn{
+		remote: wsAddr,
+		local:  wsConn.LocalAddr(),
+		wsc:    wsConn,
+	}
+	for i, w := range wrappers {
+		wrapped, err := w(conn)
+		if err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("websocket: error applying connection wrapper #%d: %w", i, err)
+		}
+		conn = wrapped
+	}
+	return conn, nil
+}
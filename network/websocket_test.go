@@ -0,0 +1,111 @@
+// SPDX-FileCopyrightText: 2021 The Go-SSB Authors
+//
+// SPDX-License-Identifier: MIT
+
+package network_test
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/ssbc/go-muxrpc/v2"
+	"github.com/stretchr/testify/require"
+	"go.mindeco.de/log"
+
+	"github.com/ssbc/go-ssb"
+	refs "github.com/ssbc/go-ssb-refs"
+	"github.com/ssbc/go-ssb/network"
+)
+
+func TestWebsocketConnect(t *testing.T) {
+	r := require.New(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var appkey = make([]byte, 32)
+	rand.Read(appkey)
+
+	logger := log.NewLogfmtLogger(os.Stderr)
+
+	kpClient, err := ssb.NewKeyPair(nil, refs.RefAlgoFeedSSB1)
+	r.NoError(err)
+
+	kpServ, err := ssb.NewKeyPair(nil, refs.RefAlgoFeedSSB1)
+	r.NoError(err)
+
+	// find a free port for the websocket listener
+	tmpLis, err := net.Listen("tcp", "127.0.0.1:0")
+	r.NoError(err)
+	wsAddr := tmpLis.Addr().String()
+	r.NoError(tmpLis.Close())
+
+	serverConns := make(chan net.Addr, 1)
+	server, err := network.New(network.Options{
+		Logger:  logger,
+		AppKey:  appkey,
+		KeyPair: kpServ,
+
+		WebsocketAddr: wsAddr,
+
+		MakeHandler: func(c net.Conn) (muxrpc.Handler, error) {
+			serverConns <- c.RemoteAddr()
+			return testHandler{t, false}, nil
+		},
+	})
+	r.NoError(err)
+
+	clientConns := make(chan net.Addr, 1)
+	client, err := network.New(network.Options{
+		Logger:  logger,
+		AppKey:  appkey,
+		KeyPair: kpClient,
+
+		MakeHandler: func(c net.Conn) (muxrpc.Handler, error) {
+			clientConns <- c.RemoteAddr()
+			return testHandler{t, true}, nil
+		},
+	})
+	r.NoError(err)
+
+	msAddr := fmt.Sprintf("net:%s~shs:%s;ws://%s~shs:%s", "127.0.0.1:1", "AAAA", wsAddr, base64.StdEncoding.EncodeToString(kpServ.ID().PubKey()))
+	_, err = network.ParseAddress(msAddr)
+	r.Error(err, "the broken net: part should be used first")
+
+	wsOnly := fmt.Sprintf("ws://%s~shs:%s", wsAddr, base64.StdEncoding.EncodeToString(kpServ.ID().PubKey()))
+	addr, err := network.ParseAddress(wsOnly)
+	r.NoError(err)
+
+	r.NoError(client.Connect(ctx, addr))
+
+	select {
+	case remote := <-clientConns:
+		r.Equal(wsOnly, network.MultiserverAddress(remote))
+	case <-time.After(5 * time.Second):
+		r.Fail("client side of the connection not established")
+	}
+
+	select {
+	case remote := <-serverConns:
+		ref, err := ssb.GetFeedRefFromAddr(remote)
+		r.NoError(err)
+		r.True(ref.Equal(kpClient.ID()))
+	case <-time.After(5 * time.Second):
+		r.Fail("server side of the connection not established")
+	}
+
+	// a http endpoint without the websocket upgrade
+	wrongPath := fmt.Sprintf("ws://%s/blobs/get~shs:%s", wsAddr, base64.StdEncoding.EncodeToString(kpServ.ID().PubKey()))
+	addr, err = network.ParseAddress(wrongPath)
+	r.NoError(err)
+	r.Error(client.Connect(ctx, addr))
+
+	client.Close()
+	server.Close()
+}
@@ -0,0 +1,104 @@
+// SPDX-FileCopyrightText: 2021 The Go-SSB Authors
+//
+// SPDX-License-Identifier: MIT
+
+// Package archive offers archive.export and archive.import, which move feeds and their blobs
+// between bots as portable archives, see internal/archive for the format.
+package archive
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/ssbc/go-muxrpc/v2"
+	"github.com/ssbc/go-muxrpc/v2/typemux"
+	refs "github.com/ssbc/go-ssb-refs"
+	"go.mindeco.de/logging"
+
+	"github.com/ssbc/go-ssb"
+)
+
+var (
+	_      ssb.Plugin = plugin{} // compile-time type check
+	method            = muxrpc.Method{"archive"}
+)
+
+type plugin struct {
+	h muxrpc.Handler
+}
+
+func (plugin) Name() string              { return "archive" }
+func (plugin) Method() muxrpc.Method     { return method }
+func (p plugin) Handler() muxrpc.Handler { return p.h }
+
+// New creates the archive plugin, which should only be offered to the master.
+// Exports without a list of feeds hold the feed of self.
+func New(log logging.Interface, self refs.FeedRef, a ssb.Archiver) ssb.Plugin {
+	rootHdlr := typemux.New(log)
+
+	rootHdlr.RegisterSource(append(method, "export"), exportH{self: self, a: a})
+	rootHdlr.RegisterDuplex(append(method, "import"), importH{a: a})
+
+	return plugin{
+		h: &rootHdlr,
+	}
+}
+
+// ExportArgs are the arguments of archive.export
+type ExportArgs struct {
+	Feeds []refs.FeedRef `json:"feeds"`
+	Blobs bool           `json:"blobs"`
+}
+
+type exportH struct {
+	self refs.FeedRef
+	a    ssb.Archiver
+}
+
+// HandleSource streams the archive as binary data
+func (h exportH) HandleSource(ctx context.Context, req *muxrpc.Request, snk *muxrpc.ByteSink) error {
+	var args []ExportArgs
+	if len(req.RawArgs) > 0 {
+		if err := json.Unmarshal(req.RawArgs, &args); err != nil {
+			return fmt.Errorf("archive.export: bad arguments: %w", err)
+		}
+	}
+
+	var arg ExportArgs
+	if len(args) > 0 {
+		arg = args[0]
+	}
+	if len(arg.Feeds) == 0 {
+		arg.Feeds = []refs.FeedRef{h.self}
+	}
+
+	w := muxrpc.NewSinkWriter(snk)
+	if _, err := h.a.ExportArchive(w, arg.Feeds, arg.Blobs); err != nil {
+		return snk.CloseWithError(err)
+	}
+	return w.Close()
+}
+
+type importH struct {
+	a ssb.Archiver
+}
+
+// HandleDuplex reads the archive the client sends and replies with the ssb.ArchiveStats after its end.
+// muxrpc can't close one side of a call, which is why the archive has to end on its own.
+func (h importH) HandleDuplex(ctx context.Context, req *muxrpc.Request, src *muxrpc.ByteSource, snk *muxrpc.ByteSink) error {
+	stats, err := h.a.ImportArchive(muxrpc.NewSourceReader(src))
+	if err != nil {
+		return snk.CloseWithError(err)
+	}
+
+	data, err := json.Marshal(stats)
+	if err != nil {
+		return snk.CloseWithError(err)
+	}
+	snk.SetEncoding(muxrpc.TypeJSON)
+	if _, err := snk.Write(data); err != nil {
+		return fmt.Errorf("archive.import: failed to send the stats: %w", err)
+	}
+	return snk.Close()
+}
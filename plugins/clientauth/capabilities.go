@@ -0,0 +1,167 @@
+// SPDX-FileCopyrightText: 2021 The Go-SSB Authors
+//
+// SPDX-License-Identifier: MIT
+
+package clientauth
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/ssbc/go-muxrpc/v2"
+)
+
+// Capability is one entry in the set of rights a client keypair is granted.
+//
+// The supported forms are:
+//
+//	read            read-only streams and lookups (createLogStream, get, friends.hops, ...)
+//	private         reading of messages that were decrypted by the bot (private.read)
+//	publish         publishing of messages of any type
+//	publish:<type>  publishing of messages where content.type equals <type>
+//	call:<method>   one additional method, like call:blobs.add or call:conn.connect
+type Capability string
+
+// The fixed capabilities which don't carry a parameter.
+const (
+	CapRead    Capability = "read"
+	CapPrivate Capability = "private"
+	CapPublish Capability = "publish"
+
+	prefixPublish = "publish:"
+	prefixCall    = "call:"
+)
+
+// ErrNotPermitted is returned if a call is outside of the capabilities of a grant.
+var ErrNotPermitted = errors.New("clientauth: call not permitted")
+
+// readMethods are the methods covered by CapRead.
+// They don't change any state on the bot and don't expose decrypted content,
+// get with private:true returns the boxed message to them and only decrypts it for the bot itself.
+var readMethods = map[string]struct{}{
+	"manifest": {},
+	"whoami":   {},
+	"status":   {},
+
+	"get":                 {},
+	"createLogStream":     {},
+	"createHistoryStream": {},
+	"createFeedStream":    {},
+	"messagesByType":      {},
+
+	"blobs.get":  {},
+	"blobs.has":  {},
+	"blobs.size": {},
+
+	"friends.blocks":      {},
+	"friends.hops":        {},
+	"friends.isBlocking":  {},
+	"friends.isFollowing": {},
+	"friends.stream":      {},
+
+	"names.get":          {},
+	"names.getImageFor":  {},
+	"names.getSignifier": {},
+
+	"partialReplication.getSubset": {},
+	"partialReplication.getTangle": {},
+
+	"replicate.upto": {},
+	"tangles.heads":  {},
+	"tangles.thread": {},
+}
+
+// ParseCapability checks that c is in one of the supported forms.
+func ParseCapability(c string) (Capability, error) {
+	switch Capability(c) {
+	case CapRead, CapPrivate, CapPublish:
+		return Capability(c), nil
+	}
+
+	if strings.HasPrefix(c, prefixPublish) {
+		if len(c) == len(prefixPublish) {
+			return "", fmt.Errorf("clientauth: empty type in capability %q", c)
+		}
+		return Capability(c), nil
+	}
+
+	if strings.HasPrefix(c, prefixCall) {
+		m := strings.TrimPrefix(c, prefixCall)
+		if m == "" {
+			return "", fmt.Errorf("clientauth: empty method in capability %q", c)
+		}
+		if strings.HasPrefix(m, "auth.") {
+			return "", fmt.Errorf("clientauth: grant managment can't be delegated (%q)", c)
+		}
+		return Capability(c), nil
+	}
+
+	return "", fmt.Errorf("clientauth: unsupported capability %q", c)
+}
+
+// Permits returns nil if a call of method m with the passed muxrpc arguments is covered by the capabilities.
+func (g Grant) Permits(m muxrpc.Method, rawArgs json.RawMessage) error {
+	name := m.String()
+
+	var publishTypes []string
+	for _, c := range g.Capabilities {
+		switch {
+		case c == CapRead:
+			if _, has := readMethods[name]; has {
+				return nil
+			}
+
+		case c == CapPrivate:
+			if name == "private.read" {
+				return nil
+			}
+
+		case c == CapPublish:
+			if name == "publish" {
+				return nil
+			}
+
+		case strings.HasPrefix(string(c), prefixPublish):
+			publishTypes = append(publishTypes, strings.TrimPrefix(string(c), prefixPublish))
+
+		case strings.HasPrefix(string(c), prefixCall):
+			if name == strings.TrimPrefix(string(c), prefixCall) {
+				return nil
+			}
+		}
+	}
+
+	if name == "publish" && len(publishTypes) > 0 {
+		typ, err := publishedType(rawArgs)
+		if err != nil {
+			return fmt.Errorf("%w: %s", ErrNotPermitted, err)
+		}
+		for _, t := range publishTypes {
+			if t == typ {
+				return nil
+			}
+		}
+		return fmt.Errorf("%w: publishing type %q", ErrNotPermitted, typ)
+	}
+
+	return fmt.Errorf("%w: %s", ErrNotPermitted, name)
+}
+
+// publishedType returns content.type of the arguments of a publish call
+func publishedType(rawArgs json.RawMessage) (string, error) {
+	var args []struct {
+		Type string `json:"type"`
+	}
+	if err := json.Unmarshal(rawArgs, &args); err != nil {
+		return "", fmt.Errorf("invalid publish arguments: %w", err)
+	}
+	if n := len(args); n != 1 {
+		return "", fmt.Errorf("expected 1 publish argument got %d", n)
+	}
+	if args[0].Type == "" {
+		return "", fmt.Errorf("publish content without a type")
+	}
+	return args[0].Type, nil
+}
@@ -0,0 +1,120 @@
+// SPDX-FileCopyrightText: 2021 The Go-SSB Authors
+//
+// SPDX-License-Identifier: MIT
+
+package clientauth
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ssbc/go-muxrpc/v2"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ssbc/go-ssb"
+	refs "github.com/ssbc/go-ssb-refs"
+	"github.com/ssbc/go-ssb/repo"
+)
+
+func TestParseCapability(t *testing.T) {
+	r := require.New(t)
+
+	for _, good := range []string{"read", "private", "publish", "publish:post", "call:blobs.add"} {
+		c, err := ParseCapability(good)
+		r.NoError(err, good)
+		r.Equal(Capability(good), c)
+	}
+
+	for _, bad := range []string{"", "write", "publish:", "call:", "call:auth.grant"} {
+		_, err := ParseCapability(bad)
+		r.Error(err, bad)
+	}
+}
+
+func TestGrantPermits(t *testing.T) {
+	var g = Grant{
+		Capabilities: []Capability{CapRead, "publish:post", "call:blobs.add"},
+	}
+
+	type tcase struct {
+		method muxrpc.Method
+		args   string
+		ok     bool
+	}
+
+	for i, tc := range []tcase{
+		{muxrpc.Method{"whoami"}, `[]`, true},
+		{muxrpc.Method{"createLogStream"}, `[{"live":true}]`, true},
+		{muxrpc.Method{"friends", "hops"}, `[{"max":2}]`, true},
+		{muxrpc.Method{"blobs", "add"}, `[]`, true},
+		{muxrpc.Method{"publish"}, `[{"type":"post","text":"hello"}]`, true},
+
+		{muxrpc.Method{"publish"}, `[{"type":"contact","contact":"@x"}]`, false},
+		{muxrpc.Method{"publish"}, `[{"text":"untyped"}]`, false},
+		{muxrpc.Method{"private", "read"}, `[]`, false},
+		{muxrpc.Method{"invite", "create"}, `[{"uses":1}]`, false},
+		{muxrpc.Method{"auth", "grant"}, `[]`, false},
+		{muxrpc.Method{"conn", "connect"}, `[]`, false},
+	} {
+		err := g.Permits(tc.method, json.RawMessage(tc.args))
+		if tc.ok {
+			require.NoError(t, err, "case %d: %s", i, tc.method)
+		} else {
+			require.True(t, errors.Is(err, ErrNotPermitted), "case %d: %s (%v)", i, tc.method, err)
+		}
+	}
+}
+
+func TestStore(t *testing.T) {
+	r := require.New(t)
+
+	tRepoPath := filepath.Join("testrun", t.Name())
+	os.RemoveAll(tRepoPath)
+
+	db, err := repo.OpenBadgerDB(tRepoPath)
+	r.NoError(err)
+	defer db.Close()
+
+	s := NewStore(db)
+
+	kp, err := ssb.NewKeyPair(bytes.NewReader(bytes.Repeat([]byte("webfront"), 4)), refs.RefAlgoFeedSSB1)
+	r.NoError(err)
+	client := kp.ID()
+
+	r.True(errors.Is(s.Authorize(client), ErrNoGrant))
+
+	_, err = s.Grant(client, nil, "nothing")
+	r.Error(err)
+
+	_, err = s.Grant(client, []string{"read", "root"}, "bad cap")
+	r.Error(err)
+
+	_, err = s.Grant(client, []string{"read", "publish:post"}, "the web frontend")
+	r.NoError(err)
+	r.NoError(s.Authorize(client))
+
+	// the grant is tied to the public key, not the feed format
+	ggClient, err := refs.NewFeedRefFromBytes(client.PubKey(), refs.RefAlgoFeedGabby)
+	r.NoError(err)
+	g, err := s.Get(ggClient)
+	r.NoError(err)
+	r.Equal("the web frontend", g.Note)
+	r.Equal([]Capability{CapRead, "publish:post"}, g.Capabilities)
+
+	lst, err := s.List()
+	r.NoError(err)
+	r.Len(lst, 1)
+	r.True(lst[0].Client.Equal(client))
+
+	r.NoError(s.Revoke(client))
+	r.True(errors.Is(s.Revoke(client), ErrNoGrant))
+	r.True(errors.Is(s.Authorize(client), ErrNoGrant))
+
+	lst, err = s.List()
+	r.NoError(err)
+	r.Len(lst, 0)
+}
@@ -0,0 +1,38 @@
+// SPDX-FileCopyrightText: 2021 The Go-SSB Authors
+//
+// SPDX-License-Identifier: MIT
+
+package clientauth
+
+import (
+	"context"
+
+	"github.com/ssbc/go-muxrpc/v2"
+)
+
+// Restrict wraps the (master) handler h so that only the calls permitted by the grant reach it.
+func Restrict(h muxrpc.Handler, g Grant) muxrpc.Handler {
+	return restrictedHandler{
+		root:  h,
+		grant: g,
+	}
+}
+
+type restrictedHandler struct {
+	root  muxrpc.Handler
+	grant Grant
+}
+
+func (rh restrictedHandler) Handled(m muxrpc.Method) bool { return rh.root.Handled(m) }
+
+// HandleConnect doesn't pass the connection on.
+// The connect hooks of the master plugins (like replication) assume they are talking to the bot's own key.
+func (rh restrictedHandler) HandleConnect(ctx context.Context, e muxrpc.Endpoint) {}
+
+func (rh restrictedHandler) HandleCall(ctx context.Context, req *muxrpc.Request) {
+	if err := rh.grant.Permits(req.Method, req.RawArgs); err != nil {
+		req.CloseWithError(err)
+		return
+	}
+	rh.root.HandleCall(ctx, req)
+}
@@ -0,0 +1,120 @@
+// SPDX-FileCopyrightText: 2021 The Go-SSB Authors
+//
+// SPDX-License-Identifier: MIT
+
+package clientauth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/ssbc/go-muxrpc/v2"
+	"github.com/ssbc/go-muxrpc/v2/typemux"
+	refs "github.com/ssbc/go-ssb-refs"
+	"go.mindeco.de/logging"
+
+	"github.com/ssbc/go-ssb"
+)
+
+/*
+  grant: 'async',
+  revoke: 'async',
+  list: 'source',
+*/
+
+var (
+	_      ssb.Plugin = plugin{} // compile-time type check
+	method            = muxrpc.Method{"auth"}
+)
+
+// GrantArgs are the arguments of auth.grant
+type GrantArgs struct {
+	Client       refs.FeedRef `json:"client"`
+	Capabilities []string     `json:"capabilities"`
+	Note         string       `json:"note,omitempty"`
+}
+
+// RevokeArgs are the arguments of auth.revoke
+type RevokeArgs struct {
+	Client refs.FeedRef `json:"client"`
+}
+
+// NewPlugin returns the managment plugin for the store. It should only be registered on the master handler.
+func NewPlugin(log logging.Interface, s *Store) ssb.Plugin {
+	rootHdlr := typemux.New(log)
+
+	rootHdlr.RegisterAsync(muxrpc.Method{"auth", "grant"}, grantH{store: s})
+	rootHdlr.RegisterAsync(muxrpc.Method{"auth", "revoke"}, revokeH{store: s})
+	rootHdlr.RegisterSource(muxrpc.Method{"auth", "list"}, listSrc{store: s})
+
+	return plugin{h: &rootHdlr}
+}
+
+type plugin struct {
+	h muxrpc.Handler
+}
+
+func (plugin) Name() string { return "auth" }
+
+func (plugin) Method() muxrpc.Method { return method }
+
+func (p plugin) Handler() muxrpc.Handler { return p.h }
+
+type grantH struct {
+	store *Store
+}
+
+func (h grantH) HandleAsync(ctx context.Context, req *muxrpc.Request) (interface{}, error) {
+	var args []GrantArgs
+	if err := json.Unmarshal(req.RawArgs, &args); err != nil {
+		return nil, fmt.Errorf("invalid argument on auth.grant call: %w", err)
+	}
+	if len(args) != 1 {
+		return nil, fmt.Errorf("expected one arg {client, capabilities, note}")
+	}
+	a := args[0]
+
+	return h.store.Grant(a.Client, a.Capabilities, a.Note)
+}
+
+type revokeH struct {
+	store *Store
+}
+
+func (h revokeH) HandleAsync(ctx context.Context, req *muxrpc.Request) (interface{}, error) {
+	var args []RevokeArgs
+	if err := json.Unmarshal(req.RawArgs, &args); err != nil {
+		return nil, fmt.Errorf("invalid argument on auth.revoke call: %w", err)
+	}
+	if len(args) != 1 {
+		return nil, fmt.Errorf("expected one arg {client}")
+	}
+
+	if err := h.store.Revoke(args[0].Client); err != nil {
+		return nil, err
+	}
+	return true, nil
+}
+
+type listSrc struct {
+	store *Store
+}
+
+func (h listSrc) HandleSource(ctx context.Context, req *muxrpc.Request, snk *muxrpc.ByteSink) error {
+	lst, err := h.store.List()
+	if err != nil {
+		return err
+	}
+
+	snk.SetEncoding(muxrpc.TypeJSON)
+	enc := json.NewEncoder(snk)
+
+	for i, g := range lst {
+		if err := enc.Encode(g); err != nil {
+			return fmt.Errorf("auth.list: failed to send item %d: %w", i, err)
+		}
+	}
+
+	return snk.Close()
+}
@@ -0,0 +1,147 @@
+// SPDX-FileCopyrightText: 2021 The Go-SSB Authors
+//
+// SPDX-License-Identifier: MIT
+
+// Package clientauth lets additional client keypairs use the master RPC interface with a restricted set of capabilities.
+//
+// Without it, only holders of the bot's own secret get past the public handler.
+// Web frontends and bots can instead use their own keypair, which is granted a set of Capabilities via auth.grant.
+package clientauth
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/dgraph-io/badger/v3"
+	refs "github.com/ssbc/go-ssb-refs"
+
+	"github.com/ssbc/go-ssb"
+)
+
+// ErrNoGrant is returned by Store.Get if a client has no capabilities
+var ErrNoGrant = errors.New("clientauth: no grant for this client")
+
+// Grant is the set of capabilities of one client keypair
+type Grant struct {
+	Client       refs.FeedRef `json:"client"`
+	Capabilities []Capability `json:"capabilities"`
+	Note         string       `json:"note,omitempty"`
+	Created      time.Time    `json:"created"`
+}
+
+var dbKeyPrefix = []byte("clientauth:")
+
+// Store keeps the grants persistently in a badger database
+type Store struct {
+	kv *badger.DB
+}
+
+var _ ssb.Authorizer = (*Store)(nil)
+
+// NewStore returns a Store that uses the passed database.
+// The grants are kept under their own key prefix so the database can be shared with other indexes.
+func NewStore(db *badger.DB) *Store {
+	return &Store{kv: db}
+}
+
+// the grants are keyed by public key since we can't see the feed format during the handshake
+func dbKey(client refs.FeedRef) []byte {
+	k := make([]byte, len(dbKeyPrefix))
+	copy(k, dbKeyPrefix)
+	return append(k, client.PubKey()...)
+}
+
+// Grant stores (or replaces) the capabilities of a client keypair.
+func (s *Store) Grant(client refs.FeedRef, caps []string, note string) (Grant, error) {
+	var g Grant
+	if len(caps) == 0 {
+		return g, fmt.Errorf("clientauth: need at least one capability")
+	}
+
+	g.Client = client
+	g.Note = note
+	g.Created = time.Now()
+	for _, c := range caps {
+		pc, err := ParseCapability(c)
+		if err != nil {
+			return g, err
+		}
+		g.Capabilities = append(g.Capabilities, pc)
+	}
+
+	data, err := json.Marshal(g)
+	if err != nil {
+		return g, fmt.Errorf("clientauth: failed to marshal grant: %w", err)
+	}
+
+	err = s.kv.Update(func(txn *badger.Txn) error {
+		return txn.Set(dbKey(client), data)
+	})
+	if err != nil {
+		return g, fmt.Errorf("clientauth: failed to store grant: %w", err)
+	}
+	return g, nil
+}
+
+// Revoke removes all capabilities of a client keypair.
+func (s *Store) Revoke(client refs.FeedRef) error {
+	return s.kv.Update(func(txn *badger.Txn) error {
+		k := dbKey(client)
+		if _, err := txn.Get(k); err != nil {
+			if errors.Is(err, badger.ErrKeyNotFound) {
+				return ErrNoGrant
+			}
+			return fmt.Errorf("clientauth: failed to probe grant: %w", err)
+		}
+		return txn.Delete(k)
+	})
+}
+
+// Get returns the grant for the client or ErrNoGrant.
+func (s *Store) Get(client refs.FeedRef) (Grant, error) {
+	var g Grant
+	err := s.kv.View(func(txn *badger.Txn) error {
+		it, err := txn.Get(dbKey(client))
+		if err != nil {
+			if errors.Is(err, badger.ErrKeyNotFound) {
+				return ErrNoGrant
+			}
+			return fmt.Errorf("clientauth: failed to get grant: %w", err)
+		}
+
+		return it.Value(func(val []byte) error {
+			return json.Unmarshal(val, &g)
+		})
+	})
+	return g, err
+}
+
+// List returns all the stored grants.
+func (s *Store) List() ([]Grant, error) {
+	var lst []Grant
+	err := s.kv.View(func(txn *badger.Txn) error {
+		iter := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer iter.Close()
+
+		for iter.Seek(dbKeyPrefix); iter.ValidForPrefix(dbKeyPrefix); iter.Next() {
+			var g Grant
+			err := iter.Item().Value(func(val []byte) error {
+				return json.Unmarshal(val, &g)
+			})
+			if err != nil {
+				return fmt.Errorf("clientauth: failed to decode grant: %w", err)
+			}
+			lst = append(lst, g)
+		}
+		return nil
+	})
+	return lst, err
+}
+
+// Authorize returns nil if the remote has a grant.
+func (s *Store) Authorize(remote refs.FeedRef) error {
+	_, err := s.Get(remote)
+	return err
+}
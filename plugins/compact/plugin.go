@@ -0,0 +1,50 @@
+// SPDX-FileCopyrightText: 2021 The Go-SSB Authors
+//
+// SPDX-License-Identifier: MIT
+
+// Package compact offers the compact call, which copies the receive log without its nulled messages.
+// The copy replaces the log the next time the bot starts.
+package compact
+
+import (
+	"context"
+
+	"github.com/ssbc/go-muxrpc/v2"
+	"github.com/ssbc/go-muxrpc/v2/typemux"
+	"go.mindeco.de/logging"
+
+	"github.com/ssbc/go-ssb"
+)
+
+var (
+	_      ssb.Plugin = plugin{} // compile-time type check
+	method            = muxrpc.Method{"compact"}
+)
+
+type plugin struct {
+	h muxrpc.Handler
+}
+
+func (plugin) Name() string              { return "compact" }
+func (plugin) Method() muxrpc.Method     { return method }
+func (p plugin) Handler() muxrpc.Handler { return p.h }
+
+// New creates the compact plugin, which should only be offered to the master
+func New(log logging.Interface, c ssb.LogCompactor) ssb.Plugin {
+	rootHdlr := typemux.New(log)
+
+	rootHdlr.RegisterAsync(method, compactH{c: c})
+
+	return plugin{
+		h: &rootHdlr,
+	}
+}
+
+type compactH struct {
+	c ssb.LogCompactor
+}
+
+// HandleAsync stages the compaction and returns what it leaves out
+func (h compactH) HandleAsync(ctx context.Context, req *muxrpc.Request) (interface{}, error) {
+	return h.c.CompactReceiveLog()
+}
@@ -14,8 +14,6 @@ import (
 
 	"github.com/ssbc/go-muxrpc/v2"
 	"github.com/ssbc/go-muxrpc/v2/typemux"
-	"github.com/ssbc/go-netwrap"
-	"github.com/ssbc/go-secretstream"
 	"go.mindeco.de/log/level"
 	"go.mindeco.de/logging"
 
@@ -23,6 +21,7 @@ import (
 	refs "github.com/ssbc/go-ssb-refs"
 
 	"github.com/ssbc/go-ssb"
+	"github.com/ssbc/go-ssb/network"
 )
 
 type handler struct {
@@ -135,11 +134,15 @@ func (h *handler) disconnect(ctx context.Context, r *muxrpc.Request) (interface{
 		}
 
 		for i, a := range rawArray {
-			na, err := multiserver.ParseNetAddress([]byte(a))
+			addr, err := network.ParseAddress(a)
 			if err != nil {
 				return nil, fmt.Errorf("bad argument no %d (%q): %w", i, string(a), err)
 			}
-			args = append(args, na.Ref)
+			ref, err := ssb.GetFeedRefFromAddr(addr)
+			if err != nil {
+				return nil, fmt.Errorf("bad argument no %d (%q): %w", i, string(a), err)
+			}
+			args = append(args, ref)
 		}
 	}
 
@@ -169,17 +172,20 @@ func (h *handler) connect(ctx context.Context, req *muxrpc.Request) (interface{}
 	}
 	dest := args[0]
 
-	msaddr, err := multiserver.ParseNetAddress([]byte(dest))
+	wrappedAddr, err := network.ParseAddress(dest)
+	if err != nil {
+		return nil, fmt.Errorf("ctrl.connect call: failed to parse input %q: %w", dest, err)
+	}
+	remote, err := ssb.GetFeedRefFromAddr(wrappedAddr)
 	if err != nil {
 		return nil, fmt.Errorf("ctrl.connect call: failed to parse input %q: %w", dest, err)
 	}
 
-	wrappedAddr := netwrap.WrapAddr(&msaddr.Addr, secretstream.Addr{PubKey: msaddr.Ref.PubKey()})
-	level.Info(h.info).Log("event", "connecting to peer", "remote", msaddr.Ref.ShortSigil())
+	level.Info(h.info).Log("event", "connecting to peer", "remote", remote.ShortSigil())
 	// TODO: add context to tracker to cancel connections
 	err = h.node.Connect(context.Background(), wrappedAddr)
 	if err != nil {
-		return nil, fmt.Errorf("ctrl.connect call: error connecting to %q: %w", msaddr.Addr, err)
+		return nil, fmt.Errorf("ctrl.connect call: error connecting to %q: %w", dest, err)
 	}
 	return reply{"connected"}, nil
 }
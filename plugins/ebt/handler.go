@@ -23,6 +23,7 @@ import (
 
 	"github.com/ssbc/go-ssb"
 	refs "github.com/ssbc/go-ssb-refs"
+	"github.com/ssbc/go-ssb/internal/replprogress"
 	"github.com/ssbc/go-ssb/internal/statematrix"
 	"github.com/ssbc/go-ssb/message"
 	"github.com/ssbc/go-ssb/plugins/gossip"
@@ -43,6 +44,9 @@ type MUXRPCHandler struct {
 
 	verify *message.VerificationRouter
 
+	// optional, records what we received
+	progress *replprogress.Tracker
+
 	Sessions Sessions
 }
 
@@ -179,7 +183,8 @@ func (h *MUXRPCHandler) Loop(ctx context.Context, tx *muxrpc.ByteSink, rx *muxrp
 			// would be rad to get this from the pretty-printed version
 			// and just pass that to verify
 			var msgWithAuthor struct {
-				Author refs.FeedRef
+				Author   refs.FeedRef
+				Sequence int64
 			}
 
 			err := json.Unmarshal(jsonBody, &msgWithAuthor)
@@ -198,6 +203,8 @@ func (h *MUXRPCHandler) Loop(ctx context.Context, tx *muxrpc.ByteSink, rx *muxrp
 			if err != nil {
 				// TODO: mark feed as bad
 				h.check(err)
+			} else if h.progress != nil {
+				h.progress.Received(peer, msgWithAuthor.Author, msgWithAuthor.Sequence, len(jsonBody))
 			}
 
 			continue
@@ -13,6 +13,7 @@ import (
 	"go.mindeco.de/logging"
 
 	refs "github.com/ssbc/go-ssb-refs"
+	"github.com/ssbc/go-ssb/internal/replprogress"
 	"github.com/ssbc/go-ssb/internal/statematrix"
 	"github.com/ssbc/go-ssb/message"
 	"github.com/ssbc/go-ssb/plugins/gossip"
@@ -28,6 +29,7 @@ func NewPlug(
 	fm *gossip.FeedManager,
 	sm *statematrix.StateMatrix,
 	v *message.VerificationRouter,
+	progress *replprogress.Tracker,
 ) *Plugin {
 
 	return &Plugin{&MUXRPCHandler{
@@ -42,6 +44,8 @@ func NewPlug(
 
 		verify: v,
 
+		progress: progress,
+
 		Sessions: Sessions{
 			mu:   new(sync.Mutex),
 			open: make(map[string]*session),
@@ -2,7 +2,7 @@
 //
 // SPDX-License-Identifier: MIT
 
-// Package friends supplies some of npm:ssb-friends, namly isFollowing, isBlocking and hops but not hopStream, onEdge or createLayer.
+// Package friends supplies some of npm:ssb-friends, namly isFollowing, isBlocking, hops, stream and hopStream but not onEdge or createLayer.
 package friends
 
 import (
@@ -22,6 +22,9 @@ import (
   isBlocking: 'async',
   hops: 'source',
   blocks: 'source',
+  explain: 'async',
+  stream: 'source',
+  hopStream: 'source',
 
 */
 
@@ -36,7 +39,13 @@ func checkAndLog(log logging.Interface, err error) {
 	}
 }
 
-func New(log logging.Interface, self refs.FeedRef, b graph.Builder) ssb.Plugin {
+// Explainer says why a feed is replicated or not
+type Explainer interface {
+	Explain(refs.FeedRef) (graph.Explanation, error)
+}
+
+// New creates the friends plugin. ex can be nil, if there is no replication policy to explain.
+func New(log logging.Interface, self refs.FeedRef, b graph.Builder, ex Explainer) ssb.Plugin {
 	rootHdlr := typemux.New(log)
 
 	rootHdlr.RegisterAsync(muxrpc.Method{"friends", "isFollowing"}, isFollowingH{
@@ -63,12 +72,30 @@ func New(log logging.Interface, self refs.FeedRef, b graph.Builder) ssb.Plugin {
 		self:    self,
 	})
 
+	rootHdlr.RegisterSource(muxrpc.Method{"friends", "stream"}, streamSrc{
+		log:     log,
+		builder: b,
+		self:    self,
+	})
+
+	rootHdlr.RegisterSource(muxrpc.Method{"friends", "hopStream"}, hopStreamSrc{
+		log:     log,
+		builder: b,
+		self:    self,
+	})
+
 	rootHdlr.RegisterAsync(muxrpc.Method{"friends", "plotsvg"}, plotSVGHandler{
 		log:     log,
 		builder: b,
 		self:    self,
 	})
 
+	rootHdlr.RegisterAsync(muxrpc.Method{"friends", "explain"}, explainH{
+		log:       log,
+		explainer: ex,
+		self:      self,
+	})
+
 	return plugin{
 		h:   &rootHdlr,
 		log: log,
@@ -75,6 +75,37 @@ func (h isBlockingH) HandleAsync(ctx context.Context, req *muxrpc.Request) (inte
 	return g.Blocks(a.Source, a.Dest), nil
 }
 
+type explainH struct {
+	self refs.FeedRef
+
+	log log.Logger
+
+	explainer Explainer
+}
+
+// ExplainArgs are the arguments of friends.explain. Feed defaults to our own.
+type ExplainArgs struct {
+	Feed *refs.FeedRef `json:"feed,omitempty"`
+}
+
+func (h explainH) HandleAsync(ctx context.Context, req *muxrpc.Request) (interface{}, error) {
+	if h.explainer == nil {
+		return nil, fmt.Errorf("explain: no replication policy")
+	}
+
+	var args []ExplainArgs
+	if err := json.Unmarshal(req.RawArgs, &args); err != nil {
+		return nil, fmt.Errorf("invalid argument on explain call: %w", err)
+	}
+
+	feed := h.self
+	if len(args) == 1 && args[0].Feed != nil {
+		feed = *args[0].Feed
+	}
+
+	return h.explainer.Explain(feed)
+}
+
 type plotSVGHandler struct {
 	self refs.FeedRef
 
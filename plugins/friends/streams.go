@@ -0,0 +1,261 @@
+// SPDX-FileCopyrightText: 2021 The Go-SSB Authors
+//
+// SPDX-License-Identifier: MIT
+
+package friends
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/ssbc/go-muxrpc/v2"
+	refs "github.com/ssbc/go-ssb-refs"
+	"github.com/ssbc/go-ssb/graph"
+	"go.mindeco.de/log"
+)
+
+// the values of ssb-friends for the relation between two feeds
+const (
+	valueFollow   = 1
+	valueBlock    = -1
+	valueUnfollow = -2
+)
+
+// defaultHopStreamMax is the hop range of hopStream if none is given, the default of ssb-friends (3) in our numbering
+const defaultHopStreamMax = 2
+
+// StreamArgs are the arguments of friends.stream and friends.hopStream.
+// Old defaults to true, Live to false.
+type StreamArgs struct {
+	Old  *bool `json:"old,omitempty"`
+	Live bool  `json:"live"`
+
+	// Max is the hop range of hopStream, like in HopsArgs
+	Max *uint `json:"max,omitempty"`
+}
+
+func parseStreamArgs(req *muxrpc.Request) (StreamArgs, error) {
+	var args []StreamArgs
+	if err := json.Unmarshal(req.RawArgs, &args); err != nil {
+		return StreamArgs{}, err
+	}
+	if len(args) == 0 {
+		return StreamArgs{}, nil
+	}
+	return args[0], nil
+}
+
+func (a StreamArgs) old() bool { return a.Old == nil || *a.Old }
+
+// updateQueue collects the updates of the graph, whose callbacks must not block, until they are sent
+type updateQueue struct {
+	mu    sync.Mutex
+	items []interface{}
+	wake  chan struct{}
+}
+
+func newUpdateQueue() *updateQueue {
+	return &updateQueue{wake: make(chan struct{}, 1)}
+}
+
+func (q *updateQueue) push(v interface{}) {
+	q.mu.Lock()
+	q.items = append(q.items, v)
+	q.mu.Unlock()
+
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// drain returns what is queued without waiting
+func (q *updateQueue) drain() []interface{} {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	items := q.items
+	q.items = nil
+	return items
+}
+
+// pop waits until there is something queued and returns all of it
+func (q *updateQueue) pop(ctx context.Context) ([]interface{}, error) {
+	for {
+		if items := q.drain(); len(items) > 0 {
+			return items, nil
+		}
+
+		select {
+		case <-q.wake:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+type streamSrc struct {
+	self refs.FeedRef
+
+	log log.Logger
+
+	builder graph.Builder
+}
+
+// edgeUpdate is a live update of friends.stream
+type edgeUpdate struct {
+	From  refs.FeedRef `json:"from"`
+	To    refs.FeedRef `json:"to"`
+	Value int          `json:"value"`
+}
+
+func edgeValue(ec graph.EdgeChange) int {
+	switch {
+	case ec.Blocking:
+		return valueBlock
+	case ec.Following:
+		return valueFollow
+	}
+	return valueUnfollow
+}
+
+// HandleSource first sends the whole graph as {from: {to: value}} and then, if live, every change as {from, to, value}
+func (h streamSrc) HandleSource(ctx context.Context, req *muxrpc.Request, snk *muxrpc.ByteSink) error {
+	args, err := parseStreamArgs(req)
+	if err != nil {
+		return fmt.Errorf("invalid argument on stream call: %w", err)
+	}
+
+	q := newUpdateQueue()
+	cancel, err := h.builder.SubscribeEdges(func(changes []graph.EdgeChange) {
+		q.push(changes)
+	})
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	snk.SetEncoding(muxrpc.TypeJSON)
+	enc := json.NewEncoder(snk)
+
+	// subscribing already queued the current graph
+	current := make(map[string]map[string]int)
+	for _, it := range q.drain() {
+		for _, ec := range it.([]graph.EdgeChange) {
+			from := ec.From.String()
+			if !ec.Following && !ec.Blocking {
+				delete(current[from], ec.To.String())
+				continue
+			}
+			if current[from] == nil {
+				current[from] = make(map[string]int)
+			}
+			current[from][ec.To.String()] = edgeValue(ec)
+		}
+	}
+	if args.old() {
+		if err := enc.Encode(current); err != nil {
+			return fmt.Errorf("stream: failed to send graph: %w", err)
+		}
+	}
+
+	for args.Live {
+		items, err := q.pop(ctx)
+		if err != nil {
+			return err
+		}
+
+		for _, it := range items {
+			for _, ec := range it.([]graph.EdgeChange) {
+				upd := edgeUpdate{From: ec.From, To: ec.To, Value: edgeValue(ec)}
+				if err := enc.Encode(upd); err != nil {
+					return fmt.Errorf("stream: failed to send update: %w", err)
+				}
+			}
+		}
+	}
+
+	return snk.Close()
+}
+
+type hopStreamSrc struct {
+	self refs.FeedRef
+
+	log log.Logger
+
+	builder graph.Builder
+}
+
+// hopValue returns the distance of ssb-friends, where our own feed is 0 and the feeds we follow are 1.
+// Blocked feeds are -1 and feeds that left the range -2.
+func hopValue(hc graph.HopsChange) int {
+	switch {
+	case hc.Blocked:
+		return valueBlock
+	case hc.Hops < 0:
+		return valueUnfollow
+	}
+	return hc.Hops + 1
+}
+
+// HandleSource first sends the distances of all feeds in range as {feed: hops} and then, if live, the changes to them in the same form
+func (h hopStreamSrc) HandleSource(ctx context.Context, req *muxrpc.Request, snk *muxrpc.ByteSink) error {
+	args, err := parseStreamArgs(req)
+	if err != nil {
+		return fmt.Errorf("invalid argument on hopStream call: %w", err)
+	}
+
+	max := defaultHopStreamMax
+	if args.Max != nil {
+		max = int(*args.Max)
+	}
+
+	q := newUpdateQueue()
+	cancel, err := h.builder.SubscribeHops(h.self, max, func(changes []graph.HopsChange) {
+		q.push(changes)
+	})
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	snk.SetEncoding(muxrpc.TypeJSON)
+	enc := json.NewEncoder(snk)
+
+	// subscribing already queued the current state, unless nothing is in range
+	initial := map[string]int{h.self.String(): 0}
+	for _, it := range q.drain() {
+		for _, hc := range it.([]graph.HopsChange) {
+			if v := hopValue(hc); v == valueUnfollow {
+				delete(initial, hc.Feed.String())
+			} else {
+				initial[hc.Feed.String()] = v
+			}
+		}
+	}
+	if args.old() {
+		if err := enc.Encode(initial); err != nil {
+			return fmt.Errorf("hopStream: failed to send hops: %w", err)
+		}
+	}
+
+	for args.Live {
+		items, err := q.pop(ctx)
+		if err != nil {
+			return err
+		}
+
+		update := make(map[string]int)
+		for _, it := range items {
+			for _, hc := range it.([]graph.HopsChange) {
+				update[hc.Feed.String()] = hopValue(hc)
+			}
+		}
+		if err := enc.Encode(update); err != nil {
+			return fmt.Errorf("hopStream: failed to send update: %w", err)
+		}
+	}
+
+	return snk.Close()
+}
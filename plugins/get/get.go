@@ -26,12 +26,15 @@ func (p plugin) Name() string            { return "get" }
 func (p plugin) Method() muxrpc.Method   { return muxrpc.Method{"get"} }
 func (p plugin) Handler() muxrpc.Handler { return p.h }
 
-func New(g ssb.Getter, rxlog margaret.Log, unboxer *private.Manager) ssb.Plugin {
+// New returns the get plugin. Only the callers that isSelf authorizes get messages decrypted with private:true,
+// the others get them as they are stored.
+func New(g ssb.Getter, rxlog margaret.Log, unboxer *private.Manager, isSelf ssb.Authorizer) ssb.Plugin {
 	return plugin{
 		h: handler{
 			get:     g,
 			rxlog:   rxlog,
 			unboxer: unboxer,
+			isSelf:  isSelf,
 		},
 	}
 }
@@ -40,6 +43,7 @@ type handler struct {
 	get     ssb.Getter
 	rxlog   margaret.Log
 	unboxer *private.Manager
+	isSelf  ssb.Authorizer
 }
 
 func (handler) Handled(m muxrpc.Method) bool { return m.String() == "get" }
@@ -87,7 +91,7 @@ func (h handler) HandleCall(ctx context.Context, req *muxrpc.Request) {
 	kv.Key_ = msg.Key()
 	kv.Value = *msg.ValueContent()
 
-	if o.Private {
+	if o.Private && h.mayDecrypt(req) {
 		cleartext, err := h.unboxer.DecryptMessage(msg)
 		if err == nil {
 			kv.Value.Meta = make(map[string]interface{}, 1)
@@ -105,3 +109,13 @@ func (h handler) HandleCall(ctx context.Context, req *muxrpc.Request) {
 		log.Printf("get(%s): failed? to return message: %s", o.ID.String(), err)
 	}
 }
+
+// mayDecrypt returns true if the caller of req is the bot itself.
+// Clients with a restricted grant use the same handler, they only get the boxed message.
+func (h handler) mayDecrypt(req *muxrpc.Request) bool {
+	remote, err := ssb.GetFeedRefFromAddr(req.RemoteAddr())
+	if err != nil {
+		return false
+	}
+	return h.isSelf.Authorize(remote) == nil
+}
@@ -39,6 +39,9 @@ type FeedManager struct {
 	UserFeeds  multilog.MultiLog
 	logger     logging.Interface
 
+	// Segments are read instead of the user feeds if they are set and have all of the requested messages
+	Segments FeedSegments
+
 	liveFeeds    map[string]*luigiutils.MultiSink
 	liveFeedsMut sync.Mutex
 
@@ -47,6 +50,12 @@ type FeedManager struct {
 	sysCtr   metrics.Counter
 }
 
+// FeedSegments has a log for every author, where the entry i is the message with the sequence i+1
+type FeedSegments interface {
+	// Acquire returns the log of author, which can be used until release is called
+	Acquire(author refs.FeedRef) (log margaret.Log, release func(), err error)
+}
+
 // NewFeedManager returns a new FeedManager used for gossiping about User
 // Feeds.
 func NewFeedManager(
@@ -162,6 +171,30 @@ func nonliveLimit(
 	return lastSeq - arg.Seq + 1
 }
 
+// segmentQuery is the query of a CreateStreamHistory request on a feed segment.
+// It only has one lower and one upper bound and doesn't go past latest, the last message of the user feed.
+func segmentQuery(arg message.CreateHistArgs, limit, latest int64) []margaret.QuerySpec {
+	var gte int64
+	if arg.Seq > 0 {
+		gte = arg.Seq
+	}
+	if arg.Gt > 0 && int64(arg.Gt)+1 > gte {
+		gte = int64(arg.Gt) + 1
+	}
+
+	lt := latest + 1
+	if arg.Lt > 0 && int64(arg.Lt) < lt {
+		lt = int64(arg.Lt)
+	}
+
+	return []margaret.QuerySpec{
+		margaret.Limit(int(limit)),
+		margaret.Reverse(arg.Reverse),
+		margaret.Gte(gte),
+		margaret.Lt(lt),
+	}
+}
+
 // liveLimit returns the limit for serving the 'live' portion for a
 // CreateStreamHistory request given the current User Feeds latest sequence.
 func liveLimit(
@@ -237,7 +270,19 @@ func (m *FeedManager) CreateStreamHistory(
 		qryArgs = append(qryArgs, margaret.Gt(int64(arg.Gt)))
 	}
 
-	resolved := mutil.Indirect(m.ReceiveLog, userLog)
+	var resolved margaret.Log = mutil.Indirect(m.ReceiveLog, userLog)
+	if m.Segments != nil {
+		seg, release, err := m.Segments.Acquire(arg.ID)
+		if err == nil {
+			defer release()
+			// the segment can be ahead of the user feed but not behind it
+			if seg.Seq() >= latest {
+				resolved = seg
+				qryArgs = segmentQuery(arg, limit, latest)
+			}
+		}
+	}
+
 	src, err := resolved.Query(qryArgs...)
 	if err != nil {
 		return fmt.Errorf("invalid user log query: %w", err)
@@ -18,6 +18,7 @@ import (
 	"go.mindeco.de/log/level"
 	"golang.org/x/sync/errgroup"
 
+	"github.com/ssbc/go-ssb"
 	refs "github.com/ssbc/go-ssb-refs"
 	"github.com/ssbc/go-ssb/internal/neterr"
 	"github.com/ssbc/go-ssb/message"
@@ -143,6 +144,14 @@ func (h *LegacyGossip) fetchFeed(
 		"fr", fr.ShortSigil(),
 		"starting", latestSeq) // , "me", g.Id.ShortRef())
 
+	var peer refs.FeedRef
+	if h.progress != nil {
+		peer, err = ssb.GetFeedRefFromAddr(edp.Remote())
+		if err != nil {
+			return fmt.Errorf("fetchFeed: failed to get peer reference: %w", err)
+		}
+	}
+
 	var q = message.NewCreateHistoryStreamArgs()
 	q.ID = fr
 	q.Seq = int64(latestSeq + 1)
@@ -193,8 +202,11 @@ func (h *LegacyGossip) fetchFeed(
 		if err != nil {
 			return err
 		}
-		buf.Reset()
 		latestSeq++
+		if h.progress != nil {
+			h.progress.Received(peer, fr, int64(latestSeq), buf.Len())
+		}
+		buf.Reset()
 	}
 
 	if err := src.Err(); err != nil {
@@ -25,6 +25,7 @@ import (
 
 	"github.com/ssbc/go-ssb"
 	refs "github.com/ssbc/go-ssb-refs"
+	"github.com/ssbc/go-ssb/internal/replprogress"
 	"github.com/ssbc/go-ssb/internal/storedrefs"
 	"github.com/ssbc/go-ssb/message"
 	"github.com/ssbc/go-ssb/repo"
@@ -57,6 +58,9 @@ type LegacyGossip struct {
 	sysGauge metrics.Gauge
 	sysCtr   metrics.Counter
 
+	// optional, records what we received
+	progress *replprogress.Tracker
+
 	feedManager *FeedManager
 
 	verifyRouter *message.VerificationRouter
@@ -12,6 +12,7 @@ import (
 	"github.com/ssbc/go-muxrpc/v2"
 	"github.com/ssbc/go-ssb"
 	refs "github.com/ssbc/go-ssb-refs"
+	"github.com/ssbc/go-ssb/internal/replprogress"
 	"github.com/ssbc/go-ssb/message"
 	"github.com/ssbc/go-ssb/repo"
 	"github.com/ssbc/margaret"
@@ -79,6 +80,8 @@ func NewFetcher(
 			h.hmacSec = v
 		case Promisc:
 			h.promisc = bool(v)
+		case *replprogress.Tracker:
+			h.progress = v
 		case WithLive:
 			h.enableLiveStreaming = bool(v)
 		case NumberOfConcurrentReplicationsPerPeer:
@@ -131,6 +134,8 @@ func NewServer(
 			h.hmacSec = v
 		case WithLive:
 			// no consequence - the outgoing live code is fine
+		case *replprogress.Tracker:
+			// only tracks what we receive
 		case NumberOfConcurrentReplicationsPerPeer:
 			h.numberOfConcurrentReplicationsPerPeer = int(v)
 		case NumberOfConcurrentReplications:
@@ -0,0 +1,129 @@
+// SPDX-FileCopyrightText: 2021 The Go-SSB Authors
+//
+// SPDX-License-Identifier: MIT
+
+// Package indexes offers indexes.status, which lists how far the indexes of the bot are,
+// and indexes.pause, indexes.resume and indexes.rebuild to control them.
+package indexes
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/ssbc/go-muxrpc/v2"
+	"github.com/ssbc/go-muxrpc/v2/typemux"
+	"github.com/ssbc/margaret"
+	"go.mindeco.de/logging"
+
+	"github.com/ssbc/go-ssb"
+)
+
+var (
+	_      ssb.Plugin = plugin{} // compile-time type check
+	method            = muxrpc.Method{"indexes"}
+)
+
+type plugin struct {
+	h muxrpc.Handler
+}
+
+func (plugin) Name() string              { return "indexes" }
+func (plugin) Method() muxrpc.Method     { return method }
+func (p plugin) Handler() muxrpc.Handler { return p.h }
+
+// New creates the indexes plugin, which should only be offered to the master
+func New(log logging.Interface, ctrl ssb.IndexController, rxLog margaret.Log) ssb.Plugin {
+	rootHdlr := typemux.New(log)
+
+	rootHdlr.RegisterAsync(muxrpc.Method{"indexes", "status"}, statusH{ctrl: ctrl, rxLog: rxLog})
+	rootHdlr.RegisterAsync(muxrpc.Method{"indexes", "pause"}, pauseH{ctrl: ctrl})
+	rootHdlr.RegisterAsync(muxrpc.Method{"indexes", "resume"}, resumeH{ctrl: ctrl})
+	rootHdlr.RegisterAsync(muxrpc.Method{"indexes", "rebuild"}, rebuildH{ctrl: ctrl})
+
+	return plugin{
+		h: &rootHdlr,
+	}
+}
+
+// Status is the reply of indexes.status
+type Status struct {
+	// RxSeq is the latest sequence of the receive log, which the indexes catch up with
+	RxSeq int64 `json:"rxSeq"`
+
+	Indexes []ssb.IndexProgress `json:"indexes"`
+}
+
+type statusH struct {
+	ctrl  ssb.IndexController
+	rxLog margaret.Log
+}
+
+func (h statusH) HandleAsync(ctx context.Context, req *muxrpc.Request) (interface{}, error) {
+	return Status{
+		RxSeq:   h.rxLog.Seq(),
+		Indexes: h.ctrl.IndexProgress(),
+	}, nil
+}
+
+type pauseH struct {
+	ctrl ssb.IndexController
+}
+
+// HandleAsync pauses the indexes that are passed by name, or all of them without arguments
+func (h pauseH) HandleAsync(ctx context.Context, req *muxrpc.Request) (interface{}, error) {
+	names, err := parseNames(req.RawArgs)
+	if err != nil {
+		return nil, err
+	}
+	if err := h.ctrl.PauseIndexing(names...); err != nil {
+		return nil, err
+	}
+	return true, nil
+}
+
+type resumeH struct {
+	ctrl ssb.IndexController
+}
+
+// HandleAsync resumes the indexes that are passed by name, or all of them without arguments
+func (h resumeH) HandleAsync(ctx context.Context, req *muxrpc.Request) (interface{}, error) {
+	names, err := parseNames(req.RawArgs)
+	if err != nil {
+		return nil, err
+	}
+	if err := h.ctrl.ResumeIndexing(names...); err != nil {
+		return nil, err
+	}
+	return true, nil
+}
+
+type rebuildH struct {
+	ctrl ssb.IndexController
+}
+
+// HandleAsync rebuilds the index that is passed by name. It returns once the index runs again.
+func (h rebuildH) HandleAsync(ctx context.Context, req *muxrpc.Request) (interface{}, error) {
+	names, err := parseNames(req.RawArgs)
+	if err != nil {
+		return nil, err
+	}
+	if len(names) != 1 {
+		return nil, fmt.Errorf("expected the name of one index but got %d", len(names))
+	}
+	if err := h.ctrl.RebuildIndex(names[0]); err != nil {
+		return nil, err
+	}
+	return true, nil
+}
+
+func parseNames(rawArgs json.RawMessage) ([]string, error) {
+	var names []string
+	if len(rawArgs) == 0 {
+		return nil, nil
+	}
+	if err := json.Unmarshal(rawArgs, &names); err != nil {
+		return nil, fmt.Errorf("expected index names as arguments: %w", err)
+	}
+	return names, nil
+}
@@ -0,0 +1,188 @@
+// SPDX-FileCopyrightText: 2021 The Go-SSB Authors
+//
+// SPDX-License-Identifier: MIT
+
+// Package links offers the links source, which streams the messages that link to a feed, message or blob, like ssb-links.
+// Since the backlinks index includes private messages, it should only be offered to the master.
+package links
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/ssbc/go-muxrpc/v2"
+	"github.com/ssbc/go-muxrpc/v2/typemux"
+	"github.com/ssbc/margaret"
+	"go.mindeco.de/logging"
+
+	"github.com/ssbc/go-ssb"
+	refs "github.com/ssbc/go-ssb-refs"
+	"github.com/ssbc/go-ssb/message"
+	"github.com/ssbc/go-ssb/multilogs"
+	"github.com/ssbc/go-ssb/private"
+	"github.com/ssbc/go-ssb/query"
+)
+
+var (
+	_      ssb.Plugin = plugin{} // compile-time type check
+	method            = muxrpc.Method{"links"}
+)
+
+type plugin struct {
+	h muxrpc.Handler
+}
+
+func (plugin) Name() string              { return "links" }
+func (plugin) Method() muxrpc.Method     { return method }
+func (p plugin) Handler() muxrpc.Handler { return p.h }
+
+// New creates the links plugin. The planer needs the Backlinks and Authors sources, and indexes is needed for live streams.
+func New(log logging.Interface, rxLog margaret.Log, planer *query.SubsetPlaner, unboxer *private.Manager, indexes query.IndexWaiter) ssb.Plugin {
+	rootHdlr := typemux.New(log)
+
+	rootHdlr.RegisterSource(method, linksSrc{
+		rxLog:   rxLog,
+		planer:  planer,
+		unboxer: unboxer,
+		indexes: indexes,
+	})
+
+	return plugin{
+		h: &rootHdlr,
+	}
+}
+
+type linksSrc struct {
+	rxLog   margaret.Log
+	planer  *query.SubsetPlaner
+	unboxer *private.Manager
+	indexes query.IndexWaiter
+}
+
+// Link is one element of the links stream
+type Link struct {
+	Source refs.FeedRef     `json:"source"`
+	Dest   string           `json:"dest"`
+	Rel    string           `json:"rel"`
+	Key    *refs.MessageRef `json:"key,omitempty"`
+	Value  json.RawMessage  `json:"value,omitempty"`
+}
+
+// HandleSource sends one {source, dest, rel, key} for every link to dest and then, if live, the new ones as they are indexed
+func (h linksSrc) HandleSource(ctx context.Context, req *muxrpc.Request, snk *muxrpc.ByteSink) error {
+	var args []json.RawMessage
+	if err := json.Unmarshal(req.RawArgs, &args); err != nil {
+		return fmt.Errorf("invalid argument on links call: %w", err)
+	}
+	if len(args) != 1 {
+		return fmt.Errorf("expected one argument but got %d", len(args))
+	}
+
+	qry := message.NewLinksArgs()
+	if err := json.Unmarshal(args[0], &qry); err != nil {
+		return fmt.Errorf("invalid argument on links call: %w", err)
+	}
+
+	dest, err := refs.ParseRef(qry.Dest)
+	if err != nil {
+		return fmt.Errorf("links: invalid dest: %w", err)
+	}
+
+	op := query.NewSubsetOpByLink(dest, qry.Rel)
+	if qry.Source != nil {
+		op = query.NewSubsetAndCombination(op, query.NewSubsetOpByAuthor(*qry.Source))
+	}
+
+	snk.SetEncoding(muxrpc.TypeJSON)
+
+	var (
+		buf bytes.Buffer
+		enc = json.NewEncoder(&buf)
+	)
+	send := func(msg refs.Message) error {
+		content := msg.ContentBytes()
+		if len(content) == 0 || content[0] != '{' {
+			decrypted, err := h.unboxer.DecryptMessage(msg)
+			if err != nil {
+				// the index only has the messages we could read but the keys might be gone by now
+				return nil
+			}
+			content = decrypted
+		}
+
+		for _, l := range multilogs.ExtractLinks(content) {
+			if l.Dest.String() != dest.String() || (qry.Rel != "" && l.Rel != qry.Rel) {
+				continue
+			}
+
+			if qry.Limit == 0 {
+				return errLimit
+			}
+
+			link := Link{
+				Source: msg.Author(),
+				Dest:   dest.String(),
+				Rel:    l.Rel,
+			}
+			if qry.Keys {
+				key := msg.Key()
+				link.Key = &key
+			}
+			if qry.Values {
+				link.Value = msg.ValueContentJSON()
+			}
+
+			buf.Reset()
+			if err := enc.Encode(link); err != nil {
+				return fmt.Errorf("failed to encode json: %w", err)
+			}
+			if _, err := buf.WriteTo(snk); err != nil {
+				return fmt.Errorf("failed to send json data: %w", err)
+			}
+
+			if qry.Limit > 0 {
+				qry.Limit--
+				if qry.Limit == 0 {
+					return errLimit
+				}
+			}
+		}
+		return nil
+	}
+
+	// the existing links end here and the live ones pick up after it
+	upto := h.rxLog.Seq()
+
+	if qry.Old {
+		existing, err := h.planer.QuerySubsetRange(op, margaret.SeqEmpty, upto)
+		if err != nil {
+			return fmt.Errorf("links: query failed: %w", err)
+		}
+
+		err = query.ForEach(h.rxLog, existing, qry.Reverse, send)
+		if errors.Is(err, errLimit) {
+			return snk.Close()
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	if qry.Live {
+		if h.indexes == nil {
+			return fmt.Errorf("links: live is not supported")
+		}
+		err := h.planer.Live(ctx, h.indexes, upto, op, send)
+		if err != nil && !errors.Is(err, context.Canceled) && !errors.Is(err, errLimit) {
+			return err
+		}
+	}
+
+	return snk.Close()
+}
+
+// errLimit ends the stream once limit links were sent
+var errLimit = errors.New("links: limit reached")
@@ -36,11 +36,47 @@ func (p plugin) Handler() muxrpc.Handler {
 	return p.h
 }
 
+// New returns the plugin for remote peers.
+// Their subsets are limited to author, type and their and/or combinations, which are answered from the sublogs without scanning the receive log.
+// They can't keep a live query open either.
 func New(log logging.Interface,
 	fm *gossip.FeedManager,
 	feeds, bytype, roots *roaring.MultiLog,
 	rxlog margaret.Log,
 	get ssb.Getter,
+) ssb.Plugin {
+	return newPlugin(log, roots, rxlog, get, getSubsetHandler{
+		queryPlaner: query.NewSubsetPlaner(feeds, bytype),
+		rxLog:       rxlog,
+	})
+}
+
+// NewPrivileged returns the plugin for the master handler.
+// If the caller is the bot itself, as decided by isSelf, its subsets can use all the operations of planer and live queries follow indexes.
+// Clients with a restricted grant use the same handler, they get what New offers.
+func NewPrivileged(log logging.Interface,
+	feeds, bytype, roots *roaring.MultiLog,
+	rxlog margaret.Log,
+	get ssb.Getter,
+	planer *query.SubsetPlaner,
+	indexes query.IndexWaiter,
+	isSelf ssb.Authorizer,
+) ssb.Plugin {
+	return newPlugin(log, roots, rxlog, get, getSubsetHandler{
+		queryPlaner: query.NewSubsetPlaner(feeds, bytype),
+		rxLog:       rxlog,
+
+		selfPlaner: planer,
+		indexes:    indexes,
+		isSelf:     isSelf,
+	})
+}
+
+func newPlugin(log logging.Interface,
+	roots *roaring.MultiLog,
+	rxlog margaret.Log,
+	get ssb.Getter,
+	subset getSubsetHandler,
 ) ssb.Plugin {
 	rootHdlr := typemux.New(log)
 
@@ -50,10 +86,7 @@ func New(log logging.Interface,
 		rxlog: rxlog,
 	})
 
-	rootHdlr.RegisterSource(muxrpc.Method{name, "getSubset"}, getSubsetHandler{
-		queryPlaner: query.NewSubsetPlaner(feeds, bytype),
-		rxLog:       rxlog,
-	})
+	rootHdlr.RegisterSource(muxrpc.Method{name, "getSubset"}, subset)
 
 	// TODO:
 	// rootHdlr.RegisterSource(muxrpc.Method{name, "resolveIndexFeed"}, getFeedReverseHandler{
@@ -8,18 +8,39 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 
 	"github.com/ssbc/go-muxrpc/v2"
+	"github.com/ssbc/margaret"
+
+	"github.com/ssbc/go-ssb"
 	refs "github.com/ssbc/go-ssb-refs"
 	"github.com/ssbc/go-ssb/query"
-	"github.com/ssbc/margaret"
 )
 
 type getSubsetHandler struct {
 	queryPlaner *query.SubsetPlaner
 
 	rxLog margaret.Log
+
+	// selfPlaner and indexes, which live queries need, are only used if the caller is the bot itself
+	selfPlaner *query.SubsetPlaner
+	indexes    query.IndexWaiter
+	isSelf     ssb.Authorizer
+}
+
+// forCaller returns the planer and the indexes for live queries of the caller of req.
+// The indexes are nil if it can't make live queries.
+func (h getSubsetHandler) forCaller(req *muxrpc.Request) (*query.SubsetPlaner, query.IndexWaiter) {
+	if h.isSelf == nil {
+		return h.queryPlaner, nil
+	}
+	remote, err := ssb.GetFeedRefFromAddr(req.RemoteAddr())
+	if err != nil || h.isSelf.Authorize(remote) != nil {
+		return h.queryPlaner, nil
+	}
+	return h.selfPlaner, h.indexes
 }
 
 func (h getSubsetHandler) HandleSource(ctx context.Context, req *muxrpc.Request, sink *muxrpc.ByteSink) error {
@@ -54,71 +75,78 @@ func (h getSubsetHandler) HandleSource(ctx context.Context, req *muxrpc.Request,
 		opts.Keys = true
 	}
 
-	resulting, err := h.queryPlaner.QuerySubsetBitmap(arg)
-	if err != nil {
-		return fmt.Errorf("failed to send query result to peer: %w", err)
+	planer, indexes := h.forCaller(req)
+	if opts.Live && indexes == nil {
+		return fmt.Errorf("getSubset: live queries are not supported")
 	}
 
-	if resulting == nil {
-		sink.Close()
-		return nil
+	// the existing messages end here and the live ones pick up after it
+	upto := h.rxLog.Seq()
+
+	resulting, err := planer.QuerySubsetRange(arg, margaret.SeqEmpty, upto)
+	if err != nil {
+		return fmt.Errorf("failed to send query result to peer: %w", err)
 	}
 
 	sink.SetEncoding(muxrpc.TypeJSON)
 
-	// iterate over the combined set of bitmaps
 	var (
 		buf bytes.Buffer
 		enc = json.NewEncoder(&buf)
 	)
-
-	vals := resulting.ToArray()
-	if opts.Descending {
-		for i, j := 0, len(vals)-1; i < j; i, j = i+1, j-1 {
-			vals[i], vals[j] = vals[j], vals[i]
-		}
-	}
-
-	for _, v := range vals {
-		msgv, err := h.rxLog.Get(int64(v))
-		if err != nil {
-			break
+	send := func(msg refs.Message) error {
+		if !opts.Keys {
+			_, err := sink.Write(msg.ValueContentJSON())
+			if err != nil {
+				return fmt.Errorf("failed to send json data: %w", err)
+			}
+			return nil
 		}
 
-		msg, ok := msgv.(refs.Message)
-		if !ok {
-			return fmt.Errorf("invalid msg type %T", msgv)
-		}
+		buf.Reset()
 
-		if opts.Keys {
-			buf.Reset()
+		var kv refs.KeyValueRaw
+		kv.Key_ = msg.Key()
+		kv.Value = *msg.ValueContent()
 
-			var kv refs.KeyValueRaw
-			kv.Key_ = msg.Key()
-			kv.Value = *msg.ValueContent()
+		if err := enc.Encode(kv); err != nil {
+			return fmt.Errorf("failed to encode json: %w", err)
+		}
 
-			if err := enc.Encode(kv); err != nil {
-				return fmt.Errorf("failed to encode json: %w", err)
-			}
+		if _, err := buf.WriteTo(sink); err != nil {
+			return fmt.Errorf("failed to send json data: %w", err)
+		}
+		return nil
+	}
 
-			if _, err = buf.WriteTo(sink); err != nil {
-				return fmt.Errorf("failed to send json data: %w", err)
-			}
-		} else {
-			_, err = sink.Write(msg.ValueContentJSON())
-			if err != nil {
-				return fmt.Errorf("failed to send json data: %w", err)
-			}
+	// stream the existing messages without loading them all
+	err = query.ForEach(h.rxLog, resulting, opts.Descending, func(msg refs.Message) error {
+		if err := send(msg); err != nil {
+			return err
 		}
 
 		if opts.PageLimit >= 0 {
 			opts.PageLimit--
 			if opts.PageLimit == 0 {
-				break
+				return errPageFull
 			}
 		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, errPageFull) {
+		return err
+	}
+
+	if opts.Live {
+		err = planer.Live(ctx, indexes, upto, arg, send)
+		if err != nil && !errors.Is(err, context.Canceled) {
+			return err
+		}
 	}
 
 	sink.Close()
 	return nil
 }
+
+// errPageFull ends the stream of existing messages once the page limit is reached
+var errPageFull = errors.New("getSubset: page limit reached")
@@ -0,0 +1,63 @@
+// SPDX-FileCopyrightText: 2021 The Go-SSB Authors
+//
+// SPDX-License-Identifier: MIT
+
+// Package query offers query.explain, which shows how a subset query is planned and how many messages each operation matched.
+package query
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/ssbc/go-muxrpc/v2"
+	"github.com/ssbc/go-muxrpc/v2/typemux"
+	"go.mindeco.de/logging"
+
+	"github.com/ssbc/go-ssb"
+	"github.com/ssbc/go-ssb/query"
+)
+
+var (
+	_      ssb.Plugin = plugin{} // compile-time type check
+	method            = muxrpc.Method{"query"}
+)
+
+type plugin struct {
+	h muxrpc.Handler
+}
+
+func (plugin) Name() string              { return "query" }
+func (plugin) Method() muxrpc.Method     { return method }
+func (p plugin) Handler() muxrpc.Handler { return p.h }
+
+// New creates the query plugin. Since the plans include the number of private messages, it should only be offered to the master.
+func New(log logging.Interface, planer *query.SubsetPlaner) ssb.Plugin {
+	rootHdlr := typemux.New(log)
+
+	rootHdlr.RegisterAsync(muxrpc.Method{"query", "explain"}, explainH{
+		planer: planer,
+	})
+
+	return plugin{
+		h: &rootHdlr,
+	}
+}
+
+type explainH struct {
+	planer *query.SubsetPlaner
+}
+
+// HandleAsync takes the same operation as partialReplication.getSubset and returns its query.Plan
+func (h explainH) HandleAsync(ctx context.Context, req *muxrpc.Request) (interface{}, error) {
+	var args []query.SubsetOperation
+	if err := json.Unmarshal(req.RawArgs, &args); err != nil {
+		return nil, fmt.Errorf("invalid argument on explain call: %w", err)
+	}
+
+	if len(args) != 1 {
+		return nil, fmt.Errorf("expected one subset operation")
+	}
+
+	return h.planer.Explain(args[0])
+}
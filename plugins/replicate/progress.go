@@ -0,0 +1,75 @@
+// SPDX-FileCopyrightText: 2021 The Go-SSB Authors
+//
+// SPDX-License-Identifier: MIT
+
+package replicate
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/ssbc/go-muxrpc/v2"
+
+	"github.com/ssbc/go-ssb"
+)
+
+// ProgressArgs are the options of replicate.progress
+type ProgressArgs struct {
+	// Live keeps the stream open and sends an update every Interval
+	Live bool `json:"live"`
+
+	// Interval in milliseconds, defaults to one second
+	Interval int `json:"interval"`
+}
+
+type progressHandler struct {
+	progress ssb.ReplicationProgresser
+}
+
+func (h progressHandler) HandleSource(ctx context.Context, req *muxrpc.Request, sink *muxrpc.ByteSink) error {
+	var args []ProgressArgs
+	if len(req.RawArgs) > 0 {
+		if err := json.Unmarshal(req.RawArgs, &args); err != nil {
+			return fmt.Errorf("replicate/progress: failed to decode call arguments: %w", err)
+		}
+	}
+
+	var opts ProgressArgs
+	if len(args) > 0 {
+		opts = args[0]
+	}
+
+	interval := time.Second
+	if opts.Interval > 0 {
+		interval = time.Duration(opts.Interval) * time.Millisecond
+	}
+
+	sink.SetEncoding(muxrpc.TypeJSON)
+	enc := json.NewEncoder(sink)
+
+	tick := time.NewTicker(interval)
+	defer tick.Stop()
+
+	for {
+		prog, err := h.progress.ReplicationProgress()
+		if err != nil {
+			return err
+		}
+
+		if err := enc.Encode(prog); err != nil {
+			return err
+		}
+
+		if !opts.Live {
+			return sink.Close()
+		}
+
+		select {
+		case <-ctx.Done():
+			return sink.CloseWithError(ctx.Err())
+		case <-tick.C:
+		}
+	}
+}
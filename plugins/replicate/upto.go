@@ -26,7 +26,7 @@ type replicatePlug struct {
 }
 
 // TODO: add request, block, changes
-func NewPlug(users multilog.MultiLog, self refs.FeedRef, lister ssb.ReplicationLister) ssb.Plugin {
+func NewPlug(users multilog.MultiLog, self refs.FeedRef, lister ssb.ReplicationLister, progress ssb.ReplicationProgresser) ssb.Plugin {
 	plug := &replicatePlug{}
 
 	tm := typemux.New(log.NewNopLogger())
@@ -37,6 +37,10 @@ func NewPlug(users multilog.MultiLog, self refs.FeedRef, lister ssb.ReplicationL
 		self:   self,
 	})
 
+	tm.RegisterSource(muxrpc.Method{"replicate", "progress"}, progressHandler{
+		progress: progress,
+	})
+
 	plug.h = &tm
 	return plug
 }
@@ -0,0 +1,61 @@
+// SPDX-FileCopyrightText: 2021 The Go-SSB Authors
+//
+// SPDX-License-Identifier: MIT
+
+// Package snapshot offers the snapshot call, which copies the repo into a folder while the bot keeps running.
+// sbot.RestoreSnapshot creates a new repo from it.
+package snapshot
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+
+	"github.com/ssbc/go-muxrpc/v2"
+	"github.com/ssbc/go-muxrpc/v2/typemux"
+	"go.mindeco.de/logging"
+
+	"github.com/ssbc/go-ssb"
+)
+
+var (
+	_      ssb.Plugin = plugin{} // compile-time type check
+	method            = muxrpc.Method{"snapshot"}
+)
+
+type plugin struct {
+	h muxrpc.Handler
+}
+
+func (plugin) Name() string              { return "snapshot" }
+func (plugin) Method() muxrpc.Method     { return method }
+func (p plugin) Handler() muxrpc.Handler { return p.h }
+
+// New creates the snapshot plugin, which should only be offered to the master
+func New(log logging.Interface, s ssb.Snapshotter) ssb.Plugin {
+	rootHdlr := typemux.New(log)
+
+	rootHdlr.RegisterAsync(method, snapshotH{s: s})
+
+	return plugin{
+		h: &rootHdlr,
+	}
+}
+
+type snapshotH struct {
+	s ssb.Snapshotter
+}
+
+// HandleAsync takes the snapshot into the folder of the first argument and returns its ssb.SnapshotInfo.
+// The folder has to be an absolute path since the working directory of the bot is not the one of the caller.
+func (h snapshotH) HandleAsync(ctx context.Context, req *muxrpc.Request) (interface{}, error) {
+	var args []string
+	if err := json.Unmarshal(req.RawArgs, &args); err != nil {
+		return nil, fmt.Errorf("snapshot: bad arguments: %w", err)
+	}
+	if len(args) != 1 || !filepath.IsAbs(args[0]) {
+		return nil, fmt.Errorf("snapshot: expected the absolute path of the folder as the only argument")
+	}
+	return h.s.Snapshot(args[0])
+}
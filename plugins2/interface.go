@@ -6,6 +6,7 @@ package plugins2
 
 import (
 	"github.com/ssbc/go-ssb"
+	"github.com/ssbc/go-ssb/indexes/declared"
 	"github.com/ssbc/margaret"
 )
 
@@ -27,3 +28,10 @@ type NeedsRootLog interface {
 type NeedsMultiLog interface {
 	WantMultiLog(ssb.MultiLogGetter) error
 }
+
+// NeedsDeclaredIndexes is for plugins that describe their indexes instead of building them.
+// The indexes of DeclareIndexes are set up when the bot starts, which then passes the registry to query them.
+type NeedsDeclaredIndexes interface {
+	DeclareIndexes() []declared.Spec
+	WantDeclaredIndexes(*declared.Registry)
+}
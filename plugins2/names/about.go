@@ -36,7 +36,10 @@ type AboutAttribute struct {
 	Prescribed map[string]int
 }
 
-var idxKeyPrefix = []byte("idx-abouts")
+// IndexKeyPrefix is what the keys of the about index start with
+const IndexKeyPrefix = "idx-abouts"
+
+var idxKeyPrefix = []byte(IndexKeyPrefix)
 var idxInSync sync.WaitGroup
 
 func (ab aboutStore) waitForIndexes() {
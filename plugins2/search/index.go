@@ -0,0 +1,188 @@
+// SPDX-FileCopyrightText: 2021 The Go-SSB Authors
+//
+// SPDX-License-Identifier: MIT
+
+// Package search is an optional full-text index over the text of post and about messages,
+// including the private ones this bot can decrypt, and the search.query source to look through it.
+package search
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"unicode"
+
+	"github.com/dgraph-io/badger/v3"
+	"github.com/dgraph-io/sroar"
+	"github.com/ssbc/margaret"
+	librarian "github.com/ssbc/margaret/indexes"
+	libbadger "github.com/ssbc/margaret/indexes/badger"
+
+	refs "github.com/ssbc/go-ssb-refs"
+)
+
+// IndexName is the name the index is served as
+const IndexName = "search"
+
+// the inverted index keeps one key per word and message, idx-search t:<word> 0x00 <rxSeq>,
+// so that all the messages with words that start with a prefix can be found with a single scan.
+var (
+	idxKeyPrefix  = []byte("idx-search")
+	idxTermPrefix = []byte("t:")
+)
+
+// maxTokenLength skips things that aren't words, like base64 blobs
+const maxTokenLength = 64
+
+// Decrypter is used to index and return the private messages, usually a *private.Manager
+type Decrypter interface {
+	DecryptMessage(refs.Message) ([]byte, error)
+}
+
+// MakeSimpleIndex opens the index in the shared badger db of the bot, for sbot.MountSimpleIndex
+func (p *Plugin) MakeSimpleIndex(db *badger.DB) (librarian.Index, librarian.SinkIndex, error) {
+	idx := libbadger.NewIndexWithKeyPrefix(db, true, idxKeyPrefix)
+
+	p.db = db
+	p.idx = idx
+
+	return idx, librarian.NewSinkIndex(p.update, idx), nil
+}
+
+// DropIndex removes the whole index from db, so that it is filled again the next time it is served
+func DropIndex(db *badger.DB) error {
+	return db.DropPrefix(idxKeyPrefix)
+}
+
+func (p *Plugin) update(ctx context.Context, seq int64, v interface{}, idx librarian.SetterIndex) error {
+	if nulled, ok := v.(error); ok {
+		if margaret.IsErrNulled(nulled) {
+			return nil
+		}
+		return nulled
+	}
+
+	msg, ok := v.(refs.Message)
+	if !ok {
+		return fmt.Errorf("search(%d): wrong message type: %T", seq, v)
+	}
+
+	content := msg.ContentBytes()
+	if len(content) == 0 {
+		return nil
+	}
+	if content[0] != '{' {
+		decrypted, err := p.unboxer.DecryptMessage(msg)
+		if err != nil {
+			// not for us or not a boxed message we know
+			return nil
+		}
+		content = decrypted
+	}
+
+	var textContent struct {
+		Type string
+		Text string
+	}
+	if err := json.Unmarshal(content, &textContent); err != nil {
+		// like the other indexes, broken content must not stop the indexing
+		return nil
+	}
+	if textContent.Type != "post" && textContent.Type != "about" {
+		return nil
+	}
+
+	for _, token := range Tokenize(textContent.Text) {
+		if err := idx.Set(ctx, termAddr(token, seq), true); err != nil {
+			return fmt.Errorf("search(%d): failed to update index: %w", seq, err)
+		}
+	}
+	return nil
+}
+
+// Tokenize splits text into the lower-case words that are indexed. Every word is only returned once.
+func Tokenize(text string) []string {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+
+	var (
+		tokens []string
+		seen   = make(map[string]struct{}, len(words))
+	)
+	for _, w := range words {
+		if len(w) > maxTokenLength {
+			continue
+		}
+		if _, has := seen[w]; has {
+			continue
+		}
+		seen[w] = struct{}{}
+		tokens = append(tokens, w)
+	}
+	return tokens
+}
+
+func termAddr(token string, seq int64) librarian.Addr {
+	key := make([]byte, len(idxTermPrefix)+len(token)+1+8)
+	n := copy(key, idxTermPrefix)
+	n += copy(key[n:], token)
+	key[n] = 0
+	binary.BigEndian.PutUint64(key[n+1:], uint64(seq))
+	return librarian.Addr(key)
+}
+
+// Lookup returns the receive log sequences of the messages that have a word starting with every term in terms
+func (p *Plugin) Lookup(terms string) (*sroar.Bitmap, error) {
+	tokens := Tokenize(terms)
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("search: no words to look for")
+	}
+
+	if p.idx == nil {
+		return nil, fmt.Errorf("search: index is not mounted")
+	}
+
+	// the index batches its writes
+	if err := p.idx.Flush(); err != nil {
+		return nil, fmt.Errorf("search: failed to flush index: %w", err)
+	}
+
+	var result *sroar.Bitmap
+	err := p.db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		iter := txn.NewIterator(opts)
+		defer iter.Close()
+
+		for _, token := range tokens {
+			prefix := append(append(append([]byte{}, idxKeyPrefix...), idxTermPrefix...), token...)
+
+			matches := sroar.NewBitmap()
+			for iter.Seek(prefix); iter.ValidForPrefix(prefix); iter.Next() {
+				key := iter.Item().Key()
+				if len(key) < len(prefix)+9 {
+					continue
+				}
+				matches.Set(binary.BigEndian.Uint64(key[len(key)-8:]))
+			}
+
+			if result == nil {
+				result = matches
+			} else {
+				result.And(matches)
+			}
+
+			if result.IsEmpty() {
+				break
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("search: failed to read index: %w", err)
+	}
+	return result, nil
+}
@@ -0,0 +1,28 @@
+// SPDX-FileCopyrightText: 2021 The Go-SSB Authors
+//
+// SPDX-License-Identifier: MIT
+
+package search
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTokenize(t *testing.T) {
+	tcs := []struct {
+		text string
+		want []string
+	}{
+		{"", nil},
+		{"Hello, World! hello again", []string{"hello", "world", "again"}},
+		{"Grüße aus #Berlin: 2021", []string{"grüße", "aus", "berlin", "2021"}},
+		{"skip " + strings.Repeat("a", maxTokenLength+1) + " this", []string{"skip", "this"}},
+	}
+
+	for _, tc := range tcs {
+		require.Equal(t, tc.want, Tokenize(tc.text), "text: %q", tc.text)
+	}
+}
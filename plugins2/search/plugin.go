@@ -0,0 +1,170 @@
+// SPDX-FileCopyrightText: 2021 The Go-SSB Authors
+//
+// SPDX-License-Identifier: MIT
+
+package search
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/dgraph-io/badger/v3"
+	"github.com/ssbc/go-muxrpc/v2"
+	"github.com/ssbc/go-muxrpc/v2/typemux"
+	"github.com/ssbc/margaret"
+	librarian "github.com/ssbc/margaret/indexes"
+	"go.mindeco.de/logging"
+
+	"github.com/ssbc/go-ssb"
+	refs "github.com/ssbc/go-ssb-refs"
+	"github.com/ssbc/go-ssb/query"
+)
+
+var (
+	_ ssb.Plugin = (*Plugin)(nil) // compile-time type check
+
+	method = muxrpc.Method{"search"}
+)
+
+// Plugin holds the index and offers search.query.
+// Since the index includes private messages, it should only be offered to the master.
+type Plugin struct {
+	rxLog   margaret.Log
+	unboxer Decrypter
+	planer  *query.SubsetPlaner
+
+	db  *badger.DB
+	idx librarian.SeqSetterIndex
+
+	h muxrpc.Handler
+}
+
+// New creates the search plugin. The planer is used for the filters of search.query.
+// The index still needs to be mounted with MakeSimpleIndex.
+func New(log logging.Interface, rxLog margaret.Log, unboxer Decrypter, planer *query.SubsetPlaner) *Plugin {
+	p := &Plugin{
+		rxLog:   rxLog,
+		unboxer: unboxer,
+		planer:  planer,
+	}
+
+	rootHdlr := typemux.New(log)
+	rootHdlr.RegisterSource(muxrpc.Method{"search", "query"}, querySrc{p})
+	p.h = &rootHdlr
+
+	return p
+}
+
+func (*Plugin) Name() string              { return IndexName }
+func (*Plugin) Method() muxrpc.Method     { return method }
+func (p *Plugin) Handler() muxrpc.Handler { return p.h }
+
+// QueryArgs defines the query parameters for the search.query rpc call
+type QueryArgs struct {
+	// Query are the words to look for. Every one of them needs to be the start of a word in the text.
+	Query string `json:"query"`
+
+	// Filter optionally restricts the results further, like to an author or a type
+	Filter *query.SubsetOperation `json:"filter,omitempty"`
+
+	Keys       bool `json:"keys"`
+	Descending bool `json:"descending,omitempty"`
+	Limit      int  `json:"limit,omitempty"`
+}
+
+// NewQueryArgs returns the defaults for search.query, which are all the messages with their keys
+func NewQueryArgs() QueryArgs {
+	return QueryArgs{
+		Keys:  true,
+		Limit: -1,
+	}
+}
+
+type querySrc struct {
+	p *Plugin
+}
+
+// HandleSource sends the matching messages, with the content of private messages decrypted
+func (h querySrc) HandleSource(ctx context.Context, req *muxrpc.Request, snk *muxrpc.ByteSink) error {
+	var args []json.RawMessage
+	if err := json.Unmarshal(req.RawArgs, &args); err != nil {
+		return fmt.Errorf("invalid argument on search call: %w", err)
+	}
+	if len(args) != 1 {
+		return fmt.Errorf("expected one argument but got %d", len(args))
+	}
+
+	qry := NewQueryArgs()
+	if err := json.Unmarshal(args[0], &qry); err != nil {
+		return fmt.Errorf("invalid argument on search call: %w", err)
+	}
+
+	found, err := h.p.Lookup(qry.Query)
+	if err != nil {
+		return err
+	}
+
+	if qry.Filter != nil {
+		filter, err := h.p.planer.QuerySubsetBitmap(*qry.Filter)
+		if err != nil {
+			return fmt.Errorf("search: filter failed: %w", err)
+		}
+		found.And(filter)
+	}
+
+	snk.SetEncoding(muxrpc.TypeJSON)
+
+	var (
+		buf bytes.Buffer
+		enc = json.NewEncoder(&buf)
+	)
+	err = query.ForEach(h.p.rxLog, found, qry.Descending, func(msg refs.Message) error {
+		value := *msg.ValueContent()
+		if content := msg.ContentBytes(); len(content) > 0 && content[0] != '{' {
+			decrypted, err := h.p.unboxer.DecryptMessage(msg)
+			if err != nil {
+				// the keys might be gone by now
+				return nil
+			}
+			value.Content = decrypted
+			value.Meta = map[string]interface{}{"private": true}
+		}
+
+		buf.Reset()
+		var err error
+		if qry.Keys {
+			err = enc.Encode(refs.KeyValueRaw{
+				Key_:      msg.Key(),
+				Value:     value,
+				Timestamp: refs.Millisecs(msg.Received()),
+			})
+		} else {
+			err = enc.Encode(value)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to encode json: %w", err)
+		}
+		if _, err := buf.WriteTo(snk); err != nil {
+			return fmt.Errorf("failed to send json data: %w", err)
+		}
+
+		if qry.Limit > 0 {
+			qry.Limit--
+			if qry.Limit == 0 {
+				return errLimit
+			}
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, errLimit) {
+		return err
+	}
+
+	return snk.Close()
+}
+
+// errLimit ends the stream once limit messages were sent
+var errLimit = errors.New("search: limit reached")
@@ -23,6 +23,8 @@ type unboxedLog struct {
 	root, seqlog margaret.Log
 	kp           ssb.KeyPair
 	boxer        *box.Boxer
+
+	decrypt func(refs.Message) ([]byte, error)
 }
 
 // NewUnboxerLog expects the sequence numbers, that are returned from seqlog, to be decryptable by kp.
@@ -33,9 +35,20 @@ func NewUnboxerLog(root, seqlog margaret.Log, kp ssb.KeyPair) margaret.Log {
 		kp:     kp,
 		boxer:  box.NewBoxer(nil),
 	}
+	il.decrypt = il.decryptBox1
 	return il
 }
 
+// NewUnboxerLog is like the package level NewUnboxerLog but decrypts with all the keys of the manager.
+// That includes box2 messages to groups we are part of.
+func (mgr *Manager) NewUnboxerLog(root, seqlog margaret.Log) margaret.Log {
+	return unboxedLog{
+		root:    root,
+		seqlog:  seqlog,
+		decrypt: mgr.DecryptMessage,
+	}
+}
+
 func (il unboxedLog) Changes() luigi.Observable {
 	return il.seqlog.Changes()
 }
@@ -57,6 +70,19 @@ func (il unboxedLog) Get(seq int64) (interface{}, error) {
 	return rv, nil
 }
 
+// RootSeq returns the sequence in the root log of the entry seq
+func (il unboxedLog) RootSeq(seq int64) (int64, error) {
+	v, err := il.seqlog.Get(seq)
+	if err != nil {
+		return -1, fmt.Errorf("seqlog: lookup failed: %w", err)
+	}
+	rootSeq, ok := v.(int64)
+	if !ok {
+		return -1, fmt.Errorf("seqlog: not a sequence: %T", v)
+	}
+	return rootSeq, nil
+}
+
 // Query maps the sequence values in seqlog to an unboxed version of the message
 func (il unboxedLog) Query(args ...margaret.QuerySpec) (luigi.Source, error) {
 	src, err := il.seqlog.Query(args...)
@@ -88,6 +114,10 @@ func (il unboxedLog) indirectFunc(ctx context.Context, iv interface{}) (interfac
 
 	val, err := il.root.Get(rootSeq)
 	if err != nil {
+		if margaret.IsErrNulled(err) {
+			// passed on like the receive log does, so that indexes can skip it
+			return err, nil
+		}
 		return nil, fmt.Errorf("unboxLog: error getting v(%v) from seqlog log: %w", iv, err)
 	}
 
@@ -96,6 +126,30 @@ func (il unboxedLog) indirectFunc(ctx context.Context, iv interface{}) (interfac
 		return nil, fmt.Errorf("wrong message type. expected %T - got %T", amsg, val)
 	}
 
+	clearContent, err := il.decrypt(amsg)
+	if err != nil {
+		return nil, fmt.Errorf("unboxLog: unbox failed: %w", err)
+	}
+
+	var msg refs.KeyValueRaw
+	msg.Key_ = amsg.Key()
+	msg.Timestamp = refs.Millisecs(amsg.Received())
+	msg.Value.Previous = amsg.Previous()
+	msg.Value.Author = amsg.Author()
+	msg.Value.Sequence = amsg.Seq()
+	msg.Value.Timestamp = refs.Millisecs(amsg.Claimed())
+	msg.Value.Hash = "go-ssb-unboxed"
+	msg.Value.Content = clearContent
+	msg.Value.Signature = "go-ssb-unboxed"
+
+	if wrappedSeq != nil {
+		return margaret.WrapWithSeq(msg, wrappedSeq.Seq()), nil
+	}
+
+	return msg, nil
+}
+
+func (il unboxedLog) decryptBox1(amsg refs.Message) ([]byte, error) {
 	author := amsg.Author()
 
 	var boxedContent []byte
@@ -121,28 +175,7 @@ func (il unboxedLog) indirectFunc(ctx context.Context, iv interface{}) (interfac
 		return nil, fmt.Errorf("decode pm: unknown feed type: %s", author.Algo())
 	}
 
-	clearContent, err := il.boxer.Decrypt(il.kp, boxedContent)
-	if err != nil {
-		return nil, fmt.Errorf("unboxLog: unbox failed: %w", err)
-	}
-
-	var msg refs.KeyValueRaw
-	msg.Key_ = amsg.Key()
-	msg.Timestamp = refs.Millisecs(amsg.Received())
-	msg.Value.Previous = amsg.Previous()
-	msg.Value.Author = author
-	msg.Value.Sequence = amsg.Seq()
-	msg.Value.Timestamp = refs.Millisecs(amsg.Claimed())
-	msg.Value.Hash = "go-ssb-unboxed"
-	msg.Value.Content = clearContent
-	msg.Value.Signature = "go-ssb-unboxed"
-
-	if wrappedSeq != nil {
-		return margaret.WrapWithSeq(msg, wrappedSeq.Seq()), nil
-	}
-
-	return msg, nil
-
+	return il.boxer.Decrypt(il.kp, boxedContent)
 }
 
 // Append doesn't work on this log. They need to go through the proper channels.
@@ -0,0 +1,58 @@
+// SPDX-FileCopyrightText: 2021 The Go-SSB Authors
+//
+// SPDX-License-Identifier: MIT
+
+package ssb
+
+import (
+	refs "github.com/ssbc/go-ssb-refs"
+)
+
+// ReplicationProgress tells how far behind the bot is with the feeds it wants to replicate.
+// "Known" sequences are the highest ones peers told us about, either via EBT frontiers or by sending us the messages over legacy gossip.
+type ReplicationProgress struct {
+	Feeds  int `json:"feeds"`  // number of wanted feeds
+	InSync int `json:"inSync"` // wanted feeds where we have everything a peer told us about
+
+	Local int64 `json:"local"` // messages stored locally, summed over the wanted feeds
+	Known int64 `json:"known"` // messages known to exist, summed over the wanted feeds
+
+	// Percent is Local in relation to Known (100 if nothing is known)
+	Percent float64 `json:"percent"`
+
+	Messages uint64 `json:"messages"` // messages received since the bot started
+	Bytes    uint64 `json:"bytes"`    // bytes received since the bot started
+
+	// Rate is the number of messages received per second, averaged over the last minute
+	Rate float64 `json:"rate"`
+	// ETA is the estimated number of seconds until Known is reached, -1 if nothing was received recently.
+	ETA float64 `json:"eta"`
+
+	Peers []PeerProgress `json:"peers"`
+}
+
+// PeerProgress is the part of ReplicationProgress that concerns a single peer
+type PeerProgress struct {
+	Peer refs.FeedRef `json:"peer"`
+
+	Messages uint64 `json:"messages"` // messages received from this peer
+	Bytes    uint64 `json:"bytes"`    // bytes received from this peer
+
+	// Behind is the number of messages this peer has and we don't
+	Behind int64 `json:"behind"`
+
+	// Feeds lists the wanted feeds where this peer is ahead of us
+	Feeds []FeedProgress `json:"feeds,omitempty"`
+}
+
+// FeedProgress compares the local sequence of a feed to the one a peer knows about
+type FeedProgress struct {
+	Feed   refs.FeedRef `json:"feed"`
+	Local  int64        `json:"local"`
+	Remote int64        `json:"remote"`
+}
+
+// ReplicationProgresser returns the current ReplicationProgress
+type ReplicationProgresser interface {
+	ReplicationProgress() (ReplicationProgress, error)
+}
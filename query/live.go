@@ -0,0 +1,62 @@
+// SPDX-FileCopyrightText: 2021 The Go-SSB Authors
+//
+// SPDX-License-Identifier: MIT
+
+package query
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/ssbc/go-luigi"
+	refs "github.com/ssbc/go-ssb-refs"
+)
+
+// IndexWaiter is implemented by the bot, to find out when new messages can be queried
+type IndexWaiter interface {
+	// WaitUntilIndexed blocks until the indexes processed the receive log up to seq, or ctx is canceled
+	WaitUntilIndexed(ctx context.Context, seq int64) error
+}
+
+// Live calls fn with every message that is appended to the receive log after since and matches qry,
+// once the indexes processed it. It blocks until ctx is canceled or fn returns an error.
+// New messages are evaluated in batches, so only the sublogs of the added range are looked at.
+func (sp *SubsetPlaner) Live(ctx context.Context, idx IndexWaiter, since int64, qry SubsetOperation, fn func(refs.Message) error) error {
+	rxLog := sp.src.RxLog
+	if rxLog == nil {
+		return fmt.Errorf("query: live is not available")
+	}
+
+	wake := make(chan struct{}, 1)
+	cancel := rxLog.Changes().Register(luigi.FuncSink(func(ctx context.Context, v interface{}, err error) error {
+		select {
+		case wake <- struct{}{}:
+		default:
+		}
+		return nil
+	}))
+	defer cancel()
+
+	for {
+		if upto := rxLog.Seq(); upto > since {
+			if err := idx.WaitUntilIndexed(ctx, upto); err != nil {
+				return err
+			}
+
+			added, err := sp.QuerySubsetRange(qry, since, upto)
+			if err != nil {
+				return err
+			}
+			if err := ForEach(rxLog, added, false, fn); err != nil {
+				return err
+			}
+			since = upto
+		}
+
+		select {
+		case <-wake:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
@@ -3,15 +3,19 @@
 // SPDX-License-Identifier: MIT
 
 // Package query holds the first version of a generic query engine for go-ssb.
-// The Subset operations are able to combine arbitrary boolen combinations of type:xzy and author:@foo filters into one result.
+// The Subset operations are able to combine arbitrary boolen combinations of filters into one result.
+// Besides type:xzy and author:@foo these filter by tangle, mention, link, channel, time, sequence and privacy,
+// and by any field of the content with the declared indexes.
 package query
 
 import (
 	"encoding/json"
 	"fmt"
+	"time"
 
 	"github.com/ssbc/go-ssb"
 	refs "github.com/ssbc/go-ssb-refs"
+	"github.com/ssbc/go-ssb/multilogs"
 )
 
 // SubsetOptions defines additional options for the getSubset rpc call
@@ -19,6 +23,10 @@ type SubsetOptions struct {
 	Keys       bool `json:"keys"` // can't omit this falsy value, the JS-stack stack assumes true if it's not there
 	Descending bool `json:"descending,omitempty"`
 	PageLimit  int  `json:"pageLimit,omitempty"`
+
+	// Live keeps the stream open after the existing messages and sends new ones as they are indexed.
+	// The PageLimit only applies to the existing messages.
+	Live bool `json:"live,omitempty"`
 }
 
 // SubsetOperation encapsulates the recursive structure of operations for the QuerySubset*() methods
@@ -28,8 +36,21 @@ type SubsetOperation struct {
 	args   []SubsetOperation
 	string string
 	feed   *refs.FeedRef
+	msg    *refs.MessageRef
+	ref    refs.Ref
+	path   []string
+
+	// the range of time and seq, gte is inclusive and lt exclusive
+	by      string
+	gte, lt *int64
 }
 
+// The time domains of NewSubsetOpByTime
+const (
+	TimeClaimed  = "claimed"
+	TimeReceived = "received"
+)
+
 // NewSubsetOpByType returns a single operation which filters messages by type
 func NewSubsetOpByType(value string) SubsetOperation {
 	return SubsetOperation{operation: "type", string: value}
@@ -50,6 +71,78 @@ func NewSubsetOrCombination(ops ...SubsetOperation) SubsetOperation {
 	return SubsetOperation{operation: "or", args: ops}
 }
 
+// NewSubsetNot returns an operation which matches all the messages op doesn't
+func NewSubsetNot(op SubsetOperation) SubsetOperation {
+	return SubsetOperation{operation: "not", args: []SubsetOperation{op}}
+}
+
+// NewSubsetOpByRoot returns a single operation which filters messages by their root field (v1 tangles)
+func NewSubsetOpByRoot(root refs.MessageRef) SubsetOperation {
+	return SubsetOperation{operation: "root", msg: &root}
+}
+
+// NewSubsetOpByTangle returns a single operation which filters messages by their membership in a named (v2) tangle
+func NewSubsetOpByTangle(name string, root refs.MessageRef) SubsetOperation {
+	return SubsetOperation{operation: "tangle", string: name, msg: &root}
+}
+
+// NewSubsetOpByMention returns a single operation which filters public messages that mention a feed, message or blob
+func NewSubsetOpByMention(r refs.Ref) SubsetOperation {
+	return SubsetOperation{operation: "mention", ref: r}
+}
+
+// NewSubsetOpByLink returns a single operation which filters messages that link to a feed, message or blob, including private ones.
+// A non-empty rel only matches the links in that field of the content, like root, vote or mentions.
+func NewSubsetOpByLink(dest refs.Ref, rel string) SubsetOperation {
+	return SubsetOperation{operation: "link", ref: dest, string: rel}
+}
+
+// NewSubsetOpByChannel returns a single operation which filters public messages by channel
+func NewSubsetOpByChannel(name string) SubsetOperation {
+	return SubsetOperation{operation: "channel", string: multilogs.NormalizeChannel(name)}
+}
+
+// NewSubsetOpByField returns a single operation which filters public messages by a field of their content,
+// like []string{"vote", "link"} and the key of a message. It is answered by a declared index of that path.
+func NewSubsetOpByField(path []string, value string) SubsetOperation {
+	return SubsetOperation{operation: "field", path: path, string: value}
+}
+
+// NewSubsetOpByTime returns a single operation which filters messages by their claimed or received timestamp.
+// The range includes from but not to. A zero time leaves that side open.
+func NewSubsetOpByTime(by string, from, to time.Time) SubsetOperation {
+	so := SubsetOperation{operation: "time", by: by}
+	if !from.IsZero() {
+		ms := from.UnixNano() / int64(time.Millisecond)
+		so.gte = &ms
+	}
+	if !to.IsZero() {
+		ms := to.UnixNano() / int64(time.Millisecond)
+		so.lt = &ms
+	}
+	return so
+}
+
+// NewSubsetOpBySeq returns a single operation which filters the messages of an author by their sequence number.
+// The range includes from but not to. A to of 0 leaves the range open.
+func NewSubsetOpBySeq(author refs.FeedRef, from, to int64) SubsetOperation {
+	so := SubsetOperation{operation: "seq", feed: &author, gte: &from}
+	if to > 0 {
+		so.lt = &to
+	}
+	return so
+}
+
+// NewSubsetOpPrivate returns a single operation which matches the encrypted messages we can read
+func NewSubsetOpPrivate() SubsetOperation {
+	return SubsetOperation{operation: "private"}
+}
+
+// NewSubsetOpPublic returns a single operation which matches the messages that are not encrypted
+func NewSubsetOpPublic() SubsetOperation {
+	return SubsetOperation{operation: "public"}
+}
+
 // MarshalJSON turns a SubsetOperation into JSON for remote calls.
 func (so SubsetOperation) MarshalJSON() ([]byte, error) {
 	var m subsetOperationJSONMarshaler
@@ -58,6 +151,14 @@ func (so SubsetOperation) MarshalJSON() ([]byte, error) {
 	m.String = so.string
 	m.Feed = so.feed
 	m.Args = so.args
+	m.Msg = so.msg
+	if so.ref != nil {
+		m.Ref = so.ref.String()
+	}
+	m.Path = so.path
+	m.By = so.by
+	m.Gte = so.gte
+	m.Lt = so.lt
 
 	return json.Marshal(m)
 }
@@ -78,6 +179,11 @@ func (so *SubsetOperation) UnmarshalJSON(input []byte) error {
 	switch m.Operation {
 	case "and", "or":
 		so.args = m.Args
+	case "not":
+		if len(m.Args) != 1 {
+			return fmt.Errorf("subset: not needs exactly one argument, not %d", len(m.Args))
+		}
+		so.args = m.Args
 	case "type":
 		so.string = m.String
 	case "author":
@@ -88,6 +194,70 @@ func (so *SubsetOperation) UnmarshalJSON(input []byte) error {
 			return fmt.Errorf("subset: author is invalid feed format: %w", err)
 		}
 		so.feed = m.Feed
+	case "root":
+		if m.Msg == nil {
+			return fmt.Errorf("subset: root needs a message")
+		}
+		so.msg = m.Msg
+	case "tangle":
+		if m.Msg == nil || m.String == "" {
+			return fmt.Errorf("subset: tangle needs a name and a root message")
+		}
+		so.string = m.String
+		so.msg = m.Msg
+	case "mention":
+		r, err := refs.ParseRef(m.Ref)
+		if err != nil {
+			return fmt.Errorf("subset: invalid mention reference: %w", err)
+		}
+		so.ref = r
+	case "link":
+		r, err := refs.ParseRef(m.Ref)
+		if err != nil {
+			return fmt.Errorf("subset: invalid link reference: %w", err)
+		}
+		so.ref = r
+		so.string = m.String
+	case "field":
+		if len(m.Path) == 0 || len(m.Path) > maxFieldPath {
+			return fmt.Errorf("subset: field needs a path of 1 to %d names", maxFieldPath)
+		}
+		for _, name := range m.Path {
+			if name == "" {
+				return fmt.Errorf("subset: field path can't have empty names")
+			}
+		}
+		so.path = m.Path
+		so.string = m.String
+	case "channel":
+		so.string = multilogs.NormalizeChannel(m.String)
+		if so.string == "" {
+			return fmt.Errorf("subset: channel can't be empty")
+		}
+	case "time":
+		if m.By != TimeClaimed && m.By != TimeReceived {
+			return fmt.Errorf("subset: time needs to be by %q or %q, not %q", TimeClaimed, TimeReceived, m.By)
+		}
+		if m.Gte != nil && m.Lt != nil && *m.Gte >= *m.Lt {
+			return fmt.Errorf("subset: empty time range")
+		}
+		so.by = m.By
+		so.gte = m.Gte
+		so.lt = m.Lt
+	case "seq":
+		if m.Feed == nil {
+			return fmt.Errorf("subset: seq needs an author")
+		}
+		if err := ssb.IsValidFeedFormat(*m.Feed); err != nil {
+			return fmt.Errorf("subset: author is invalid feed format: %w", err)
+		}
+		if m.Gte != nil && m.Lt != nil && *m.Gte >= *m.Lt {
+			return fmt.Errorf("subset: empty seq range")
+		}
+		so.feed = m.Feed
+		so.gte = m.Gte
+		so.lt = m.Lt
+	case "private", "public":
 	default:
 		return fmt.Errorf("unhandled subset operation: %q", m.Operation)
 	}
@@ -97,6 +267,9 @@ func (so *SubsetOperation) UnmarshalJSON(input []byte) error {
 	return nil
 }
 
+// maxFieldPath is how deep into the content a field operation can look
+const maxFieldPath = 8
+
 // a helper for converting a SubsetOperation to JSON
 type subsetOperationJSONMarshaler struct {
 	Operation string `json:"op"`
@@ -104,4 +277,12 @@ type subsetOperationJSONMarshaler struct {
 	Args   []SubsetOperation `json:"args,omitempty"`
 	String string            `json:"string,omitempty"`
 	Feed   *refs.FeedRef     `json:"feed,omitempty"`
+	Msg    *refs.MessageRef  `json:"msg,omitempty"`
+	Ref    string            `json:"ref,omitempty"`
+	Path   []string          `json:"path,omitempty"`
+
+	// time ranges are in milliseconds, like the timestamps of messages
+	By  string `json:"by,omitempty"`
+	Gte *int64 `json:"gte,omitempty"`
+	Lt  *int64 `json:"lt,omitempty"`
 }
@@ -5,36 +5,130 @@
 package query
 
 import (
+	"errors"
 	"fmt"
+	"math"
+	"sort"
+	"strings"
 
 	"github.com/dgraph-io/sroar"
 	refs "github.com/ssbc/go-ssb-refs"
 
 	"github.com/ssbc/go-ssb/internal/storedrefs"
+	"github.com/ssbc/go-ssb/multilogs"
+	"github.com/ssbc/go-ssb/repo"
 	"github.com/ssbc/margaret"
 	"github.com/ssbc/margaret/indexes"
+	"github.com/ssbc/margaret/multilog"
 	"github.com/ssbc/margaret/multilog/roaring"
 )
 
+// Sources are the indexes a SubsetPlaner evaluates operations with.
+// Operations whose source is missing can't be evaluated and return an error.
+type Sources struct {
+	Authors *roaring.MultiLog // author and seq, one sublog per feed
+	ByType  *roaring.MultiLog // type
+	Tangles *roaring.MultiLog // root and tangle
+	Private *roaring.MultiLog // private and public, with the meta:box* and box*:<feed> sublogs
+	Query   *roaring.MultiLog // mention and channel, see multilogs.QueryUpdate
+
+	Backlinks *roaring.MultiLog // link, including private messages
+
+	// Declared are the indexes for field, see the declared package
+	Declared FieldIndexes
+
+	// Resolver has the timestamps and sequence numbers for time and seq
+	Resolver *repo.SequenceResolver
+
+	// RxLog is needed for not, public and time on their own, which start from all the messages
+	RxLog margaret.Log
+
+	// Self is needed for private, which are the messages that were decrypted for this feed
+	Self *refs.FeedRef
+}
+
+// FieldIndexes returns the messages whose content has value at path, up to and including the receive log entry upto.
+// If typ isn't empty, the result only needs to be right for the messages of that type.
+// It also returns the name of the index that was used. *declared.Registry implements it.
+type FieldIndexes interface {
+	LookupField(path []string, value, typ string, upto int64) (*sroar.Bitmap, string, error)
+}
+
 type SubsetPlaner struct {
-	authors, bytype *roaring.MultiLog
+	src Sources
 }
 
+// NewSubsetPlaner returns a planer that only supports author, type and their combinations
 func NewSubsetPlaner(authors, bytype *roaring.MultiLog) *SubsetPlaner {
-	return &SubsetPlaner{
-		authors: authors,
-		bytype:  bytype,
-	}
+	return NewPlaner(Sources{
+		Authors: authors,
+		ByType:  bytype,
+	})
+}
+
+// NewPlaner returns a planer that supports all the operations whose sources are set
+func NewPlaner(src Sources) *SubsetPlaner {
+	return &SubsetPlaner{src: src}
+}
+
+// Plan describes how a SubsetOperation was evaluated
+type Plan struct {
+	Op string `json:"op"`
+
+	// Detail is the argument of the operation, like the type or the author
+	Detail string `json:"detail,omitempty"`
+
+	// Strategy is how the result was computed:
+	// bitmap (loaded from a sublog), filter (checking every candidate), intersect, union,
+	// complement (all messages without the ones of the argument), subtract (a not in an and, removed from its candidates)
+	// or skipped (an and that was already empty).
+	Strategy string `json:"strategy"`
+
+	// Estimate is the upper bound of matching messages the planer worked with, Actual the number that matched.
+	// Operations are only evaluated on the candidates of their and, so Actual can be smaller than what matches on its own.
+	Estimate int `json:"estimate"`
+	Actual   int `json:"actual"`
+
+	Children []*Plan `json:"children,omitempty"`
 }
 
 // QuerySubsetBitmap evaluates the passed SubsetOperation and returns a bitmap which maps to messages in the receive log.
 func (sp *SubsetPlaner) QuerySubsetBitmap(qry SubsetOperation) (*sroar.Bitmap, error) {
-	return combineBitmaps(sp, qry)
+	return sp.QuerySubsetRange(qry, margaret.SeqEmpty, -1)
+}
+
+// QuerySubsetRange evaluates qry for the receive log entries after since, up to and including upto.
+// An upto of -1 is the end of the log.
+func (sp *SubsetPlaner) QuerySubsetRange(qry SubsetOperation, since, upto int64) (*sroar.Bitmap, error) {
+	ev := evaluation{sp: sp, since: since, upto: upto}
+	n, err := ev.prepare(qry)
+	if err != nil {
+		return nil, err
+	}
+	res, err := ev.exec(n)
+	if err != nil {
+		return nil, err
+	}
+	ev.restrict(res)
+	return res, nil
+}
+
+// Explain evaluates the passed SubsetOperation and returns how it was done
+func (sp *SubsetPlaner) Explain(qry SubsetOperation) (*Plan, error) {
+	ev := evaluation{sp: sp, since: margaret.SeqEmpty, upto: -1}
+	n, err := ev.prepare(qry)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := ev.exec(n); err != nil {
+		return nil, err
+	}
+	return n.plan, nil
 }
 
 // QuerySubsetMessages evaluates the passed SubsetOperation and returns a slice of messages
 func (sp *SubsetPlaner) QuerySubsetMessages(rxLog margaret.Log, qry SubsetOperation) ([]refs.Message, error) {
-	resulting, err := combineBitmaps(sp, qry)
+	resulting, err := sp.QuerySubsetBitmap(qry)
 	if err != nil {
 		return nil, err
 	}
@@ -43,69 +137,517 @@ func (sp *SubsetPlaner) QuerySubsetMessages(rxLog margaret.Log, qry SubsetOperat
 		return nil, nil
 	}
 
-	// iterate over the combined set of bitmaps
-	it := resulting.NewIterator()
-
 	var msgs []refs.Message
+	err = ForEach(rxLog, resulting, false, func(msg refs.Message) error {
+		msgs = append(msgs, msg)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return msgs, nil
+}
+
+// ForEach calls fn for the messages of resulting, in the order of the receive log or the reverse if desc is set.
+// Unlike QuerySubsetMessages it doesn't keep them around, which makes it the better choice for large results.
+// Messages that were nulled in the meantime are skipped. If fn returns an error, ForEach stops and returns it.
+func ForEach(rxLog margaret.Log, resulting *sroar.Bitmap, desc bool, fn func(refs.Message) error) error {
+	it := resulting.NewIterator()
+	n := resulting.GetCardinality()
+	for i := 0; i < n; i++ {
+		var v uint64
+		if desc {
+			// Select picks by position, which goes backwards without copying the bitmap
+			var err error
+			if v, err = resulting.Select(uint64(n - 1 - i)); err != nil {
+				return err
+			}
+		} else {
+			v = it.Next()
+		}
 
-	for i := 0; i < resulting.GetCardinality(); i++ {
-		v := int64(it.Next())
-		msgv, err := rxLog.Get(v)
+		msgv, err := rxLog.Get(int64(v))
 		if err != nil {
-			return nil, err
+			if margaret.IsErrNulled(err) {
+				continue
+			}
+			return err
+		}
+
+		if err, ok := msgv.(error); ok {
+			if margaret.IsErrNulled(err) {
+				continue
+			}
+			return err
 		}
 
 		msg, ok := msgv.(refs.Message)
 		if !ok {
-			return nil, fmt.Errorf("invalid msg type %T", msgv)
+			return fmt.Errorf("invalid msg type %T", msgv)
 		}
 
-		msgs = append(msgs, msg)
+		if err := fn(msg); err != nil {
+			return err
+		}
 	}
+	return nil
+}
 
-	return msgs, nil
+// evaluation holds the state of a single query
+type evaluation struct {
+	sp *SubsetPlaner
+
+	// the range of the receive log that is queried, see QuerySubsetRange
+	since, upto int64
+
+	// all the messages of the range, loaded on first use
+	universe *sroar.Bitmap
+
+	// typeHint is the type of an enclosing and, which lets field use an index of that type
+	typeHint string
 }
 
-func combineBitmaps(sp *SubsetPlaner, qry SubsetOperation) (*sroar.Bitmap, error) {
+// restrict removes everything outside of the range of the evaluation from bmap
+func (ev *evaluation) restrict(bmap *sroar.Bitmap) {
+	if ev.since >= 0 {
+		bmap.RemoveRange(0, uint64(ev.since)+1)
+	}
+	if ev.upto >= 0 {
+		bmap.RemoveRange(uint64(ev.upto)+1, math.MaxUint64)
+	}
+}
+
+// planNode is an operation with the data the planer needs to evaluate it
+type planNode struct {
+	op   SubsetOperation
+	plan *Plan
+
+	// bmap is set for operations that are backed by sublogs, filter for time and seq
+	bmap   *sroar.Bitmap
+	filter func(candidates *sroar.Bitmap) (*sroar.Bitmap, error)
+
+	children []*planNode
+}
+
+// prepare loads the sublogs of qry and estimates the size of every operation
+func (ev *evaluation) prepare(qry SubsetOperation) (*planNode, error) {
+	src := ev.sp.src
+	n := &planNode{
+		op:   qry,
+		plan: &Plan{Op: qry.operation, Strategy: "bitmap"},
+	}
+
+	var err error
 	switch qry.operation {
 
 	case "author":
-		return sp.authors.LoadInternalBitmap(storedrefs.Feed(*qry.feed))
+		n.plan.Detail = qry.feed.String()
+		n.bmap, err = ev.load("author", src.Authors, storedrefs.Feed(*qry.feed))
 
 	case "type":
-		return sp.bytype.LoadInternalBitmap(indexes.Addr("string:" + qry.string))
+		n.plan.Detail = qry.string
+		n.bmap, err = ev.load("type", src.ByType, indexes.Addr("string:"+qry.string))
+
+	case "root":
+		n.plan.Detail = qry.msg.String()
+		n.bmap, err = ev.load("root", src.Tangles, storedrefs.TangleV1(*qry.msg))
+
+	case "tangle":
+		n.plan.Detail = qry.string + ":" + qry.msg.String()
+		n.bmap, err = ev.load("tangle", src.Tangles, storedrefs.TangleV2(qry.string, *qry.msg))
 
-	case "or", "and":
-		if len(qry.args) == 0 {
-			return nil, nil
+	case "mention":
+		n.plan.Detail = qry.ref.String()
+		n.bmap, err = ev.load("mention", src.Query, multilogs.MentionAddr(qry.ref))
+
+	case "link":
+		addr := multilogs.BacklinkAddr(qry.ref)
+		n.plan.Detail = qry.ref.String()
+		if qry.string != "" {
+			addr = multilogs.BacklinkRelAddr(qry.string, qry.ref)
+			n.plan.Detail = qry.string + ":" + n.plan.Detail
 		}
+		n.bmap, err = ev.load("link", src.Backlinks, addr)
+
+	case "channel":
+		n.plan.Detail = "#" + qry.string
+		n.bmap, err = ev.load("channel", src.Query, multilogs.ChannelAddr(qry.string))
 
-		// run the first operation and use it's result as the workBitmap the rest will be applied to
-		workBitmap, err := combineBitmaps(sp, qry.args[0])
+	case "field":
+		if src.Declared == nil {
+			return nil, fmt.Errorf("query: field is not available")
+		}
+		var name string
+		n.bmap, name, err = src.Declared.LookupField(qry.path, qry.string, ev.typeHint, ev.upto)
 		if err != nil {
-			return nil, fmt.Errorf("boolean (%s) operation %d of %d failed: %w", qry.operation, 1, len(qry.args), err)
+			err = fmt.Errorf("query: failed to look up field: %w", err)
+			break
 		}
+		ev.restrict(n.bmap)
+		n.plan.Detail = fmt.Sprintf("%s=%s (%s)", strings.Join(qry.path, "."), qry.string, name)
 
-		// choose the boolean operation that all arguments will use
-		boolOp := workBitmap.Or
+	case "private":
+		if src.Self == nil {
+			return nil, fmt.Errorf("query: private is not available")
+		}
+		n.plan.Detail = src.Self.String()
+		n.bmap, err = ev.loadAny("private", src.Private,
+			indexes.Addr("box1:")+storedrefs.Feed(*src.Self),
+			indexes.Addr("box2:")+storedrefs.Feed(*src.Self),
+		)
+
+	case "public":
+		var boxed *sroar.Bitmap
+		boxed, err = ev.loadAny("public", src.Private, indexes.Addr("meta:box1"), indexes.Addr("meta:box2"))
+		if err != nil {
+			break
+		}
+		n.bmap, err = ev.all("public")
+		if err != nil {
+			break
+		}
+		n.bmap = n.bmap.Clone()
+		n.bmap.AndNot(boxed)
+
+	case "time":
+		if src.Resolver == nil {
+			return nil, fmt.Errorf("query: time is not available")
+		}
+		n.plan.Strategy = "filter"
+		n.plan.Detail = rangeDetail(qry.by, qry.gte, qry.lt)
+		var all *sroar.Bitmap
+		if all, err = ev.all("time"); err != nil {
+			break
+		}
+		n.plan.Estimate = all.GetCardinality()
+		domain := repo.SortByClaimed
+		if qry.by == TimeReceived {
+			domain = repo.SortByReceived
+		}
+		// the resolver has the timestamps to the second
+		n.filter = ev.resolve(domain, func(v int64) bool {
+			return inRange(v*1000, qry.gte, qry.lt)
+		})
+
+	case "seq":
+		if src.Resolver == nil {
+			return nil, fmt.Errorf("query: seq is not available")
+		}
+		n.plan.Strategy = "filter"
+		n.plan.Detail = rangeDetail(qry.feed.String(), qry.gte, qry.lt)
+		n.bmap, err = ev.load("seq", src.Authors, storedrefs.Feed(*qry.feed))
+		n.filter = ev.resolve(repo.SortByFeedSeq, func(v int64) bool {
+			return inRange(v, qry.gte, qry.lt)
+		})
+
+	case "not", "and", "or":
 		if qry.operation == "and" {
-			boolOp = workBitmap.And
+			// the result is intersected with the type anyway, including the nested operations
+			outer := ev.typeHint
+			for _, op := range qry.args {
+				if op.operation == "type" {
+					ev.typeHint = op.string
+					break
+				}
+			}
+			defer func() { ev.typeHint = outer }()
+		}
+		for i, op := range qry.args {
+			child, err := ev.prepare(op)
+			if err != nil {
+				return nil, fmt.Errorf("boolean (%s) operation %d of %d failed: %w", qry.operation, i+1, len(qry.args), err)
+			}
+			n.children = append(n.children, child)
+			n.plan.Children = append(n.plan.Children, child.plan)
 		}
+		err = ev.estimate(n)
+
+	default:
+		return nil, fmt.Errorf("sbot: invalid subset query: %s", qry.operation)
+	}
+	if err != nil {
+		return nil, err
+	}
 
-		for i, op := range qry.args[1:] {
+	if n.bmap != nil {
+		n.plan.Estimate = n.bmap.GetCardinality()
+	}
+	return n, nil
+}
 
-			// get the bitmap for the current operation
-			opsBitmap, err := combineBitmaps(sp, op)
+// estimate sets the strategy and estimate of a boolean operation from its prepared children
+func (ev *evaluation) estimate(n *planNode) error {
+	switch n.op.operation {
+	case "not":
+		all, err := ev.all("not")
+		if err != nil {
+			return err
+		}
+		n.plan.Strategy = "complement"
+		n.plan.Estimate = all.GetCardinality() - n.children[0].plan.Estimate
+		if n.plan.Estimate < 0 {
+			n.plan.Estimate = 0
+		}
+
+	case "and":
+		n.plan.Strategy = "intersect"
+		n.plan.Estimate = -1
+		for _, c := range n.children {
+			if c.op.operation == "not" {
+				continue
+			}
+			if n.plan.Estimate < 0 || c.plan.Estimate < n.plan.Estimate {
+				n.plan.Estimate = c.plan.Estimate
+			}
+		}
+		if n.plan.Estimate < 0 {
+			// only nots, which start from all the messages
+			n.plan.Estimate = 0
+			if len(n.children) > 0 {
+				all, err := ev.all("and")
+				if err != nil {
+					return err
+				}
+				n.plan.Estimate = all.GetCardinality()
+			}
+		}
+
+	case "or":
+		n.plan.Strategy = "union"
+		for _, c := range n.children {
+			n.plan.Estimate += c.plan.Estimate
+		}
+		if ev.universe != nil && n.plan.Estimate > ev.universe.GetCardinality() {
+			n.plan.Estimate = ev.universe.GetCardinality()
+		}
+	}
+	return nil
+}
+
+// exec evaluates a prepared operation
+func (ev *evaluation) exec(n *planNode) (*sroar.Bitmap, error) {
+	var (
+		res *sroar.Bitmap
+		err error
+	)
+	switch n.op.operation {
+	case "not":
+		var all, sub *sroar.Bitmap
+		if all, err = ev.all("not"); err != nil {
+			return nil, err
+		}
+		if sub, err = ev.exec(n.children[0]); err != nil {
+			return nil, err
+		}
+		res = all.Clone()
+		res.AndNot(sub)
+
+	case "and":
+		res, err = ev.execAnd(n)
+
+	case "or":
+		var bmaps []*sroar.Bitmap
+		for i, c := range n.children {
+			bmap, err := ev.exec(c)
 			if err != nil {
-				return nil, fmt.Errorf("boolean (%s) operation %d of %d failed: %w", qry.operation, i+1, len(qry.args)-1, err)
+				return nil, fmt.Errorf("boolean (or) operation %d of %d failed: %w", i+1, len(n.children), err)
 			}
+			bmaps = append(bmaps, bmap)
+		}
+		res = sroar.FastOr(bmaps...)
 
-			// apply the result to the workBitmap
-			boolOp(opsBitmap)
+	case "time":
+		var all *sroar.Bitmap
+		if all, err = ev.all("time"); err != nil {
+			return nil, err
 		}
-		return workBitmap, nil
+		res, err = n.filter(all)
 
 	default:
-		return nil, fmt.Errorf("sbot: invalid subset query: %s", qry.operation)
+		if n.filter != nil {
+			res, err = n.filter(n.bmap)
+		} else {
+			res = n.bmap.Clone()
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	n.plan.Actual = res.GetCardinality()
+	return res, nil
+}
+
+// execAnd intersects the operations that are backed by sublogs, smallest first, stopping as soon as nothing is left.
+// Time ranges then filter the remaining candidates and the arguments of nots are subtracted from them,
+// so that neither needs to look at all the messages.
+func (ev *evaluation) execAnd(n *planNode) (*sroar.Bitmap, error) {
+	if len(n.children) == 0 {
+		return sroar.NewBitmap(), nil
+	}
+
+	var positive, filters, negative []*planNode
+	for _, c := range n.children {
+		switch c.op.operation {
+		case "not":
+			negative = append(negative, c)
+		case "time":
+			filters = append(filters, c)
+		default:
+			positive = append(positive, c)
+		}
+	}
+	sort.SliceStable(positive, func(i, j int) bool {
+		return positive[i].plan.Estimate < positive[j].plan.Estimate
+	})
+
+	var res *sroar.Bitmap
+	if len(positive) == 0 {
+		all, err := ev.all("and")
+		if err != nil {
+			return nil, err
+		}
+		res = all.Clone()
+	}
+
+	for i, c := range positive {
+		if res != nil && res.IsEmpty() {
+			c.plan.Strategy = "skipped"
+			continue
+		}
+
+		bmap, err := ev.exec(c)
+		if err != nil {
+			return nil, fmt.Errorf("boolean (and) operation %d of %d failed: %w", i+1, len(n.children), err)
+		}
+		if res == nil {
+			res = bmap
+		} else {
+			res.And(bmap)
+		}
+	}
+
+	for _, c := range filters {
+		if res.IsEmpty() {
+			c.plan.Strategy = "skipped"
+			continue
+		}
+
+		var err error
+		res, err = c.filter(res)
+		if err != nil {
+			return nil, err
+		}
+		c.plan.Actual = res.GetCardinality()
+	}
+
+	for _, c := range negative {
+		if res.IsEmpty() {
+			c.plan.Strategy = "skipped"
+			continue
+		}
+
+		sub, err := ev.exec(c.children[0])
+		if err != nil {
+			return nil, err
+		}
+		c.plan.Strategy = "subtract"
+		c.plan.Actual = sub.GetCardinality()
+		res.AndNot(sub)
+	}
+
+	return res, nil
+}
+
+// load returns the sublog addr of mlog, which is empty if it doesn't exist yet
+func (ev *evaluation) load(op string, mlog *roaring.MultiLog, addr indexes.Addr) (*sroar.Bitmap, error) {
+	if mlog == nil {
+		return nil, fmt.Errorf("query: %s is not available", op)
+	}
+
+	bmap, err := mlog.LoadInternalBitmap(addr)
+	if err != nil {
+		if errors.Is(err, multilog.ErrSublogNotFound) {
+			return sroar.NewBitmap(), nil
+		}
+		return nil, fmt.Errorf("query: failed to load sublog for %s: %w", op, err)
+	}
+	ev.restrict(bmap)
+	return bmap, nil
+}
+
+// loadAny returns the union of the sublogs addrs of mlog
+func (ev *evaluation) loadAny(op string, mlog *roaring.MultiLog, addrs ...indexes.Addr) (*sroar.Bitmap, error) {
+	var bmaps []*sroar.Bitmap
+	for _, addr := range addrs {
+		bmap, err := ev.load(op, mlog, addr)
+		if err != nil {
+			return nil, err
+		}
+		bmaps = append(bmaps, bmap)
+	}
+	return sroar.FastOr(bmaps...), nil
+}
+
+// all returns the bitmap of every message in the receive log. It must not be changed.
+func (ev *evaluation) all(op string) (*sroar.Bitmap, error) {
+	if ev.universe != nil {
+		return ev.universe, nil
+	}
+
+	rxLog := ev.sp.src.RxLog
+	if rxLog == nil {
+		return nil, fmt.Errorf("query: %s is not available", op)
+	}
+
+	upto := ev.upto
+	if upto < 0 {
+		upto = rxLog.Seq()
+	}
+
+	var seqs []uint64
+	for seq := ev.since + 1; seq <= upto; seq++ {
+		seqs = append(seqs, uint64(seq))
+	}
+	ev.universe = sroar.FromSortedList(seqs)
+	return ev.universe, nil
+}
+
+// resolve returns a filter that keeps the candidates whose value in domain is ok
+func (ev *evaluation) resolve(domain repo.SortDomain, ok repo.ResolverFilter) func(*sroar.Bitmap) (*sroar.Bitmap, error) {
+	return func(candidates *sroar.Bitmap) (*sroar.Bitmap, error) {
+		sorted, err := ev.sp.src.Resolver.SortAndFilterBitmap(candidates, domain, ok, false)
+		if err != nil {
+			return nil, fmt.Errorf("query: failed to filter by range: %w", err)
+		}
+
+		seqs := make([]uint64, len(sorted))
+		for i, s := range sorted {
+			seqs[i] = uint64(s.Seq)
+		}
+		sort.Slice(seqs, func(i, j int) bool { return seqs[i] < seqs[j] })
+		return sroar.FromSortedList(seqs), nil
+	}
+}
+
+// inRange checks gte <= v < lt, where nil leaves that side open
+func inRange(v int64, gte, lt *int64) bool {
+	if gte != nil && v < *gte {
+		return false
+	}
+	if lt != nil && v >= *lt {
+		return false
+	}
+	return true
+}
+
+func rangeDetail(what string, gte, lt *int64) string {
+	from, to := "", ""
+	if gte != nil {
+		from = fmt.Sprint(*gte)
+	}
+	if lt != nil {
+		to = fmt.Sprint(*lt)
 	}
+	return fmt.Sprintf("%s [%s, %s)", what, from, to)
 }
@@ -6,11 +6,13 @@ package query_test
 
 import (
 	"bytes"
+	"context"
 	"crypto/rand"
 	"encoding/json"
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -30,6 +32,11 @@ func TestSubsetQuerySerializing(t *testing.T) {
 		t.Fatal(err)
 	}
 
+	testMsg, err := refs.NewMessageRefFromBytes(bytes.Repeat([]byte{2}, 32), refs.RefAlgoMessageSSB1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
 	cases := []tcaseSerialized{
 		{
 			name:      "simple type",
@@ -73,6 +80,96 @@ func TestSubsetQuerySerializing(t *testing.T) {
 			jsonInput: `{"op":"and","args":[{"op":"author","feed":"@AQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQE=.ed25519"},{"op":"or","args":[{"op":"type","string":"foo"},{"op":"type","string":"bar"}]}]}`,
 		},
 
+		{
+			name:      "not",
+			query:     query.NewSubsetNot(query.NewSubsetOpByType("foo")),
+			jsonInput: `{"op":"not","args":[{"op":"type","string":"foo"}]}`,
+		},
+
+		{
+			name:      "root",
+			query:     query.NewSubsetOpByRoot(testMsg),
+			jsonInput: `{"op":"root","msg":"%AgICAgICAgICAgICAgICAgICAgICAgICAgICAgICAgI=.sha256"}`,
+		},
+
+		{
+			name:      "tangle",
+			query:     query.NewSubsetOpByTangle("group", testMsg),
+			jsonInput: `{"op":"tangle","string":"group","msg":"%AgICAgICAgICAgICAgICAgICAgICAgICAgICAgICAgI=.sha256"}`,
+		},
+
+		{
+			name:      "mention",
+			query:     query.NewSubsetOpByMention(testRef),
+			jsonInput: `{"op":"mention","ref":"@AQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQE=.ed25519"}`,
+		},
+
+		{
+			name:      "link",
+			query:     query.NewSubsetOpByLink(testRef, "vote"),
+			jsonInput: `{"op":"link","string":"vote","ref":"@AQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQE=.ed25519"}`,
+		},
+
+		{
+			name:      "field",
+			query:     query.NewSubsetOpByField([]string{"vote", "link"}, "%x"),
+			jsonInput: `{"op":"field","string":"%x","path":["vote","link"]}`,
+		},
+
+		{
+			name:      "channel",
+			query:     query.NewSubsetOpByChannel("#Go"),
+			jsonInput: `{"op":"channel","string":"go"}`,
+		},
+
+		{
+			name:      "time",
+			query:     query.NewSubsetOpByTime(query.TimeReceived, time.Unix(1, 0), time.Unix(2, 0)),
+			jsonInput: `{"op":"time","by":"received","gte":1000,"lt":2000}`,
+		},
+
+		{
+			name:      "seq",
+			query:     query.NewSubsetOpBySeq(testRef, 3, 0),
+			jsonInput: `{"op":"seq","feed":"@AQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQE=.ed25519","gte":3}`,
+		},
+
+		{
+			name:      "private and public",
+			query:     query.NewSubsetOrCombination(query.NewSubsetOpPrivate(), query.NewSubsetOpPublic()),
+			jsonInput: `{"op":"or","args":[{"op":"private"},{"op":"public"}]}`,
+		},
+
+		{
+			name:      "not with two arguments",
+			jsonInput: `{"op":"not","args":[{"op":"private"},{"op":"public"}]}`,
+			invalid:   true,
+		},
+
+		{
+			name:      "invalid mention",
+			jsonInput: `{"op":"mention","ref":"over 9000"}`,
+			invalid:   true,
+		},
+
+		{
+			name:      "field without path",
+			jsonInput: `{"op":"field","string":"foo"}`,
+			invalid:   true,
+		},
+
+		{
+			name:      "invalid time domain",
+			jsonInput: `{"op":"time","by":"feelings","gte":1000}`,
+			invalid:   true,
+		},
+
+		{
+			name:      "empty seq range",
+			jsonInput: `{"op":"seq","feed":"@AQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQE=.ed25519","gte":3,"lt":3}`,
+			invalid:   true,
+		},
+
 		{
 			name:      "invalid operation",
 			jsonInput: `{"op":"stuff","times":"over 9000"}`,
@@ -230,6 +327,179 @@ func TestSubsetQueryPlanExecution(t *testing.T) {
 		r.Equal(testRefs[6], res[2])
 	})
 
+	// a post in a channel and a reply to it
+	ref, err := mainbot.PublishAs("arny", map[string]interface{}{
+		"type":     "post",
+		"text":     "hello #SSB, @cloe",
+		"channel":  "SSB",
+		"mentions": []refs.Mention{refs.NewMention(kpCloe.ID(), "cloe")},
+	})
+	r.NoError(err)
+	testRefs = append(testRefs, ref)
+
+	root := ref.Key()
+	reply := refs.NewPost("hi arny!")
+	reply.Root = &root
+	ref, err = mainbot.PublishAs("bert", reply)
+	r.NoError(err)
+	testRefs = append(testRefs, ref)
+
+	mainbot.WaitUntilIndexesAreSynced()
+
+	self := kpArny.ID()
+	all := query.NewPlaner(query.Sources{
+		Authors:   mainbot.Users,
+		ByType:    mainbot.ByType,
+		Tangles:   mainbot.Tangles,
+		Private:   mainbot.Private,
+		Query:     mainbot.Query,
+		Backlinks: mainbot.Backlinks,
+		Declared:  mainbot.Declared,
+		Resolver:  mainbot.SeqResolver,
+		RxLog:     mainbot.ReceiveLog,
+		Self:      &self,
+	})
+
+	queryCases := []struct {
+		name string
+		qry  query.SubsetOperation
+		want []int
+	}{
+		{"not about", query.NewSubsetNot(query.NewSubsetOpByType("about")), []int{1, 3, 6, 8, 9}},
+		{"arny without abouts", query.NewSubsetAndCombination(
+			query.NewSubsetOpByAuthor(kpArny.ID()),
+			query.NewSubsetNot(query.NewSubsetOpByType("about")),
+		), []int{1, 8}},
+		{"root", query.NewSubsetOpByRoot(testRefs[8].Key()), []int{9}},
+		{"mention", query.NewSubsetOpByMention(kpCloe.ID()), []int{8}},
+		{"link", query.NewSubsetOpByLink(kpCloe.ID(), ""), []int{4, 7, 8}},
+		{"link by rel", query.NewSubsetOpByLink(kpCloe.ID(), "mentions"), []int{8}},
+		{"link to a message", query.NewSubsetOpByLink(testRefs[8].Key(), "root"), []int{9}},
+		{"channel", query.NewSubsetOpByChannel("#ssb"), []int{8}},
+		{"field", query.NewSubsetOpByField([]string{"about"}, kpCloe.ID().String()), []int{4, 7}},
+		{"field of a type", query.NewSubsetAndCombination(
+			query.NewSubsetOpByType("post"),
+			query.NewSubsetOpByField([]string{"channel"}, "SSB"),
+		), []int{8}},
+		{"unknown channel", query.NewSubsetOpByChannel("go"), nil},
+		{"seq", query.NewSubsetOpBySeq(kpCloe.ID(), 2, 0), []int{6, 7}},
+		{"seq range", query.NewSubsetOpBySeq(kpCloe.ID(), 2, 3), []int{6}},
+		{"time", query.NewSubsetAndCombination(
+			query.NewSubsetOpByType("post"),
+			query.NewSubsetOpByTime(query.TimeClaimed, time.Now().Add(-time.Hour), time.Time{}),
+		), []int{6, 8, 9}},
+		{"future", query.NewSubsetOpByTime(query.TimeReceived, time.Now().Add(time.Hour), time.Time{}), nil},
+		{"public", query.NewSubsetAndCombination(query.NewSubsetOpPublic(), query.NewSubsetOpByAuthor(kpBert.ID())), []int{2, 3, 4, 9}},
+		{"private", query.NewSubsetOpPrivate(), nil},
+	}
+	for _, tc := range queryCases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := require.New(t)
+
+			msgs, err := all.QuerySubsetMessages(mainbot.ReceiveLog, tc.qry)
+			r.NoError(err)
+			r.Len(msgs, len(tc.want), "wrong number of resulting messages")
+			for i, idx := range tc.want {
+				r.Equal(testRefs[idx].Key(), msgs[i].Key(), "wrong message %d", i)
+			}
+		})
+	}
+
+	t.Run("unavailable operations", func(t *testing.T) {
+		r := require.New(t)
+
+		_, err := sp.QuerySubsetBitmap(query.NewSubsetOpByChannel("ssb"))
+		r.Error(err)
+
+		_, err = sp.QuerySubsetBitmap(query.NewSubsetNot(query.NewSubsetOpByType("about")))
+		r.Error(err)
+	})
+
+	t.Run("explain", func(t *testing.T) {
+		r := require.New(t)
+
+		plan, err := all.Explain(query.NewSubsetAndCombination(
+			query.NewSubsetOpByType("post"),
+			query.NewSubsetOpByAuthor(kpCloe.ID()),
+			query.NewSubsetNot(query.NewSubsetOpByChannel("ssb")),
+		))
+		r.NoError(err)
+		r.Equal("intersect", plan.Strategy)
+		r.Equal(1, plan.Actual)
+		r.Equal(3, plan.Estimate, "the smallest argument")
+		r.Len(plan.Children, 3)
+		r.Equal("subtract", plan.Children[2].Strategy)
+
+		// an empty sublog ends the and early
+		plan, err = all.Explain(query.NewSubsetAndCombination(
+			query.NewSubsetOpByAuthor(kpCloe.ID()),
+			query.NewSubsetOpByType("nope"),
+		))
+		r.NoError(err)
+		r.Equal(0, plan.Actual)
+		r.Equal("skipped", plan.Children[0].Strategy)
+		r.Equal("bitmap", plan.Children[1].Strategy)
+
+		// fields use a declared index, which is made on the first use
+		plan, err = all.Explain(query.NewSubsetOpByField([]string{"about"}, kpArny.ID().String()))
+		r.NoError(err)
+		r.Equal(1, plan.Actual)
+		r.Contains(plan.Detail, "(auto-")
+	})
+
+	t.Run("descending", func(t *testing.T) {
+		r := require.New(t)
+
+		bmap, err := all.QuerySubsetBitmap(query.NewSubsetOpByType("post"))
+		r.NoError(err)
+
+		var keys []refs.MessageRef
+		err = query.ForEach(mainbot.ReceiveLog, bmap, true, func(msg refs.Message) error {
+			keys = append(keys, msg.Key())
+			return nil
+		})
+		r.NoError(err)
+		r.Equal([]refs.MessageRef{testRefs[9].Key(), testRefs[8].Key(), testRefs[6].Key()}, keys)
+	})
+
+	t.Run("live", func(t *testing.T) {
+		r := require.New(t)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		live := make(chan refs.Message)
+		done := make(chan error, 1)
+		go func() {
+			done <- all.Live(ctx, mainbot, mainbot.ReceiveLog.Seq(), query.NewSubsetOpByChannel("ssb"), func(msg refs.Message) error {
+				live <- msg
+				return nil
+			})
+		}()
+
+		for i := 0; i < 3; i++ {
+			_, err := mainbot.PublishAs("cloe", refs.NewPost("no channel"))
+			r.NoError(err)
+
+			ref, err := mainbot.PublishAs("bert", map[string]interface{}{
+				"type":    "post",
+				"text":    "more",
+				"channel": "ssb",
+			})
+			r.NoError(err)
+
+			select {
+			case msg := <-live:
+				r.Equal(ref.Key(), msg.Key())
+			case <-time.After(10 * time.Second):
+				r.FailNow("timeout waiting for live message")
+			}
+		}
+
+		cancel()
+		r.ErrorIs(<-done, context.Canceled)
+	})
+
 	// shutdown bot
 	mainbot.Shutdown()
 	r.NoError(mainbot.Close())
@@ -0,0 +1,243 @@
+// SPDX-FileCopyrightText: 2021 The Go-SSB Authors
+//
+// SPDX-License-Identifier: MIT
+
+package repo
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/crypto/nacl/secretbox"
+
+	"github.com/ssbc/go-ssb/internal/keywrap"
+)
+
+/* An encrypted repo has a random data key, which encrypts the receive log, the other logs of messages and the shared badger db.
+The data key is kept in EncryptionFile, encrypted with a key derived from a secret: a passphrase or the contents of a keyfile.
+Changing the secret only encrypts the data key again, the data stays as it is.
+*/
+
+// EncryptionFile is the file in an encrypted repo with its encrypted data key
+const EncryptionFile = "encryption.json"
+
+// encryptionVersion is the version of EncryptionFile that is written
+const encryptionVersion = 1
+
+var (
+	// ErrRepoLocked is returned when an encrypted repo is opened without a secret
+	ErrRepoLocked = errors.New("repo: the repo is encrypted, it needs its passphrase or keyfile")
+
+	// ErrWrongSecret is returned if the secret doesn't decrypt the data key of the repo
+	ErrWrongSecret = errors.New("repo: wrong passphrase or keyfile")
+
+	// ErrNotEncrypted is returned by UnlockEncryption and RotateEncryption for a repo without EncryptionFile
+	ErrNotEncrypted = errors.New("repo: the repo is not encrypted")
+)
+
+// DataKey is the key the data of an encrypted repo is encrypted with
+type DataKey [32]byte
+
+// encryptionInfo is the content of EncryptionFile
+type encryptionInfo struct {
+	Version int `json:"version"`
+
+	keywrap.Params
+
+	// Key is the data key, sealed with the key derived from the secret
+	Key []byte `json:"key"`
+}
+
+// IsEncrypted returns true if the repo has EncryptionFile
+func IsEncrypted(r Interface) (bool, error) {
+	_, err := os.Stat(r.GetPath(EncryptionFile))
+	if err == nil {
+		return true, nil
+	} else if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	return false, fmt.Errorf("repo: failed to check for encryption: %w", err)
+}
+
+// InitEncryption creates a data key for the repo, which is encrypted with secret.
+// The repo must not have a receive log or indexes yet, they would stay unencrypted.
+func InitEncryption(r Interface, secret []byte) (*DataKey, error) {
+	if len(secret) == 0 {
+		return nil, fmt.Errorf("repo: the secret is empty")
+	}
+	if enc, err := IsEncrypted(r); err != nil {
+		return nil, err
+	} else if enc {
+		return nil, fmt.Errorf("repo: the repo is already encrypted")
+	}
+
+	for _, rel := range [][]string{{"log"}, {PrefixMultiLog, "shared-badger"}} {
+		if _, err := os.Stat(r.GetPath(rel...)); err == nil {
+			return nil, fmt.Errorf("repo: can't encrypt a repo that already has data in %s", filepath.Join(rel...))
+		}
+	}
+
+	var key DataKey
+	if _, err := io.ReadFull(rand.Reader, key[:]); err != nil {
+		return nil, fmt.Errorf("repo: failed to create a data key: %w", err)
+	}
+	if err := writeEncryptionInfo(r, &key, secret); err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
+
+// UnlockEncryption returns the data key of the repo, decrypted with secret
+func UnlockEncryption(r Interface, secret []byte) (*DataKey, error) {
+	data, err := os.ReadFile(r.GetPath(EncryptionFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotEncrypted
+	} else if err != nil {
+		return nil, fmt.Errorf("repo: failed to read the data key: %w", err)
+	}
+
+	var info encryptionInfo
+	if err := json.Unmarshal(data, &info); err != nil {
+		return nil, fmt.Errorf("repo: invalid %s: %w", EncryptionFile, err)
+	}
+	if info.Version != encryptionVersion {
+		return nil, fmt.Errorf("repo: unsupported encryption version %d", info.Version)
+	}
+
+	plain, err := info.Open(secret, info.Key)
+	if errors.Is(err, keywrap.ErrWrongSecret) {
+		return nil, ErrWrongSecret
+	} else if err != nil {
+		return nil, fmt.Errorf("repo: failed to decrypt the data key: %w", err)
+	}
+	if len(plain) != len(DataKey{}) {
+		return nil, fmt.Errorf("repo: the data key has %d bytes", len(plain))
+	}
+
+	var key DataKey
+	copy(key[:], plain)
+	return &key, nil
+}
+
+// RotateEncryption encrypts the data key of the repo with newSecret instead of oldSecret
+func RotateEncryption(r Interface, oldSecret, newSecret []byte) error {
+	if len(newSecret) == 0 {
+		return fmt.Errorf("repo: the new secret is empty")
+	}
+	key, err := UnlockEncryption(r, oldSecret)
+	if err != nil {
+		return err
+	}
+	return writeEncryptionInfo(r, key, newSecret)
+}
+
+// writeEncryptionInfo seals key with a new salt and replaces EncryptionFile with it
+func writeEncryptionInfo(r Interface, key *DataKey, secret []byte) error {
+	params, err := keywrap.NewParams()
+	if err != nil {
+		return err
+	}
+	info := encryptionInfo{
+		Version: encryptionVersion,
+		Params:  params,
+	}
+	info.Key, err = params.Seal(secret, key[:])
+	if err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(info, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	path := r.GetPath(EncryptionFile)
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0600); err != nil {
+		return fmt.Errorf("repo: failed to write the data key: %w", err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("repo: failed to replace the data key: %w", err)
+	}
+	return nil
+}
+
+const (
+	nonceLen = 24
+
+	// sealedOverhead is how much larger a sealed frame is than its plaintext
+	sealedOverhead = 4 + nonceLen + secretbox.Overhead
+)
+
+// Seal encrypts plain with a random nonce.
+// The frame starts with its length, so that it can be read back if it is padded with zeros, like by the Replace of a log.
+func (k *DataKey) Seal(plain []byte) ([]byte, error) {
+	var nonce [nonceLen]byte
+	if _, err := io.ReadFull(rand.Reader, nonce[:]); err != nil {
+		return nil, fmt.Errorf("repo: failed to create a nonce: %w", err)
+	}
+
+	frame := make([]byte, 4+nonceLen, len(plain)+sealedOverhead)
+	boxLen := uint32(nonceLen + len(plain) + secretbox.Overhead)
+	frame[0], frame[1], frame[2], frame[3] = byte(boxLen>>24), byte(boxLen>>16), byte(boxLen>>8), byte(boxLen)
+	copy(frame[4:], nonce[:])
+
+	key := [32]byte(*k)
+	return secretbox.Seal(frame, plain, &nonce, &key), nil
+}
+
+// errSealedFrame is returned by Open for frames that weren't sealed with the key
+var errSealedFrame = errors.New("repo: failed to decrypt, the data is corrupted or the key is wrong")
+
+// Open decrypts a frame of Seal, which may be followed by padding
+func (k *DataKey) Open(frame []byte) ([]byte, error) {
+	if len(frame) < 4 {
+		return nil, errSealedFrame
+	}
+	boxLen := int64(frame[0])<<24 | int64(frame[1])<<16 | int64(frame[2])<<8 | int64(frame[3])
+	if boxLen < nonceLen+secretbox.Overhead || boxLen > int64(len(frame)-4) {
+		return nil, errSealedFrame
+	}
+
+	var nonce [nonceLen]byte
+	copy(nonce[:], frame[4:])
+
+	key := [32]byte(*k)
+	plain, ok := secretbox.Open(nil, frame[4+nonceLen:4+boxLen], &nonce, &key)
+	if !ok {
+		return nil, errSealedFrame
+	}
+	return plain, nil
+}
+
+// keyedRepo is a repo with the data key it is encrypted with
+type keyedRepo struct {
+	Interface
+	key *DataKey
+}
+
+// WithKey returns r with the data key it is encrypted with, which the Open functions of this package use.
+// A nil key returns r as it is.
+func WithKey(r Interface, key *DataKey) Interface {
+	if key == nil {
+		return r
+	}
+	return keyedRepo{Interface: r, key: key}
+}
+
+// KeyOf returns the data key of r, if it was passed to WithKey
+func KeyOf(r Interface) *DataKey {
+	if kr, ok := r.(keyedRepo); ok {
+		return kr.key
+	}
+	return nil
+}
@@ -0,0 +1,191 @@
+// SPDX-FileCopyrightText: 2021 The Go-SSB Authors
+//
+// SPDX-License-Identifier: MIT
+
+package repo
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	refs "github.com/ssbc/go-ssb-refs"
+	"github.com/ssbc/margaret"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ssbc/go-ssb"
+	"github.com/ssbc/go-ssb/internal/storedrefs"
+	"github.com/ssbc/go-ssb/message/legacy"
+	"github.com/ssbc/go-ssb/message/multimsg"
+)
+
+func TestEncryption(t *testing.T) {
+	r := require.New(t)
+
+	base := filepath.Join("testrun", t.Name())
+	os.RemoveAll(base)
+	repo := New(base)
+
+	enc, err := IsEncrypted(repo)
+	r.NoError(err)
+	r.False(enc)
+	_, err = UnlockEncryption(repo, []byte("secret"))
+	r.ErrorIs(err, ErrNotEncrypted)
+
+	key, err := InitEncryption(repo, []byte("secret"))
+	r.NoError(err)
+	enc, err = IsEncrypted(repo)
+	r.NoError(err)
+	r.True(enc)
+	_, err = InitEncryption(repo, []byte("secret"))
+	r.Error(err, "encrypted twice")
+
+	_, err = UnlockEncryption(repo, []byte("wrong"))
+	r.ErrorIs(err, ErrWrongSecret)
+	unlocked, err := UnlockEncryption(repo, []byte("secret"))
+	r.NoError(err)
+	r.Equal(key, unlocked)
+
+	// rotating keeps the data key
+	r.ErrorIs(RotateEncryption(repo, []byte("wrong"), []byte("new secret")), ErrWrongSecret)
+	r.NoError(RotateEncryption(repo, []byte("secret"), []byte("new secret")))
+	_, err = UnlockEncryption(repo, []byte("secret"))
+	r.ErrorIs(err, ErrWrongSecret)
+	unlocked, err = UnlockEncryption(repo, []byte("new secret"))
+	r.NoError(err)
+	r.Equal(key, unlocked)
+
+	// a repo with data can't be encrypted
+	plain := New(filepath.Join(base, "plain"))
+	log, err := OpenLog(plain)
+	r.NoError(err)
+	r.NoError(log.Close())
+	_, err = InitEncryption(plain, []byte("secret"))
+	r.Error(err)
+
+	// streams
+	data := bytes.Repeat([]byte("0123456789"), sealedChunkSize/4)
+	var buf bytes.Buffer
+	w := NewSealingWriter(&buf, key)
+	_, err = w.Write(data)
+	r.NoError(err)
+	r.NoError(w.Close())
+	r.False(bytes.Contains(buf.Bytes(), []byte("0123456789")))
+	got, err := io.ReadAll(NewOpeningReader(&buf, key))
+	r.NoError(err)
+	r.Equal(data, got)
+}
+
+func TestEncryptedLog(t *testing.T) {
+	r := require.New(t)
+
+	base := filepath.Join("testrun", t.Name())
+	os.RemoveAll(base)
+
+	repo := New(base)
+	key, err := InitEncryption(repo, []byte("secret"))
+	r.NoError(err)
+	otherKey, err := InitEncryption(New(filepath.Join(base, "other")), []byte("secret"))
+	r.NoError(err)
+
+	kp, err := ssb.NewKeyPair(nil, refs.RefAlgoFeedSSB1)
+	r.NoError(err)
+	var prev *refs.MessageRef
+	next := func(i int64) *legacy.StoredMessage {
+		lm := legacy.LegacyMessage{
+			Previous:  prev,
+			Author:    kp.ID().String(),
+			Sequence:  i,
+			Timestamp: 1600000000000 + i,
+			Hash:      "sha256",
+			Content:   map[string]interface{}{"type": "test", "text": "plaintext"},
+		}
+		key, raw, err := lm.Sign(kp.Secret(), nil)
+		r.NoError(err)
+		sm := &legacy.StoredMessage{
+			Author_:   storedrefs.SerialzedFeed{FeedRef: kp.ID()},
+			Key_:      storedrefs.SerialzedMessage{MessageRef: key},
+			Sequence_: i,
+			Raw_:      raw,
+		}
+		if prev != nil {
+			sm.Previous_ = &storedrefs.SerialzedMessage{MessageRef: *prev}
+		}
+		prev = &key
+		return sm
+	}
+
+	for _, format := range []LogFormat{LogFormatOffset2, LogFormatSegmented} {
+		dir := filepath.Join(base, string(format))
+		_, err := OpenLogAtWithKey(filepath.Join(base, "bipf"), LogFormatBIPF, key)
+		r.Error(err, "bipf can't be encrypted")
+
+		log, err := OpenLogWithFormat(WithKey(New(dir), key), format)
+		r.NoError(err)
+		var msgs []*legacy.StoredMessage
+		for i := int64(1); i <= 3; i++ {
+			msg := next(i)
+			msgs = append(msgs, msg)
+			_, err = log.Append(msg)
+			r.NoError(err)
+		}
+		r.NoError(log.Null(1))
+
+		// replaced data is encrypted as well
+		v, err := log.Get(2)
+		r.NoError(err)
+		replaced, err := v.(*multimsg.MultiMessage).MarshalBinary()
+		r.NoError(err)
+		r.NoError(log.Replace(2, replaced))
+		r.NoError(log.Close())
+
+		// nothing of the messages is readable on disk
+		files, err := os.ReadDir(filepath.Join(dir, "log"))
+		r.NoError(err)
+		for _, f := range files {
+			data, err := os.ReadFile(filepath.Join(dir, "log", f.Name()))
+			r.NoError(err)
+			r.False(bytes.Contains(data, []byte("plaintext")), "%s isn't encrypted", f.Name())
+		}
+
+		_, err = OpenLog(New(dir))
+		r.ErrorIs(err, ErrRepoLocked)
+		_, err = OpenLog(WithKey(New(dir), otherKey))
+		r.ErrorIs(err, ErrWrongSecret)
+
+		log, err = OpenLog(WithKey(New(dir), key))
+		r.NoError(err)
+		r.EqualValues(2, log.Seq())
+		for _, seq := range []int64{0, 2} {
+			v, err := log.Get(seq)
+			r.NoError(err)
+			r.Equal(msgs[seq].Key(), v.(refs.Message).Key())
+		}
+		_, err = log.Get(1)
+		r.True(margaret.IsErrNulled(err))
+		r.NoError(log.Close())
+	}
+
+	// a log that isn't encrypted isn't opened with a key
+	plainDir := filepath.Join(base, "plain")
+	log, err := OpenLogAt(plainDir, "")
+	r.NoError(err)
+	_, err = log.Append(next(4))
+	r.NoError(err)
+	r.NoError(log.Close())
+	_, err = OpenLogAtWithKey(plainDir, "", key)
+	r.Error(err)
+
+	// badger
+	dbPath := filepath.Join(base, "badger")
+	db, err := OpenBadgerDBWithKey(dbPath, key)
+	r.NoError(err)
+	r.NoError(db.Close())
+	_, err = OpenBadgerDB(dbPath)
+	r.Error(err, "opened encrypted db without the key")
+	db, err = OpenBadgerDBWithKey(dbPath, key)
+	r.NoError(err)
+	r.NoError(db.Close())
+}
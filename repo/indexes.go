@@ -32,7 +32,7 @@ func OpenBadgerIndex(r Interface, name string, f LibrarianIndexCreater) (*badger
 		return nil, nil, nil, fmt.Errorf("error making index directory: %w", err)
 	}
 
-	db, err := badger.Open(badgerOpts(pth))
+	db, err := OpenBadgerDBWithKey(pth, KeyOf(r))
 	if err != nil {
 		return nil, nil, nil, fmt.Errorf("db/idx: badger failed to open: %w", err)
 	}
@@ -0,0 +1,112 @@
+// SPDX-FileCopyrightText: 2021 The Go-SSB Authors
+//
+// SPDX-License-Identifier: MIT
+
+package repo
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/ssbc/margaret"
+	"github.com/ssbc/margaret/legacyflumeoffset"
+
+	"github.com/ssbc/go-ssb/internal/aalog"
+	"github.com/ssbc/go-ssb/internal/framelog"
+	"github.com/ssbc/go-ssb/message/multimsg"
+)
+
+// JSLogFormat is how the JS implementation stores its log
+type JSLogFormat string
+
+const (
+	// JSLogFlume is the flume/log.offset of ssb-db, a flumelog-offset of JSON records
+	JSLogFlume JSLogFormat = "flume"
+
+	// JSLogDB2 is the db2/log.bipf of ssb-db2
+	JSLogDB2 JSLogFormat = "db2"
+)
+
+const flumeLogFile = "log.offset"
+
+// OpenJSLog opens the log of a JS sbot to read its messages.
+// path can be the ~/.ssb directory, its flume or db2 directory or the log file itself.
+// If a directory has both, the db2 log is used because ssb-db2 stops writing to the flume log once it migrated it.
+//
+// The flume log is read with legacyflumeoffset, so its Seq() isn't implemented and the sequences are byte offsets.
+func OpenJSLog(path string) (margaret.Log, JSLogFormat, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, "", fmt.Errorf("openJSLog: %w", err)
+	}
+
+	if !fi.IsDir() {
+		if filepath.Base(path) == bipfLogFile {
+			return openJSLog(path, JSLogDB2)
+		}
+		return openJSLog(path, JSLogFlume)
+	}
+
+	for _, c := range []struct {
+		path   string
+		format JSLogFormat
+	}{
+		{filepath.Join(path, "db2", bipfLogFile), JSLogDB2},
+		{filepath.Join(path, bipfLogFile), JSLogDB2},
+		{filepath.Join(path, "flume", flumeLogFile), JSLogFlume},
+		{filepath.Join(path, flumeLogFile), JSLogFlume},
+	} {
+		if _, err := os.Stat(c.path); err == nil {
+			return openJSLog(c.path, c.format)
+		}
+	}
+	return nil, "", fmt.Errorf("openJSLog: no flume or db2 log in %s", path)
+}
+
+// CreateJSLog creates a new log in the ~/.ssb directory ssbDir, which a JS sbot with the format's database plugin picks up.
+// It doesn't write to an existing log.
+func CreateJSLog(ssbDir string, format JSLogFormat) (margaret.Log, error) {
+	var path string
+	switch format {
+	case JSLogFlume:
+		path = filepath.Join(ssbDir, "flume", flumeLogFile)
+	case JSLogDB2:
+		path = filepath.Join(ssbDir, "db2", bipfLogFile)
+	default:
+		return nil, fmt.Errorf("createJSLog: unknown format %q", format)
+	}
+
+	if fi, err := os.Stat(path); err == nil && fi.Size() > 0 {
+		return nil, fmt.Errorf("createJSLog: %s already has a log", path)
+	}
+
+	err := os.MkdirAll(filepath.Dir(path), 0700)
+	if err != nil {
+		return nil, fmt.Errorf("createJSLog: %w", err)
+	}
+
+	log, _, err := openJSLog(path, format)
+	return log, err
+}
+
+func openJSLog(path string, format JSLogFormat) (margaret.Log, JSLogFormat, error) {
+	switch format {
+	case JSLogFlume:
+		log, err := legacyflumeoffset.Open(path, multimsg.FlumeCodec{})
+		if err != nil {
+			return nil, "", fmt.Errorf("openJSLog: failed to open flume log: %w", err)
+		}
+		return log, format, nil
+
+	case JSLogDB2:
+		s, err := aalog.Open(path, aalog.DefaultBlockSize)
+		if err != nil {
+			return nil, "", fmt.Errorf("openJSLog: failed to open db2 log: %w", err)
+		}
+		return framelog.New(filepath.Dir(path), s, multimsg.BIPFCodec{}), format, nil
+
+	default:
+		return nil, "", fmt.Errorf("openJSLog: unknown format %q", format)
+	}
+}
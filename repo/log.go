@@ -5,23 +5,327 @@
 package repo
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
 
-	"github.com/ssbc/go-ssb/message/multimsg"
+	"github.com/ssbc/go-luigi"
+	"github.com/ssbc/margaret"
 	"github.com/ssbc/margaret/offset2"
+
+	"github.com/ssbc/go-ssb/internal/aalog"
+	"github.com/ssbc/go-ssb/internal/framelog"
+	"github.com/ssbc/go-ssb/internal/seglog"
+	"github.com/ssbc/go-ssb/message/multimsg"
 )
 
+// LogFormat is how a log is stored on disk
+type LogFormat string
+
+const (
+	// LogFormatOffset2 is margaret's offset2, which logs are stored as unless they say otherwise
+	LogFormatOffset2 LogFormat = "offset2"
+
+	// LogFormatBIPF is the log.bipf of ssb-db2: bipf encoded messages in an async-append-only-log.
+	// It can only store classic messages.
+	LogFormatBIPF LogFormat = "bipf"
+
+	// LogFormatSegmented splits the log into segments of a fixed number of entries, which can be deleted as a whole
+	LogFormatSegmented LogFormat = "segmented"
+)
+
+// LogFormats are the formats OpenLogAt supports
+var LogFormats = []LogFormat{LogFormatOffset2, LogFormatBIPF, LogFormatSegmented}
+
+// ParseLogFormat returns the format s names, which can also be db2 for the bipf format
+func ParseLogFormat(s string) (LogFormat, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "offset2":
+		return LogFormatOffset2, nil
+	case "bipf", "db2", "ssb-db2", "async-append-only-log":
+		return LogFormatBIPF, nil
+	case "segmented", "segments":
+		return LogFormatSegmented, nil
+	default:
+		return "", fmt.Errorf("unknown log format: %q", s)
+	}
+}
+
+const (
+	// logFormatFile says which format the log in a directory has, offset2 logs don't have it
+	logFormatFile = "format"
+
+	// bipfLogFile is what ssb-db2 calls its log
+	bipfLogFile = "log.bipf"
+
+	// encryptedLogFile marks an encrypted log, it has a sealed check value to tell if the key is the right one
+	encryptedLogFile = "encrypted"
+)
+
+// encryptedLogCheck is sealed into the encryptedLogFile of a log
+var encryptedLogCheck = []byte("go-ssb encrypted log")
+
+// DetectLogFormat returns the format of the log in dir or an empty format if there is none yet.
+// A directory with a log.bipf in it, like the db2 folder of ssb-db2, is a bipf log.
+func DetectLogFormat(dir string) (LogFormat, error) {
+	data, err := os.ReadFile(filepath.Join(dir, logFormatFile))
+	if err == nil {
+		return ParseLogFormat(string(data))
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return "", fmt.Errorf("failed to read the log format: %w", err)
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, bipfLogFile)); err == nil {
+		return LogFormatBIPF, nil
+	}
+
+	if fi, err := os.Stat(filepath.Join(dir, "data")); err == nil && fi.Size() > 0 {
+		return LogFormatOffset2, nil
+	}
+	return "", nil
+}
+
+// OpenLog opens the receive log of the repo, or another log if path is set, in the format it has.
+// A new log is created as offset2.
 func OpenLog(r Interface, path ...string) (multimsg.AlterableLog, error) {
+	return OpenLogWithFormat(r, "", path...)
+}
+
+// OpenLogWithFormat is like OpenLog but creates a new log in format.
+// An existing log has to have that format, unless format is empty.
+func OpenLogWithFormat(r Interface, format LogFormat, path ...string) (multimsg.AlterableLog, error) {
 	// prefix path with "logs" if path is not empty, otherwise use "log"
 	path = append([]string{"log"}, path...)
 	if len(path) > 1 {
 		path[0] = "logs"
 	}
 
-	// TODO use proper log message type here
-	log, err := offset2.Open(r.GetPath(path...), multimsg.MargaretCodec{})
+	return OpenLogAtWithKey(r.GetPath(path...), format, KeyOf(r))
+}
+
+// OpenLogAt opens the log in the directory dir, see OpenLogWithFormat
+func OpenLogAt(dir string, format LogFormat) (multimsg.AlterableLog, error) {
+	return OpenLogAtWithKey(dir, format, nil)
+}
+
+// OpenLogAtWithKey is like OpenLogAt but encrypts the entries of the log with key, unless it is nil.
+// A new log is encrypted if key is set, an existing one has to be encrypted with the same key.
+// The bipf format can't be encrypted, since it is meant to be read by ssb-db2.
+func OpenLogAtWithKey(dir string, format LogFormat, key *DataKey) (multimsg.AlterableLog, error) {
+	existing, err := DetectLogFormat(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open log: %w", err)
+	}
+
+	encrypted, err := checkLogKey(dir, key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open log: %w", err)
+	}
+	if existing != "" && !encrypted && key != nil {
+		return nil, fmt.Errorf("failed to open log: %s has a log that isn't encrypted", dir)
+	}
+
+	switch {
+	case existing == "" && format == "":
+		format = LogFormatOffset2
+	case format == "":
+		format = existing
+	case existing != "" && existing != format:
+		return nil, fmt.Errorf("failed to open log: %s has a %s log, not %s", dir, existing, format)
+	}
+	if key != nil && format == LogFormatBIPF {
+		return nil, fmt.Errorf("failed to open log: the %s format can't be encrypted", format)
+	}
+
+	var codec margaret.Codec = multimsg.MargaretCodec{}
+	if key != nil {
+		codec = sealedCodec{codec: codec, key: key}
+	}
+
+	var log multimsg.AlterableLog
+	switch format {
+	case LogFormatOffset2:
+		log, err = offset2.Open(dir, codec)
+
+	case LogFormatBIPF:
+		var s *aalog.Store
+		s, err = aalog.Open(filepath.Join(dir, bipfLogFile), aalog.DefaultBlockSize)
+		if err == nil {
+			log = framelog.New(dir, s, multimsg.BIPFCodec{})
+		}
+
+	case LogFormatSegmented:
+		var s *seglog.Store
+		s, err = seglog.Open(dir, seglog.DefaultEntriesPerSegment)
+		if err == nil {
+			log = framelog.New(dir, s, codec)
+		}
+
+	default:
+		return nil, fmt.Errorf("failed to open log: unsupported format %q", format)
+	}
 	if err != nil {
 		return nil, fmt.Errorf("failed to open log: %w", err)
 	}
+
+	if existing == "" && format != LogFormatOffset2 {
+		err = os.WriteFile(filepath.Join(dir, logFormatFile), []byte(format), 0600)
+		if err != nil {
+			log.Close()
+			return nil, fmt.Errorf("failed to write the format of the new log: %w", err)
+		}
+	}
+
+	if key != nil {
+		if !encrypted {
+			if err := markLogEncrypted(dir, key); err != nil {
+				log.Close()
+				return nil, fmt.Errorf("failed to open log: %w", err)
+			}
+		}
+		log = sealedLog{AlterableLog: log, key: key}
+	}
+
 	return multimsg.NewWrappedLog(log), nil
 }
+
+// checkLogKey returns true if the log in dir is encrypted and checks that key is the one it is encrypted with
+func checkLogKey(dir string, key *DataKey) (bool, error) {
+	check, err := os.ReadFile(filepath.Join(dir, encryptedLogFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	if key == nil {
+		return true, ErrRepoLocked
+	}
+	plain, err := key.Open(check)
+	if err != nil || !bytes.Equal(plain, encryptedLogCheck) {
+		return true, ErrWrongSecret
+	}
+	return true, nil
+}
+
+func markLogEncrypted(dir string, key *DataKey) error {
+	check, err := key.Seal(encryptedLogCheck)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, encryptedLogFile), check, 0600)
+}
+
+// ConvertStats says what ConvertLog copied
+type ConvertStats struct {
+	Copied int64
+
+	// Nulled were already nulled in the source.
+	// Dropped are the messages the format of the destination can't store, which are nulled there.
+	Nulled  int64
+	Dropped int64
+}
+
+// ConvertLog copies the entries of from to the empty log to, so that they keep their sequences.
+// That way the indexes of a repo stay valid when its receive log is replaced by the copy.
+// Nulled entries, and the messages the format of to can't store, are written as a copy of another message and nulled right away.
+// A limit of -1 copies all the entries.
+func ConvertLog(from margaret.Log, to multimsg.AlterableLog, limit int) (ConvertStats, error) {
+	var stats ConvertStats
+
+	if to.Seq() != margaret.SeqEmpty {
+		return stats, fmt.Errorf("convertLog: the destination already has entries")
+	}
+
+	src, err := from.Query(margaret.Limit(limit))
+	if err != nil {
+		return stats, fmt.Errorf("convertLog: failed to query the source: %w", err)
+	}
+
+	var (
+		filler  *multimsg.MultiMessage // stands in for the nulled entries
+		pending int64                  // nulled entries before the first message that could be copied
+	)
+
+	nullAs := func(mm *multimsg.MultiMessage) error {
+		seq, err := to.Append(mm)
+		if err != nil {
+			return fmt.Errorf("convertLog: failed to write a stand-in for a nulled entry: %w", err)
+		}
+		return to.Null(seq)
+	}
+
+	ctx := context.Background()
+	for {
+		v, err := src.Next(ctx)
+		if luigi.IsEOS(err) {
+			break
+		} else if err != nil {
+			return stats, fmt.Errorf("convertLog: failed to read entry %d: %w", stats.Copied+stats.Nulled+stats.Dropped, err)
+		}
+
+		var mm *multimsg.MultiMessage
+		switch tv := v.(type) {
+		case *multimsg.MultiMessage:
+			mm = tv
+		case multimsg.MultiMessage:
+			mm = &tv
+		case error:
+			if !margaret.IsErrNulled(tv) {
+				return stats, fmt.Errorf("convertLog: failed to read entry: %w", tv)
+			}
+			stats.Nulled++
+			if filler == nil {
+				pending++
+				continue
+			}
+			if err := nullAs(filler); err != nil {
+				return stats, err
+			}
+			continue
+		default:
+			return stats, fmt.Errorf("convertLog: unexpected entry type %T", v)
+		}
+
+		if pending > 0 {
+			// the first message that can be copied stands in for the nulled ones before it
+			for ; pending > 0; pending-- {
+				if err := nullAs(mm); err != nil {
+					if errors.Is(err, multimsg.ErrUnsupportedFormat) {
+						break
+					}
+					return stats, err
+				}
+			}
+		}
+
+		_, err = to.Append(mm)
+		if errors.Is(err, multimsg.ErrUnsupportedFormat) {
+			stats.Dropped++
+			if filler == nil {
+				pending++
+				continue
+			}
+			if err := nullAs(filler); err != nil {
+				return stats, err
+			}
+			continue
+		} else if err != nil {
+			return stats, fmt.Errorf("convertLog: failed to write entry: %w", err)
+		}
+		stats.Copied++
+		filler = mm
+	}
+
+	if pending > 0 {
+		return stats, fmt.Errorf("convertLog: none of the %d entries could be copied", pending)
+	}
+	return stats, nil
+}
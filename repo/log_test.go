@@ -0,0 +1,135 @@
+// SPDX-FileCopyrightText: 2021 The Go-SSB Authors
+//
+// SPDX-License-Identifier: MIT
+
+package repo
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	gabbygrove "github.com/ssbc/go-gabbygrove"
+	refs "github.com/ssbc/go-ssb-refs"
+	"github.com/ssbc/margaret"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ssbc/go-ssb"
+	"github.com/ssbc/go-ssb/internal/storedrefs"
+	"github.com/ssbc/go-ssb/message/legacy"
+)
+
+func TestConvertLog(t *testing.T) {
+	r := require.New(t)
+
+	base := filepath.Join("testrun", t.Name())
+	os.RemoveAll(base)
+
+	kp, err := ssb.NewKeyPair(nil, refs.RefAlgoFeedSSB1)
+	r.NoError(err)
+	gabbyKP, err := ssb.NewKeyPair(nil, refs.RefAlgoFeedGabby)
+	r.NoError(err)
+
+	var prev *refs.MessageRef
+	classic := func(i int64) *legacy.StoredMessage {
+		lm := legacy.LegacyMessage{
+			Previous:  prev,
+			Author:    kp.ID().String(),
+			Sequence:  i,
+			Timestamp: 1600000000000 + i,
+			Hash:      "sha256",
+			Content:   map[string]interface{}{"type": "test", "i": i},
+		}
+		key, raw, err := lm.Sign(kp.Secret(), nil)
+		r.NoError(err)
+		sm := &legacy.StoredMessage{
+			Author_:   storedrefs.SerialzedFeed{FeedRef: kp.ID()},
+			Key_:      storedrefs.SerialzedMessage{MessageRef: key},
+			Sequence_: i,
+			Raw_:      raw,
+		}
+		if prev != nil {
+			sm.Previous_ = &storedrefs.SerialzedMessage{MessageRef: *prev}
+		}
+		prev = &key
+		return sm
+	}
+
+	src, err := OpenLogAt(filepath.Join(base, "source"), "")
+	r.NoError(err)
+
+	_, err = src.Append(classic(1))
+	r.NoError(err)
+	tr, _, err := gabbygrove.NewEncoder(gabbyKP.Secret()).Encode(1, gabbygrove.BinaryRef{}, "hello")
+	r.NoError(err)
+	_, err = src.Append(tr)
+	r.NoError(err)
+	var keys = make(map[int64]refs.MessageRef)
+	for i, seq := range []int64{2, 3, 4} {
+		msg := classic(int64(i + 2))
+		_, err = src.Append(msg)
+		r.NoError(err)
+		keys[seq] = msg.Key()
+	}
+	r.NoError(src.Null(0))
+	r.NoError(src.Null(3))
+
+	format, err := DetectLogFormat(filepath.Join(base, "source"))
+	r.NoError(err)
+	r.Equal(LogFormatOffset2, format)
+
+	for _, format := range LogFormats {
+		dir := filepath.Join(base, string(format))
+		dst, err := OpenLogAt(dir, format)
+		r.NoError(err, "%s", format)
+
+		stats, err := ConvertLog(src, dst, -1)
+		r.NoError(err, "%s", format)
+		r.EqualValues(2, stats.Nulled, "%s", format)
+		if format == LogFormatBIPF {
+			r.Equal(ConvertStats{Copied: 2, Nulled: 2, Dropped: 1}, stats, "bipf can't store gabby grove")
+		} else {
+			r.Equal(ConvertStats{Copied: 3, Nulled: 2}, stats, "%s", format)
+		}
+
+		_, err = ConvertLog(src, dst, -1)
+		r.Error(err, "%s: not empty", format)
+		r.NoError(dst.Close())
+
+		// the format is detected and the sequences are the same as in the source
+		got, err := DetectLogFormat(dir)
+		r.NoError(err)
+		r.Equal(format, got)
+
+		dst, err = OpenLogAt(dir, "")
+		r.NoError(err, "%s", format)
+		r.EqualValues(4, dst.Seq(), "%s", format)
+
+		nulled := []int64{0, 3}
+		if format == LogFormatBIPF {
+			nulled = append(nulled, 1)
+		} else {
+			v, err := dst.Get(1)
+			r.NoError(err, "%s", format)
+			r.True(v.(refs.Message).Author().Equal(gabbyKP.ID()))
+		}
+		for _, seq := range nulled {
+			_, err = dst.Get(seq)
+			r.True(margaret.IsErrNulled(err), "%s: %d not nulled: %v", format, seq, err)
+		}
+		for _, seq := range []int64{2, 4} {
+			v, err := dst.Get(seq)
+			r.NoError(err, "%s", format)
+			r.True(v.(refs.Message).Key().Equal(keys[seq]), "%s: wrong message at %d", format, seq)
+		}
+		r.NoError(dst.Close())
+
+		for _, other := range LogFormats {
+			if other != format {
+				_, err = OpenLogAt(dir, other)
+				r.Error(err, "%s opened as %s", format, other)
+			}
+		}
+	}
+	r.NoError(src.Close())
+}
@@ -35,7 +35,20 @@ func makeSinkIndex(dbPath string, mlog multilog.MultiLog, fn multilog.Func) (lib
 const PrefixMultiLog = "sublogs"
 
 func OpenBadgerDB(path string) (*badger.DB, error) {
+	return OpenBadgerDBWithKey(path, nil)
+}
+
+// OpenBadgerDBWithKey opens the db at path with badger's encryption, using key, unless it is nil.
+// A db that was created without a key can't be opened with one and the other way around.
+func OpenBadgerDBWithKey(path string, key *DataKey) (*badger.DB, error) {
 	opts := badgerOpts(path)
+	if key != nil {
+		opts = opts.WithEncryptionKey(key[:])
+		// badger needs a cache for the decrypted table indexes
+		if opts.IndexCacheSize == 0 {
+			opts = opts.WithIndexCacheSize(1 << 26)
+		}
+	}
 	return badger.Open(opts)
 }
 
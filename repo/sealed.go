@@ -0,0 +1,174 @@
+// SPDX-FileCopyrightText: 2021 The Go-SSB Authors
+//
+// SPDX-License-Identifier: MIT
+
+package repo
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/ssbc/margaret"
+
+	"github.com/ssbc/go-ssb/message/multimsg"
+)
+
+// sealedCodec encrypts the entries the codec it wraps encodes with the data key
+type sealedCodec struct {
+	codec margaret.Codec
+	key   *DataKey
+}
+
+func (c sealedCodec) Marshal(v interface{}) ([]byte, error) {
+	data, err := c.codec.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return c.key.Seal(data)
+}
+
+func (c sealedCodec) Unmarshal(data []byte) (interface{}, error) {
+	plain, err := c.key.Open(data)
+	if err != nil {
+		return nil, err
+	}
+	return c.codec.Unmarshal(plain)
+}
+
+func (c sealedCodec) NewEncoder(w io.Writer) margaret.Encoder {
+	return sealedEncoder{codec: c, w: w}
+}
+
+func (c sealedCodec) NewDecoder(r io.Reader) margaret.Decoder {
+	return sealedDecoder{codec: c, r: r}
+}
+
+type sealedEncoder struct {
+	codec sealedCodec
+	w     io.Writer
+}
+
+func (enc sealedEncoder) Encode(v interface{}) error {
+	data, err := enc.codec.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = enc.w.Write(data)
+	return err
+}
+
+type sealedDecoder struct {
+	codec sealedCodec
+	r     io.Reader
+}
+
+func (dec sealedDecoder) Decode() (interface{}, error) {
+	data, err := ioutil.ReadAll(dec.r)
+	if err != nil {
+		return nil, err
+	}
+	return dec.codec.Unmarshal(data)
+}
+
+// sealedLog encrypts the data of Replace, which bypasses the codec of the log
+type sealedLog struct {
+	multimsg.AlterableLog
+	key *DataKey
+}
+
+func (sl sealedLog) Replace(seq int64, data []byte) error {
+	sealed, err := sl.key.Seal(data)
+	if err != nil {
+		return err
+	}
+	return sl.AlterableLog.Replace(seq, sealed)
+}
+
+// Unwrap returns the log that stores the encrypted entries
+func (sl sealedLog) Unwrap() multimsg.AlterableLog { return sl.AlterableLog }
+
+// sealedChunkSize is how much of a stream NewSealingWriter encrypts at once
+const sealedChunkSize = 64 * 1024
+
+// NewSealingWriter returns a writer that encrypts what is written to it in chunks, which NewOpeningReader decrypts again.
+// It has to be closed to write the last chunk, which doesn't close w.
+func NewSealingWriter(w io.Writer, key *DataKey) io.WriteCloser {
+	return &sealingWriter{w: w, key: key}
+}
+
+type sealingWriter struct {
+	w   io.Writer
+	key *DataKey
+	buf bytes.Buffer
+}
+
+func (sw *sealingWriter) Write(p []byte) (int, error) {
+	sw.buf.Write(p)
+	for sw.buf.Len() >= sealedChunkSize {
+		if err := sw.flush(sealedChunkSize); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+func (sw *sealingWriter) flush(n int) error {
+	frame, err := sw.key.Seal(sw.buf.Next(n))
+	if err != nil {
+		return err
+	}
+	_, err = sw.w.Write(frame)
+	return err
+}
+
+func (sw *sealingWriter) Close() error {
+	if sw.buf.Len() == 0 {
+		return nil
+	}
+	return sw.flush(sw.buf.Len())
+}
+
+// NewOpeningReader returns a reader of the decrypted stream that NewSealingWriter wrote to r
+func NewOpeningReader(r io.Reader, key *DataKey) io.Reader {
+	return &openingReader{r: r, key: key}
+}
+
+type openingReader struct {
+	r     io.Reader
+	key   *DataKey
+	plain []byte
+}
+
+func (or *openingReader) Read(p []byte) (int, error) {
+	for len(or.plain) == 0 {
+		var head [4]byte
+		if _, err := io.ReadFull(or.r, head[:]); err != nil {
+			if err == io.ErrUnexpectedEOF {
+				return 0, errSealedFrame
+			}
+			return 0, err
+		}
+		boxLen := int(head[0])<<24 | int(head[1])<<16 | int(head[2])<<8 | int(head[3])
+		if boxLen > sealedChunkSize+sealedOverhead {
+			return 0, fmt.Errorf("repo: encrypted chunk too large (%d bytes)", boxLen)
+		}
+
+		frame := make([]byte, 4+boxLen)
+		copy(frame, head[:])
+		if _, err := io.ReadFull(or.r, frame[4:]); err != nil {
+			return 0, errSealedFrame
+		}
+
+		var err error
+		or.plain, err = or.key.Open(frame)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(p, or.plain)
+	or.plain = or.plain[n:]
+	return n, nil
+}
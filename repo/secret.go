@@ -16,8 +16,14 @@ import (
 )
 
 func DefaultKeyPair(r Interface, algo refs.RefAlgo) (ssb.KeyPair, error) {
+	return DefaultKeyPairWithSecret(r, algo, nil)
+}
+
+// DefaultKeyPairWithSecret is DefaultKeyPair for a secret file that is encrypted with secret.
+// A new secret file is encrypted if secret isn't nil.
+func DefaultKeyPairWithSecret(r Interface, algo refs.RefAlgo, secret []byte) (ssb.KeyPair, error) {
 	secPath := r.GetPath("secret")
-	keyPair, err := ssb.LoadKeyPair(secPath)
+	keyPair, err := ssb.LoadKeyPairWithSecret(secPath, secret)
 	if err != nil {
 		if !os.IsNotExist(err) {
 			return nil, fmt.Errorf("repo: error opening key pair: %w", err)
@@ -26,7 +32,7 @@ func DefaultKeyPair(r Interface, algo refs.RefAlgo) (ssb.KeyPair, error) {
 		if err != nil {
 			return nil, fmt.Errorf("repo: no keypair but couldn't create one either: %w", err)
 		}
-		if err := ssb.SaveKeyPair(keyPair, secPath); err != nil {
+		if err := saveKeyPair(keyPair, secPath, secret); err != nil {
 			return nil, fmt.Errorf("repo: error saving new identity file: %w", err)
 		}
 		log.Printf("saved identity %s to %s", keyPair.ID().String(), secPath)
@@ -34,24 +40,42 @@ func DefaultKeyPair(r Interface, algo refs.RefAlgo) (ssb.KeyPair, error) {
 	return keyPair, nil
 }
 
+func saveKeyPair(kp ssb.KeyPair, path string, secret []byte) error {
+	if secret != nil {
+		return ssb.SaveEncryptedKeyPair(kp, path, secret)
+	}
+	return ssb.SaveKeyPair(kp, path)
+}
+
 func NewKeyPair(r Interface, name string, algo refs.RefAlgo) (ssb.KeyPair, error) {
-	return newKeyPair(r, name, algo, nil)
+	return newKeyPair(r, name, algo, nil, nil)
 }
 
 func NewKeyPairFromSeed(r Interface, name string, algo refs.RefAlgo, seed io.Reader) (ssb.KeyPair, error) {
-	return newKeyPair(r, name, algo, seed)
+	return newKeyPair(r, name, algo, seed, nil)
+}
+
+// NewEncryptedKeyPair is NewKeyPair with the secret file encrypted with secret, see ssb.SaveEncryptedKeyPair
+func NewEncryptedKeyPair(r Interface, name string, algo refs.RefAlgo, secret []byte) (ssb.KeyPair, error) {
+	if len(secret) == 0 {
+		return nil, fmt.Errorf("repo: the secret for the key-pair is empty")
+	}
+	return newKeyPair(r, name, algo, nil, secret)
 }
 
-func newKeyPair(r Interface, name string, algo refs.RefAlgo, seed io.Reader) (ssb.KeyPair, error) {
-	var secPath string
+// KeyPairPath returns the path of the secret file of the named key-pair, "-" is the default one of the repo
+func KeyPairPath(r Interface, name string) string {
 	if name == "-" {
-		secPath = r.GetPath("secret")
-	} else {
-		secPath = r.GetPath("secrets", name)
-		err := os.MkdirAll(filepath.Dir(secPath), 0700)
-		if err != nil && !os.IsExist(err) {
-			return nil, err
-		}
+		return r.GetPath("secret")
+	}
+	return r.GetPath("secrets", name)
+}
+
+func newKeyPair(r Interface, name string, algo refs.RefAlgo, seed io.Reader, secret []byte) (ssb.KeyPair, error) {
+	secPath := KeyPairPath(r, name)
+	err := os.MkdirAll(filepath.Dir(secPath), 0700)
+	if err != nil && !os.IsExist(err) {
+		return nil, err
 	}
 	// TODO: move to refs pkg
 	if algo != refs.RefAlgoFeedSSB1 &&
@@ -59,7 +83,7 @@ func newKeyPair(r Interface, name string, algo refs.RefAlgo, seed io.Reader) (ss
 		algo != refs.RefAlgoFeedBendyButt { //  enums would be nice
 		return nil, fmt.Errorf("invalid feed refrence algo")
 	}
-	if _, err := ssb.LoadKeyPair(secPath); err == nil {
+	if _, err := os.Stat(secPath); err == nil {
 		return nil, fmt.Errorf("new key-pair name already taken")
 	}
 
@@ -68,7 +92,7 @@ func newKeyPair(r Interface, name string, algo refs.RefAlgo, seed io.Reader) (ss
 		return nil, fmt.Errorf("repo: no keypair but couldn't create one either: %w", err)
 	}
 
-	if err := ssb.SaveKeyPair(keyPair, secPath); err != nil {
+	if err := saveKeyPair(keyPair, secPath, secret); err != nil {
 		return nil, fmt.Errorf("repo: error saving new identity file: %w", err)
 	}
 	log.Printf("saved identity %s to %s", keyPair.ID().String(), secPath)
@@ -76,8 +100,13 @@ func newKeyPair(r Interface, name string, algo refs.RefAlgo, seed io.Reader) (ss
 }
 
 func LoadKeyPair(r Interface, name string) (ssb.KeyPair, error) {
+	return LoadKeyPairWithSecret(r, name, nil)
+}
+
+// LoadKeyPairWithSecret is LoadKeyPair for a secret file that might be encrypted with secret
+func LoadKeyPairWithSecret(r Interface, name string, secret []byte) (ssb.KeyPair, error) {
 	secPath := r.GetPath("secrets", name)
-	keyPair, err := ssb.LoadKeyPair(secPath)
+	keyPair, err := ssb.LoadKeyPairWithSecret(secPath, secret)
 	if err != nil {
 		return nil, fmt.Errorf("Load: failed to open %q: %w", secPath, err)
 	}
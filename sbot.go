@@ -6,6 +6,8 @@ package ssb
 
 import (
 	"fmt"
+	"io"
+	"time"
 
 	refs "github.com/ssbc/go-ssb-refs"
 	"github.com/ssbc/go-ssb-refs/tfk"
@@ -87,6 +89,91 @@ type IndexState struct {
 	State string
 }
 
+// IndexController lists the indexes of a bot and controls how they are filled, see the indexes plugin
+type IndexController interface {
+	IndexProgress() []IndexProgress
+
+	// PauseIndexing stops the named indexes before their next message, or all of them if there are no names.
+	// ResumeIndexing lets them continue.
+	PauseIndexing(names ...string) error
+	ResumeIndexing(names ...string) error
+
+	// RebuildIndex processes all the messages of an index again, while the other indexes keep being served.
+	// Indexes with keys of their own drop their values first, the others overwrite them.
+	RebuildIndex(name string) error
+}
+
+// IndexProgress is how far an index got
+type IndexProgress struct {
+	Name  string `json:"name"`
+	State string `json:"state"` // like pending, live, paused or the error it stopped with
+
+	// Seq is the receive log sequence of the last message the index processed.
+	// Pending are the messages it still needs to look at, which for some indexes are only the ones of a type.
+	Seq     int64 `json:"seq"`
+	Pending int64 `json:"pending"`
+
+	Processed int64   `json:"processed"` // since the bot started
+	Rate      float64 `json:"rate"`      // messages per second over the last few seconds
+
+	Paused      bool `json:"paused"`
+	Rebuildable bool `json:"rebuildable"` // if RebuildIndex works while the bot is running
+}
+
+// LogCompactor rewrites the receive log without the entries that were nulled, see the compact plugin
+type LogCompactor interface {
+	// CompactReceiveLog copies the receive log without its nulled entries while the bot keeps running.
+	// The copy replaces the log, with the indexes moved to its sequences, the next time the bot starts.
+	CompactReceiveLog() (CompactionStats, error)
+}
+
+// CompactionStats says what a compaction of the receive log leaves out
+type CompactionStats struct {
+	Kept    int64 `json:"kept"`    // the entries that were copied
+	Removed int64 `json:"removed"` // the nulled entries that were left out
+
+	// Reclaimed is about how many bytes the log shrinks
+	Reclaimed int64 `json:"reclaimed"`
+}
+
+// Archiver moves feeds and the blobs they reference in and out of portable archives, see the archive plugin
+type Archiver interface {
+	// ExportArchive writes the messages of feeds in the order of their sequence to w, followed by their blobs if withBlobs is set.
+	ExportArchive(w io.Writer, feeds []refs.FeedRef, withBlobs bool) (ArchiveStats, error)
+
+	// ImportArchive verifies the messages of the archive in r and appends the ones that continue their feeds.
+	ImportArchive(r io.Reader) (ArchiveStats, error)
+}
+
+// ArchiveStats says what was written to or taken from an archive
+type ArchiveStats struct {
+	Messages int64 `json:"messages"`
+	Blobs    int64 `json:"blobs"`
+
+	Known   int64 `json:"known"`   // messages and blobs that were already stored
+	Invalid int64 `json:"invalid"` // messages that failed the verification or didn't continue their feed and blobs that didn't match their hash
+	Missing int64 `json:"missing"` // blobs that were referenced but aren't stored
+}
+
+// Snapshotter writes consistent copies of the repo while the bot keeps running, see the snapshot plugin
+type Snapshotter interface {
+	// Snapshot copies the repo into the new directory dir, with the indexes as far as they were when it started.
+	Snapshot(dir string) (SnapshotInfo, error)
+}
+
+// SnapshotInfo describes a snapshot of a repo
+type SnapshotInfo struct {
+	Feed    refs.FeedRef `json:"feed"`
+	Created time.Time    `json:"created"`
+
+	// Seq is the last entry of the receive log in the snapshot, LogFormat the format it has
+	Seq       int64  `json:"seq"`
+	LogFormat string `json:"logFormat"`
+
+	// Blobs are the blobs that were linked or copied into the snapshot
+	Blobs int64 `json:"blobs"`
+}
+
 type ContentNuller interface {
 	NullContent(feed refs.FeedRef, seq uint) error
 }
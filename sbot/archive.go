@@ -0,0 +1,224 @@
+// SPDX-FileCopyrightText: 2021 The Go-SSB Authors
+//
+// SPDX-License-Identifier: MIT
+
+package sbot
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/ssbc/go-luigi"
+	refs "github.com/ssbc/go-ssb-refs"
+	"github.com/ssbc/margaret"
+	"go.mindeco.de/log/level"
+
+	"github.com/ssbc/go-ssb"
+	"github.com/ssbc/go-ssb/blobstore"
+	"github.com/ssbc/go-ssb/internal/archive"
+	"github.com/ssbc/go-ssb/internal/storedrefs"
+	"github.com/ssbc/go-ssb/message"
+	"github.com/ssbc/go-ssb/message/multimsg"
+	"github.com/ssbc/go-ssb/multilogs"
+)
+
+var _ ssb.Archiver = (*Sbot)(nil)
+
+// maxArchivedMessageSize is well above what any of the feed formats allow
+const maxArchivedMessageSize = 64 * 1024
+
+// ExportArchive writes the messages of feeds and, if withBlobs is set, the blobs their contents reference to w.
+// A feed ends at its first nulled message, since the ones after it couldn't be verified.
+func (s *Sbot) ExportArchive(w io.Writer, feeds []refs.FeedRef, withBlobs bool) (ssb.ArchiveStats, error) {
+	var stats ssb.ArchiveStats
+
+	aw, err := archive.NewWriter(w, archive.Header{Feeds: feeds, Blobs: withBlobs})
+	if err != nil {
+		return stats, err
+	}
+
+	var (
+		blobs []refs.BlobRef
+		seen  = make(map[string]struct{})
+	)
+
+	for _, feed := range feeds {
+		userLog, err := s.Users.Get(storedrefs.Feed(feed))
+		if err != nil {
+			return stats, fmt.Errorf("exportArchive: failed to open the sublog of %s: %w", feed.ShortSigil(), err)
+		}
+		if userLog.Seq() == margaret.SeqEmpty {
+			return stats, fmt.Errorf("exportArchive: no messages of %s", feed.ShortSigil())
+		}
+
+		if err := aw.WriteFeed(feed); err != nil {
+			return stats, err
+		}
+
+		src, err := userLog.Query()
+		if err != nil {
+			return stats, fmt.Errorf("exportArchive: failed to query the sublog of %s: %w", feed.ShortSigil(), err)
+		}
+
+		for {
+			v, err := src.Next(s.rootCtx)
+			if luigi.IsEOS(err) {
+				break
+			} else if err != nil {
+				return stats, fmt.Errorf("exportArchive: failed to read the sublog of %s: %w", feed.ShortSigil(), err)
+			}
+
+			rxSeq, ok := v.(int64)
+			if !ok {
+				return stats, fmt.Errorf("exportArchive: unexpected sublog entry: %T (wanted %T)", v, rxSeq)
+			}
+
+			mv, err := s.ReceiveLog.Get(rxSeq)
+			if margaret.IsErrNulled(err) {
+				level.Warn(s.info).Log("event", "archive: feed ends at nulled message", "feed", feed.ShortSigil(), "rxSeq", rxSeq)
+				break
+			} else if err != nil {
+				return stats, fmt.Errorf("exportArchive: failed to load message %d: %w", rxSeq, err)
+			}
+
+			mm, ok := mv.(*multimsg.MultiMessage)
+			if !ok {
+				return stats, fmt.Errorf("exportArchive: unexpected message type: %T (wanted %T)", mv, mm)
+			}
+
+			raw, err := wireBytes(mm)
+			if err != nil {
+				return stats, fmt.Errorf("exportArchive: message %s: %w", mm.Key().ShortSigil(), err)
+			}
+
+			if err := aw.WriteMessage(mm.Key(), raw); err != nil {
+				return stats, err
+			}
+			stats.Messages++
+
+			if !withBlobs {
+				continue
+			}
+			for _, link := range multilogs.ExtractLinks(mm.ContentBytes()) {
+				blob, ok := link.Dest.(refs.BlobRef)
+				if !ok {
+					continue
+				}
+				if _, has := seen[blob.String()]; has {
+					continue
+				}
+				seen[blob.String()] = struct{}{}
+				blobs = append(blobs, blob)
+			}
+		}
+	}
+
+	for _, blob := range blobs {
+		size, err := s.BlobStore.Size(blob)
+		if err != nil {
+			stats.Missing++
+			continue
+		}
+
+		rd, err := s.BlobStore.Get(blob)
+		if err != nil {
+			return stats, fmt.Errorf("exportArchive: failed to read blob %s: %w", blob.ShortSigil(), err)
+		}
+		err = aw.WriteBlob(blob, size, rd)
+		rd.Close()
+		if err != nil {
+			return stats, err
+		}
+		stats.Blobs++
+	}
+
+	return stats, aw.Close()
+}
+
+// ImportArchive verifies the messages of the archive in r and appends the ones that continue their feed,
+// like they were replicated. The blobs are stored if they match their reference.
+func (s *Sbot) ImportArchive(r io.Reader) (ssb.ArchiveStats, error) {
+	var stats ssb.ArchiveStats
+
+	ar, err := archive.NewReader(r)
+	if err != nil {
+		return stats, err
+	}
+
+	var snk message.SequencedVerificationSink
+	for {
+		block, err := ar.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return stats, err
+		}
+
+		switch ref := block.Ref.(type) {
+		case refs.FeedRef:
+			snk, err = s.verifyRouter.GetSink(ref, false)
+			if err != nil {
+				return stats, fmt.Errorf("importArchive: failed to get verification sink for %s: %w", ref.ShortSigil(), err)
+			}
+
+		case refs.MessageRef:
+			if snk == nil {
+				return stats, fmt.Errorf("message %s before the first feed: %w", ref.ShortSigil(), archive.ErrInvalid)
+			}
+
+			if block.Size > maxArchivedMessageSize {
+				stats.Invalid++
+				continue
+			}
+			raw := make([]byte, block.Size)
+			if _, err := io.ReadFull(block.Data, raw); err != nil {
+				return stats, fmt.Errorf("importArchive: failed to read message %s: %w", ref.ShortSigil(), err)
+			}
+
+			before := snk.Seq()
+			err = snk.Verify(raw)
+			if err != nil {
+				level.Debug(s.info).Log("event", "archive: invalid message", "msg", ref.ShortSigil(), "err", err)
+				stats.Invalid++
+			} else if snk.Seq() > before {
+				stats.Messages++
+			} else {
+				stats.Known++
+			}
+
+		case refs.BlobRef:
+			if _, err := s.BlobStore.Size(ref); err == nil {
+				stats.Known++
+				continue
+			}
+
+			// blobs are checked before they are stored, so that a wrong one can't replace anything
+			if block.Size > blobstore.DefaultMaxSize {
+				stats.Invalid++
+				continue
+			}
+			data := make([]byte, block.Size)
+			if _, err := io.ReadFull(block.Data, data); err != nil {
+				return stats, fmt.Errorf("importArchive: failed to read blob %s: %w", ref.ShortSigil(), err)
+			}
+			sum := sha256.Sum256(data)
+			if hashed, err := refs.NewBlobRefFromBytes(sum[:], refs.RefAlgoBlobSSB1); err != nil || !hashed.Equal(ref) {
+				stats.Invalid++
+				continue
+			}
+
+			if _, err := s.BlobStore.Put(bytes.NewReader(data)); err != nil {
+				return stats, fmt.Errorf("importArchive: failed to store blob %s: %w", ref.ShortSigil(), err)
+			}
+			stats.Blobs++
+
+		default:
+			return stats, errors.New("importArchive: unexpected block")
+		}
+	}
+
+	return stats, nil
+}
@@ -0,0 +1,170 @@
+// SPDX-FileCopyrightText: 2021 The Go-SSB Authors
+//
+// SPDX-License-Identifier: MIT
+
+package sbot
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ssbc/go-muxrpc/v2"
+	"github.com/stretchr/testify/require"
+	"go.mindeco.de/log"
+
+	"github.com/ssbc/go-ssb"
+	refs "github.com/ssbc/go-ssb-refs"
+	"github.com/ssbc/go-ssb/client"
+	"github.com/ssbc/go-ssb/internal/archive"
+	archiveplug "github.com/ssbc/go-ssb/plugins/archive"
+	"github.com/ssbc/go-ssb/repo"
+)
+
+func TestArchive(t *testing.T) {
+	r := require.New(t)
+
+	tRepoPath := filepath.Join("testrun", t.Name())
+	os.RemoveAll(tRepoPath)
+
+	logger := log.NewNopLogger()
+	if testing.Verbose() {
+		logger = log.NewLogfmtLogger(os.Stderr)
+	}
+
+	open := func(name string) (*Sbot, *client.Client) {
+		bot, err := New(
+			WithInfo(logger),
+			WithRepoPath(filepath.Join(tRepoPath, name)),
+			WithListenAddr(":0"),
+			LateOption(WithUNIXSocket()),
+		)
+		r.NoError(err)
+
+		c, err := client.NewUnix(filepath.Join(tRepoPath, name, "socket"))
+		r.NoError(err)
+		return bot, c
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	srcBot, srcClient := open("source")
+	srcRepo := repo.New(filepath.Join(tRepoPath, "source"))
+	arny, err := repo.NewKeyPair(srcRepo, "arny", refs.RefAlgoFeedSSB1)
+	r.NoError(err)
+	bert, err := repo.NewKeyPair(srcRepo, "bert", refs.RefAlgoFeedGabby)
+	r.NoError(err)
+
+	blobContent := strings.Repeat("archived blob ", 100)
+	blob, err := srcBot.BlobStore.Put(strings.NewReader(blobContent))
+	r.NoError(err)
+
+	var keys []refs.MessageRef
+	for i := 0; i < 3; i++ {
+		msg, err := srcBot.PublishAs("arny", map[string]interface{}{"type": "test", "i": i})
+		r.NoError(err)
+		keys = append(keys, msg.Key())
+		msg, err = srcBot.PublishAs("bert", map[string]interface{}{"type": "test", "i": i})
+		r.NoError(err)
+		keys = append(keys, msg.Key())
+	}
+	msg, err := srcBot.PublishLog.Publish(map[string]interface{}{"type": "post", "text": "look", "mentions": []interface{}{map[string]interface{}{"link": blob.String()}}})
+	r.NoError(err)
+	keys = append(keys, msg.Key())
+	srcBot.WaitUntilIndexesAreSynced()
+
+	feeds := []refs.FeedRef{arny.ID(), bert.ID(), srcBot.KeyPair.ID()}
+	var buf bytes.Buffer
+	stats, err := srcBot.ExportArchive(&buf, feeds, true)
+	r.NoError(err)
+	r.Equal(ssb.ArchiveStats{Messages: 7, Blobs: 1}, stats)
+	full := buf.Bytes()
+
+	// over rpc, with the feed of the bot by default
+	src, err := srcClient.Source(ctx, muxrpc.TypeBinary, muxrpc.Method{"archive", "export"}, archiveplug.ExportArgs{Blobs: true})
+	r.NoError(err)
+	ownArchive, err := ioutil.ReadAll(muxrpc.NewSourceReader(src))
+	r.NoError(err)
+
+	var own bytes.Buffer
+	stats, err = srcBot.ExportArchive(&own, feeds[2:], true)
+	r.NoError(err)
+	r.Equal(ssb.ArchiveStats{Messages: 1, Blobs: 1}, stats)
+	r.Equal(own.Bytes(), ownArchive)
+
+	unknown, err := refs.NewFeedRefFromBytes(bytes.Repeat([]byte{2}, 32), refs.RefAlgoFeedSSB1)
+	r.NoError(err)
+	_, err = srcBot.ExportArchive(ioutil.Discard, []refs.FeedRef{unknown}, false)
+	r.Error(err, "no messages of the feed")
+
+	r.NoError(srcClient.Close())
+	srcBot.Shutdown()
+	r.NoError(srcBot.Close())
+
+	dstBot, dstClient := open("destination")
+
+	importRPC := func(data []byte) ssb.ArchiveStats {
+		src, snk, err := dstClient.Duplex(ctx, muxrpc.TypeBinary, muxrpc.Method{"archive", "import"})
+		r.NoError(err)
+		_, err = muxrpc.NewSinkWriter(snk).Write(data)
+		r.NoError(err)
+
+		r.True(src.Next(ctx), "no reply: %v", src.Err())
+		reply, err := src.Bytes()
+		r.NoError(err)
+		var stats ssb.ArchiveStats
+		r.NoError(json.Unmarshal(reply, &stats))
+		return stats
+	}
+
+	r.Equal(ssb.ArchiveStats{Messages: 7, Blobs: 1}, importRPC(full))
+
+	stats, err = dstBot.ImportArchive(bytes.NewReader(full))
+	r.NoError(err)
+	r.Equal(ssb.ArchiveStats{Known: 8}, stats)
+
+	dstBot.WaitUntilIndexesAreSynced()
+	for _, key := range keys {
+		msg, err := dstBot.Get(key)
+		r.NoError(err)
+		r.True(msg.Key().Equal(key))
+	}
+	rd, err := dstBot.BlobStore.Get(blob)
+	r.NoError(err)
+	got, err := ioutil.ReadAll(rd)
+	r.NoError(err)
+	r.Equal(blobContent, string(got))
+
+	// a blob that doesn't match its reference isn't stored
+	otherBlob, err := refs.NewBlobRefFromBytes(bytes.Repeat([]byte{1}, 32), refs.RefAlgoBlobSSB1)
+	r.NoError(err)
+	var tampered bytes.Buffer
+	aw, err := archive.NewWriter(&tampered, archive.Header{Blobs: true})
+	r.NoError(err)
+	r.NoError(aw.WriteBlob(otherBlob, 7, strings.NewReader("tamper!")))
+	r.NoError(aw.Close())
+	stats, err = dstBot.ImportArchive(&tampered)
+	r.NoError(err)
+	r.Equal(ssb.ArchiveStats{Invalid: 1}, stats)
+	_, err = dstBot.BlobStore.Size(otherBlob)
+	r.Error(err)
+
+	_, err = dstBot.ImportArchive(strings.NewReader("\x05nope!"))
+	r.ErrorIs(err, archive.ErrInvalid)
+
+	// damaged archives are errors
+	_, err = dstBot.ImportArchive(io.LimitReader(bytes.NewReader(full), int64(len(full)-10)))
+	r.ErrorIs(err, archive.ErrInvalid)
+
+	r.NoError(dstClient.Close())
+	dstBot.Shutdown()
+	r.NoError(dstBot.Close())
+}
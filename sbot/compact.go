@@ -0,0 +1,888 @@
+// SPDX-FileCopyrightText: 2021 The Go-SSB Authors
+//
+// SPDX-License-Identifier: MIT
+
+package sbot
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/dgraph-io/badger/v3"
+	"github.com/dgraph-io/sroar"
+	"github.com/ssbc/go-luigi"
+	refs "github.com/ssbc/go-ssb-refs"
+	"github.com/ssbc/margaret"
+	"go.mindeco.de/log"
+	"go.mindeco.de/log/level"
+
+	"github.com/ssbc/go-ssb"
+	"github.com/ssbc/go-ssb/internal/feedsegments"
+	"github.com/ssbc/go-ssb/internal/storedrefs"
+	"github.com/ssbc/go-ssb/message/multimsg"
+	"github.com/ssbc/go-ssb/multilogs"
+	"github.com/ssbc/go-ssb/repo"
+)
+
+/* Compacting the receive log drops the entries that were nulled, which moves all the entries after them to smaller sequences.
+Everything that keeps receive log sequences has to be moved along:
+the sublogs of the multilogs, the get and search indexes, the declared indexes, the timestamps of the SequenceResolver
+and how far every index got. The statematrix and the feed segments only have feed sequences, which stay the same.
+
+It happens in two steps, which both work on copies in the compact folder of the repo:
+	1) stageCompaction copies the entries that aren't nulled into a new log. The bot can keep running while it does that.
+	2) finishCompaction runs when the bot starts, before anything else opens the log.
+	   It copies the entries that were appended after the staging, writes moved copies of the indexes
+	   and then swaps the copies in for the originals.
+
+The state file of the compaction says which step is done, so that a crash in between only means
+that the last step is done again. Until the swap starts the originals aren't touched.
+*/
+
+// compactDir is the folder in the repo where a compaction is prepared
+const compactDir = "compact"
+
+const (
+	compactionStaged = "staged"
+	compactionSwap   = "swap"
+)
+
+// compactionState is saved after each step of a compaction
+type compactionState struct {
+	Phase string
+
+	// Upto is the last entry of the old log that was staged. Kept are the ones of them that were copied.
+	Upto int64
+	Kept int64
+
+	// Online is set if the bot was running during the staging.
+	// The entries up to Upto might have been nulled after they were copied.
+	Online bool
+}
+
+// compactedStores are the paths in the repo that are replaced by their compacted copies
+var compactedStores = [][]string{
+	{"log"},
+	{repo.PrefixMultiLog, "shared-badger"},
+	{repo.PrefixMultiLog, "combined-state.json"},
+	{repo.PrefixMultiLog, multilogs.IndexNameQuery + "-state.json"},
+	{repo.PrefixIndex, "seqmaps"},
+	{feedsegments.FolderName, feedsegments.StateFile},
+}
+
+func compactPath(r repo.Interface, rel ...string) string {
+	return r.GetPath(append([]string{compactDir}, rel...)...)
+}
+
+// stagedPath is where the compacted copy of the store at rel is written to
+func stagedPath(r repo.Interface, rel ...string) string {
+	return compactPath(r, append([]string{"new"}, rel...)...)
+}
+
+var _ ssb.LogCompactor = (*Sbot)(nil)
+
+// CompactReceiveLog copies the receive log without its nulled entries while the bot keeps running.
+// The copy replaces the log, with the indexes moved to its sequences, the next time the bot starts.
+// A staged compaction that wasn't finished yet is replaced.
+func (s *Sbot) CompactReceiveLog() (ssb.CompactionStats, error) {
+	s.compactMu.Lock()
+	if s.compacting {
+		s.compactMu.Unlock()
+		return ssb.CompactionStats{}, fmt.Errorf("sbot: the receive log is already being compacted")
+	}
+	s.compacting = true
+	s.compactMu.Unlock()
+
+	defer func() {
+		s.compactMu.Lock()
+		s.compacting = false
+		s.compactMu.Unlock()
+	}()
+
+	stats, err := stageCompaction(s.storage(), s.ReceiveLog, true)
+	if err != nil {
+		return stats, err
+	}
+	level.Info(s.info).Log("event", "compaction staged", "kept", stats.Kept, "removed", stats.Removed, "reclaimed", stats.Reclaimed)
+	return stats, nil
+}
+
+// CompactReceiveLog rewrites the receive log of the repo at path without its nulled entries
+// and moves the indexes to the new sequences. The bot must not be running.
+// Like RebuildIndicies, it opens the repo with opts to let the indexes catch up afterwards.
+func CompactReceiveLog(path string, opts ...Option) (ssb.CompactionStats, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return ssb.CompactionStats{}, fmt.Errorf("CompactReceiveLog: failed to open repo: %w", err)
+	}
+	if !fi.IsDir() {
+		return ssb.CompactionStats{}, fmt.Errorf("CompactReceiveLog: repo path is not a directory")
+	}
+
+	r, _, err := unlockRepo(path, repoSecretOf(opts))
+	if err != nil {
+		return ssb.CompactionStats{}, fmt.Errorf("CompactReceiveLog: %w", err)
+	}
+	rxLog, err := repo.OpenLog(r)
+	if err != nil {
+		return ssb.CompactionStats{}, fmt.Errorf("CompactReceiveLog: %w", err)
+	}
+	stats, err := stageCompaction(r, rxLog, false)
+	if cerr := rxLog.Close(); err == nil && cerr != nil {
+		err = fmt.Errorf("CompactReceiveLog: failed to close receive log: %w", cerr)
+	}
+	if err != nil {
+		return stats, err
+	}
+
+	// opening the bot finishes the compaction
+	sbot, err := New(append([]Option{
+		DisableNetworkNode(),
+		WithRepoPath(path),
+		DisableLiveIndexMode(),
+	}, opts...)...)
+	if err != nil {
+		return stats, fmt.Errorf("CompactReceiveLog: failed to open sbot: %w", err)
+	}
+	if err := sbot.Close(); err != nil {
+		return stats, fmt.Errorf("CompactReceiveLog: failed to close sbot: %w", err)
+	}
+	return stats, nil
+}
+
+// stageCompaction copies the entries of rxLog that aren't nulled into the compact folder of r
+func stageCompaction(r repo.Interface, rxLog margaret.Log, online bool) (ssb.CompactionStats, error) {
+	var stats ssb.CompactionStats
+
+	err := os.RemoveAll(compactPath(r))
+	if err != nil {
+		return stats, fmt.Errorf("compaction: failed to remove previous compaction: %w", err)
+	}
+
+	oldSize := logSize(r.GetPath("log"))
+	upto := rxLog.Seq()
+
+	// the copy has the format of the log
+	format, err := repo.DetectLogFormat(r.GetPath("log"))
+	if err != nil {
+		return stats, fmt.Errorf("compaction: %w", err)
+	}
+
+	newLog, err := repo.OpenLogAtWithKey(stagedPath(r, "log"), format, repo.KeyOf(r))
+	if err != nil {
+		return stats, fmt.Errorf("compaction: failed to create new log: %w", err)
+	}
+
+	removed := sroar.NewBitmap()
+	err = copyEntries(rxLog, newLog, 0, upto, func(seq int64) { removed.Set(uint64(seq)) })
+	if cerr := newLog.Close(); err == nil && cerr != nil {
+		err = fmt.Errorf("compaction: failed to close new log: %w", cerr)
+	}
+	if err != nil {
+		return stats, err
+	}
+
+	err = writeFileSynced(compactPath(r, "removed"), removed.ToBuffer())
+	if err != nil {
+		return stats, fmt.Errorf("compaction: failed to save removed entries: %w", err)
+	}
+
+	stats.Removed = int64(removed.GetCardinality())
+	stats.Kept = upto + 1 - stats.Removed
+	stats.Reclaimed = oldSize - logSize(stagedPath(r, "log"))
+
+	err = saveCompactionState(r, compactionState{
+		Phase:  compactionStaged,
+		Upto:   upto,
+		Kept:   stats.Kept,
+		Online: online,
+	})
+	if err != nil {
+		return stats, err
+	}
+	return stats, nil
+}
+
+// copyEntries appends the entries start..upto of from to to. The sequences of nulled entries are passed to nulled.
+func copyEntries(from margaret.Log, to margaret.Log, start, upto int64, nulled func(int64)) error {
+	return eachEntry(from, start, upto, func(seq int64, mm *multimsg.MultiMessage) error {
+		if _, err := to.Append(mm); err != nil {
+			return fmt.Errorf("compaction: failed to copy entry %d: %w", seq, err)
+		}
+		return nil
+	}, nulled)
+}
+
+// eachEntry calls fn for the entries start..upto of rxLog and nulled for the ones that were nulled
+func eachEntry(rxLog margaret.Log, start, upto int64, fn func(int64, *multimsg.MultiMessage) error, nulled func(int64)) error {
+	if upto < start {
+		return nil
+	}
+	src, err := rxLog.Query(margaret.Gte(start), margaret.Lte(upto))
+	if err != nil {
+		return fmt.Errorf("compaction: failed to query receive log: %w", err)
+	}
+
+	ctx := context.Background()
+	// nulled entries come without their sequence, they are counted instead
+	for seq := start; ; seq++ {
+		v, err := src.Next(ctx)
+		if err != nil {
+			if luigi.IsEOS(err) {
+				return nil
+			}
+			return fmt.Errorf("compaction: failed to read entry %d: %w", seq, err)
+		}
+
+		if errv, ok := v.(error); ok {
+			if margaret.IsErrNulled(errv) {
+				nulled(seq)
+				continue
+			}
+			return fmt.Errorf("compaction: failed to read entry %d: %w", seq, errv)
+		}
+
+		mm, ok := v.(*multimsg.MultiMessage)
+		if !ok {
+			return fmt.Errorf("compaction: unexpected entry %d: %T", seq, v)
+		}
+		if err := fn(seq, mm); err != nil {
+			return err
+		}
+	}
+}
+
+// finishCompaction completes a staged compaction of the repo r, see stageCompaction.
+// self is needed to find the sublogs of the private contacts.
+func finishCompaction(r repo.Interface, self refs.FeedRef, logger log.Logger) error {
+	if _, err := os.Stat(compactPath(r)); os.IsNotExist(err) {
+		return nil
+	}
+
+	state, err := loadCompactionState(r)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			return err
+		}
+		// the staging didn't finish
+		level.Warn(logger).Log("event", "dropping incomplete compaction")
+		return os.RemoveAll(compactPath(r))
+	}
+
+	if state.Phase == compactionStaged {
+		reset, err := remapCompaction(r, state, self)
+		if err != nil {
+			return err
+		}
+		if len(reset) > 0 {
+			level.Warn(logger).Log("event", "compaction", "msg", "indexes start over", "prefixes", fmt.Sprint(reset))
+		}
+
+		state.Phase = compactionSwap
+		if err := saveCompactionState(r, state); err != nil {
+			return err
+		}
+	}
+
+	if state.Phase != compactionSwap {
+		return fmt.Errorf("compaction: unknown phase %q", state.Phase)
+	}
+
+	for _, rel := range compactedStores {
+		err := swapStore(r, rel)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = os.RemoveAll(compactPath(r))
+	if err != nil {
+		return fmt.Errorf("compaction: failed to remove old files: %w", err)
+	}
+	level.Info(logger).Log("event", "compaction finished")
+	return nil
+}
+
+// remapCompaction completes the staged log and writes the moved copies of the indexes.
+// It returns the prefixes of the indexes that have to start over, because their progress can't be moved.
+func remapCompaction(r repo.Interface, state compactionState, self refs.FeedRef) ([]string, error) {
+	removedData, err := ioutil.ReadFile(compactPath(r, "removed"))
+	if err != nil {
+		return nil, fmt.Errorf("compaction: failed to load removed entries: %w", err)
+	}
+	removed := sroar.FromBufferWithCopy(removedData)
+
+	oldLog, err := repo.OpenLog(r)
+	if err != nil {
+		return nil, fmt.Errorf("compaction: failed to open receive log: %w", err)
+	}
+	defer oldLog.Close()
+
+	newLog, err := repo.OpenLogAtWithKey(stagedPath(r, "log"), "", repo.KeyOf(r))
+	if err != nil {
+		return nil, fmt.Errorf("compaction: failed to open new log: %w", err)
+	}
+
+	err = completeLog(oldLog, newLog, state, removed)
+	if cerr := newLog.Close(); err == nil && cerr != nil {
+		err = fmt.Errorf("compaction: failed to close new log: %w", cerr)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	m := newSeqMap(removed)
+
+	reset, err := remapSharedIndexes(r, m, self)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, name := range []string{"combined-state.json", multilogs.IndexNameQuery + "-state.json"} {
+		err := remapStateFile(r, m, repo.PrefixMultiLog, name)
+		if err != nil {
+			return nil, err
+		}
+	}
+	// only the progress of the feed segments refers to the receive log
+	err = remapStateFile(r, m, feedsegments.FolderName, feedsegments.StateFile)
+	if err != nil {
+		return nil, err
+	}
+
+	err = remapSeqResolver(r, m)
+	if err != nil {
+		return nil, err
+	}
+
+	err = syncTree(compactPath(r, "new"))
+	if err != nil {
+		return nil, fmt.Errorf("compaction: failed to sync new files: %w", err)
+	}
+	return reset, nil
+}
+
+// completeLog copies the entries that were appended to oldLog after the staging.
+// If the bot was running, it also nulls the copies of entries that were nulled after they were copied.
+// Since oldLog doesn't change anymore, it can be done again after a crash: the entries that are already there are skipped.
+func completeLog(oldLog, newLog multimsg.AlterableLog, state compactionState, removed *sroar.Bitmap) error {
+	// the entries after Upto that were already copied
+	copied := newLog.Seq() + 1 - state.Kept
+
+	var kept int64
+	err := eachEntry(oldLog, state.Upto+1, oldLog.Seq(), func(seq int64, mm *multimsg.MultiMessage) error {
+		if kept++; kept <= copied {
+			return nil
+		}
+		if _, err := newLog.Append(mm); err != nil {
+			return fmt.Errorf("compaction: failed to copy entry %d: %w", seq, err)
+		}
+		return nil
+	}, func(seq int64) { removed.Set(uint64(seq)) })
+	if err != nil {
+		return err
+	}
+
+	if !state.Online {
+		return nil
+	}
+
+	var lateNulls []int64
+	err = eachEntry(oldLog, 0, state.Upto, func(int64, *multimsg.MultiMessage) error { return nil }, func(seq int64) {
+		if !removed.Contains(uint64(seq)) {
+			lateNulls = append(lateNulls, seq)
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	m := newSeqMap(removed)
+	for _, seq := range lateNulls {
+		newSeq, _ := m.Map(seq)
+		if err := newLog.Null(newSeq); err != nil {
+			return fmt.Errorf("compaction: failed to null copy of entry %d: %w", seq, err)
+		}
+	}
+	return nil
+}
+
+// swapStore replaces the store at rel with its compacted copy, if there is one.
+// The original is moved into the compact folder first, so that it can be done again after a crash.
+func swapStore(r repo.Interface, rel []string) error {
+	staged := stagedPath(r, rel...)
+	if _, err := os.Stat(staged); os.IsNotExist(err) {
+		return nil
+	}
+
+	orig := r.GetPath(rel...)
+	if _, err := os.Stat(orig); err == nil {
+		old := compactPath(r, append([]string{"old"}, rel...)...)
+		if err := os.MkdirAll(filepath.Dir(old), 0700); err != nil {
+			return fmt.Errorf("compaction: failed to make room for old %s: %w", filepath.Join(rel...), err)
+		}
+		if err := os.Rename(orig, old); err != nil {
+			return fmt.Errorf("compaction: failed to move old %s: %w", filepath.Join(rel...), err)
+		}
+	}
+
+	if err := os.MkdirAll(filepath.Dir(orig), 0700); err != nil {
+		return fmt.Errorf("compaction: failed to make room for %s: %w", filepath.Join(rel...), err)
+	}
+	if err := os.Rename(staged, orig); err != nil {
+		return fmt.Errorf("compaction: failed to move %s in place: %w", filepath.Join(rel...), err)
+	}
+	return syncDir(filepath.Dir(orig))
+}
+
+// seqMap moves the sequences of the old receive log to the compacted one
+type seqMap struct {
+	removed []uint64 // sorted
+}
+
+func newSeqMap(removed *sroar.Bitmap) seqMap {
+	return seqMap{removed: removed.ToArray()}
+}
+
+// removedUpTo returns how many entries up to and including seq were removed
+func (m seqMap) removedUpTo(seq int64) int64 {
+	return int64(sort.Search(len(m.removed), func(i int) bool { return m.removed[i] > uint64(seq) }))
+}
+
+func (m seqMap) isRemoved(seq int64) bool {
+	i := sort.Search(len(m.removed), func(i int) bool { return m.removed[i] >= uint64(seq) })
+	return i < len(m.removed) && m.removed[i] == uint64(seq)
+}
+
+// Map returns the new sequence of the entry seq, or false if it was removed
+func (m seqMap) Map(seq int64) (int64, bool) {
+	if m.isRemoved(seq) {
+		return margaret.SeqEmpty, false
+	}
+	return seq - m.removedUpTo(seq), true
+}
+
+// Progress moves the last sequence an index processed.
+// If that entry was removed, it becomes the last one before it that was kept.
+func (m seqMap) Progress(seq int64) int64 {
+	if seq < 0 {
+		return seq
+	}
+	return seq - m.removedUpTo(seq)
+}
+
+// SublogProgress moves the last position an index processed in the sublog bm
+func (m seqMap) SublogProgress(bm *sroar.Bitmap, pos int64) int64 {
+	if pos < 0 {
+		return pos
+	}
+	if n := int64(bm.GetCardinality()); pos >= n {
+		pos = n - 1
+	}
+
+	var kept int64
+	it := bm.NewIterator()
+	for i := int64(0); i <= pos; i++ {
+		if !m.isRemoved(int64(it.Next())) {
+			kept++
+		}
+	}
+	return kept - 1
+}
+
+// Bitmap returns the new sequences of the entries in bm that were kept
+func (m seqMap) Bitmap(bm *sroar.Bitmap) *sroar.Bitmap {
+	seqs := bm.ToArray()
+	moved := seqs[:0]
+	for _, seq := range seqs {
+		if newSeq, ok := m.Map(int64(seq)); ok {
+			moved = append(moved, uint64(newSeq))
+		}
+	}
+	return sroar.FromSortedList(moved)
+}
+
+/*
+	the keys of the shared badger that have receive log sequences
+
+They are spread over a few packages, which each own their prefix. Indexes that aren't listed here keep their values
+but start over, like RebuildIndex does it, since their progress can't be moved without knowing what they read.
+*/
+var (
+	// libbadger saves the progress of an index under its prefix with this suffix, as a big endian uint64
+	progressSuffix = []byte("__current_observable")
+
+	// multibadger.NewShared saves a sroar bitmap per sublog under its prefix
+	sublogPrefixes = [][]byte{
+		[]byte("mlog-"),               // the default multilogs
+		[]byte("group-member-helper"), // only has group/add-member
+		[]byte("decl-bmap-"),          // the sublogs of the declared indexes
+	}
+
+	getIdxPrefix      = []byte("byMsgRef")          // the get index, values are the json encoded sequences
+	searchTermsPrefix = []byte("idx-search" + "t:") // key ends with the big endian sequence
+	declKVPrefix      = []byte("decl-kv-")          // the latest sequence per value, big endian
+	declStatePrefix   = []byte("decl-state:")       // the progress of a declared index, big endian
+	rxProgress        = []string{"byMsgRef", "idx-search"}
+)
+
+// sublogIndex is an index that reads a sublog, which is where its progress is
+type sublogIndex struct {
+	mlog string
+	addr string
+}
+
+func sublogIndexes(self refs.FeedRef) map[string]sublogIndex {
+	return map[string]sublogIndex{
+		// contacts, also the metafeed ones which share the prefix
+		"trust-graph":         {"mlog-msgTypes", "string:contact"},
+		"private-trust/box1/": {"mlog-" + multilogs.IndexNamePrivates, "box1:" + string(storedrefs.Feed(self))},
+		"private-trust/box2/": {"mlog-" + multilogs.IndexNamePrivates, "box2:" + string(storedrefs.Feed(self))},
+		"idx-abouts":          {"mlog-msgTypes", "string:about"},
+		"group-members":       {"group-member-helper", "string:group/add-member"},
+	}
+}
+
+// remapSharedIndexes writes a copy of the shared badger with the sequences moved by m
+func remapSharedIndexes(r repo.Interface, m seqMap, self refs.FeedRef) ([]string, error) {
+	origPath := r.GetPath(repo.PrefixMultiLog, "shared-badger")
+	if _, err := os.Stat(origPath); os.IsNotExist(err) {
+		return nil, nil
+	}
+
+	newPath := stagedPath(r, repo.PrefixMultiLog, "shared-badger")
+	if err := os.RemoveAll(newPath); err != nil {
+		return nil, fmt.Errorf("compaction: failed to remove previous copy of shared indexes: %w", err)
+	}
+
+	orig, err := repo.OpenBadgerDBWithKey(origPath, repo.KeyOf(r))
+	if err != nil {
+		return nil, fmt.Errorf("compaction: failed to open shared indexes: %w", err)
+	}
+	defer orig.Close()
+
+	copied, err := repo.OpenBadgerDBWithKey(newPath, repo.KeyOf(r))
+	if err != nil {
+		return nil, fmt.Errorf("compaction: failed to create shared indexes: %w", err)
+	}
+
+	reset, err := copySharedIndexes(orig, copied, m, self)
+	if cerr := copied.Close(); err == nil && cerr != nil {
+		err = fmt.Errorf("compaction: failed to close shared indexes: %w", cerr)
+	}
+	return reset, err
+}
+
+func copySharedIndexes(orig, copied *badger.DB, m seqMap, self refs.FeedRef) ([]string, error) {
+	// the sublogs the indexes read, before they are moved
+	sublogs := make(map[string]*sroar.Bitmap)
+	byPrefix := sublogIndexes(self)
+	err := orig.View(func(txn *badger.Txn) error {
+		for prefix, si := range byPrefix {
+			item, err := txn.Get([]byte(si.mlog + si.addr))
+			if errors.Is(err, badger.ErrKeyNotFound) {
+				continue
+			} else if err != nil {
+				return err
+			}
+			data, err := item.ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+			sublogs[prefix] = sroar.FromBuffer(data)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("compaction: failed to load sublogs of indexes: %w", err)
+	}
+
+	var reset []string
+	wb := copied.NewWriteBatch()
+	defer wb.Cancel()
+
+	err = orig.View(func(txn *badger.Txn) error {
+		iter := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer iter.Close()
+
+		for iter.Rewind(); iter.Valid(); iter.Next() {
+			item := iter.Item()
+			key := item.KeyCopy(nil)
+			val, err := item.ValueCopy(nil)
+			if err != nil {
+				return fmt.Errorf("failed to read %q: %w", key, err)
+			}
+
+			switch {
+			case bytes.HasSuffix(key, progressSuffix) && len(key) > len(progressSuffix) && len(val) == 8:
+				prefix := string(bytes.TrimSuffix(key, progressSuffix))
+				seq := binary.BigEndian.Uint64(val)
+				if seq > math.MaxInt64 {
+					// nothing processed yet
+					break
+				}
+
+				var moved int64
+				if _, has := byPrefix[prefix]; has {
+					bm, has := sublogs[prefix]
+					if !has {
+						bm = sroar.NewBitmap()
+					}
+					moved = m.SublogProgress(bm, int64(seq))
+				} else if contains(rxProgress, prefix) {
+					moved = m.Progress(int64(seq))
+				} else {
+					reset = append(reset, prefix)
+					continue
+				}
+				if moved < 0 {
+					continue
+				}
+				binary.BigEndian.PutUint64(val, uint64(moved))
+
+			case hasAnyPrefix(key, sublogPrefixes):
+				val = m.Bitmap(sroar.FromBuffer(val)).ToBuffer()
+
+			case bytes.HasPrefix(key, getIdxPrefix):
+				var seq int64
+				if err := json.Unmarshal(val, &seq); err != nil {
+					return fmt.Errorf("broken get index entry %q: %w", key, err)
+				}
+				newSeq, ok := m.Map(seq)
+				if !ok {
+					continue
+				}
+				val, err = json.Marshal(newSeq)
+				if err != nil {
+					return err
+				}
+
+			case bytes.HasPrefix(key, searchTermsPrefix) && len(key) >= len(searchTermsPrefix)+9:
+				seqBytes := key[len(key)-8:]
+				newSeq, ok := m.Map(int64(binary.BigEndian.Uint64(seqBytes)))
+				if !ok {
+					continue
+				}
+				binary.BigEndian.PutUint64(seqBytes, uint64(newSeq))
+
+			case bytes.HasPrefix(key, declKVPrefix) && len(val) == 8:
+				newSeq, ok := m.Map(int64(binary.BigEndian.Uint64(val)))
+				if !ok {
+					continue
+				}
+				binary.BigEndian.PutUint64(val, uint64(newSeq))
+
+			case bytes.HasPrefix(key, declStatePrefix) && len(val) == 8:
+				seq := binary.BigEndian.Uint64(val)
+				if seq > math.MaxInt64 {
+					break
+				}
+				moved := m.Progress(int64(seq))
+				if moved < 0 {
+					continue
+				}
+				binary.BigEndian.PutUint64(val, uint64(moved))
+			}
+
+			if err := wb.Set(key, val); err != nil {
+				return fmt.Errorf("failed to write %q: %w", key, err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("compaction: failed to copy shared indexes: %w", err)
+	}
+
+	if err := wb.Flush(); err != nil {
+		return nil, fmt.Errorf("compaction: failed to write shared indexes: %w", err)
+	}
+	return reset, nil
+}
+
+func hasAnyPrefix(key []byte, prefixes [][]byte) bool {
+	for _, p := range prefixes {
+		if bytes.HasPrefix(key, p) {
+			return true
+		}
+	}
+	return false
+}
+
+func contains(list []string, s string) bool {
+	for _, e := range list {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
+
+// remapStateFile copies the json encoded progress of an index that reads the whole receive log, like the combined index
+func remapStateFile(r repo.Interface, m seqMap, rel ...string) error {
+	data, err := ioutil.ReadFile(r.GetPath(rel...))
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("compaction: failed to read %s: %w", filepath.Join(rel...), err)
+	}
+
+	var seq int64
+	if len(bytes.TrimSpace(data)) > 0 {
+		if err := json.Unmarshal(data, &seq); err != nil {
+			return fmt.Errorf("compaction: broken state in %s: %w", filepath.Join(rel...), err)
+		}
+		data, err = json.MarshalIndent(m.Progress(seq), "", "\t")
+		if err != nil {
+			return err
+		}
+	}
+
+	newPath := stagedPath(r, rel...)
+	if err := os.MkdirAll(filepath.Dir(newPath), 0700); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(newPath, data, 0700)
+}
+
+// remapSeqResolver copies the arrays of the SequenceResolver without the removed entries
+func remapSeqResolver(r repo.Interface, m seqMap) error {
+	origDir := r.GetPath(repo.PrefixIndex, "seqmaps")
+	if _, err := os.Stat(origDir); os.IsNotExist(err) {
+		return nil
+	}
+
+	newDir := stagedPath(r, repo.PrefixIndex, "seqmaps")
+	if err := os.RemoveAll(newDir); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(newDir, 0700); err != nil {
+		return err
+	}
+
+	names, err := ioutil.ReadDir(origDir)
+	if err != nil {
+		return fmt.Errorf("compaction: failed to list sequence maps: %w", err)
+	}
+	for _, fi := range names {
+		data, err := ioutil.ReadFile(filepath.Join(origDir, fi.Name()))
+		if err != nil {
+			return fmt.Errorf("compaction: failed to read sequence map %s: %w", fi.Name(), err)
+		}
+
+		// one big endian int64 per entry of the receive log
+		moved := make([]byte, 0, len(data))
+		for seq := 0; seq+8 <= len(data); seq += 8 {
+			if m.isRemoved(int64(seq / 8)) {
+				continue
+			}
+			moved = append(moved, data[seq:seq+8]...)
+		}
+
+		err = ioutil.WriteFile(filepath.Join(newDir, fi.Name()), moved, 0700)
+		if err != nil {
+			return fmt.Errorf("compaction: failed to write sequence map %s: %w", fi.Name(), err)
+		}
+	}
+	return nil
+}
+
+func loadCompactionState(r repo.Interface) (compactionState, error) {
+	var state compactionState
+	data, err := ioutil.ReadFile(compactPath(r, "state.json"))
+	if err != nil {
+		return state, err
+	}
+	if err := json.Unmarshal(data, &state); err != nil {
+		return state, fmt.Errorf("compaction: broken state: %w", err)
+	}
+	return state, nil
+}
+
+func saveCompactionState(r repo.Interface, state compactionState) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	if err := writeFileSynced(compactPath(r, "state.json"), data); err != nil {
+		return fmt.Errorf("compaction: failed to save state: %w", err)
+	}
+	return nil
+}
+
+// writeFileSynced replaces the file at path with data, which is on disk once it returns
+func writeFileSynced(path string, data []byte) error {
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+	return syncDir(filepath.Dir(path))
+}
+
+// syncTree makes sure all the files below dir are on disk
+func syncTree(dir string) error {
+	return filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return syncDir(path)
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		err = f.Sync()
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		return err
+	})
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	err = d.Sync()
+	if cerr := d.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// logSize is how many bytes the files of the log at path take, whichever format it has
+func logSize(path string) int64 {
+	var size int64
+	filepath.Walk(path, func(_ string, fi os.FileInfo, err error) error {
+		if err == nil && fi.Mode().IsRegular() {
+			size += fi.Size()
+		}
+		return nil
+	})
+	return size
+}
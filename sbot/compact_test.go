@@ -0,0 +1,180 @@
+// SPDX-FileCopyrightText: 2021 The Go-SSB Authors
+//
+// SPDX-License-Identifier: MIT
+
+package sbot
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ssbc/margaret"
+	librarian "github.com/ssbc/margaret/indexes"
+	"github.com/stretchr/testify/require"
+	"go.mindeco.de/log"
+
+	"github.com/ssbc/go-ssb"
+	refs "github.com/ssbc/go-ssb-refs"
+	"github.com/ssbc/go-ssb/internal/storedrefs"
+	"github.com/ssbc/go-ssb/repo"
+)
+
+func TestCompactReceiveLog(t *testing.T) {
+	r := require.New(t)
+
+	tRepoPath := filepath.Join("testrun", t.Name())
+	os.RemoveAll(tRepoPath)
+	tRepo := repo.New(tRepoPath)
+
+	logger := log.NewNopLogger()
+	if testing.Verbose() {
+		logger = log.NewLogfmtLogger(os.Stderr)
+	}
+
+	kps := make(map[string]ssb.KeyPair)
+	for name, algo := range map[string]refs.RefAlgo{
+		"arny": refs.RefAlgoFeedSSB1,
+		"bert": refs.RefAlgoFeedGabby,
+		"cher": refs.RefAlgoFeedSSB1,
+	} {
+		kp, err := repo.NewKeyPair(tRepo, name, algo)
+		r.NoError(err)
+		kps[name] = kp
+	}
+
+	open := func() *Sbot {
+		bot, err := New(
+			WithInfo(logger),
+			WithRepoPath(tRepoPath),
+			DisableNetworkNode(),
+		)
+		r.NoError(err)
+		return bot
+	}
+	mainbot := open()
+
+	test := func(i int) map[string]interface{} {
+		return map[string]interface{}{"type": "test", "i": i}
+	}
+	publishAs := func(bot *Sbot, who string, content interface{}) refs.Message {
+		msg, err := bot.PublishAs(who, content)
+		r.NoError(err)
+		return msg
+	}
+
+	publishAs(mainbot, "arny", refs.NewAboutName(kps["arny"].ID(), "arny"))
+	for i := 0; i < 3; i++ {
+		publishAs(mainbot, "bert", test(i))
+	}
+	publishAs(mainbot, "bert", refs.NewContactFollow(kps["arny"].ID()))
+	publishAs(mainbot, "cher", test(0))
+	publishAs(mainbot, "arny", test(1))
+	_, err := mainbot.PublishLog.Publish(refs.NewContactFollow(kps["arny"].ID()))
+	r.NoError(err)
+	publishAs(mainbot, "arny", test(2))
+	mainbot.WaitUntilIndexesAreSynced()
+
+	r.NoError(mainbot.NullFeed(kps["bert"].ID()))
+
+	stats, err := mainbot.CompactReceiveLog()
+	r.NoError(err)
+	r.EqualValues(4, stats.Removed)
+	r.EqualValues(5, stats.Kept)
+	r.True(stats.Reclaimed > 0)
+
+	// a message that is appended after the staging and one that is nulled after it was copied
+	tail := publishAs(mainbot, "arny", test(3))
+	mainbot.WaitUntilIndexesAreSynced()
+	r.NoError(mainbot.NullFeed(kps["cher"].ID()))
+
+	oldSize := logSize(tRepo.GetPath("log"))
+	mainbot.Shutdown()
+	r.NoError(mainbot.Close())
+
+	// the next start swaps in the compacted log and indexes
+	mainbot = open()
+	mainbot.WaitUntilIndexesAreSynced()
+
+	_, err = os.Stat(compactPath(tRepo))
+	r.True(os.IsNotExist(err), "compaction not cleaned up")
+	r.True(logSize(tRepo.GetPath("log")) < oldSize)
+
+	r.EqualValues(5, mainbot.ReceiveLog.Seq(), "expected 6 messages left")
+	_, err = mainbot.ReceiveLog.Get(1)
+	r.True(margaret.IsErrNulled(err), "cher was nulled after the staging")
+
+	// the sublogs point to the right messages
+	checkFeed := func(who refs.FeedRef, n int64) {
+		seqs, err := mainbot.Users.Get(storedrefs.Feed(who))
+		r.NoError(err)
+		r.EqualValues(n-1, seqs.Seq(), "wrong length of %s", who.ShortSigil())
+		for i := int64(0); i < n; i++ {
+			rxSeq, err := seqs.Get(i)
+			r.NoError(err)
+			msg, err := mainbot.ReceiveLog.Get(rxSeq.(int64))
+			r.NoError(err)
+			r.True(msg.(refs.Message).Author().Equal(who))
+			r.EqualValues(i+1, msg.(refs.Message).Seq())
+		}
+	}
+	checkFeed(kps["arny"].ID(), 4)
+	checkFeed(mainbot.KeyPair.ID(), 1)
+
+	testSeqs, err := mainbot.ByType.Get(librarian.Addr("string:test"))
+	r.NoError(err)
+	// nulling doesn't remove them from the sublog, so cher's is still there
+	r.EqualValues(3, testSeqs.Seq(), "arny's test messages and cher's but not the ones of bert")
+
+	// the get and timestamp indexes
+	got, err := mainbot.Get(tail.Key())
+	r.NoError(err)
+	r.True(got.Key().Equal(tail.Key()))
+	r.EqualValues(mainbot.ReceiveLog.Seq()+1, mainbot.SeqResolver.Seq())
+
+	g, err := mainbot.GraphBuilder.Build()
+	r.NoError(err)
+	r.True(g.Follows(mainbot.KeyPair.ID(), kps["arny"].ID()))
+
+	// the indexes continue where they were
+	publishAs(mainbot, "arny", test(4))
+	mainbot.WaitUntilIndexesAreSynced()
+	r.EqualValues(4, testSeqs.Seq())
+	checkFeed(kps["arny"].ID(), 5)
+
+	mainbot.Shutdown()
+	r.NoError(mainbot.Close())
+
+	// an incomplete staging is dropped
+	r.NoError(os.MkdirAll(stagedPath(tRepo, "log"), 0700))
+	mainbot = open()
+	_, err = os.Stat(compactPath(tRepo))
+	r.True(os.IsNotExist(err))
+	r.EqualValues(6, mainbot.ReceiveLog.Seq())
+
+	// a crash in the middle of the swap
+	publishAs(mainbot, "bert", test(5))
+	mainbot.WaitUntilIndexesAreSynced()
+	r.NoError(mainbot.NullFeed(kps["bert"].ID()))
+	_, err = mainbot.CompactReceiveLog()
+	r.NoError(err)
+	mainbot.Shutdown()
+	r.NoError(mainbot.Close())
+
+	state, err := loadCompactionState(tRepo)
+	r.NoError(err)
+	_, err = remapCompaction(tRepo, state, mainbot.KeyPair.ID())
+	r.NoError(err)
+	state.Phase = compactionSwap
+	r.NoError(saveCompactionState(tRepo, state))
+	r.NoError(swapStore(tRepo, compactedStores[0]))
+
+	mainbot = open()
+	mainbot.WaitUntilIndexesAreSynced()
+	r.EqualValues(5, mainbot.ReceiveLog.Seq(), "bert's new message and cher's are gone")
+	checkFeed(kps["arny"].ID(), 5)
+	checkFeed(mainbot.KeyPair.ID(), 1)
+
+	mainbot.Shutdown()
+	r.NoError(mainbot.Close())
+}
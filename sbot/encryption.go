@@ -0,0 +1,63 @@
+// SPDX-FileCopyrightText: 2021 The Go-SSB Authors
+//
+// SPDX-License-Identifier: MIT
+
+package sbot
+
+import (
+	"github.com/ssbc/go-ssb/repo"
+)
+
+// unlockRepo returns the repo of the bot with its data key if it is encrypted.
+// A new repo is encrypted if the bot has a secret.
+func (s *Sbot) unlockRepo() (repo.Interface, error) {
+	r, key, err := unlockRepo(s.repoPath, s.repoSecret)
+	if err != nil {
+		return nil, err
+	}
+	s.repoKey = key
+	return r, nil
+}
+
+// storage returns the repo of the bot with its data key, like unlockRepo did
+func (s *Sbot) storage() repo.Interface {
+	return repo.WithKey(repo.New(s.repoPath), s.repoKey)
+}
+
+func unlockRepo(path string, secret []byte) (repo.Interface, *repo.DataKey, error) {
+	r := repo.New(path)
+
+	encrypted, err := repo.IsEncrypted(r)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var key *repo.DataKey
+	switch {
+	case encrypted && secret == nil:
+		return nil, nil, repo.ErrRepoLocked
+	case encrypted:
+		key, err = repo.UnlockEncryption(r, secret)
+	case secret != nil:
+		key, err = repo.InitEncryption(r, secret)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	return repo.WithKey(r, key), key, nil
+}
+
+// repoSecretOf returns the secret that opts set with WithRepoSecret.
+// The functions that open a repo before they start a bot with opts use it.
+// Errors of the options are left to New, which applies them again.
+func repoSecretOf(opts []Option) []byte {
+	var probe Sbot
+	for _, opt := range opts {
+		opt(&probe)
+	}
+	if probe.Shutdown != nil {
+		// WithContext derives a context
+		probe.Shutdown()
+	}
+	return probe.repoSecret
+}
@@ -0,0 +1,199 @@
+// SPDX-FileCopyrightText: 2021 The Go-SSB Authors
+//
+// SPDX-License-Identifier: MIT
+
+package sbot
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.mindeco.de/log"
+
+	"github.com/ssbc/go-ssb"
+	refs "github.com/ssbc/go-ssb-refs"
+	"github.com/ssbc/go-ssb/repo"
+)
+
+func TestEncryptedRepo(t *testing.T) {
+	r := require.New(t)
+
+	tRepoPath := filepath.Join("testrun", t.Name())
+	os.RemoveAll(tRepoPath)
+
+	logger := log.NewNopLogger()
+	if testing.Verbose() {
+		logger = log.NewLogfmtLogger(os.Stderr)
+	}
+
+	secret := []byte("correct horse battery staple")
+	open := func(path string, opts ...Option) (*Sbot, error) {
+		return New(append([]Option{
+			WithInfo(logger),
+			WithRepoPath(path),
+			DisableNetworkNode(),
+			EnableFeedSegments(),
+		}, opts...)...)
+	}
+
+	// publishes a post with marker in it and returns its key
+	marker := []byte("not for the disk")
+	publish := func(bot *Sbot) refs.MessageRef {
+		msg, err := bot.PublishLog.Publish(map[string]interface{}{"type": "post", "text": string(marker)})
+		r.NoError(err)
+		_, err = bot.PublishLog.Publish(refs.NewContactFollow(bot.KeyPair.ID()))
+		r.NoError(err)
+		bot.WaitUntilIndexesAreSynced()
+		return msg.Key()
+	}
+
+	// returns the files of the repo that have marker in them
+	plaintextFiles := func(path string) []string {
+		var files []string
+		err := filepath.Walk(path, func(p string, info os.FileInfo, err error) error {
+			if err != nil || info.IsDir() {
+				return err
+			}
+			data, err := os.ReadFile(p)
+			if err != nil {
+				return err
+			}
+			if bytes.Contains(data, marker) {
+				files = append(files, p)
+			}
+			return nil
+		})
+		r.NoError(err)
+		return files
+	}
+	checkEncrypted := func(path string) {
+		r.Empty(plaintextFiles(path))
+	}
+
+	livePath := filepath.Join(tRepoPath, "live")
+	bot, err := open(livePath, WithRepoSecret(secret))
+	r.NoError(err)
+	post := publish(bot)
+
+	snapDir, err := filepath.Abs(filepath.Join(tRepoPath, "snapshot"))
+	r.NoError(err)
+	_, err = bot.Snapshot(snapDir)
+	r.NoError(err)
+
+	bot.Shutdown()
+	r.NoError(bot.Close())
+	checkEncrypted(livePath)
+	checkEncrypted(snapDir)
+
+	_, err = open(livePath)
+	r.ErrorIs(err, repo.ErrRepoLocked)
+	_, err = open(livePath, WithRepoSecret([]byte("wrong")))
+	r.ErrorIs(err, repo.ErrWrongSecret)
+
+	bot, err = open(livePath, WithRepoSecret(secret))
+	r.NoError(err)
+	bot.WaitUntilIndexesAreSynced()
+	msg, err := bot.Get(post)
+	r.NoError(err)
+	r.True(msg.Key().Equal(post))
+	bot.Shutdown()
+	r.NoError(bot.Close())
+
+	// the snapshot of an encrypted repo needs the secret as well
+	restoredPath := filepath.Join(tRepoPath, "restored")
+	_, err = RestoreSnapshot(snapDir, restoredPath, FSCKModeSequences, WithInfo(logger))
+	r.ErrorIs(err, repo.ErrRepoLocked)
+	_, err = RestoreSnapshot(snapDir, restoredPath, FSCKModeSequences, WithInfo(logger), WithRepoSecret(secret))
+	r.NoError(err)
+	checkEncrypted(restoredPath)
+
+	// a repo that isn't encrypted is encrypted by restoring a snapshot of it with a secret
+	plainPath := filepath.Join(tRepoPath, "plain")
+	bot, err = open(plainPath)
+	r.NoError(err)
+	post = publish(bot)
+	plainSnap, err := filepath.Abs(filepath.Join(tRepoPath, "plain-snapshot"))
+	r.NoError(err)
+	_, err = bot.Snapshot(plainSnap)
+	r.NoError(err)
+	bot.Shutdown()
+	r.NoError(bot.Close())
+
+	r.NotEmpty(plaintextFiles(plainPath))
+	_, err = open(plainPath, WithRepoSecret(secret))
+	r.Error(err, "opened a repo that isn't encrypted with a secret")
+
+	encryptedPath := filepath.Join(tRepoPath, "encrypted")
+	_, err = RestoreSnapshot(plainSnap, encryptedPath, FSCKModeVerify, WithInfo(logger), WithRepoSecret(secret))
+	r.NoError(err)
+	checkEncrypted(encryptedPath)
+
+	bot, err = open(encryptedPath, WithRepoSecret(secret))
+	r.NoError(err)
+	bot.WaitUntilIndexesAreSynced()
+	msg, err = bot.Get(post)
+	r.NoError(err)
+	r.True(msg.Key().Equal(post))
+
+	seg, release, err := bot.FeedSegments.Acquire(bot.KeyPair.ID())
+	r.NoError(err)
+	r.EqualValues(1, seg.Seq())
+	release()
+
+	// compaction copies the log encrypted
+	r.NoError(bot.NullFeed(bot.KeyPair.ID()))
+	stats, err := bot.CompactReceiveLog()
+	r.NoError(err)
+	r.EqualValues(2, stats.Removed)
+	bot.Shutdown()
+	r.NoError(bot.Close())
+
+	bot, err = open(encryptedPath, WithRepoSecret(secret))
+	r.NoError(err)
+	r.EqualValues(-1, bot.ReceiveLog.Seq())
+	bot.Shutdown()
+	r.NoError(bot.Close())
+	checkEncrypted(encryptedPath)
+}
+
+func TestEncryptedKeyPair(t *testing.T) {
+	r := require.New(t)
+
+	tRepoPath := filepath.Join("testrun", t.Name())
+	os.RemoveAll(tRepoPath)
+
+	secret := []byte("correct horse battery staple")
+	open := func(opts ...Option) (*Sbot, error) {
+		return New(append([]Option{
+			WithInfo(log.NewNopLogger()),
+			WithRepoPath(tRepoPath),
+			DisableNetworkNode(),
+			WithMetaFeedMode(true),
+		}, opts...)...)
+	}
+
+	// the seed of the metafeed is saved encrypted
+	bot, err := open(WithKeyPairSecret(secret))
+	r.NoError(err)
+	id := bot.KeyPair.ID()
+	bot.Shutdown()
+	r.NoError(bot.Close())
+
+	enc, err := ssb.IsEncryptedKeyPair(filepath.Join(tRepoPath, "secret"))
+	r.NoError(err)
+	r.True(enc)
+
+	_, err = open()
+	r.ErrorIs(err, ssb.ErrKeyPairLocked)
+	_, err = open(WithKeyPairSecret([]byte("wrong")))
+	r.ErrorIs(err, ssb.ErrKeyPairWrongSecret)
+
+	bot, err = open(WithKeyPairSecret(secret))
+	r.NoError(err)
+	r.True(bot.KeyPair.ID().Equal(id))
+	bot.Shutdown()
+	r.NoError(bot.Close())
+}
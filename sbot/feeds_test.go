@@ -169,6 +169,19 @@ func createFeedsOneByOneTest(useEBT bool) func(t *testing.T) {
 			a.Equal(int64(i), alisLog.Seq(), "check run %d", i)
 		}
 
+		// bob got all of ali's messages from her
+		prog, err := bob.ReplicationProgress()
+		r.NoError(err)
+		a.EqualValues(n, prog.Messages)
+		a.NotZero(prog.Bytes)
+		a.Equal(100.0, prog.Percent)
+		a.Equal(prog.Feeds, prog.InSync)
+		if a.Len(prog.Peers, 1) {
+			a.True(prog.Peers[0].Peer.Equal(ali.KeyPair.ID()))
+			a.EqualValues(n, prog.Peers[0].Messages)
+			a.EqualValues(0, prog.Peers[0].Behind)
+		}
+
 		// <teardown>
 		err = ali.FSCK(FSCKWithMode(FSCKModeSequences))
 		a.NoError(err, "fsck on A failed")
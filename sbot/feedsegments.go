@@ -0,0 +1,77 @@
+// SPDX-FileCopyrightText: 2021 The Go-SSB Authors
+//
+// SPDX-License-Identifier: MIT
+
+package sbot
+
+import (
+	"fmt"
+
+	"go.mindeco.de/log/level"
+
+	"github.com/ssbc/go-ssb/internal/framelog"
+	"github.com/ssbc/go-ssb/internal/seglog"
+	"github.com/ssbc/go-ssb/message/multimsg"
+)
+
+// feedSegmentsIndex is the name of the index of EnableFeedSegments
+const feedSegmentsIndex = "feed-segments"
+
+// receiveLogSegments returns the segments of the receive log, if it has the segmented format
+func (s *Sbot) receiveLogSegments() (*seglog.Store, bool) {
+	wl, ok := s.ReceiveLog.(*multimsg.WrappedLog)
+	if !ok {
+		return nil, false
+	}
+	inner := wl.AlterableLog
+	if sealed, ok := inner.(interface{ Unwrap() multimsg.AlterableLog }); ok {
+		// the log of an encrypted repo
+		inner = sealed.Unwrap()
+	}
+	fl, ok := inner.(*framelog.Log)
+	if !ok {
+		return nil, false
+	}
+	st, ok := fl.Store().(*seglog.Store)
+	return st, ok
+}
+
+// reclaimSegments deletes the segments of the receive log with the entries nulled, which only have nulled entries left.
+// It does nothing if the receive log doesn't have the segmented format.
+func (s *Sbot) reclaimSegments(nulled []int64) error {
+	st, ok := s.receiveLogSegments()
+	if !ok {
+		return nil
+	}
+
+	checked := make(map[int64]struct{})
+	var reclaimed int64
+	for _, seq := range nulled {
+		num := seq / st.EntriesPerSegment()
+		if _, ok := checked[num]; ok {
+			continue
+		}
+		checked[num] = struct{}{}
+
+		info, err := st.Segment(num)
+		if err != nil {
+			return err
+		}
+		if info.Deleted || info.Nulled < info.Last-info.First+1 {
+			continue
+		}
+		if info.Last+1 < (num+1)*st.EntriesPerSegment() {
+			// the last segment is still appended to
+			continue
+		}
+		if err := st.DeleteSegment(num); err != nil {
+			return fmt.Errorf("failed to delete segment %d of the receive log: %w", num, err)
+		}
+		reclaimed += info.Size
+	}
+
+	if reclaimed > 0 {
+		level.Info(s.info).Log("event", "deleted nulled segments of the receive log", "bytes", reclaimed)
+	}
+	return nil
+}
@@ -0,0 +1,136 @@
+// SPDX-FileCopyrightText: 2021 The Go-SSB Authors
+//
+// SPDX-License-Identifier: MIT
+
+package sbot
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.mindeco.de/log"
+
+	refs "github.com/ssbc/go-ssb-refs"
+	"github.com/ssbc/go-ssb/client"
+	"github.com/ssbc/go-ssb/internal/feedsegments"
+	"github.com/ssbc/go-ssb/message"
+	"github.com/ssbc/go-ssb/repo"
+)
+
+func TestFeedSegments(t *testing.T) {
+	r := require.New(t)
+
+	tRepoPath := filepath.Join("testrun", t.Name())
+	os.RemoveAll(tRepoPath)
+	tRepo := repo.New(tRepoPath)
+
+	logger := log.NewNopLogger()
+	if testing.Verbose() {
+		logger = log.NewLogfmtLogger(os.Stderr)
+	}
+
+	// a segmented receive log with small segments, so that NullFeed can delete one
+	logDir := tRepo.GetPath("log")
+	r.NoError(os.MkdirAll(logDir, 0700))
+	r.NoError(os.WriteFile(filepath.Join(logDir, "format"), []byte(repo.LogFormatSegmented), 0600))
+	r.NoError(os.WriteFile(filepath.Join(logDir, "entries-per-segment"), []byte("4"), 0600))
+
+	bot, err := New(
+		WithInfo(logger),
+		WithRepoPath(tRepoPath),
+		WithListenAddr(":0"),
+		LateOption(WithUNIXSocket()),
+		EnableFeedSegments(),
+	)
+	r.NoError(err)
+
+	c, err := client.NewUnix(tRepo.GetPath("socket"))
+	r.NoError(err)
+
+	arny, err := repo.NewKeyPair(tRepo, "arny", refs.RefAlgoFeedSSB1)
+	r.NoError(err)
+	bert, err := repo.NewKeyPair(tRepo, "bert", refs.RefAlgoFeedSSB1)
+	r.NoError(err)
+
+	keys := make(map[string][]refs.MessageRef)
+	publishAs := func(who string, i int) {
+		msg, err := bot.PublishAs(who, map[string]interface{}{"type": "test", "i": i})
+		r.NoError(err)
+		keys[who] = append(keys[who], msg.Key())
+	}
+	// receive log: arny 1-4 | bert 1-3, self 1 | bert 4, arny 5
+	for i := 0; i < 4; i++ {
+		publishAs("arny", i)
+	}
+	for i := 0; i < 3; i++ {
+		publishAs("bert", i)
+	}
+	_, err = bot.PublishLog.Publish(refs.NewContactFollow(bert.ID()))
+	r.NoError(err)
+	publishAs("bert", 3)
+	publishAs("arny", 4)
+	bot.WaitUntilIndexesAreSynced()
+
+	history := func(who refs.FeedRef, seq, limit int64) []refs.MessageRef {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		var args message.CreateHistArgs
+		args.ID = who
+		args.Seq = seq
+		args.Limit = limit
+		args.Keys = true
+		src, err := c.CreateHistoryStream(args)
+		r.NoError(err)
+
+		var got []refs.MessageRef
+		for src.Next(ctx) {
+			var msg refs.KeyValueRaw
+			err = src.Reader(func(rd io.Reader) error {
+				return json.NewDecoder(rd).Decode(&msg)
+			})
+			r.NoError(err)
+			got = append(got, msg.Key())
+		}
+		r.NoError(src.Err())
+		return got
+	}
+
+	seg, release, err := bot.FeedSegments.Acquire(arny.ID())
+	r.NoError(err)
+	r.EqualValues(4, seg.Seq())
+	release()
+
+	r.Equal(keys["arny"], history(arny.ID(), 0, -1))
+	r.Equal(keys["arny"][1:4], history(arny.ID(), 2, 3))
+	r.Equal(keys["bert"], history(bert.ID(), 0, -1))
+
+	// the log of arny and the first segment of the receive log, which only has messages of arny, are deleted
+	r.NoError(bot.NullFeed(arny.ID()))
+	_, _, err = bot.FeedSegments.Acquire(arny.ID())
+	r.ErrorIs(err, feedsegments.ErrNoSegment)
+	_, err = os.Stat(filepath.Join(logDir, "0000000000.data"))
+	r.True(os.IsNotExist(err), "segment not deleted: %v", err)
+	_, err = os.Stat(filepath.Join(logDir, "0000000002.data"))
+	r.NoError(err, "segment with bert 4 deleted")
+	r.Len(history(arny.ID(), 0, -1), 0)
+
+	// starting over writes the logs again
+	r.NoError(bot.RebuildIndex(feedSegmentsIndex))
+	bot.WaitUntilIndexesAreSynced()
+	seg, release, err = bot.FeedSegments.Acquire(bert.ID())
+	r.NoError(err)
+	r.EqualValues(3, seg.Seq())
+	release()
+	r.Equal(keys["bert"][1:], history(bert.ID(), 2, -1))
+
+	r.NoError(c.Close())
+	bot.Shutdown()
+	r.NoError(bot.Close())
+}
@@ -8,6 +8,8 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"runtime"
+	"sort"
 	"sync"
 	"time"
 
@@ -17,11 +19,15 @@ import (
 	refs "github.com/ssbc/go-ssb-refs"
 	"github.com/ssbc/go-ssb-refs/tfk"
 	"github.com/ssbc/margaret"
+	"github.com/ssbc/margaret/indexes"
 	"github.com/ssbc/margaret/multilog"
 	kitlog "go.mindeco.de/log"
 	"go.mindeco.de/log/level"
+	"golang.org/x/sync/errgroup"
 
 	"github.com/ssbc/go-ssb"
+	"github.com/ssbc/go-ssb/message"
+	"github.com/ssbc/go-ssb/message/multimsg"
 	"github.com/ssbc/go-ssb/multilogs"
 )
 
@@ -37,27 +43,63 @@ const (
 	// FSCKModeSequences makes sure the sequence field of each message on a feed are increasing correctly
 	FSCKModeSequences
 
-	// FSCKModeVerify does a full signature and hash verification
-	// FSCKModeVerify
+	// FSCKModeVerify does a full signature and hash verification.
+	// The feeds are checked in parallel and each one is reported from its first invalid message on.
+	FSCKModeVerify
 )
 
 type ErrConsistencyProblems struct {
-	Errors    []ssb.ErrWrongSequence
+	Errors []ssb.ErrWrongSequence
+
+	// Suffixes are the feeds that stop verifying somewhere, see FSCKModeVerify
+	Suffixes []ErrInvalidSuffix
+
 	Sequences *roaring.Bitmap
 }
 
 func (e ErrConsistencyProblems) Error() string {
-	if len(e.Errors) == 1 {
-		return e.Errors[0].Error()
+	var errs []error
+	for _, err := range e.Errors {
+		errs = append(errs, err)
+	}
+	for _, err := range e.Suffixes {
+		errs = append(errs, err)
 	}
-	errStr := fmt.Sprintf("ssb: multiple consistency problems (%d) over %d messages", len(e.Errors), e.Sequences.GetCardinality())
-	for i, err := range e.Errors {
+	if len(errs) == 1 {
+		return errs[0].Error()
+	}
+	errStr := fmt.Sprintf("ssb: multiple consistency problems (%d) over %d messages", len(errs), e.Sequences.GetCardinality())
+	for i, err := range errs {
 		errStr += fmt.Sprintf("\n%02d: %s", i, err.Error())
 	}
 	errStr += "\n"
 	return errStr
 }
 
+// ErrInvalidSuffix is a feed whose messages don't verify from Sequence on.
+// The ones before it are fine, which is why HealRepo only drops the invalid ones.
+type ErrInvalidSuffix struct {
+	Ref refs.FeedRef
+
+	// Sequence is the position of the first invalid message in the feed, starting with 1
+	Sequence int64
+
+	// Sequences are the receive log entries of the invalid message and all the ones after it
+	Sequences *roaring.Bitmap
+
+	Err error
+}
+
+func (e ErrInvalidSuffix) Error() string {
+	return fmt.Sprintf("ssb/consistency error: feed %s is invalid from message %d on (%d messages): %s",
+		e.Ref.String(),
+		e.Sequence,
+		e.Sequences.GetCardinality(),
+		e.Err)
+}
+
+func (e ErrInvalidSuffix) Unwrap() error { return e.Err }
+
 type fsckOpt struct {
 	feedsIdx   multilog.MultiLog
 	mode       FSCKMode
@@ -75,7 +117,7 @@ func FSCKWithFeedIndex(idx multilog.MultiLog) FSCKOption {
 
 func FSCKWithMode(m FSCKMode) FSCKOption {
 	return func(o *fsckOpt) error {
-		if m != FSCKModeLength && m != FSCKModeSequences {
+		if m != FSCKModeLength && m != FSCKModeSequences && m != FSCKModeVerify {
 			return fmt.Errorf("invalid fsck mode: %d", m)
 		}
 
@@ -135,6 +177,9 @@ func (s *Sbot) FSCK(opts ...FSCKOption) error {
 	case FSCKModeSequences:
 		return sequenceFSCK(s.ReceiveLog, opt.progressFn)
 
+	case FSCKModeVerify:
+		return verifyFSCK(opt.feedsIdx, s.ReceiveLog, s.signHMACsecret, opt.progressFn)
+
 	default:
 		return errors.New("sbot: unknown fsck mode")
 	}
@@ -349,10 +394,205 @@ func sequenceFSCK(receiveLog margaret.Log, progressFn FSCKUpdateFunc) error {
 	}
 }
 
-// HealRepo just nulls the messages and is a very naive repair but the only one that is feasably implemented right now
+// verifyFSCK checks the signature of every message and that it points to the one before it.
+// It goes through the feeds of authorMlog in parallel, one feed per CPU.
+func verifyFSCK(authorMlog multilog.MultiLog, receiveLog margaret.Log, hmacKey *[32]byte, progressFn FSCKUpdateFunc) error {
+	feeds, err := authorMlog.List()
+	if err != nil {
+		return fmt.Errorf("fsck/verify: author listing failed: %w", err)
+	}
+
+	totalMessages := receiveLog.Seq()
+	var pc processedCounter
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go func() {
+		p := progress.NewTicker(ctx, &pc, int64(totalMessages), 3*time.Second)
+		for remaining := range p {
+			estDone := remaining.Estimated()
+			// how much time until it's done?
+			timeLeft := estDone.Sub(time.Now()).Round(time.Second)
+			progressFn(remaining.Percent(), timeLeft)
+		}
+	}()
+
+	var (
+		mu       sync.Mutex
+		suffixes []ErrInvalidSuffix
+	)
+
+	authors := make(chan indexes.Addr)
+	grp, ctx := errgroup.WithContext(ctx)
+	for i := 0; i < runtime.NumCPU(); i++ {
+		grp.Go(func() error {
+			for author := range authors {
+				suffix, err := verifyFeed(ctx, authorMlog, receiveLog, author, hmacKey, &pc)
+				if err != nil {
+					return err
+				}
+				if suffix != nil {
+					mu.Lock()
+					suffixes = append(suffixes, *suffix)
+					mu.Unlock()
+				}
+			}
+			return nil
+		})
+	}
+
+	grp.Go(func() error {
+		defer close(authors)
+		for _, author := range feeds {
+			select {
+			case authors <- author:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		return nil
+	})
+
+	if err := grp.Wait(); err != nil {
+		return err
+	}
+
+	if len(suffixes) == 0 {
+		return nil
+	}
+
+	sort.Slice(suffixes, func(i, j int) bool { return suffixes[i].Ref.String() < suffixes[j].Ref.String() })
+
+	nullMap := roaring.New()
+	for _, suffix := range suffixes {
+		nullMap.Or(suffix.Sequences)
+	}
+
+	// error report
+	return ErrConsistencyProblems{
+		Suffixes:  suffixes,
+		Sequences: nullMap,
+	}
+}
+
+// verifyFeed checks the messages of one author in the order of the feed.
+// It returns the invalid suffix of it or nil if all of them are valid.
+func verifyFeed(ctx context.Context, authorMlog multilog.MultiLog, receiveLog margaret.Log, author indexes.Addr, hmacKey *[32]byte, pc *processedCounter) (*ErrInvalidSuffix, error) {
+	var sr tfk.Feed
+	err := sr.UnmarshalBinary([]byte(author))
+	if err != nil {
+		return nil, fmt.Errorf("fsck/verify: failed to unpack author %q: %w", author, err)
+	}
+	feed, err := sr.Feed()
+	if err != nil {
+		return nil, fmt.Errorf("fsck/verify: failed to feed reference for author (%q): %w", author, err)
+	}
+
+	verifier, err := message.NewVerifier(feed, hmacKey)
+	if err != nil {
+		return nil, fmt.Errorf("fsck/verify: feed %s: %w", feed.ShortSigil(), err)
+	}
+
+	subLog, err := authorMlog.Get(author)
+	if err != nil {
+		return nil, fmt.Errorf("fsck/verify: failed to get sublog for %s: %w", feed.ShortSigil(), err)
+	}
+
+	src, err := subLog.Query()
+	if err != nil {
+		return nil, fmt.Errorf("fsck/verify: failed to query sublog for %s: %w", feed.ShortSigil(), err)
+	}
+
+	var (
+		suffix *ErrInvalidSuffix
+
+		// the message before the current one, if it could be checked
+		previous refs.Message
+		chained  = true
+	)
+	for pos := int64(1); ; pos++ {
+		v, err := src.Next(ctx)
+		if err != nil {
+			if luigi.IsEOS(err) {
+				break
+			}
+			return nil, err
+		}
+
+		rxSeq, ok := v.(int64)
+		if !ok {
+			return nil, fmt.Errorf("fsck/verify: unexpected sublog entry: %T (wanted %T)", v, rxSeq)
+		}
+
+		if suffix != nil {
+			suffix.Sequences.Add(uint32(rxSeq))
+			continue
+		}
+
+		rv, err := receiveLog.Get(rxSeq)
+		if err != nil {
+			if margaret.IsErrNulled(err) {
+				// the next one can't be compared to this one
+				previous, chained = nil, false
+				continue
+			}
+			return nil, fmt.Errorf("fsck/verify: failed to load rxlog entry %d for %s: %w", rxSeq, feed.ShortSigil(), err)
+		}
+
+		verified, err := verifyStored(verifier, rv)
+		if err == nil && !verified.Author().Equal(feed) {
+			err = fmt.Errorf("message by %s in the feed", verified.Author().ShortSigil())
+		}
+		if err == nil && chained {
+			err = message.ValidateNext(previous, verified)
+		}
+		if err != nil {
+			suffix = &ErrInvalidSuffix{
+				Ref:       feed,
+				Sequence:  pos,
+				Sequences: roaring.BitmapOf(uint32(rxSeq)),
+				Err:       err,
+			}
+			continue
+		}
+		previous, chained = verified, true
+
+		pc.Incr()
+	}
+
+	return suffix, nil
+}
+
+// verifyStored checks a message of the receive log like it was received
+func verifyStored(verifier message.Verifier, v interface{}) (refs.Message, error) {
+	mm, ok := v.(*multimsg.MultiMessage)
+	if !ok {
+		return nil, fmt.Errorf("unexpected message type: %T (wanted %T)", v, mm)
+	}
+
+	raw, err := wireBytes(mm)
+	if err != nil {
+		return nil, err
+	}
+
+	verified, err := verifier.Verify(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	// the stored key is what the indexes point to
+	if !verified.Key().Equal(mm.Key()) {
+		return nil, fmt.Errorf("stored key %s doesn't match the message %s", mm.Key().ShortSigil(), verified.Key().ShortSigil())
+	}
+	return verified, nil
+}
+
+// HealRepo just nulls the messages and is a very naive repair but the only one that is feasably implemented right now.
+// Feeds with wrong sequences are dropped completely, the ones of FSCKModeVerify only from their first invalid message on.
 func (s *Sbot) HealRepo(report ErrConsistencyProblems) error {
 	funcLog := kitlog.With(s.info, "event", "heal repo")
-	brokenCount := len(report.Errors)
+	brokenCount := len(report.Errors) + len(report.Suffixes)
 	if brokenCount == 0 {
 		level.Warn(funcLog).Log("msg", "no errors to repair, run FSCK first.")
 		return nil
@@ -381,5 +621,39 @@ func (s *Sbot) HealRepo(report ErrConsistencyProblems) error {
 		level.Debug(funcLog).Log("feed", constErr.Ref.String())
 	}
 
+	for i, suffix := range report.Suffixes {
+		var err error
+		if suffix.Sequence <= 1 {
+			err = s.NullFeed(suffix.Ref)
+		} else {
+			err = s.nullFeedSuffix(suffix.Ref, suffix.Sequence-1)
+		}
+		if err != nil {
+			return fmt.Errorf("heal(%d): failed to drop invalid messages: %w", i, err)
+		}
+		level.Debug(funcLog).Log("feed", suffix.Ref.String(), "from", suffix.Sequence)
+	}
+
 	return nil
 }
+
+// wireBytes returns a stored message like it is sent to other peers, which is what its signature covers
+func wireBytes(mm *multimsg.MultiMessage) ([]byte, error) {
+	var (
+		raw []byte
+		err error
+	)
+	if msg, ok := mm.AsLegacy(); ok {
+		raw = msg.Raw_
+	} else if msg, ok := mm.AsGabby(); ok {
+		raw, err = msg.MarshalCBOR()
+	} else if msg, ok := mm.AsMetaFeed(); ok {
+		raw, err = msg.MarshalBencode()
+	} else {
+		return nil, fmt.Errorf("unsupported message format")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode stored message: %w", err)
+	}
+	return raw, nil
+}
@@ -5,6 +5,7 @@
 package sbot
 
 import (
+	"bytes"
 	"context"
 	"crypto/rand"
 	"fmt"
@@ -19,7 +20,9 @@ import (
 	"github.com/stretchr/testify/require"
 	"go.mindeco.de/log"
 
+	"github.com/ssbc/go-ssb/internal/storedrefs"
 	"github.com/ssbc/go-ssb/internal/testutils"
+	"github.com/ssbc/go-ssb/message/multimsg"
 	"github.com/ssbc/go-ssb/repo"
 )
 
@@ -65,6 +68,7 @@ func TestFSCK(t *testing.T) {
 	t.Run("correct", testFSCKcorrect)
 	t.Run("double", testFSCKdouble)
 	t.Run("multipleFeeds", testFSCKmultipleFeeds)
+	t.Run("verify", testFSCKverify)
 	// t.Run("rerpo", testFSCKrerpo)
 }
 
@@ -242,6 +246,101 @@ func testFSCKmultipleFeeds(t *testing.T) {
 	r.NoError(theBot.Close())
 }
 
+func testFSCKverify(t *testing.T) {
+	r := require.New(t)
+	theBot, _ := makeFSCKTestBot(t)
+
+	const n = 8
+	for i := 1; i <= n; i++ {
+		_, err := theBot.PublishLog.Publish(refs.NewPost(fmt.Sprintf("test:%d", i)))
+		r.NoError(err)
+	}
+	for i := 1; i <= 3; i++ {
+		_, err := theBot.PublishAs("one", map[string]interface{}{"type": "test", "i": i})
+		r.NoError(err)
+		_, err = theBot.PublishAs("two", map[string]interface{}{"type": "test", "i": i})
+		r.NoError(err)
+	}
+
+	err := theBot.FSCK(FSCKWithMode(FSCKModeVerify))
+	r.NoError(err, "all valid")
+
+	replace := func(rxSeq int64, forge func(*multimsg.MultiMessage)) refs.FeedRef {
+		v, err := theBot.ReceiveLog.Get(rxSeq)
+		r.NoError(err)
+		mm, ok := v.(*multimsg.MultiMessage)
+		r.True(ok, "got %T", v)
+		forge(mm)
+		data, err := mm.MarshalBinary()
+		r.NoError(err)
+		r.NoError(theBot.ReceiveLog.Replace(rxSeq, data))
+		return mm.Author()
+	}
+
+	// the text of the fifth post of the main feed
+	mainFeed := replace(4, func(mm *multimsg.MultiMessage) {
+		msg, ok := mm.AsLegacy()
+		r.True(ok)
+		msg.Raw_ = bytes.Replace(msg.Raw_, []byte("test:5"), []byte("fake:5"), 1)
+	})
+
+	// the signature of the second message of two
+	twoFeed := replace(n+3, func(mm *multimsg.MultiMessage) {
+		tr, ok := mm.AsGabby()
+		r.True(ok)
+		tr.Signature[0] ^= 1
+	})
+
+	err = theBot.FSCK(FSCKWithMode(FSCKModeSequences))
+	r.NoError(err, "the sequences are still fine")
+
+	err = theBot.FSCK(FSCKWithMode(FSCKModeVerify))
+	r.Error(err)
+	constErrs, ok := err.(ErrConsistencyProblems)
+	r.True(ok, "wrong error type. got %T", err)
+	r.Len(constErrs.Errors, 0)
+	r.Len(constErrs.Suffixes, 2)
+	r.EqualValues(n-4+2, constErrs.Sequences.GetCardinality())
+
+	for _, suffix := range constErrs.Suffixes {
+		switch {
+		case suffix.Ref.Equal(mainFeed):
+			r.EqualValues(5, suffix.Sequence)
+			r.EqualValues(n-4, suffix.Sequences.GetCardinality())
+		case suffix.Ref.Equal(twoFeed):
+			r.EqualValues(2, suffix.Sequence)
+			r.EqualValues(2, suffix.Sequences.GetCardinality())
+		default:
+			r.Fail("unexpected feed", suffix.Ref.String())
+		}
+	}
+
+	// only the invalid messages are dropped
+	err = theBot.HealRepo(constErrs)
+	r.NoError(err)
+
+	err = theBot.FSCK(FSCKWithMode(FSCKModeVerify))
+	r.NoError(err, "after heal (verify)")
+
+	err = theBot.FSCK(FSCKWithMode(FSCKModeLength))
+	r.NoError(err, "after heal (len)")
+
+	userLog, err := theBot.Users.Get(storedrefs.Feed(mainFeed))
+	r.NoError(err)
+	r.EqualValues(3, userLog.Seq(), "the first four messages are kept")
+
+	userLog, err = theBot.Users.Get(storedrefs.Feed(twoFeed))
+	r.NoError(err)
+	r.EqualValues(0, userLog.Seq())
+
+	_, err = theBot.ReceiveLog.Get(n - 1)
+	r.True(margaret.IsErrNulled(err), "got %v", err)
+
+	// cleanup
+	theBot.Shutdown()
+	r.NoError(theBot.Close())
+}
+
 // to use this, put the repo in
 func testFSCKrepro(t *testing.T) {
 	r := require.New(t)
@@ -15,7 +15,7 @@ import (
 func (sbot *Sbot) PublishAs(nick string, val interface{}) (refs.Message, error) {
 	r := repo.New(sbot.repoPath)
 
-	kp, err := repo.LoadKeyPair(r, nick)
+	kp, err := repo.LoadKeyPairWithSecret(r, nick, sbot.keyPairSecret)
 	if err != nil {
 		return nil, err
 	}
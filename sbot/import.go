@@ -0,0 +1,137 @@
+// SPDX-FileCopyrightText: 2021 The Go-SSB Authors
+//
+// SPDX-License-Identifier: MIT
+
+package sbot
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/ssbc/go-luigi"
+	"github.com/ssbc/margaret"
+	"go.mindeco.de/log/level"
+
+	"github.com/ssbc/go-ssb/message/multimsg"
+)
+
+// ImportStats says what ImportLog did with the entries it read
+type ImportStats struct {
+	Imported int64
+
+	// Known were already stored.
+	// Invalid failed the verification or didn't continue their feed.
+	Known   int64
+	Invalid int64
+
+	// Skipped are nulled entries and records of formats that can't be verified
+	Skipped int64
+}
+
+// ImportLog verifies the messages of src, like the log of a JS sbot opened with repo.OpenJSLog,
+// and appends the ones that continue their feed to the receive log, where the indexes pick them up.
+// The messages of a feed have to be in order, like they are in the log of another sbot.
+func (s *Sbot) ImportLog(src margaret.Log) (ImportStats, error) {
+	var stats ImportStats
+
+	qry, err := src.Query()
+	if err != nil {
+		return stats, fmt.Errorf("importLog: failed to query the source: %w", err)
+	}
+
+	for {
+		v, err := qry.Next(s.rootCtx)
+		if luigi.IsEOS(err) {
+			break
+		} else if err != nil {
+			return stats, fmt.Errorf("importLog: failed to read the source: %w", err)
+		}
+
+		var mm *multimsg.MultiMessage
+		switch tv := v.(type) {
+		case *multimsg.MultiMessage:
+			mm = tv
+		case multimsg.MultiMessage:
+			mm = &tv
+		case error:
+			if margaret.IsErrNulled(tv) {
+				stats.Skipped++
+				continue
+			}
+			return stats, fmt.Errorf("importLog: failed to read the source: %w", tv)
+		default:
+			return stats, fmt.Errorf("importLog: unexpected entry type %T", v)
+		}
+
+		raw, err := wireBytes(mm)
+		if err != nil {
+			stats.Skipped++
+			continue
+		}
+
+		snk, err := s.verifyRouter.GetSink(mm.Author(), false)
+		if err != nil {
+			return stats, fmt.Errorf("importLog: failed to get verification sink for %s: %w", mm.Author().ShortSigil(), err)
+		}
+
+		before := snk.Seq()
+		err = snk.Verify(raw)
+		if err != nil {
+			level.Debug(s.info).Log("event", "import: invalid message", "msg", mm.Key().ShortSigil(), "err", err)
+			stats.Invalid++
+		} else if snk.Seq() > before {
+			stats.Imported++
+		} else {
+			stats.Known++
+		}
+	}
+
+	return stats, nil
+}
+
+// ExportStats says what ExportLog wrote
+type ExportStats struct {
+	Exported int64
+
+	// Skipped are the messages the destination can't store
+	Skipped int64
+}
+
+// ExportLog appends the messages of the receive log in the order they were received to dst, like a log created with repo.CreateJSLog.
+// Nulled entries are left out.
+func (s *Sbot) ExportLog(dst margaret.Log) (ExportStats, error) {
+	var stats ExportStats
+
+	src, err := s.ReceiveLog.Query()
+	if err != nil {
+		return stats, fmt.Errorf("exportLog: failed to query the receive log: %w", err)
+	}
+
+	for {
+		v, err := src.Next(s.rootCtx)
+		if luigi.IsEOS(err) {
+			break
+		} else if err != nil {
+			return stats, fmt.Errorf("exportLog: failed to read the receive log: %w", err)
+		}
+
+		mm, ok := v.(*multimsg.MultiMessage)
+		if !ok {
+			if err, ok := v.(error); ok && margaret.IsErrNulled(err) {
+				continue
+			}
+			return stats, fmt.Errorf("exportLog: unexpected entry type %T", v)
+		}
+
+		_, err = dst.Append(*mm)
+		if errors.Is(err, multimsg.ErrUnsupportedFormat) {
+			stats.Skipped++
+			continue
+		} else if err != nil {
+			return stats, fmt.Errorf("exportLog: failed to write %s: %w", mm.Key().ShortSigil(), err)
+		}
+		stats.Exported++
+	}
+
+	return stats, nil
+}
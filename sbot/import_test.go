@@ -0,0 +1,125 @@
+// SPDX-FileCopyrightText: 2021 The Go-SSB Authors
+//
+// SPDX-License-Identifier: MIT
+
+package sbot
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.mindeco.de/log"
+
+	refs "github.com/ssbc/go-ssb-refs"
+	"github.com/ssbc/go-ssb/internal/storedrefs"
+	"github.com/ssbc/go-ssb/message/multimsg"
+	"github.com/ssbc/go-ssb/repo"
+)
+
+func TestImportExportLog(t *testing.T) {
+	r := require.New(t)
+
+	tRepoPath := filepath.Join("testrun", t.Name())
+	os.RemoveAll(tRepoPath)
+
+	logger := log.NewNopLogger()
+	if testing.Verbose() {
+		logger = log.NewLogfmtLogger(os.Stderr)
+	}
+
+	open := func(name string) *Sbot {
+		bot, err := New(
+			WithInfo(logger),
+			WithRepoPath(filepath.Join(tRepoPath, name)),
+			DisableNetworkNode(),
+		)
+		r.NoError(err)
+		return bot
+	}
+
+	srcBot := open("source")
+	srcRepo := repo.New(filepath.Join(tRepoPath, "source"))
+	arny, err := repo.NewKeyPair(srcRepo, "arny", refs.RefAlgoFeedSSB1)
+	r.NoError(err)
+	_, err = repo.NewKeyPair(srcRepo, "bert", refs.RefAlgoFeedGabby)
+	r.NoError(err)
+
+	var keys []refs.MessageRef
+	for i := 0; i < 3; i++ {
+		msg, err := srcBot.PublishAs("arny", map[string]interface{}{"type": "test", "i": i})
+		r.NoError(err)
+		keys = append(keys, msg.Key())
+		_, err = srcBot.PublishAs("bert", map[string]interface{}{"type": "test", "i": i})
+		r.NoError(err)
+	}
+	msg, err := srcBot.PublishLog.Publish(map[string]interface{}{"type": "test", "text": "<hello & goodbye>"})
+	r.NoError(err)
+	keys = append(keys, msg.Key())
+
+	jsDir := filepath.Join(tRepoPath, "js")
+	for _, format := range []repo.JSLogFormat{repo.JSLogFlume, repo.JSLogDB2} {
+		dst, err := repo.CreateJSLog(filepath.Join(jsDir, string(format)), format)
+		r.NoError(err)
+		stats, err := srcBot.ExportLog(dst)
+		r.NoError(err)
+		r.Equal(ExportStats{Exported: 4, Skipped: 3}, stats, "%s: gabby grove can't be exported", format)
+		if c, ok := dst.(io.Closer); ok {
+			r.NoError(c.Close())
+		}
+
+		_, err = repo.CreateJSLog(filepath.Join(jsDir, string(format)), format)
+		r.Error(err, "%s: already exists", format)
+	}
+
+	// arny's second message on its own can't be imported
+	gapped, err := repo.CreateJSLog(filepath.Join(jsDir, "gapped"), repo.JSLogFlume)
+	r.NoError(err)
+	v, err := srcBot.ReceiveLog.Get(2)
+	r.NoError(err)
+	r.True(v.(*multimsg.MultiMessage).Key().Equal(keys[1]))
+	_, err = gapped.Append(v)
+	r.NoError(err)
+
+	srcBot.Shutdown()
+	r.NoError(srcBot.Close())
+
+	dstBot := open("destination")
+
+	src, format, err := repo.OpenJSLog(filepath.Join(jsDir, "gapped"))
+	r.NoError(err)
+	r.Equal(repo.JSLogFlume, format)
+	stats, err := dstBot.ImportLog(src)
+	r.NoError(err)
+	r.Equal(ImportStats{Invalid: 1}, stats)
+
+	src, format, err = repo.OpenJSLog(filepath.Join(jsDir, "flume", "flume", "log.offset"))
+	r.NoError(err)
+	r.Equal(repo.JSLogFlume, format)
+	stats, err = dstBot.ImportLog(src)
+	r.NoError(err)
+	r.Equal(ImportStats{Imported: 4}, stats)
+
+	src, format, err = repo.OpenJSLog(filepath.Join(jsDir, "db2"))
+	r.NoError(err)
+	r.Equal(repo.JSLogDB2, format)
+	stats, err = dstBot.ImportLog(src)
+	r.NoError(err)
+	r.Equal(ImportStats{Known: 4}, stats)
+	r.NoError(src.(io.Closer).Close())
+
+	dstBot.WaitUntilIndexesAreSynced()
+	for _, key := range keys {
+		msg, err := dstBot.Get(key)
+		r.NoError(err)
+		r.True(msg.Key().Equal(key))
+	}
+	seqs, err := dstBot.Users.Get(storedrefs.Feed(arny.ID()))
+	r.NoError(err)
+	r.EqualValues(2, seqs.Seq())
+
+	dstBot.Shutdown()
+	r.NoError(dstBot.Close())
+}
@@ -0,0 +1,468 @@
+// SPDX-FileCopyrightText: 2021 The Go-SSB Authors
+//
+// SPDX-License-Identifier: MIT
+
+package sbot
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dgraph-io/badger/v3"
+	"github.com/ssbc/margaret"
+	librarian "github.com/ssbc/margaret/indexes"
+	"go.mindeco.de/log/level"
+
+	"github.com/ssbc/go-ssb"
+)
+
+var _ ssb.IndexController = (*Sbot)(nil)
+
+// declaredIndexPrefix marks the declared indexes in IndexProgress, which only catch up when they are queried
+const declaredIndexPrefix = "declared:"
+
+// indexRunner feeds the messages of msgs to an index and keeps track of its progress, see serveIndexFrom
+type indexRunner struct {
+	name string
+	snk  librarian.SinkIndex
+	msgs margaret.Log
+
+	// reset makes the index start over from the first message, see rebuildable
+	reset func() error
+
+	mu sync.Mutex
+
+	// stop ends the processing of the current run, which closes done
+	stop context.CancelFunc
+	done chan struct{}
+
+	// resume is set while the index is paused and closed to let it continue
+	resume chan struct{}
+
+	// held is like resume but only used by holdIndexes, so that it doesn't resume indexes that were paused before
+	held chan struct{}
+
+	// pouring is locked while a message is passed to the index
+	pouring sync.Mutex
+
+	rebuilding bool
+
+	// seq is the last entry of msgs that was processed
+	seq       int64
+	processed int64
+	rate      rateMeter
+}
+
+// Pour waits while the index is paused or held and then passes v on to the index
+func (r *indexRunner) Pour(ctx context.Context, v interface{}) error {
+	for {
+		r.pouring.Lock()
+		r.mu.Lock()
+		wait := r.resume
+		if wait == nil {
+			wait = r.held
+		}
+		r.mu.Unlock()
+		if wait == nil {
+			break
+		}
+		r.pouring.Unlock()
+
+		select {
+		case <-wait:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	defer r.pouring.Unlock()
+
+	if err := r.snk.Pour(ctx, v); err != nil {
+		return err
+	}
+
+	if sw, ok := v.(margaret.SeqWrapper); ok {
+		r.mu.Lock()
+		r.seq = sw.Seq()
+		r.processed++
+		r.rate.mark(time.Now())
+		r.mu.Unlock()
+	}
+	return nil
+}
+
+// Close does nothing, the index itself is closed by the bot
+func (r *indexRunner) Close() error { return nil }
+
+// QuerySpec is the one of the index
+func (r *indexRunner) QuerySpec() margaret.QuerySpec { return r.snk.QuerySpec() }
+
+// caughtUp notes that the index processed its backlog up to seq
+func (r *indexRunner) caughtUp(seq int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if seq > r.seq {
+		r.seq = seq
+	}
+}
+
+// rootSeqer is implemented by the logs that only have some of the messages of the receive log, like mutil.Indirect
+type rootSeqer interface {
+	RootSeq(seq int64) (int64, error)
+}
+
+func (r *indexRunner) progress(state string) ssb.IndexProgress {
+	r.mu.Lock()
+	p := ssb.IndexProgress{
+		Name:        r.name,
+		State:       state,
+		Seq:         r.seq,
+		Processed:   r.processed,
+		Rate:        r.rate.perSecond(time.Now()),
+		Paused:      r.resume != nil,
+		Rebuildable: r.reset != nil,
+	}
+	if r.rebuilding {
+		p.State = "rebuilding"
+	} else if p.Paused {
+		p.State = "paused"
+	}
+	r.mu.Unlock()
+
+	if p.Pending = r.msgs.Seq() - p.Seq; p.Pending < 0 {
+		p.Pending = 0
+	}
+	if rs, ok := r.msgs.(rootSeqer); ok && p.Seq >= 0 {
+		if rxSeq, err := rs.RootSeq(p.Seq); err == nil {
+			p.Seq = rxSeq
+		}
+	}
+	return p
+}
+
+// rateMeter estimates how many messages per second an index processes
+type rateMeter struct {
+	start, last time.Time
+	n           int64
+	rate        float64
+}
+
+// rateWindow is how long the messages are counted for one estimate
+const rateWindow = 3 * time.Second
+
+func (m *rateMeter) mark(now time.Time) {
+	if m.start.IsZero() {
+		m.start = now
+	}
+	m.n++
+	m.last = now
+	if d := now.Sub(m.start); d >= rateWindow {
+		m.rate = float64(m.n) / d.Seconds()
+		m.start, m.n = now, 0
+	}
+}
+
+func (m *rateMeter) perSecond(now time.Time) float64 {
+	if m.last.IsZero() || now.Sub(m.last) > 2*rateWindow {
+		// idle
+		return 0
+	}
+	if m.rate == 0 {
+		// the first window isn't over yet
+		return float64(m.n) / math.Max(now.Sub(m.start).Seconds(), 1)
+	}
+	return m.rate
+}
+
+// rebuildable lets RebuildIndex start the index name over with reset
+func (s *Sbot) rebuildable(name string, reset func() error) {
+	s.indexRunnersMu.Lock()
+	defer s.indexRunnersMu.Unlock()
+	if r, has := s.indexRunners[name]; has {
+		r.mu.Lock()
+		r.reset = reset
+		r.mu.Unlock()
+	}
+}
+
+// startOver returns the reset for indexes that keep their progress in idx, or nil if it doesn't.
+// They only process the messages again, which overwrites what they stored but keeps the values of messages that are gone.
+// That is for the indexes that share their keys with others, like the ones of the graph builder, or whose keys the bot doesn't know.
+func startOver(idx interface{}) func() error {
+	ssi, ok := idx.(librarian.SeqSetterIndex)
+	if !ok {
+		return nil
+	}
+	return func() error {
+		return ssi.SetSeq(margaret.SeqEmpty)
+	}
+}
+
+// dropAndStartOver is startOver for indexes that have the keys with prefix in db to themselves.
+// They are removed before the index starts over, so nothing of the previous run is left.
+func dropAndStartOver(idx interface{}, db *badger.DB, prefix []byte) func() error {
+	ssi, ok := idx.(librarian.SeqSetterIndex)
+	if !ok {
+		return nil
+	}
+	return func() error {
+		// the batched values would be written after the drop otherwise
+		if err := ssi.Flush(); err != nil {
+			return fmt.Errorf("failed to flush index: %w", err)
+		}
+		if err := dropIndexKeys(ssi, db, prefix); err != nil {
+			return err
+		}
+		return ssi.SetSeq(margaret.SeqEmpty)
+	}
+}
+
+// dropIndexKeysBatch is how many keys dropIndexKeys collects at once
+const dropIndexKeysBatch = 4096
+
+// dropIndexKeys deletes the values of idx, which are the keys with prefix in db.
+// They go through the index to update the values it handed out, and one by one
+// since badger's DropPrefix would block the writes of the other indexes in the same database.
+func dropIndexKeys(idx librarian.SetterIndex, db *badger.DB, prefix []byte) error {
+	// the seq of the index is kept with its values, SetSeq takes care of it
+	seqKey := append(append([]byte{}, prefix...), "__current_observable"...)
+	for {
+		var addrs []librarian.Addr
+		err := db.View(func(txn *badger.Txn) error {
+			opts := badger.DefaultIteratorOptions
+			opts.PrefetchValues = false
+			iter := txn.NewIterator(opts)
+			defer iter.Close()
+
+			for iter.Seek(prefix); iter.ValidForPrefix(prefix) && len(addrs) < dropIndexKeysBatch; iter.Next() {
+				k := iter.Item().Key()
+				if bytes.Equal(k, seqKey) {
+					continue
+				}
+				addrs = append(addrs, librarian.Addr(k[len(prefix):]))
+			}
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("failed to list index values: %w", err)
+		}
+		if len(addrs) == 0 {
+			return nil
+		}
+
+		for _, addr := range addrs {
+			if err := idx.Delete(context.TODO(), addr); err != nil {
+				return fmt.Errorf("failed to drop index value: %w", err)
+			}
+		}
+	}
+}
+
+// IndexProgress returns how far all the indexes got, sorted by name
+func (s *Sbot) IndexProgress() []ssb.IndexProgress {
+	s.indexStateMu.Lock()
+	states := make(map[string]string, len(s.indexStates))
+	for name, state := range s.indexStates {
+		states[name] = state
+	}
+	s.indexStateMu.Unlock()
+
+	s.indexRunnersMu.Lock()
+	var progress []ssb.IndexProgress
+	for name, r := range s.indexRunners {
+		progress = append(progress, r.progress(states[name]))
+	}
+	s.indexRunnersMu.Unlock()
+
+	if s.Declared != nil {
+		rxSeq := s.ReceiveLog.Seq()
+		for _, spec := range s.Declared.Specs() {
+			seq, err := s.Declared.Progress(spec.Name)
+			if err != nil {
+				continue
+			}
+			p := ssb.IndexProgress{
+				Name:        declaredIndexPrefix + spec.Name,
+				State:       "on demand",
+				Seq:         seq,
+				Pending:     rxSeq - seq,
+				Rebuildable: true,
+			}
+			progress = append(progress, p)
+		}
+	}
+
+	sort.Slice(progress, func(i, j int) bool { return progress[i].Name < progress[j].Name })
+	return progress
+}
+
+// PauseIndexing stops the named indexes before their next message, or all of them if there are no names.
+// Until they are resumed, WaitUntilIndexesAreSynced and WaitUntilIndexed wait for them as well.
+func (s *Sbot) PauseIndexing(names ...string) error {
+	runners, err := s.getIndexRunners(names)
+	if err != nil {
+		return err
+	}
+	for _, r := range runners {
+		r.mu.Lock()
+		if r.resume == nil {
+			r.resume = make(chan struct{})
+		}
+		r.mu.Unlock()
+	}
+	return nil
+}
+
+// ResumeIndexing continues the named indexes, or all of them if there are no names
+func (s *Sbot) ResumeIndexing(names ...string) error {
+	runners, err := s.getIndexRunners(names)
+	if err != nil {
+		return err
+	}
+	for _, r := range runners {
+		r.mu.Lock()
+		if r.resume != nil {
+			close(r.resume)
+			r.resume = nil
+		}
+		r.mu.Unlock()
+	}
+	return nil
+}
+
+// holdIndexes stops all the indexes before their next message and returns once none of them is processing one.
+// Unlike PauseIndexing it doesn't interfere with the indexes that are paused, release lets the others continue.
+func (s *Sbot) holdIndexes() (release func()) {
+	runners, _ := s.getIndexRunners(nil)
+
+	held := make(chan struct{})
+	for _, r := range runners {
+		r.mu.Lock()
+		r.held = held
+		r.mu.Unlock()
+	}
+
+	// wait for the messages that were being processed
+	for _, r := range runners {
+		r.pouring.Lock()
+		r.pouring.Unlock()
+	}
+
+	return func() {
+		for _, r := range runners {
+			r.mu.Lock()
+			r.held = nil
+			r.mu.Unlock()
+		}
+		close(held)
+	}
+}
+
+func (s *Sbot) getIndexRunners(names []string) ([]*indexRunner, error) {
+	s.indexRunnersMu.Lock()
+	defer s.indexRunnersMu.Unlock()
+
+	if len(names) == 0 {
+		runners := make([]*indexRunner, 0, len(s.indexRunners))
+		for _, r := range s.indexRunners {
+			runners = append(runners, r)
+		}
+		return runners, nil
+	}
+
+	runners := make([]*indexRunner, len(names))
+	for i, name := range names {
+		r, has := s.indexRunners[name]
+		if !has {
+			if strings.HasPrefix(name, declaredIndexPrefix) {
+				return nil, fmt.Errorf("sbot: %s only catches up when it is queried", name)
+			}
+			return nil, fmt.Errorf("sbot: no such index: %s", name)
+		}
+		runners[i] = r
+	}
+	return runners, nil
+}
+
+// RebuildIndex stops the index name, makes it start over from the first message and serves it again,
+// while the other indexes keep being served. It returns once the index runs again, not when it caught up.
+// The values of indexes with keys of their own, like get and abouts, are dropped first.
+// The others, like contacts whose keys are shared in the graph builder, process the messages again over what they stored.
+// The indexes it works for say so in IndexProgress. The others, like combined, need RebuildIndicies while the bot is stopped.
+func (s *Sbot) RebuildIndex(name string) error {
+	if strings.HasPrefix(name, declaredIndexPrefix) {
+		if s.Declared == nil {
+			return fmt.Errorf("sbot: no such index: %s", name)
+		}
+		return s.Declared.Reset(strings.TrimPrefix(name, declaredIndexPrefix))
+	}
+
+	s.indexRunnersMu.Lock()
+	r, has := s.indexRunners[name]
+	s.indexRunnersMu.Unlock()
+	if !has {
+		return fmt.Errorf("sbot: no such index: %s", name)
+	}
+
+	r.mu.Lock()
+	if r.reset == nil {
+		r.mu.Unlock()
+		return fmt.Errorf("sbot: index %s can't be rebuilt while the bot is running", name)
+	}
+	if r.rebuilding {
+		r.mu.Unlock()
+		return fmt.Errorf("sbot: index %s is already being rebuilt", name)
+	}
+	r.rebuilding = true
+	stop, done := r.stop, r.done
+	r.mu.Unlock()
+
+	defer func() {
+		r.mu.Lock()
+		r.rebuilding = false
+		r.mu.Unlock()
+	}()
+
+	if s.rootCtx.Err() != nil {
+		return ssb.ErrShuttingDown
+	}
+
+	stop()
+	<-done
+
+	err := r.reset()
+	if err != nil {
+		// it continues from where it was
+		level.Warn(s.info).Log("event", "index rebuild failed", "index", name, "err", err)
+		err = fmt.Errorf("sbot: failed to reset index %s: %w", name, err)
+	} else {
+		r.mu.Lock()
+		r.seq = margaret.SeqEmpty
+		r.processed = 0
+		r.rate = rateMeter{}
+		r.mu.Unlock()
+
+		s.indexedMu.Lock()
+		if _, tracked := s.indexedSeqs[name]; tracked {
+			s.indexedSeqs[name] = margaret.SeqEmpty
+		}
+		s.indexedMu.Unlock()
+	}
+
+	// Close waits for the indexes that run, they can't be started once it does
+	s.closedMu.Lock()
+	defer s.closedMu.Unlock()
+	if s.closed || s.rootCtx.Err() != nil {
+		if err == nil {
+			err = ssb.ErrShuttingDown
+		}
+		return err
+	}
+	s.runIndex(r)
+	return err
+}
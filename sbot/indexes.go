@@ -38,6 +38,12 @@ func MountPlugin(plug ssb.Plugin, mode plugins2.AuthMode) Option {
 			}
 		}
 
+		if ndi, ok := plug.(plugins2.NeedsDeclaredIndexes); ok {
+			// the registry is only there once the index store is open
+			s.declaredSpecs = append(s.declaredSpecs, ndi.DeclareIndexes()...)
+			s.indexDeclarers = append(s.indexDeclarers, ndi)
+		}
+
 		if slm, ok := plug.(repo.SimpleIndexMaker); ok {
 			err := MountSimpleIndex(plug.Name(), slm.MakeSimpleIndex)(s)
 			if err != nil {
@@ -73,6 +79,7 @@ func MountMultiLog(name string, fn repo.MakeMultiLog) Option {
 		}
 		s.closers.AddCloser(updateSink)
 		s.closers.AddCloser(mlog)
+		s.flushable(mlog)
 		s.serveIndex(name, updateSink)
 		s.mlogIndicies[name] = mlog
 		return nil
@@ -87,6 +94,8 @@ func MountSimpleIndex(name string, fn repo.MakeSimpleIndex) Option {
 		}
 		s.closers.AddCloser(updateSink)
 		s.serveIndex(name, updateSink)
+		s.rebuildable(name, startOver(idx))
+		s.flushable(idx)
 		s.simpleIndex[name] = idx
 		return nil
 	}
@@ -165,7 +174,75 @@ func (s *Sbot) AreIndexesSynced() bool {
 
 // the default is to fill an index with all messages
 func (s *Sbot) serveIndex(name string, snk librarian.SinkIndex) {
-	s.serveIndexFrom(name, snk, s.ReceiveLog)
+	s.indexedMu.Lock()
+	s.indexedSeqs[name] = margaret.SeqEmpty
+	s.indexedMu.Unlock()
+
+	s.serveIndexFrom(name, indexedSink{SinkIndex: snk, name: name, s: s}, s.ReceiveLog)
+}
+
+// WaitUntilIndexed blocks until all the indexes that read the whole receive log processed it up to seq, or ctx is canceled.
+// Unlike WaitUntilIndexesAreSynced, this doesn't depend on timing and can be used to query the indexes for a message that was just appended.
+func (s *Sbot) WaitUntilIndexed(ctx context.Context, seq int64) error {
+	for {
+		s.indexedMu.Lock()
+		done := true
+		for _, indexed := range s.indexedSeqs {
+			if indexed < seq {
+				done = false
+				break
+			}
+		}
+		changed := s.indexedChanged
+		s.indexedMu.Unlock()
+
+		if done {
+			return nil
+		}
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-s.rootCtx.Done():
+			return ssb.ErrShuttingDown
+		}
+	}
+}
+
+// markIndexed notes that the index name processed the receive log up to seq. Indexes that read other logs are ignored.
+func (s *Sbot) markIndexed(name string, seq int64) {
+	s.indexedMu.Lock()
+	defer s.indexedMu.Unlock()
+
+	indexed, tracked := s.indexedSeqs[name]
+	if !tracked || indexed >= seq {
+		return
+	}
+	s.indexedSeqs[name] = seq
+
+	close(s.indexedChanged)
+	s.indexedChanged = make(chan struct{})
+}
+
+// indexedSink marks the sequence of every message it processed
+type indexedSink struct {
+	librarian.SinkIndex
+
+	name string
+	s    *Sbot
+}
+
+func (is indexedSink) Pour(ctx context.Context, v interface{}) error {
+	err := is.SinkIndex.Pour(ctx, v)
+	if err != nil {
+		return err
+	}
+
+	if sw, ok := v.(margaret.SeqWrapper); ok {
+		is.s.markIndexed(is.name, sw.Seq())
+	}
+	return nil
 }
 
 /* some indexes just require a certain kind of message, like type:contact or type:about.
@@ -175,27 +252,57 @@ if err != nil { ... }
 msgs := mutil.Indirect(s.ReceiveLog, contactLog)
 */
 func (s *Sbot) serveIndexFrom(name string, snk librarian.SinkIndex, msgs margaret.Log) {
+	r := &indexRunner{
+		name: name,
+		snk:  snk,
+		msgs: msgs,
+		seq:  margaret.SeqEmpty,
+	}
+
+	s.indexRunnersMu.Lock()
+	s.indexRunners[name] = r
+	s.indexRunnersMu.Unlock()
+
+	s.runIndex(r)
+}
+
+// runIndex processes the backlog of r and then the new messages, until the bot shuts down or r is stopped for a rebuild
+func (s *Sbot) runIndex(r *indexRunner) {
+	name, msgs := r.name, r.msgs
+
 	s.indexSyncStart()
 
 	s.indexStateMu.Lock()
 	s.indexStates[name] = "pending"
 	s.indexStateMu.Unlock()
 
+	runCtx, stop := context.WithCancel(s.rootCtx)
+	done := make(chan struct{})
+	r.mu.Lock()
+	r.stop, r.done = stop, done
+	r.mu.Unlock()
+
 	s.idxDone.Go(func() error {
+		defer close(done)
+		defer stop()
+
+		// the backlog goes at least this far, also if there was nothing left to process
+		before := msgs.Seq()
 
-		src, err := msgs.Query(margaret.Live(false), margaret.SeqWrap(true), snk.QuerySpec())
+		src, err := msgs.Query(margaret.Live(false), margaret.SeqWrap(true), r.snk.QuerySpec())
 		if err != nil {
+			s.indexSyncDone()
 			return fmt.Errorf("sbot index(%s) error querying receiveLog for message backlog: %w", name, err)
 		}
 
 		logger := log.With(s.info, "index", name)
 
 		var ps progressSink
-		ps.backing = snk
+		ps.backing = r
 
 		totalMessages := msgs.Seq()
 
-		ctx, cancel := context.WithCancel(s.rootCtx)
+		ctx, cancel := context.WithCancel(runCtx)
 		go func() {
 			p := progress.NewTicker(ctx, &ps, int64(totalMessages), 7*time.Second)
 			pinfo := log.With(level.Info(logger), "event", "index-progress")
@@ -212,7 +319,7 @@ func (s *Sbot) serveIndexFrom(name string, snk librarian.SinkIndex, msgs margare
 			}
 		}()
 
-		err = luigi.Pump(s.rootCtx, &ps, src)
+		err = luigi.Pump(runCtx, &ps, src)
 		cancel()
 		s.indexSyncDone() // this needs to be before we can return for errors or idxInSync will not be updated correctly
 		if errors.Is(err, ssb.ErrShuttingDown) || errors.Is(err, context.Canceled) {
@@ -225,12 +332,14 @@ func (s *Sbot) serveIndexFrom(name string, snk librarian.SinkIndex, msgs margare
 			level.Warn(logger).Log("event", "index stopped", "err", err)
 			return fmt.Errorf("sbot index(%s) update of backlog failed: %w", name, err)
 		}
+		s.markIndexed(name, before)
+		r.caughtUp(before)
 
 		if !s.liveIndexUpdates {
 			return nil
 		}
 
-		src, err = msgs.Query(margaret.Live(true), margaret.SeqWrap(true), snk.QuerySpec())
+		src, err = msgs.Query(margaret.Live(true), margaret.SeqWrap(true), r.snk.QuerySpec())
 		if err != nil {
 			return fmt.Errorf("sbot index(%s) failed to query receive log for live updates: %w", name, err)
 		}
@@ -256,7 +365,7 @@ func (s *Sbot) serveIndexFrom(name string, snk librarian.SinkIndex, msgs margare
 			})
 		}
 
-		err = luigi.PumpWithStatus(s.rootCtx, snk, src, startWaiting, doneWaiting, startProcessing, doneProcessing)
+		err = luigi.PumpWithStatus(runCtx, r, src, startWaiting, doneWaiting, startProcessing, doneProcessing)
 		if errors.Is(err, ssb.ErrShuttingDown) || errors.Is(err, context.Canceled) {
 			return nil
 		}
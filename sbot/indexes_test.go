@@ -0,0 +1,185 @@
+// SPDX-FileCopyrightText: 2021 The Go-SSB Authors
+//
+// SPDX-License-Identifier: MIT
+
+package sbot
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/dgraph-io/badger/v3"
+	"github.com/ssbc/go-muxrpc/v2"
+	librarian "github.com/ssbc/margaret/indexes"
+	"github.com/stretchr/testify/require"
+	"go.mindeco.de/log"
+
+	"github.com/ssbc/go-ssb"
+	refs "github.com/ssbc/go-ssb-refs"
+	"github.com/ssbc/go-ssb/client"
+	"github.com/ssbc/go-ssb/internal/storedrefs"
+	"github.com/ssbc/go-ssb/plugins/indexes"
+	"github.com/ssbc/go-ssb/plugins2/names"
+)
+
+func TestIndexControl(t *testing.T) {
+	r := require.New(t)
+
+	tRepoPath := filepath.Join("testrun", t.Name())
+	os.RemoveAll(tRepoPath)
+
+	logger := log.NewNopLogger()
+	if testing.Verbose() {
+		logger = log.NewLogfmtLogger(os.Stderr)
+	}
+
+	mainbot, err := New(
+		WithInfo(logger),
+		WithRepoPath(tRepoPath),
+		WithListenAddr(":0"),
+		LateOption(WithUNIXSocket()),
+	)
+	r.NoError(err)
+
+	publish := func(content interface{}) int64 {
+		_, err := mainbot.PublishLog.Publish(content)
+		r.NoError(err)
+		return mainbot.ReceiveLog.Seq()
+	}
+
+	publish(refs.NewAboutName(mainbot.KeyPair.ID(), "arny"))
+	publish(refs.NewContactFollow(mainbot.KeyPair.ID()))
+	mainbot.WaitUntilIndexesAreSynced()
+
+	c, err := client.NewUnix(filepath.Join(tRepoPath, "socket"))
+	r.NoError(err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	status := func() (int64, map[string]ssb.IndexProgress) {
+		var st indexes.Status
+		err := c.Async(ctx, &st, muxrpc.TypeJSON, muxrpc.Method{"indexes", "status"})
+		r.NoError(err)
+		byName := make(map[string]ssb.IndexProgress, len(st.Indexes))
+		for _, p := range st.Indexes {
+			byName[p.Name] = p
+		}
+		return st.RxSeq, byName
+	}
+
+	call := func(method string, names ...interface{}) error {
+		var ok bool
+		return c.Async(ctx, &ok, muxrpc.TypeJSON, muxrpc.Method{"indexes", method}, names...)
+	}
+
+	rxSeq, progress := status()
+	r.Contains(progress, "combined")
+	r.False(progress["combined"].Rebuildable)
+	r.True(progress["abouts"].Rebuildable)
+	r.Equal(int64(0), progress["abouts"].Seq, "the first message is the about")
+	r.Equal(rxSeq, progress["contacts"].Seq, "the contact is the latest message")
+	r.Equal(int64(0), progress["abouts"].Pending)
+
+	// a paused index doesn't see new messages
+	r.NoError(call("pause", "abouts"))
+	about := publish(refs.NewAboutName(mainbot.KeyPair.ID(), "bert"))
+
+	time.Sleep(250 * time.Millisecond)
+	_, progress = status()
+	r.True(progress["abouts"].Paused)
+	r.Equal("paused", progress["abouts"].State)
+	r.Equal(int64(0), progress["abouts"].Seq)
+	r.Equal(int64(1), progress["abouts"].Pending)
+
+	r.NoError(call("resume", "abouts"))
+	r.Eventually(func() bool {
+		_, progress = status()
+		return progress["abouts"].Seq == about
+	}, 5*time.Second, 50*time.Millisecond)
+	r.False(progress["abouts"].Paused)
+
+	// contacts starts over and catches up again
+	r.NoError(call("rebuild", "contacts"))
+	r.Eventually(func() bool {
+		_, progress = status()
+		return progress["contacts"].Seq == 1 && progress["contacts"].Processed == 1
+	}, 5*time.Second, 50*time.Millisecond)
+
+	r.Error(call("rebuild", "combined"), "only offline")
+	r.Error(call("rebuild", "nope"))
+	r.Error(call("rebuild"), "needs a name")
+	r.Error(call("pause", "nope"))
+
+	r.NoError(c.Close())
+	mainbot.Shutdown()
+	r.NoError(mainbot.Close())
+}
+
+func TestRebuildIndexDropsValues(t *testing.T) {
+	r := require.New(t)
+
+	tRepoPath := filepath.Join("testrun", t.Name())
+	os.RemoveAll(tRepoPath)
+
+	bot, err := New(
+		WithInfo(log.NewNopLogger()),
+		WithRepoPath(tRepoPath),
+		DisableNetworkNode(),
+	)
+	r.NoError(err)
+
+	post, err := bot.PublishLog.Publish(refs.NewPost("hello"))
+	r.NoError(err)
+	_, err = bot.PublishLog.Publish(refs.NewAboutName(bot.KeyPair.ID(), "arny"))
+	r.NoError(err)
+	bot.WaitUntilIndexesAreSynced()
+
+	// values that processing the messages doesn't write
+	gone, err := refs.NewMessageRefFromBytes(bytes.Repeat([]byte{1}, 32), refs.RefAlgoMessageSSB1)
+	r.NoError(err)
+	getIdx := bot.simpleIndex["get"].(librarian.SetterIndex)
+	r.NoError(getIdx.Set(context.TODO(), storedrefs.Message(gone), int64(0)))
+	_, err = bot.Get(gone)
+	r.NoError(err)
+
+	staleAbout := []byte(names.IndexKeyPrefix + "stale")
+	err = bot.indexStore.Update(func(txn *badger.Txn) error {
+		return txn.Set(staleAbout, []byte("{}"))
+	})
+	r.NoError(err)
+
+	for _, name := range []string{"get", "abouts"} {
+		r.NoError(bot.RebuildIndex(name))
+	}
+	rxSeq := bot.ReceiveLog.Seq()
+	r.Eventually(func() bool {
+		var caughtUp int
+		for _, p := range bot.IndexProgress() {
+			if (p.Name == "get" || p.Name == "abouts") && p.Seq == rxSeq {
+				caughtUp++
+			}
+		}
+		return caughtUp == 2
+	}, 5*time.Second, 50*time.Millisecond)
+
+	_, err = bot.Get(gone)
+	r.Error(err, "get kept a value of the previous run")
+	msg, err := bot.Get(post.Key())
+	r.NoError(err)
+	r.Equal(post.Key().String(), msg.Key().String())
+
+	err = bot.indexStore.View(func(txn *badger.Txn) error {
+		_, err := txn.Get(staleAbout)
+		return err
+	})
+	r.ErrorIs(err, badger.ErrKeyNotFound, "abouts kept a value of the previous run")
+
+	bot.Shutdown()
+	r.ErrorIs(bot.RebuildIndex("get"), ssb.ErrShuttingDown)
+	r.NoError(bot.Close())
+}
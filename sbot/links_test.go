@@ -0,0 +1,121 @@
+// SPDX-FileCopyrightText: 2021 The Go-SSB Authors
+//
+// SPDX-License-Identifier: MIT
+
+package sbot
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.mindeco.de/log"
+
+	"github.com/ssbc/go-ssb"
+	refs "github.com/ssbc/go-ssb-refs"
+	"github.com/ssbc/go-ssb/client"
+	"github.com/ssbc/go-ssb/message"
+	"github.com/ssbc/go-ssb/plugins/links"
+)
+
+func TestLinks(t *testing.T) {
+	r := require.New(t)
+
+	tRepoPath := filepath.Join("testrun", t.Name())
+	os.RemoveAll(tRepoPath)
+
+	logger := log.NewNopLogger()
+	if testing.Verbose() {
+		logger = log.NewLogfmtLogger(os.Stderr)
+	}
+
+	mainbot, err := New(
+		WithInfo(logger),
+		WithRepoPath(tRepoPath),
+		WithListenAddr(":0"),
+		LateOption(WithUNIXSocket()),
+	)
+	r.NoError(err)
+
+	target, err := ssb.NewKeyPair(nil, refs.RefAlgoFeedSSB1)
+	r.NoError(err)
+
+	// a public mention and a private one, in a group that only we are part of
+	mention := refs.NewPost("hello there")
+	mention.Mentions = []refs.Mention{refs.NewMention(target.ID(), "target")}
+	public, err := mainbot.PublishLog.Publish(mention)
+	r.NoError(err)
+
+	cloaked, _, err := mainbot.Groups.Create("just me")
+	r.NoError(err)
+	secret, err := json.Marshal(refs.NewPost("psst, " + target.ID().String()))
+	r.NoError(err)
+	private, err := mainbot.Groups.PublishTo(cloaked, secret)
+	r.NoError(err)
+
+	_, err = mainbot.PublishLog.Publish(refs.NewPost("no links here"))
+	r.NoError(err)
+
+	mainbot.WaitUntilIndexesAreSynced()
+
+	c, err := client.NewUnix(filepath.Join(tRepoPath, "socket"))
+	r.NoError(err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	next := func(src interface {
+		Next(context.Context) bool
+		Bytes() ([]byte, error)
+	}) links.Link {
+		r.True(src.Next(ctx), "expected another link")
+		b, err := src.Bytes()
+		r.NoError(err)
+		var l links.Link
+		r.NoError(json.Unmarshal(b, &l))
+		return l
+	}
+
+	args := message.NewLinksArgs()
+	args.Dest = target.ID().String()
+	args.Live = true
+	src, err := c.Links(args)
+	r.NoError(err)
+
+	l := next(src)
+	r.Equal("mentions", l.Rel)
+	r.Equal(public.Key().String(), l.Key.String())
+	r.True(l.Source.Equal(mainbot.KeyPair.ID()))
+
+	l = next(src)
+	r.Equal("text", l.Rel)
+	r.Equal(private.String(), l.Key.String())
+
+	// new links follow once they are indexed
+	live, err := mainbot.PublishLog.Publish(refs.NewPost("about " + target.ID().String()))
+	r.NoError(err)
+
+	l = next(src)
+	r.Equal("text", l.Rel)
+	r.Equal(live.Key().String(), l.Key.String())
+
+	// only the mentions
+	args = message.NewLinksArgs()
+	args.Dest = target.ID().String()
+	args.Rel = "mentions"
+	src, err = c.Links(args)
+	r.NoError(err)
+
+	l = next(src)
+	r.Equal(public.Key().String(), l.Key.String())
+	r.False(src.Next(ctx))
+
+	r.NoError(c.Close())
+
+	mainbot.Shutdown()
+	r.NoError(mainbot.Close())
+}
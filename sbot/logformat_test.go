@@ -0,0 +1,110 @@
+// SPDX-FileCopyrightText: 2021 The Go-SSB Authors
+//
+// SPDX-License-Identifier: MIT
+
+package sbot
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ssbc/margaret"
+	"github.com/stretchr/testify/require"
+	"go.mindeco.de/log"
+
+	refs "github.com/ssbc/go-ssb-refs"
+	"github.com/ssbc/go-ssb/internal/storedrefs"
+	"github.com/ssbc/go-ssb/repo"
+)
+
+func TestReceiveLogFormat(t *testing.T) {
+	for _, format := range []repo.LogFormat{repo.LogFormatBIPF, repo.LogFormatSegmented} {
+		t.Run(string(format), func(t *testing.T) {
+			r := require.New(t)
+
+			tRepoPath := filepath.Join("testrun", t.Name())
+			os.RemoveAll(tRepoPath)
+			tRepo := repo.New(tRepoPath)
+
+			logger := log.NewNopLogger()
+			if testing.Verbose() {
+				logger = log.NewLogfmtLogger(os.Stderr)
+			}
+
+			open := func(opts ...Option) (*Sbot, error) {
+				return New(append([]Option{
+					WithInfo(logger),
+					WithRepoPath(tRepoPath),
+					DisableNetworkNode(),
+				}, opts...)...)
+			}
+
+			mainbot, err := open(WithReceiveLogFormat(format))
+			r.NoError(err)
+
+			arny, err := repo.NewKeyPair(tRepo, "arny", refs.RefAlgoFeedSSB1)
+			r.NoError(err)
+
+			_, err = mainbot.PublishLog.Publish(map[string]interface{}{"type": "test", "i": -1})
+			r.NoError(err)
+
+			var keys []refs.MessageRef
+			for i := 0; i < 4; i++ {
+				msg, err := mainbot.PublishAs("arny", map[string]interface{}{"type": "test", "i": i})
+				r.NoError(err)
+				keys = append(keys, msg.Key())
+			}
+			mainbot.WaitUntilIndexesAreSynced()
+			r.NoError(mainbot.NullFeed(mainbot.KeyPair.ID()))
+			mainbot.Shutdown()
+			r.NoError(mainbot.Close())
+
+			got, err := repo.DetectLogFormat(tRepo.GetPath("log"))
+			r.NoError(err)
+			r.Equal(format, got)
+
+			// an existing log isn't opened as another format
+			_, err = repo.OpenLogWithFormat(tRepo, repo.LogFormatOffset2)
+			r.Error(err)
+
+			mainbot, err = open()
+			r.NoError(err)
+
+			seqs, err := mainbot.Users.Get(storedrefs.Feed(arny.ID()))
+			r.NoError(err)
+			r.EqualValues(3, seqs.Seq())
+			for i, key := range keys {
+				msg, err := mainbot.Get(key)
+				r.NoError(err, "message %d", i)
+				r.True(msg.Author().Equal(arny.ID()))
+				r.EqualValues(i+1, msg.Seq())
+			}
+
+			// compaction keeps the format
+			stats, err := mainbot.CompactReceiveLog()
+			r.NoError(err)
+			r.EqualValues(1, stats.Removed)
+			r.EqualValues(4, stats.Kept)
+			mainbot.Shutdown()
+			r.NoError(mainbot.Close())
+
+			mainbot, err = open()
+			r.NoError(err)
+			mainbot.WaitUntilIndexesAreSynced()
+
+			got, err = repo.DetectLogFormat(tRepo.GetPath("log"))
+			r.NoError(err)
+			r.Equal(format, got)
+			r.EqualValues(3, mainbot.ReceiveLog.Seq())
+			_, err = mainbot.ReceiveLog.Get(0)
+			r.False(margaret.IsErrNulled(err), "the nulled message was dropped")
+			msg, err := mainbot.Get(keys[3])
+			r.NoError(err)
+			r.EqualValues(4, msg.Seq())
+
+			mainbot.Shutdown()
+			r.NoError(mainbot.Close())
+		})
+	}
+}
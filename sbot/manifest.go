@@ -59,6 +59,15 @@ func init() {
 // hardcoded manifest for MUXRPC clients
 var manifestBlob manifestHandler = `
 {
+	"archive": {
+		"export": "source",
+		"import": "duplex"
+	},
+	"auth": {
+		"grant": "async",
+		"list": "source",
+		"revoke": "async"
+	},
 	"blobs": {
 		"add": "sink",
 		"createWants": "source",
@@ -67,6 +76,7 @@ var manifestBlob manifestHandler = `
 		"size": "async",
 		"want": "async"
 	},
+	"compact": "async",
 	"conn": {
 		"connect": "async",
 		"dialViaRoom": "async",
@@ -81,9 +91,12 @@ var manifestBlob manifestHandler = `
 	},
 	"friends": {
 		"blocks": "source",
+		"explain": "async",
+		"hopStream": "source",
 		"hops": "source",
 		"isBlocking": "async",
-		"isFollowing": "async"
+		"isFollowing": "async",
+		"stream": "source"
 	},
 	"get": "async",
 	"gossip": {
@@ -94,10 +107,17 @@ var manifestBlob manifestHandler = `
 		"create":"async",
 		"publishTo":"async"
   },
+	"indexes": {
+		"pause": "async",
+		"rebuild": "async",
+		"resume": "async",
+		"status": "async"
+	},
 	"invite": {
 		"create": "async",
 		"use": "async"
 	},
+	"links": "source",
 	"manifest": "sync",
 	"messagesByType": "source",
 	"names": {
@@ -114,9 +134,17 @@ var manifestBlob manifestHandler = `
 		"publish": "async",
 		"read":"source"
 	},
+	"query": {
+		"explain": "async"
+	},
 	"replicate": {
+		"progress": "source",
 		"upto": "source"
 	},
+	"search": {
+		"query": "source"
+	},
+	"snapshot": "async",
 	"status": "sync",
 	"tangles": {
 		"thread": "source"
@@ -7,6 +7,7 @@ package sbot
 import (
 	"context"
 	"encoding/base64"
+	"errors"
 	"fmt"
 	"io"
 	"net"
@@ -24,6 +25,7 @@ import (
 	"github.com/ssbc/go-metafeed/metamngmt"
 	"github.com/ssbc/go-muxrpc/v2"
 	"github.com/ssbc/go-netwrap"
+	"github.com/ssbc/margaret"
 	librarian "github.com/ssbc/margaret/indexes"
 	libbadger "github.com/ssbc/margaret/indexes/badger"
 	"github.com/ssbc/margaret/multilog"
@@ -39,33 +41,46 @@ import (
 	"github.com/ssbc/go-ssb/blobstore"
 	"github.com/ssbc/go-ssb/graph"
 	"github.com/ssbc/go-ssb/indexes"
+	"github.com/ssbc/go-ssb/indexes/declared"
+	"github.com/ssbc/go-ssb/internal/feedsegments"
 	"github.com/ssbc/go-ssb/internal/multicloser"
 	"github.com/ssbc/go-ssb/internal/mutil"
+	"github.com/ssbc/go-ssb/internal/replprogress"
 	"github.com/ssbc/go-ssb/internal/statematrix"
 	"github.com/ssbc/go-ssb/internal/storedrefs"
 	"github.com/ssbc/go-ssb/message"
 	"github.com/ssbc/go-ssb/message/multimsg"
 	"github.com/ssbc/go-ssb/multilogs"
 	"github.com/ssbc/go-ssb/network"
+	archiveplug "github.com/ssbc/go-ssb/plugins/archive"
 	"github.com/ssbc/go-ssb/plugins/blobs"
+	"github.com/ssbc/go-ssb/plugins/clientauth"
+	compactplug "github.com/ssbc/go-ssb/plugins/compact"
 	"github.com/ssbc/go-ssb/plugins/conn"
 	"github.com/ssbc/go-ssb/plugins/ebt"
 	"github.com/ssbc/go-ssb/plugins/friends"
 	"github.com/ssbc/go-ssb/plugins/get"
 	"github.com/ssbc/go-ssb/plugins/gossip"
 	"github.com/ssbc/go-ssb/plugins/groups"
+	indexesplug "github.com/ssbc/go-ssb/plugins/indexes"
 	"github.com/ssbc/go-ssb/plugins/legacyinvites"
+	"github.com/ssbc/go-ssb/plugins/links"
 	"github.com/ssbc/go-ssb/plugins/partial"
 	privplug "github.com/ssbc/go-ssb/plugins/private"
 	"github.com/ssbc/go-ssb/plugins/publish"
+	queryplug "github.com/ssbc/go-ssb/plugins/query"
 	"github.com/ssbc/go-ssb/plugins/rawread"
 	"github.com/ssbc/go-ssb/plugins/replicate"
+	snapshotplug "github.com/ssbc/go-ssb/plugins/snapshot"
 	"github.com/ssbc/go-ssb/plugins/status"
 	"github.com/ssbc/go-ssb/plugins/tangles"
 	"github.com/ssbc/go-ssb/plugins/whoami"
+	"github.com/ssbc/go-ssb/plugins2"
 	"github.com/ssbc/go-ssb/plugins2/names"
+	"github.com/ssbc/go-ssb/plugins2/search"
 	"github.com/ssbc/go-ssb/private"
 	"github.com/ssbc/go-ssb/private/keys"
+	"github.com/ssbc/go-ssb/query"
 	"github.com/ssbc/go-ssb/repo"
 )
 
@@ -94,6 +109,8 @@ type Sbot struct {
 	promisc  bool
 	hopCount uint
 
+	replicationPolicy *graph.Policy
+
 	disableEBT                   bool
 	disableLegacyLiveReplication bool
 
@@ -103,8 +120,12 @@ type Sbot struct {
 	appKey             []byte
 	listenAddr         net.Addr
 	dialer             netwrap.Dialer
+	onionDialer        netwrap.Dialer
+	onionListenAddr    net.Addr
 	edpWrapper         MuxrpcEndpointWrapper
 	networkConnTracker ssb.ConnTracker
+	firewallConfig     *network.FirewallConfig
+	Firewall           *network.Firewall // only set if WithFirewall was used
 	preSecureWrappers  []netwrap.ConnWrapper
 	postSecureWrappers []netwrap.ConnWrapper
 
@@ -113,6 +134,9 @@ type Sbot struct {
 
 	authorizer ssb.Authorizer
 
+	// ClientGrants holds the capabilities of other keypairs which may use the master interface
+	ClientGrants *clientauth.Store
+
 	enableAdverts   bool
 	enableDiscovery bool
 
@@ -123,8 +147,13 @@ type Sbot struct {
 	numberOfConcurrentReplicationsPerPeer uint
 	numberOfConcurrentReplications        uint
 
-	repoPath string
-	KeyPair  ssb.KeyPair
+	repoPath   string
+	repoSecret []byte
+	repoKey    *repo.DataKey // the data key of an encrypted repo
+	logFormat  repo.LogFormat
+	KeyPair    ssb.KeyPair
+
+	keyPairSecret []byte // decrypts the secret file of KeyPair
 
 	Groups *private.Manager
 
@@ -140,9 +169,36 @@ type Sbot struct {
 	Private *roaring.MultiLog // one sublog per keypair
 	ByType  *roaring.MultiLog // one sublog per type: ... (special cases for private messages by suffix)
 	Tangles *roaring.MultiLog // one sublog per root:%ref (actual root is in the get index)
+	Query   *roaring.MultiLog // one sublog per mention:ref and channel:name of public messages
+
+	Backlinks *roaring.MultiLog // one sublog per link:ref and rel:field:ref, including private messages
+
+	// FeedSegments has a log for every author if it is enabled, see EnableFeedSegments
+	FeedSegments       *feedsegments.Store
+	enableFeedSegments bool
+
+	// Search is the optional full-text index, see EnableSearch
+	Search       *search.Plugin
+	enableSearch bool
+
+	// Declared are the indexes of DeclareIndex and the plugins, which the query engine also uses for field
+	Declared       *declared.Registry
+	declaredSpecs  []declared.Spec
+	indexDeclarers []plugins2.NeedsDeclaredIndexes
 
 	indexStore *badger.DB
 
+	// only one CompactReceiveLog at a time
+	compactMu  sync.Mutex
+	compacting bool
+
+	// only one Snapshot at a time
+	snapshotMu   sync.Mutex
+	snapshotting bool
+
+	// the index stores that batch their writes, see flushable
+	flushers []flusher
+
 	// plugin indexes
 	mlogIndicies map[string]multilog.MultiLog
 	simpleIndex  map[string]librarian.Index
@@ -151,8 +207,19 @@ type Sbot struct {
 	indexStateMu     sync.Mutex
 	indexStates      map[string]string
 
+	// the served indexes, see IndexProgress
+	indexRunnersMu sync.Mutex
+	indexRunners   map[string]*indexRunner
+
+	// the receive log sequence that each index which reads all of it has processed, see WaitUntilIndexed
+	indexedMu      sync.Mutex
+	indexedSeqs    map[string]int64
+	indexedChanged chan struct{}
+
 	ebtState *statematrix.StateMatrix
 
+	replProgress *replprogress.Tracker
+
 	verifyRouter *message.VerificationRouter
 
 	GraphBuilder *graph.BadgerBuilder
@@ -183,6 +250,9 @@ func New(fopts ...Option) (*Sbot, error) {
 	s.mlogIndicies = make(map[string]multilog.MultiLog)
 	s.simpleIndex = make(map[string]librarian.Index)
 	s.indexStates = make(map[string]string)
+	s.indexRunners = make(map[string]*indexRunner)
+	s.indexedSeqs = make(map[string]int64)
+	s.indexedChanged = make(chan struct{})
 
 	s.disableLegacyLiveReplication = true
 
@@ -224,27 +294,36 @@ func New(fopts ...Option) (*Sbot, error) {
 		s.info = logger
 	}
 
-	if s.rootCtx == nil {
-		s.rootCtx, s.Shutdown = ShutdownContext(context.Background())
+	// before anything is started that would have to be stopped again
+	storageRepo, err := s.unlockRepo()
+	if err != nil {
+		return nil, fmt.Errorf("sbot: %w", err)
 	}
-	ctx := s.rootCtx
-
-	storageRepo := repo.New(s.repoPath)
 
-	var err error
 	if s.KeyPair == nil {
 		algo := refs.RefAlgoFeedSSB1
 		if s.enableMetafeeds {
 			algo = refs.RefAlgoFeedBendyButt
 		}
-		s.KeyPair, err = repo.DefaultKeyPair(storageRepo, algo)
+		s.KeyPair, err = repo.DefaultKeyPairWithSecret(storageRepo, algo, s.keyPairSecret)
 		if err != nil {
 			return nil, fmt.Errorf("sbot: failed to get keypair: %w", err)
 		}
 	}
 
+	if s.rootCtx == nil {
+		s.rootCtx, s.Shutdown = ShutdownContext(context.Background())
+	}
+	ctx := s.rootCtx
+
+	// a compaction of the receive log is finished before anything uses the log or the indexes
+	err = finishCompaction(storageRepo, s.KeyPair.ID(), s.info)
+	if err != nil {
+		return nil, fmt.Errorf("sbot: failed to finish compaction of the receive log: %w", err)
+	}
+
 	// TODO: optionize
-	s.ReceiveLog, err = repo.OpenLog(storageRepo)
+	s.ReceiveLog, err = repo.OpenLogWithFormat(storageRepo, s.logFormat)
 	if err != nil {
 		return nil, fmt.Errorf("sbot: failed to open rootlog: %w", err)
 	}
@@ -284,6 +363,7 @@ func New(fopts ...Option) (*Sbot, error) {
 	}
 	s.closers.AddCloser(sm)
 	s.ebtState = sm
+	s.replProgress = replprogress.New()
 
 	// open timestamp and sequence resovlers
 	s.SeqResolver, err = repo.NewSequenceResolver(storageRepo)
@@ -294,7 +374,7 @@ func New(fopts ...Option) (*Sbot, error) {
 	s.closers.AddCloser(idxTimestamps)
 	s.serveIndex("timestamps", idxTimestamps)
 
-	s.indexStore, err = repo.OpenBadgerDB(storageRepo.GetPath(repo.PrefixMultiLog, "shared-badger"))
+	s.indexStore, err = repo.OpenBadgerDBWithKey(storageRepo.GetPath(repo.PrefixMultiLog, "shared-badger"), s.repoKey)
 	if err != nil {
 		return nil, err
 	}
@@ -308,8 +388,8 @@ func New(fopts ...Option) (*Sbot, error) {
 		{multilogs.IndexNamePrivates, &s.Private},
 		{"msgTypes", &s.ByType},
 		{"tangles", &s.Tangles},
-		// TODO: channels
-		// TODO: mentions
+		{multilogs.IndexNameQuery, &s.Query},
+		{multilogs.IndexNameBacklinks, &s.Backlinks},
 	}
 	for _, index := range mlogs {
 		mlog, err := multibadger.NewShared(s.indexStore, []byte("mlog-"+index.Name))
@@ -317,6 +397,7 @@ func New(fopts ...Option) (*Sbot, error) {
 			return nil, err
 		}
 		s.closers.AddCloser(mlog)
+		s.flushable(mlog)
 		s.mlogIndicies[index.Name] = mlog
 
 		*index.Mlog = mlog
@@ -339,6 +420,8 @@ func New(fopts ...Option) (*Sbot, error) {
 	getIdx, updateSink := indexes.OpenGet(s.indexStore)
 	s.closers.AddCloser(updateSink)
 	s.serveIndex("get", updateSink)
+	s.rebuildable("get", dropAndStartOver(getIdx, s.indexStore, []byte(indexes.GetKeyPrefix)))
+	s.flushable(getIdx)
 	s.simpleIndex["get"] = getIdx
 
 	// groups2
@@ -347,6 +430,7 @@ func New(fopts ...Option) (*Sbot, error) {
 		Index: idxKeys,
 	}
 	s.closers.AddCloser(idxKeys)
+	s.flushable(idxKeys)
 
 	s.Groups = private.NewManager(s.KeyPair, s.PublishLog, keysStore, s.ReceiveLog, s, s.Tangles)
 
@@ -355,6 +439,7 @@ func New(fopts ...Option) (*Sbot, error) {
 		return nil, err
 	}
 	s.closers.AddCloser(groupsHelperMlog)
+	s.flushable(groupsHelperMlog)
 
 	// the big combined index of most the things
 	combIdx, err := multilogs.NewCombinedIndex(
@@ -366,6 +451,7 @@ func New(fopts ...Option) (*Sbot, error) {
 		s.Private,
 		s.ByType,
 		s.Tangles,
+		s.Backlinks,
 		groupsHelperMlog,
 		sm,
 	)
@@ -375,6 +461,25 @@ func New(fopts ...Option) (*Sbot, error) {
 	s.serveIndex("combined", combIdx)
 	s.closers.AddCloser(combIdx)
 
+	// mentions and channels for the query engine
+	queryIdx, err := multilogs.OpenQueryIndex(storageRepo, s.Query)
+	if err != nil {
+		return nil, fmt.Errorf("sbot: failed to open query index: %w", err)
+	}
+	s.serveIndex(multilogs.IndexNameQuery, queryIdx)
+	s.closers.AddCloser(queryIdx)
+
+	// one log per author, see EnableFeedSegments
+	if s.enableFeedSegments {
+		s.FeedSegments, err = feedsegments.Open(storageRepo.GetPath(feedsegments.FolderName), s.repoKey)
+		if err != nil {
+			return nil, fmt.Errorf("sbot: failed to open feed segments: %w", err)
+		}
+		s.closers.AddCloser(s.FeedSegments)
+		s.serveIndex(feedSegmentsIndex, s.FeedSegments)
+		s.rebuildable(feedSegmentsIndex, s.FeedSegments.Reset)
+	}
+
 	// groups re-indexing
 	members, membersSnk := multilogs.NewMembershipIndex(
 		log.With(s.info, "unit", "private-groups"),
@@ -384,6 +489,7 @@ func New(fopts ...Option) (*Sbot, error) {
 		combIdx,
 	)
 	s.closers.AddCloser(members)
+	s.flushable(members)
 	s.closers.AddCloser(membersSnk)
 
 	addMemberIdxAddr := librarian.Addr("string:group/add-member")
@@ -422,9 +528,32 @@ func New(fopts ...Option) (*Sbot, error) {
 
 	// fill the index
 	s.serveIndexFrom("contacts", updateContactsSink, justContacts)
+	s.rebuildable("contacts", startOver(seqSetter))
 	s.closers.AddCloser(seqSetter)
+	s.flushable(seqSetter)
 	s.GraphBuilder = gb
 
+	// private contacts count for our own replication and blocking, but are not shared with other peers
+	box1Seqs, err := s.Private.Get(librarian.Addr("box1:") + storedrefs.Feed(s.KeyPair.ID()))
+	if err != nil {
+		return nil, fmt.Errorf("sbot: failed to open box1 sublog: %w", err)
+	}
+	box2Seqs, err := s.Private.Get(librarian.Addr("box2:") + storedrefs.Feed(s.KeyPair.ID()))
+	if err != nil {
+		return nil, fmt.Errorf("sbot: failed to open box2 sublog: %w", err)
+	}
+	privateMsgs := map[string]margaret.Log{
+		"box1": private.NewUnboxerLog(s.ReceiveLog, box1Seqs, s.KeyPair),
+		"box2": s.Groups.NewUnboxerLog(s.ReceiveLog, box2Seqs),
+	}
+	for name, msgs := range privateMsgs {
+		privSeqSetter, privContactsSink := gb.OpenPrivateContactsIndex(name)
+		s.serveIndexFrom("private contacts "+name, privContactsSink, msgs)
+		s.rebuildable("private contacts "+name, startOver(privSeqSetter))
+		s.closers.AddCloser(privSeqSetter)
+		s.flushable(privSeqSetter)
+	}
+
 	// abouts
 
 	// create data source for abouts
@@ -435,9 +564,32 @@ func New(fopts ...Option) (*Sbot, error) {
 	aboutsOnly := mutil.Indirect(s.ReceiveLog, aboutSeqs)
 
 	var namesPlug names.Plugin
-	_, aboutSnk := namesPlug.OpenSharedIndex(s.indexStore)
+	aboutIdx, aboutSnk := namesPlug.OpenSharedIndex(s.indexStore)
 	s.closers.AddCloser(aboutSnk)
 	s.serveIndexFrom("abouts", aboutSnk, aboutsOnly)
+	s.rebuildable("abouts", dropAndStartOver(aboutIdx, s.indexStore, []byte(names.IndexKeyPrefix)))
+	s.flushable(aboutIdx)
+
+	// full-text search, which includes the private messages
+	if s.enableSearch {
+		s.Search = search.New(log.With(s.info, "plugin", "search"), s.ReceiveLog, s.Groups, query.NewPlaner(s.querySources()))
+		err = MountSimpleIndex(search.IndexName, s.Search.MakeSimpleIndex)(s)
+		if err != nil {
+			return nil, fmt.Errorf("sbot: failed to mount search index: %w", err)
+		}
+	}
+
+	// the declared indexes catch up when they are queried, so they aren't served like the others
+	s.Declared = declared.NewRegistry(s.indexStore, s.ReceiveLog, s.Groups)
+	for _, spec := range s.declaredSpecs {
+		if err := s.Declared.Declare(spec); err != nil {
+			return nil, fmt.Errorf("sbot: failed to declare index: %w", err)
+		}
+	}
+	for _, ndi := range s.indexDeclarers {
+		ndi.WantDeclaredIndexes(s.Declared)
+	}
+	s.closers.AddCloser(s.Declared)
 
 	// need to close s.indexStore _after_ the all the indexes closed and flushed
 	s.closers.AddCloser(s.indexStore)
@@ -527,8 +679,10 @@ func New(fopts ...Option) (*Sbot, error) {
 			return rightType
 		})
 
-		_, mfSink := gb.OpenMetafeedsIndex()
+		mfIdx, mfSink := gb.OpenMetafeedsIndex()
 		s.serveIndexFrom("metafeed", mfSink, justMetafeedMessages)
+		s.rebuildable("metafeed", startOver(mfIdx))
+		s.flushable(mfIdx)
 
 		// 2) metafeed/announce on normal format
 		byTypeAnnouncementSeqs, err := s.ByType.Get(librarian.Addr("string:metafeed/announce"))
@@ -539,8 +693,16 @@ func New(fopts ...Option) (*Sbot, error) {
 		// convert sequences only to their actual messages using mutil.Indirect
 		byTypeAnnouncements := mutil.Indirect(s.ReceiveLog, byTypeAnnouncementSeqs)
 
-		_, announcementSink := gb.OpenAnnouncementIndex()
+		announcementIdx, announcementSink := gb.OpenAnnouncementIndex()
 		s.serveIndexFrom("metafeed announcements", announcementSink, byTypeAnnouncements)
+		s.rebuildable("metafeed announcements", startOver(announcementIdx))
+		s.flushable(announcementIdx)
+	}
+
+	// used by replication and imports alike, so that they don't append the same messages
+	s.verifyRouter, err = message.NewVerificationRouter(s.ReceiveLog, s.Users, s.signHMACsecret)
+	if err != nil {
+		return nil, err
 	}
 
 	// from here on just network related stuff
@@ -550,6 +712,8 @@ func New(fopts ...Option) (*Sbot, error) {
 
 	var inviteService *legacyinvites.Service
 
+	s.ClientGrants = clientauth.NewStore(s.indexStore)
+
 	// muxrpc handler creation and authoratization decider
 	mkHandler := func(conn net.Conn) (muxrpc.Handler, error) {
 		// bypassing badger-close bug to go through with an accept (or not) before closing the bot
@@ -567,6 +731,17 @@ func New(fopts ...Option) (*Sbot, error) {
 			return s.master.MakeHandler(conn)
 		}
 
+		// other keypairs with a grant get the master handler, restricted to their capabilities
+		if grant, err := s.ClientGrants.Get(remote); err == nil {
+			h, err := s.master.MakeHandler(conn)
+			if err != nil {
+				return nil, err
+			}
+			return clientauth.Restrict(h, grant), nil
+		} else if !errors.Is(err, clientauth.ErrNoGrant) {
+			return nil, err
+		}
+
 		if inviteService != nil {
 			err := inviteService.Authorize(remote)
 			if err == nil {
@@ -660,10 +835,14 @@ func New(fopts ...Option) (*Sbot, error) {
 		s.systemGauge,
 		s.eventCounter,
 	)
+	if s.FeedSegments != nil {
+		fm.Segments = s.FeedSegments
+	}
 
 	// outgoing gossip behavior
 	var histOpts = []interface{}{
 		gossip.Promisc(s.promisc),
+		s.replProgress,
 	}
 
 	if s.systemGauge != nil {
@@ -686,11 +865,6 @@ func New(fopts ...Option) (*Sbot, error) {
 		histOpts = append(histOpts, gossip.NumberOfConcurrentReplications(s.numberOfConcurrentReplications))
 	}
 
-	s.verifyRouter, err = message.NewVerificationRouter(s.ReceiveLog, s.Users, s.signHMACsecret)
-	if err != nil {
-		return nil, err
-	}
-
 	if s.disableLegacyLiveReplication {
 		histOpts = append(histOpts, gossip.WithLive(!s.disableLegacyLiveReplication))
 	}
@@ -715,6 +889,7 @@ func New(fopts ...Option) (*Sbot, error) {
 			fm,
 			sm,
 			s.verifyRouter,
+			s.replProgress,
 		)
 		s.public.Register(ebtPlug)
 
@@ -738,24 +913,51 @@ func New(fopts ...Option) (*Sbot, error) {
 	s.public.Register(hist)
 
 	// get idx muxrpc handler
-	s.master.Register(get.New(s, s.ReceiveLog, s.Groups))
+	s.master.Register(get.New(s, s.ReceiveLog, s.Groups, selfChecker{s.KeyPair.ID()}))
 
 	// about information
 	s.master.Register(namesPlug)
 
 	// (insecure) partial proof-of-concept for browser-core/demo
-	plug := partial.New(s.info,
+	s.public.Register(partial.New(s.info,
 		fm,
 		s.Users,
 		s.ByType,
 		s.Tangles,
-		s.ReceiveLog, s)
-	s.public.Register(plug)
-	s.master.Register(plug)
+		s.ReceiveLog, s))
+	// the bot itself can use all the operations, including the private messages.
+	// clients with a read grant also use the master handler, they get the same as remote peers.
+	s.master.Register(partial.NewPrivileged(s.info,
+		s.Users,
+		s.ByType,
+		s.Tangles,
+		s.ReceiveLog, s,
+		query.NewPlaner(s.querySources()), s,
+		selfChecker{s.KeyPair.ID()}))
 
 	// group managment
 	s.master.Register(groups.New(s.info, s.Groups))
 
+	// query plans, which include the private messages
+	s.master.Register(queryplug.New(s.info, query.NewPlaner(s.querySources())))
+
+	// index progress, pausing and rebuilding
+	s.master.Register(indexesplug.New(s.info, s, s.ReceiveLog))
+	s.master.Register(compactplug.New(s.info, s))
+	s.master.Register(archiveplug.New(s.info, s.KeyPair.ID(), s))
+	s.master.Register(snapshotplug.New(s.info, s))
+
+	if s.Search != nil {
+		s.master.Register(s.Search)
+	}
+
+	// backlinks, which include the private messages
+	s.master.Register(links.New(s.info, s.ReceiveLog, query.NewPlaner(query.Sources{
+		Authors:   s.Users,
+		Backlinks: s.Backlinks,
+		RxLog:     s.ReceiveLog,
+	}), s.Groups, s))
+
 	// raw log plugins
 
 	sc := selfChecker{s.KeyPair.ID()}
@@ -772,9 +974,14 @@ func New(fopts ...Option) (*Sbot, error) {
 	s.master.Register(rawread.NewSortedStream(s.info, s.ReceiveLog, s.SeqResolver))
 	s.master.Register(hist) // createHistoryStream
 
-	s.master.Register(replicate.NewPlug(s.Users, s.KeyPair.ID(), s.Lister()))
+	s.master.Register(replicate.NewPlug(s.Users, s.KeyPair.ID(), s.Lister(), s))
 
-	s.master.Register(friends.New(s.info, s.KeyPair.ID(), s.GraphBuilder))
+	// only the graph based replicator can explain its decisions
+	var explainer friends.Explainer
+	if gr, ok := s.Replicator.(*graphReplicator); ok {
+		explainer = gr
+	}
+	s.master.Register(friends.New(s.info, s.KeyPair.ID(), s.GraphBuilder, explainer))
 
 	mh := namedPlugin{
 		h:    manifestBlob,
@@ -792,10 +999,25 @@ func New(fopts ...Option) (*Sbot, error) {
 		sc)
 	s.master.Register(tplug)
 
+	if s.firewallConfig != nil {
+		fwCfg := *s.firewallConfig
+		if fwCfg.IsBlocked == nil {
+			fwCfg.IsBlocked = func(remote refs.FeedRef) bool {
+				return s.Replicator.Lister().BlockList().Has(remote)
+			}
+		}
+		s.Firewall = network.NewFirewallTracker(s.info, s.networkConnTracker, fwCfg)
+		s.networkConnTracker = s.Firewall
+		// last, so that the other wrappers can't fail after it counted the connection
+		s.preSecureWrappers = append(s.preSecureWrappers, s.Firewall.PreSecureWrapper())
+	}
+
 	// tcp+shs
 	opts := network.Options{
 		Logger:              s.info,
 		Dialer:              s.dialer,
+		OnionDialer:         s.onionDialer,
+		OnionListenAddr:     s.onionListenAddr,
 		ListenAddr:          s.listenAddr,
 		AdvertsSend:         s.enableAdverts,
 		AdvertsConnectTo:    s.enableDiscovery,
@@ -883,6 +1105,7 @@ func New(fopts ...Option) (*Sbot, error) {
 	// TODO: should be gossip.connect but conflicts with our namespace assumption
 	s.master.Register(conn.NewPlug(log.With(s.info, "unit", "conn"), networkNode, s))
 	s.master.Register(status.New(s))
+	s.master.Register(clientauth.NewPlugin(log.With(s.info, "unit", "clientauth"), s.ClientGrants))
 
 	s.public.Register(networkNode.TunnelPlugin())
 	s.Network = networkNode
@@ -926,6 +1149,23 @@ func (s *Sbot) Close() error {
 	return nil
 }
 
+// querySources are all the indexes the query engine can use, including the private ones
+func (s *Sbot) querySources() query.Sources {
+	self := s.KeyPair.ID()
+	return query.Sources{
+		Authors:   s.Users,
+		ByType:    s.ByType,
+		Tangles:   s.Tangles,
+		Private:   s.Private,
+		Query:     s.Query,
+		Backlinks: s.Backlinks,
+		Declared:  s.Declared,
+		Resolver:  s.SeqResolver,
+		RxLog:     s.ReceiveLog,
+		Self:      &self,
+	}
+}
+
 type selfChecker struct {
 	me refs.FeedRef
 }
@@ -16,12 +16,15 @@ import (
 	"github.com/ssbc/go-luigi"
 	refs "github.com/ssbc/go-ssb-refs"
 
+	"github.com/ssbc/go-ssb/indexes/declared"
 	"github.com/ssbc/go-ssb/internal/storedrefs"
 	"github.com/ssbc/go-ssb/multilogs"
+	"github.com/ssbc/go-ssb/plugins2/search"
 	"github.com/ssbc/go-ssb/repo"
 )
 
-// NullFeed overwrites all the entries from ref in repo with zeros
+// NullFeed overwrites all the entries from ref in repo with zeros.
+// The log of ref is deleted if EnableFeedSegments is used, as are the segments of a segmented receive log that only have nulled entries afterwards.
 func (s *Sbot) NullFeed(ref refs.FeedRef) error {
 	ctx := context.Background()
 
@@ -36,6 +39,7 @@ func (s *Sbot) NullFeed(ref refs.FeedRef) error {
 		return fmt.Errorf("NullFeed: failed create user seqs query: %w", err)
 	}
 
+	var nulled []int64
 	for {
 		v, err := src.Next(ctx)
 		if err != nil {
@@ -52,6 +56,16 @@ func (s *Sbot) NullFeed(ref refs.FeedRef) error {
 		if err != nil {
 			return err
 		}
+		nulled = append(nulled, seq)
+	}
+
+	if s.FeedSegments != nil {
+		if err := s.FeedSegments.Drop(ref); err != nil {
+			return fmt.Errorf("NullFeed: %w", err)
+		}
+	}
+	if err := s.reclaimSegments(nulled); err != nil {
+		return fmt.Errorf("NullFeed: %w", err)
 	}
 
 	err = s.Users.Delete(feedAddr)
@@ -79,7 +93,100 @@ func (s *Sbot) NullFeed(ref refs.FeedRef) error {
 	return nil
 }
 
-// Drop indicies deletes the following folders of the indexes.
+// nullFeedSuffix overwrites the entries from ref after the first keep ones with zeros.
+// The contacts of ref are processed again since some of them might have been dropped.
+func (s *Sbot) nullFeedSuffix(ref refs.FeedRef, keep int64) error {
+	ctx := context.Background()
+
+	feedAddr := storedrefs.Feed(ref)
+	userSeqs, err := s.Users.Get(feedAddr)
+	if err != nil {
+		return fmt.Errorf("NullFeedSuffix: failed to open log for feed argument: %w", err)
+	}
+
+	src, err := userSeqs.Query()
+	if err != nil {
+		return fmt.Errorf("NullFeedSuffix: failed create user seqs query: %w", err)
+	}
+
+	var kept, nulled []int64
+	for {
+		v, err := src.Next(ctx)
+		if err != nil {
+			if luigi.IsEOS(err) {
+				break
+			}
+			return err
+		}
+		seq, ok := v.(int64)
+		if !ok {
+			return fmt.Errorf("NullFeedSuffix: not a sequence from userlog query")
+		}
+		if int64(len(kept)) < keep {
+			kept = append(kept, seq)
+			continue
+		}
+		err = s.ReceiveLog.Null(seq)
+		if err != nil {
+			return err
+		}
+		nulled = append(nulled, seq)
+	}
+	if err := s.reclaimSegments(nulled); err != nil {
+		return fmt.Errorf("NullFeedSuffix: %w", err)
+	}
+
+	// the sublog can't be shortened, it is written again with the messages that are left
+	err = s.Users.Delete(feedAddr)
+	if err != nil {
+		return fmt.Errorf("NullFeedSuffix: error while deleting feed from userFeeds index: %w", err)
+	}
+	userSeqs, err = s.Users.Get(feedAddr)
+	if err != nil {
+		return fmt.Errorf("NullFeedSuffix: failed to open new log for feed argument: %w", err)
+	}
+	for _, seq := range kept {
+		if _, err := userSeqs.Append(seq); err != nil {
+			return fmt.Errorf("NullFeedSuffix: failed to add message to userFeeds index: %w", err)
+		}
+	}
+	err = s.Users.Flush()
+	if err != nil {
+		return fmt.Errorf("NullFeedSuffix: failed to write userFeeds index: %w", err)
+	}
+
+	if s.FeedSegments != nil {
+		if err := s.FeedSegments.Truncate(ref, keep); err != nil {
+			return fmt.Errorf("NullFeedSuffix: %w", err)
+		}
+	}
+
+	err = s.GraphBuilder.DeleteAuthor(ref)
+	if err != nil {
+		return fmt.Errorf("NullFeedSuffix: error while deleting feed from graph index: %w", err)
+	}
+	err = s.RebuildIndex("contacts")
+	if err != nil {
+		return fmt.Errorf("NullFeedSuffix: failed to process the contacts again: %w", err)
+	}
+
+	// delete my ebt state
+	// TODO: just remove that single feed
+	sfn, err := s.ebtState.StateFileName(s.KeyPair.ID())
+	if err != nil {
+		return fmt.Errorf("NullFeedSuffix: error while deleting ebt state file: %w", err)
+	}
+	os.Remove(sfn)
+
+	if !s.disableNetwork {
+		// it picks up the new latest message when it is opened again
+		s.verifyRouter.CloseSink(ref)
+	}
+
+	return nil
+}
+
+// Drop indicies deletes the following folders of the indexes and the optional indexes in the shared badger, like search and the declared ones.
 // TODO: check that sbot isn't running?
 func DropIndicies(r repo.Interface) error {
 
@@ -97,6 +204,25 @@ func DropIndicies(r repo.Interface) error {
 			return err
 		}
 	}
+	// the optional indexes in the shared badger
+	sharedPath := r.GetPath(repo.PrefixMultiLog, "shared-badger")
+	if _, err := os.Stat(sharedPath); err == nil {
+		db, err := repo.OpenBadgerDBWithKey(sharedPath, repo.KeyOf(r))
+		if err != nil {
+			return fmt.Errorf("failed to open shared indexes: %w", err)
+		}
+		err = search.DropIndex(db)
+		if err == nil {
+			err = declared.DropIndexes(db)
+		}
+		if cerr := db.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return fmt.Errorf("failed to drop optional indexes: %w", err)
+		}
+	}
+
 	// TODO: shared mlog
 	// var badger = []string{
 	// 	indexes.FolderNameContacts,
@@ -113,7 +239,9 @@ func DropIndicies(r repo.Interface) error {
 	return nil
 }
 
-func RebuildIndicies(path string) error {
+// RebuildIndicies opens the repo at path and fills the indexes that are missing, like the ones removed by DropIndicies.
+// Optional indexes need to be enabled through opts, like EnableSearch.
+func RebuildIndicies(path string, opts ...Option) error {
 	fi, err := os.Stat(path)
 	if err != nil {
 		err = fmt.Errorf("RebuildIndicies: failed to open sbot: %w", err)
@@ -125,11 +253,11 @@ func RebuildIndicies(path string) error {
 	}
 
 	// rebuilding indexes
-	sbot, err := New(
+	sbot, err := New(append([]Option{
 		DisableNetworkNode(),
 		WithRepoPath(path),
 		DisableLiveIndexMode(),
-	)
+	}, opts...)...)
 	if err != nil {
 		err = fmt.Errorf("failed to open sbot: %w", err)
 		return err
@@ -79,6 +79,13 @@ func (s *Sbot) NullContent(fr refs.FeedRef, seq uint) error {
 	if err != nil {
 		return fmt.Errorf("nullContent: failed to execute replace operation: %w", err)
 	}
+
+	if s.FeedSegments != nil {
+		err = s.FeedSegments.Replace(fr, int64(seq), nulled)
+		if err != nil {
+			return fmt.Errorf("nullContent: %w", err)
+		}
+	}
 	return nil
 }
 
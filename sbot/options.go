@@ -22,8 +22,11 @@ import (
 	"go.mindeco.de/logging"
 
 	"github.com/ssbc/go-ssb"
+	"github.com/ssbc/go-ssb/graph"
+	"github.com/ssbc/go-ssb/indexes/declared"
 	"github.com/ssbc/go-ssb/internal/ctxutils"
 	"github.com/ssbc/go-ssb/internal/netwraputil"
+	"github.com/ssbc/go-ssb/network"
 	"github.com/ssbc/go-ssb/repo"
 )
 
@@ -50,6 +53,34 @@ func DisableLiveIndexMode() Option {
 	}
 }
 
+// EnableSearch mounts the full-text search index over the text of post and about messages and offers search.query to the master.
+// It includes the private messages, so the index is as sensitive as the repo itself.
+func EnableSearch() Option {
+	return func(s *Sbot) error {
+		s.enableSearch = true
+		return nil
+	}
+}
+
+// EnableFeedSegments keeps the messages of every author in a log of its own as well, see the feedsegments package.
+// createHistoryStream reads them sequentially instead of through the receive log and NullFeed deletes their files.
+// The messages take up space twice, once in the receive log and once in the segments.
+func EnableFeedSegments() Option {
+	return func(s *Sbot) error {
+		s.enableFeedSegments = true
+		return nil
+	}
+}
+
+// DeclareIndex adds indexes that are built from the receive log when they are first queried, see the declared package.
+// They are available through Sbot.Declared and to the field operation of the query engine.
+func DeclareIndex(specs ...declared.Spec) Option {
+	return func(s *Sbot) error {
+		s.declaredSpecs = append(s.declaredSpecs, specs...)
+		return nil
+	}
+}
+
 // WithRepoPath changes where the replication database and blobs are stored.
 func WithRepoPath(path string) Option {
 	return func(s *Sbot) error {
@@ -58,6 +89,28 @@ func WithRepoPath(path string) Option {
 	}
 }
 
+// WithRepoSecret opens an encrypted repo with secret, a passphrase or the contents of a keyfile, see repo.InitEncryption.
+// A new repo is encrypted with it. An existing repo that isn't encrypted can't be opened with a secret,
+// it has to be encrypted by restoring a snapshot of it with RestoreSnapshot.
+func WithRepoSecret(secret []byte) Option {
+	return func(s *Sbot) error {
+		if len(secret) == 0 {
+			return fmt.Errorf("the repo secret is empty")
+		}
+		s.repoSecret = secret
+		return nil
+	}
+}
+
+// WithReceiveLogFormat sets the format a new receive log is created in, offset2 is used otherwise.
+// An existing receive log in another format is not opened, it has to be converted with ssb-offset-converter first.
+func WithReceiveLogFormat(format repo.LogFormat) Option {
+	return func(s *Sbot) error {
+		s.logFormat = format
+		return nil
+	}
+}
+
 // DisableNetworkNode disables all networking, in turn it only serves the database.
 func DisableNetworkNode() Option {
 	return func(s *Sbot) error {
@@ -105,6 +158,27 @@ func WithDialer(dial netwrap.Dialer) Option {
 	}
 }
 
+// WithOnionProxy dials onion addresses through the SOCKS5 proxy at proxyAddr, usually tor on localhost:9050.
+func WithOnionProxy(proxyAddr string) Option {
+	return func(s *Sbot) error {
+		s.onionDialer = network.NewSOCKS5Dialer(proxyAddr)
+		return nil
+	}
+}
+
+// WithOnionListenAddr opens an additional listener for incoming connections from a tor hidden service.
+// It should be the local address the HiddenServicePort of the torrc points to.
+func WithOnionListenAddr(addr string) Option {
+	return func(s *Sbot) error {
+		var err error
+		s.onionListenAddr, err = net.ResolveTCPAddr("tcp", addr)
+		if err != nil {
+			return fmt.Errorf("failed to parse hidden-service listen addr: %w", err)
+		}
+		return nil
+	}
+}
+
 // WithNetworkConnTracker changes the connection tracker. See network.NewLastWinsTracker and network.NewAcceptAllTracker.
 func WithNetworkConnTracker(ct ssb.ConnTracker) Option {
 	return func(s *Sbot) error {
@@ -113,6 +187,15 @@ func WithNetworkConnTracker(ct ssb.ConnTracker) Option {
 	}
 }
 
+// WithFirewall puts a network.Firewall in front of the connection tracker, which rate-limits, caps and bans connections by IP.
+// If the config doesn't have an IsBlocked function, the block list of the replicator is used.
+func WithFirewall(cfg network.FirewallConfig) Option {
+	return func(s *Sbot) error {
+		s.firewallConfig = &cfg
+		return nil
+	}
+}
+
 // WithUNIXSocket enables listening for muxrpc connections on a unix socket files ($repo/socket).
 // This socket is not encrypted or authenticated since access to it is mediated by filesystem ownership.
 func WithUNIXSocket() Option {
@@ -243,12 +326,24 @@ func WithAppKey(k []byte) Option {
 	}
 }
 
+// WithKeyPairSecret decrypts the secret file of the bot with secret, a passphrase or the contents of a keyfile.
+// A new secret file is encrypted with it, see ssb.SaveEncryptedKeyPair. It has to come before WithNamedKeyPair.
+func WithKeyPairSecret(secret []byte) Option {
+	return func(s *Sbot) error {
+		if len(secret) == 0 {
+			return fmt.Errorf("the key-pair secret is empty")
+		}
+		s.keyPairSecret = secret
+		return nil
+	}
+}
+
 // WithNamedKeyPair changes from the default `secret` file, useful for testing.
 func WithNamedKeyPair(name string) Option {
 	return func(s *Sbot) error {
 		r := repo.New(s.repoPath)
 		var err error
-		s.KeyPair, err = repo.LoadKeyPair(r, name)
+		s.KeyPair, err = repo.LoadKeyPairWithSecret(r, name, s.keyPairSecret)
 		if err != nil {
 			return fmt.Errorf("loading named key-pair %q failed: %w", name, err)
 		}
@@ -408,6 +503,18 @@ func WithHops(h uint) Option {
 	}
 }
 
+// WithReplicationPolicy replaces the single root and hop count of WithHops with a policy of several trust roots,
+// each with its own hop count, an allow-list and how much the blocks of friends count.
+func WithReplicationPolicy(p graph.Policy) Option {
+	return func(s *Sbot) error {
+		if len(p.Roots) == 0 {
+			return fmt.Errorf("replication policy: need at least one root")
+		}
+		s.replicationPolicy = &p
+		return nil
+	}
+}
+
 // WithPromisc when enabled bypasses graph-distance lookups on connections and makes the gossip handler fetch the remotes feed
 func WithPromisc(yes bool) Option {
 	return func(s *Sbot) error {
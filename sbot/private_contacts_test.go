@@ -0,0 +1,86 @@
+// SPDX-FileCopyrightText: 2021 The Go-SSB Authors
+//
+// SPDX-License-Identifier: MIT
+
+package sbot
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	kitlog "go.mindeco.de/log"
+	"golang.org/x/sync/errgroup"
+
+	"github.com/ssbc/go-ssb"
+	refs "github.com/ssbc/go-ssb-refs"
+)
+
+func TestPrivateContacts(t *testing.T) {
+	r := require.New(t)
+
+	testRepo := filepath.Join("testrun", t.Name())
+	os.RemoveAll(testRepo)
+
+	srvLog := kitlog.NewNopLogger()
+	if testing.Verbose() {
+		srvLog = kitlog.NewLogfmtLogger(os.Stderr)
+	}
+	todoCtx := context.TODO()
+	botgroup, ctx := errgroup.WithContext(todoCtx)
+	bs := newBotServer(todoCtx, srvLog)
+
+	srh, err := New(
+		WithContext(ctx),
+		WithInfo(srvLog),
+		WithRepoPath(filepath.Join(testRepo, "srh")),
+		WithListenAddr(":0"),
+		DisableEBT(true),
+	)
+	r.NoError(err)
+	botgroup.Go(bs.Serve(srh))
+
+	public, err := ssb.NewKeyPair(nil, refs.RefAlgoFeedSSB1)
+	r.NoError(err)
+	secret, err := ssb.NewKeyPair(nil, refs.RefAlgoFeedSSB1)
+	r.NoError(err)
+
+	_, err = srh.PublishLog.Publish(refs.NewContactFollow(public.ID()))
+	r.NoError(err)
+
+	// block someone in a group that only we are part of
+	cloaked, _, err := srh.Groups.Create("just me")
+	r.NoError(err)
+	block, err := json.Marshal(refs.NewContactBlock(secret.ID()))
+	r.NoError(err)
+	_, err = srh.Groups.PublishTo(cloaked, block)
+	r.NoError(err)
+
+	self := srh.KeyPair.ID()
+	r.Eventually(func() bool {
+		g, err := srh.GraphBuilder.BuildWithPrivate()
+		r.NoError(err)
+		return g.Blocks(self, secret.ID()) && g.Follows(self, public.ID())
+	}, 10*time.Second, 50*time.Millisecond, "private block not indexed")
+
+	// it counts for replication
+	lister := srh.Replicator.Lister()
+	r.Eventually(func() bool {
+		return lister.BlockList().Has(secret.ID()) && lister.ReplicationList().Has(public.ID())
+	}, 10*time.Second, 50*time.Millisecond, "replication lists not updated")
+	r.Error(lister.Authorize(secret.ID()))
+
+	// but it's not part of the public graph
+	g, err := srh.GraphBuilder.Build()
+	r.NoError(err)
+	r.False(g.Blocks(self, secret.ID()))
+	r.Equal(0, g.BlockedList(self).Count())
+
+	srh.Shutdown()
+	r.NoError(srh.Close())
+	r.NoError(botgroup.Wait())
+}
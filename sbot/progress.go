@@ -0,0 +1,34 @@
+// SPDX-FileCopyrightText: 2021 The Go-SSB Authors
+//
+// SPDX-License-Identifier: MIT
+
+package sbot
+
+import (
+	"fmt"
+
+	"github.com/ssbc/go-ssb"
+)
+
+var _ ssb.ReplicationProgresser = (*Sbot)(nil)
+
+// ReplicationProgress compares the stored sequences of the wanted feeds with what our peers told us about,
+// either by EBT frontiers or the messages they sent over legacy gossip.
+func (s *Sbot) ReplicationProgress() (ssb.ReplicationProgress, error) {
+	wanted, err := s.Replicator.Lister().ReplicationList().List()
+	if err != nil {
+		return ssb.ReplicationProgress{}, fmt.Errorf("replication progress: failed to get wanted feeds: %w", err)
+	}
+
+	local, err := ssb.WantedFeedsWithSeqs(s.Users, wanted)
+	if err != nil {
+		return ssb.ReplicationProgress{}, fmt.Errorf("replication progress: failed to get stored sequences: %w", err)
+	}
+
+	longer, err := s.ebtState.HasLonger()
+	if err != nil {
+		return ssb.ReplicationProgress{}, fmt.Errorf("replication progress: failed to get ebt state: %w", err)
+	}
+
+	return s.replProgress.Progress(local, longer), nil
+}
@@ -5,19 +5,15 @@
 package sbot
 
 import (
-	"context"
 	"errors"
-	"fmt"
 	"sync"
-	"time"
 
-	"github.com/ssbc/go-luigi"
-	"github.com/ssbc/margaret"
 	"go.mindeco.de/log"
 	"go.mindeco.de/log/level"
 
 	"github.com/ssbc/go-ssb"
 	refs "github.com/ssbc/go-ssb-refs"
+	"github.com/ssbc/go-ssb/graph"
 	"github.com/ssbc/go-ssb/internal/statematrix"
 	"github.com/ssbc/go-ssb/internal/storedrefs"
 )
@@ -58,101 +54,144 @@ func (sbot *Sbot) DontReplicate(r refs.FeedRef) {
 type graphReplicator struct {
 	bot     *Sbot
 	current *lister
+
+	// feeds that were added with Replicate stay wanted when the policy doesn't want them
+	manual *ssb.StrFeedSet
+
+	// feeds that were blocked with Block stay blocked when the policy allows them
+	manualBlocks *ssb.StrFeedSet
+
+	engineMu sync.Mutex
+	engine   *graph.PolicyEngine
 }
 
 func (s *Sbot) newGraphReplicator() (*graphReplicator, error) {
 	var r graphReplicator
 	r.bot = s
 	r.current = newLister()
+	r.manual = ssb.NewFeedSet(0)
+	r.manualBlocks = ssb.NewFeedSet(0)
+
+	policy := graph.Policy{
+		Roots: []graph.PolicyRoot{
+			{Feed: s.KeyPair.ID(), Hops: int(s.hopCount)},
+		},
+	}
+	if s.replicationPolicy != nil {
+		policy = *s.replicationPolicy
+	}
 
 	replicateEvt := log.With(s.info, "event", "update-replicate")
-	update := r.makeUpdater(replicateEvt, s.KeyPair.ID(), int(s.hopCount))
+	update := r.makeUpdater(replicateEvt)
 
-	// update for new messages but only once they didnt change in a while
-	// meaning, not while sync is busy with new incoming messages
-	go debounce(s.rootCtx, 3*time.Second, s.ReceiveLog.Changes(), update)
+	// the graph builder tells us about every feed whose decision changes as contacts are indexed.
+	// subscribing reads the whole graph once, don't hold up the startup for that.
+	// Close waits for that with the indexes, so that the graph isn't read after the stores are closed.
+	s.idxDone.Go(func() error {
+		engine, cancel, err := s.GraphBuilder.SubscribePolicy(policy, update)
+		if err != nil {
+			level.Error(replicateEvt).Log("msg", "failed to evaluate replication policy", "err", err)
+			return nil
+		}
+		r.engineMu.Lock()
+		r.engine = engine
+		r.engineMu.Unlock()
+
+		go func() {
+			<-s.rootCtx.Done()
+			cancel()
+		}()
+		return nil
+	})
 
 	return &r, nil
 }
 
-// makeUpdater returns a func that does the hop-walk and block checks, used together with debounce
-func (r *graphReplicator) makeUpdater(log log.Logger, self refs.FeedRef, hopCount int) func() {
-	return func() {
-		start := time.Now()
-		newWants := r.bot.GraphBuilder.Hops(self, hopCount)
+// makeUpdater returns a func that applies the changed decisions of the policy to the replication lists
+func (r *graphReplicator) makeUpdater(log log.Logger) func([]graph.PolicyChange) {
+	self := r.bot.KeyPair.ID()
+	return func(changes []graph.PolicyChange) {
+		for _, c := range changes {
+			// our own feed is a root of the default policy but nothing to fetch
+			if c.Feed.Equal(self) {
+				continue
+			}
 
-		refs, err := newWants.List()
-		if err != nil {
-			level.Error(log).Log("msg", "want list failed", "err", err, "wants", newWants.Count())
-			return
-		}
-		for _, ref := range refs {
-			r.current.feedWants.AddRef(ref)
+			// make sure we dont fetch and allow blocked feeds
+			if c.Blocked {
+				r.current.blocked.AddRef(c.Feed)
+				r.current.feedWants.Delete(c.Feed)
+				continue
+			}
+			if r.manualBlocks.Has(c.Feed) {
+				continue
+			}
+			r.current.blocked.Delete(c.Feed)
+
+			if c.Replicate || r.manual.Has(c.Feed) {
+				r.current.feedWants.AddRef(c.Feed)
+			} else {
+				r.current.feedWants.Delete(c.Feed)
+			}
 		}
 
-		level.Debug(log).Log("feed-want-count", r.current.feedWants.Count(), "hops", hopCount, "took", time.Since(start))
+		level.Debug(log).Log("changes", len(changes), "feed-want-count", r.current.feedWants.Count(), "blocked-count", r.current.blocked.Count())
+	}
+}
 
-		// make sure we dont fetch and allow blocked feeds
-		g, err := r.bot.GraphBuilder.Build()
-		if err != nil {
-			level.Error(log).Log("msg", "failed to build blocks", "err", err)
-			return
-		}
+// Explain returns why the policy replicates feed or not.
+// Feeds that were added with Replicate count as allowed.
+func (r *graphReplicator) Explain(feed refs.FeedRef) (graph.Explanation, error) {
+	r.engineMu.Lock()
+	engine := r.engine
+	r.engineMu.Unlock()
+	if engine == nil {
+		return graph.Explanation{}, errors.New("replication policy not evaluated yet")
+	}
 
-		newBlocked := g.BlockedList(self)
-		lst, err := newBlocked.List()
-		if err == nil {
-			for _, bf := range lst {
-				r.current.blocked.AddRef(bf)
-				r.current.feedWants.Delete(bf)
-			}
-		}
+	expl, err := engine.Explain(feed)
+	if err != nil {
+		return expl, err
 	}
+	if r.manual.Has(feed) && !expl.Blocked {
+		expl.Replicate = true
+		expl.Allowed = true
+	}
+	return expl, nil
 }
 
-func debounce(ctx context.Context, interval time.Duration, obs luigi.Observable, work func()) {
-	var seqMu sync.Mutex
-	var seq = margaret.SeqEmpty
-	timer := time.NewTimer(interval)
+func (r *graphReplicator) Block(ref refs.FeedRef) {
+	r.manualBlocks.AddRef(ref)
+	r.current.blocked.AddRef(ref)
+	r.current.feedWants.Delete(ref)
+}
 
-	handle := luigi.FuncSink(func(ctx context.Context, val interface{}, err error) error {
-		if err != nil {
-			return err
-		}
-		newSeq, ok := val.(int64)
-		if !ok {
-			return fmt.Errorf("graph rebuild debounce: wrong type: %T", val)
-		}
-		seqMu.Lock()
-		seq = newSeq
-		timer.Reset(interval)
-		seqMu.Unlock()
-		return nil
-	})
-	done := obs.Register(handle)
-
-	for {
-		select {
-		case <-ctx.Done():
-			done()
-			return
-
-		case <-timer.C:
-			seqMu.Lock()
-			if seq != margaret.SeqEmpty {
-				work()
-				seq = margaret.SeqEmpty
-			}
-			seqMu.Unlock()
-		}
+func (r *graphReplicator) Unblock(ref refs.FeedRef) {
+	r.manualBlocks.Delete(ref)
+	r.current.blocked.Delete(ref)
+
+	// the changes of the policy were skipped while it was blocked.
+	// before the policy is evaluated there is nothing to restore, the first changes include the feed.
+	expl, err := r.Explain(ref)
+	if err != nil {
+		return
+	}
+	if expl.Blocked {
+		r.current.blocked.AddRef(ref)
+	} else if expl.Replicate {
+		r.current.feedWants.AddRef(ref)
 	}
 }
 
-func (r *graphReplicator) Block(ref refs.FeedRef)   { r.current.blocked.AddRef(ref) }
-func (r *graphReplicator) Unblock(ref refs.FeedRef) { r.current.blocked.Delete(ref) }
+func (r *graphReplicator) Replicate(ref refs.FeedRef) {
+	r.manual.AddRef(ref)
+	r.current.feedWants.AddRef(ref)
+}
 
-func (r *graphReplicator) Replicate(ref refs.FeedRef)     { r.current.feedWants.AddRef(ref) }
-func (r *graphReplicator) DontReplicate(ref refs.FeedRef) { r.current.feedWants.Delete(ref) }
+func (r *graphReplicator) DontReplicate(ref refs.FeedRef) {
+	r.manual.Delete(ref)
+	r.current.feedWants.Delete(ref)
+}
 
 func (r *graphReplicator) Lister() ssb.ReplicationLister { return r.current }
 
@@ -0,0 +1,64 @@
+// SPDX-FileCopyrightText: 2021 The Go-SSB Authors
+//
+// SPDX-License-Identifier: MIT
+
+package sbot
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.mindeco.de/log"
+
+	"github.com/ssbc/go-ssb"
+	refs "github.com/ssbc/go-ssb-refs"
+	"github.com/ssbc/go-ssb/graph"
+)
+
+func TestReplicatorKeepsManualBlocks(t *testing.T) {
+	r := require.New(t)
+
+	tRepoPath := filepath.Join("testrun", t.Name())
+	os.RemoveAll(tRepoPath)
+
+	bot, err := New(
+		WithInfo(log.NewNopLogger()),
+		WithRepoPath(tRepoPath),
+		DisableNetworkNode(),
+	)
+	r.NoError(err)
+
+	friend, err := ssb.NewKeyPair(nil, refs.RefAlgoFeedSSB1)
+	r.NoError(err)
+
+	_, err = bot.PublishLog.Publish(refs.NewContactFollow(friend.ID()))
+	r.NoError(err)
+
+	lst := bot.Replicator.Lister()
+	r.Eventually(func() bool {
+		return lst.ReplicationList().Has(friend.ID())
+	}, 5*time.Second, 50*time.Millisecond, "followed feed not replicated")
+
+	// blocked by hand, like with conn.block
+	bot.Replicator.Block(friend.ID())
+	r.Error(lst.Authorize(friend.ID()))
+	r.False(lst.ReplicationList().Has(friend.ID()), "blocked feed is still replicated")
+
+	// changes of the policy don't undo it
+	repl := bot.Replicator.(*graphReplicator)
+	update := repl.makeUpdater(log.NewNopLogger())
+	update([]graph.PolicyChange{{Feed: friend.ID(), Replicate: true}})
+	r.Error(lst.Authorize(friend.ID()), "policy change undid the block")
+	r.False(lst.ReplicationList().Has(friend.ID()))
+
+	// once unblocked, the policy decides again
+	bot.Replicator.Unblock(friend.ID())
+	r.NoError(lst.Authorize(friend.ID()))
+	r.True(lst.ReplicationList().Has(friend.ID()), "unblocked feed isn't replicated again")
+
+	bot.Shutdown()
+	r.NoError(bot.Close())
+}
@@ -0,0 +1,150 @@
+// SPDX-FileCopyrightText: 2021 The Go-SSB Authors
+//
+// SPDX-License-Identifier: MIT
+
+package sbot
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ssbc/go-muxrpc/v2"
+	"github.com/stretchr/testify/require"
+	"go.mindeco.de/log"
+
+	refs "github.com/ssbc/go-ssb-refs"
+	"github.com/ssbc/go-ssb/client"
+	"github.com/ssbc/go-ssb/plugins2/search"
+	"github.com/ssbc/go-ssb/query"
+	"github.com/ssbc/go-ssb/repo"
+)
+
+func TestSearch(t *testing.T) {
+	r := require.New(t)
+
+	tRepoPath := filepath.Join("testrun", t.Name())
+	os.RemoveAll(tRepoPath)
+
+	logger := log.NewNopLogger()
+	if testing.Verbose() {
+		logger = log.NewLogfmtLogger(os.Stderr)
+	}
+
+	tRepo := repo.New(tRepoPath)
+	kpArny, err := repo.NewKeyPair(tRepo, "arny", refs.RefAlgoFeedSSB1)
+	r.NoError(err)
+
+	opts := []Option{
+		WithInfo(logger),
+		WithRepoPath(tRepoPath),
+		WithListenAddr(":0"),
+		LateOption(WithUNIXSocket()),
+		EnableSearch(),
+	}
+	mainbot, err := New(opts...)
+	r.NoError(err)
+
+	var keys []refs.MessageRef
+	publish := func(as string, content interface{}) {
+		var (
+			msg refs.Message
+			err error
+		)
+		if as == "" {
+			msg, err = mainbot.PublishLog.Publish(content)
+		} else {
+			msg, err = mainbot.PublishAs(as, content)
+		}
+		r.NoError(err)
+		keys = append(keys, msg.Key())
+	}
+
+	publish("", refs.NewPost("Searching for the lighthouse"))
+	publish("", refs.NewPost("nothing to see"))
+	publish("arny", refs.NewPost("a LIGHT snack"))
+	publish("", map[string]interface{}{"type": "contact", "text": "light"})
+
+	cloaked, _, err := mainbot.Groups.Create("just me")
+	r.NoError(err)
+	secret, err := json.Marshal(refs.NewPost("the secret lighthouse keeper"))
+	r.NoError(err)
+	private, err := mainbot.Groups.PublishTo(cloaked, secret)
+	r.NoError(err)
+
+	mainbot.WaitUntilIndexesAreSynced()
+
+	c, err := client.NewUnix(filepath.Join(tRepoPath, "socket"))
+	r.NoError(err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	find := func(args search.QueryArgs) []refs.KeyValueRaw {
+		src, err := c.Source(ctx, muxrpc.TypeJSON, muxrpc.Method{"search", "query"}, args)
+		r.NoError(err)
+
+		var found []refs.KeyValueRaw
+		for src.Next(ctx) {
+			b, err := src.Bytes()
+			r.NoError(err)
+			var kv refs.KeyValueRaw
+			r.NoError(json.Unmarshal(b, &kv))
+			found = append(found, kv)
+		}
+		r.NoError(src.Err())
+		return found
+	}
+
+	args := search.NewQueryArgs()
+	args.Query = "light"
+	found := find(args)
+	r.Len(found, 3, "prefix matches, without the contact")
+	r.Equal(keys[0], found[0].Key_)
+	r.Equal(keys[2], found[1].Key_)
+	r.Equal(private, found[2].Key_)
+	r.Equal(true, found[2].Value.Meta["private"])
+
+	var post refs.Post
+	r.NoError(json.Unmarshal(found[2].Value.Content, &post))
+	r.Equal("the secret lighthouse keeper", post.Text)
+
+	// all words need to match
+	args.Query = "Lighthouse KEEP"
+	found = find(args)
+	r.Len(found, 1)
+	r.Equal(private, found[0].Key_)
+
+	// filtered by author
+	args.Query = "light"
+	filter := query.NewSubsetOpByAuthor(kpArny.ID())
+	args.Filter = &filter
+	found = find(args)
+	r.Len(found, 1)
+	r.Equal(keys[2], found[0].Key_)
+
+	args.Query = "nope"
+	args.Filter = nil
+	r.Len(find(args), 0)
+
+	r.NoError(c.Close())
+	mainbot.Shutdown()
+	r.NoError(mainbot.Close())
+
+	// the index can be filled again
+	r.NoError(DropIndicies(tRepo))
+	r.NoError(RebuildIndicies(tRepoPath, EnableSearch()))
+
+	mainbot, err = New(opts...)
+	r.NoError(err)
+
+	bmap, err := mainbot.Search.Lookup("snack")
+	r.NoError(err)
+	r.Equal(1, bmap.GetCardinality())
+
+	mainbot.Shutdown()
+	r.NoError(mainbot.Close())
+}
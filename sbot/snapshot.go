@@ -0,0 +1,463 @@
+// SPDX-FileCopyrightText: 2021 The Go-SSB Authors
+//
+// SPDX-License-Identifier: MIT
+
+package sbot
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/ssbc/margaret"
+	"go.mindeco.de/log/level"
+
+	"github.com/ssbc/go-ssb"
+	"github.com/ssbc/go-ssb/multilogs"
+	"github.com/ssbc/go-ssb/repo"
+)
+
+/* A snapshot is a folder with the layout of a repo, see repo/layout.md, which a new repo is restored from.
+Everything in it belongs to the same point of the receive log: the indexes are held before their next message,
+so none of them processed more than the entries that are copied.
+Instead of the shared badger db there is a backup stream of it, which is loaded into a new db by RestoreSnapshot.
+The ebt state matrix and the feed segments are left out, a restored bot derives them from the feeds it has.
+
+The snapshot of an encrypted repo is encrypted with the same data key: it has the repo.EncryptionFile of the repo,
+the receive log is copied encrypted and the backup stream is written with repo.NewSealingWriter.
+Restoring a snapshot that isn't encrypted with a repo secret encrypts the new repo, which is how an existing repo is encrypted.
+*/
+
+// snapshotVersion is the version of the layout Snapshot writes
+const snapshotVersion = 1
+
+const (
+	snapshotInfoFile   = "snapshot.json"
+	snapshotBadgerFile = "shared-badger.backup"
+)
+
+// snapshotManifest is the snapshotInfoFile of a snapshot
+type snapshotManifest struct {
+	Version int `json:"version"`
+
+	ssb.SnapshotInfo
+}
+
+// snapshotFiles are copied as they are while the indexes are held
+var snapshotFiles = [][]string{
+	{repo.EncryptionFile},
+	{"secret"},
+	{"secrets"},
+	{"indexfeeds"},
+	{repo.PrefixMultiLog, "combined-state.json"},
+	{repo.PrefixMultiLog, "combined-backlinks"},
+	{repo.PrefixMultiLog, multilogs.IndexNameQuery + "-state.json"},
+	{repo.PrefixIndex, "seqmaps"},
+}
+
+type flusher interface {
+	Flush() error
+}
+
+// flushable notes the stores that keep index updates in memory before they write them, so that Snapshot can flush them
+func (s *Sbot) flushable(stores ...interface{}) {
+	for _, st := range stores {
+		if f, ok := st.(flusher); ok {
+			s.flushers = append(s.flushers, f)
+		}
+	}
+}
+
+var _ ssb.Snapshotter = (*Sbot)(nil)
+
+// Snapshot copies the repo into dir, which must not exist yet or be empty, while the bot keeps running.
+// The indexes are held while they are written out and copied, the receive log is copied up to the last entry they could have seen.
+// The blobs are hard-linked into the snapshot if it is on the same filesystem, otherwise they are copied.
+func (s *Sbot) Snapshot(dir string) (ssb.SnapshotInfo, error) {
+	s.snapshotMu.Lock()
+	if s.snapshotting {
+		s.snapshotMu.Unlock()
+		return ssb.SnapshotInfo{}, fmt.Errorf("sbot: a snapshot is already being taken")
+	}
+	s.snapshotting = true
+	s.snapshotMu.Unlock()
+
+	defer func() {
+		s.snapshotMu.Lock()
+		s.snapshotting = false
+		s.snapshotMu.Unlock()
+	}()
+
+	if err := createEmptyDir(dir); err != nil {
+		return ssb.SnapshotInfo{}, fmt.Errorf("snapshot: %w", err)
+	}
+
+	info, err := s.snapshot(dir)
+	if err != nil {
+		os.RemoveAll(dir)
+		return info, err
+	}
+	level.Info(s.info).Log("event", "snapshot taken", "dir", dir, "seq", info.Seq, "blobs", info.Blobs)
+	return info, nil
+}
+
+func (s *Sbot) snapshot(dir string) (ssb.SnapshotInfo, error) {
+	r := s.storage()
+	snap := repo.New(dir)
+
+	format, err := repo.DetectLogFormat(r.GetPath("log"))
+	if err != nil {
+		return ssb.SnapshotInfo{}, fmt.Errorf("snapshot: %w", err)
+	}
+	if format == "" {
+		format = repo.LogFormatOffset2
+	}
+
+	info := ssb.SnapshotInfo{
+		Feed:      s.KeyPair.ID(),
+		Created:   time.Now(),
+		LogFormat: string(format),
+	}
+
+	info.Seq, err = s.snapshotIndexes(r, snap)
+	if err != nil {
+		return info, err
+	}
+
+	// the entries up to info.Seq only change if they are nulled, which the indexes don't depend on
+	snapLog, err := repo.OpenLogAtWithKey(snap.GetPath("log"), format, s.repoKey)
+	if err != nil {
+		return info, fmt.Errorf("snapshot: failed to create the log: %w", err)
+	}
+	if info.Seq >= 0 {
+		_, err = repo.ConvertLog(s.ReceiveLog, snapLog, int(info.Seq+1))
+	}
+	if cerr := snapLog.Close(); err == nil && cerr != nil {
+		err = cerr
+	}
+	if err != nil {
+		return info, fmt.Errorf("snapshot: failed to copy the receive log: %w", err)
+	}
+
+	if _, err := os.Stat(r.GetPath("blobs")); err == nil {
+		info.Blobs, err = linkTree(r.GetPath("blobs"), snap.GetPath("blobs"))
+		if err != nil {
+			return info, fmt.Errorf("snapshot: failed to link the blobs: %w", err)
+		}
+	}
+
+	if err := syncTree(dir); err != nil {
+		return info, fmt.Errorf("snapshot: failed to sync: %w", err)
+	}
+
+	// the manifest is written last, a snapshot without it is incomplete
+	data, err := json.MarshalIndent(snapshotManifest{Version: snapshotVersion, SnapshotInfo: info}, "", "  ")
+	if err != nil {
+		return info, err
+	}
+	if err := writeFileSynced(snap.GetPath(snapshotInfoFile), data); err != nil {
+		return info, fmt.Errorf("snapshot: failed to write the manifest: %w", err)
+	}
+	return info, nil
+}
+
+// snapshotIndexes writes the indexes into snap while they are held and returns the last entry of the receive log they could have processed
+func (s *Sbot) snapshotIndexes(r, snap repo.Interface) (int64, error) {
+	release := s.holdIndexes()
+	defer release()
+
+	releaseDeclared := s.Declared.Hold()
+	defer releaseDeclared()
+
+	seq := s.ReceiveLog.Seq()
+
+	for _, f := range s.flushers {
+		if err := f.Flush(); err != nil {
+			return seq, fmt.Errorf("snapshot: failed to flush an index: %w", err)
+		}
+	}
+	if err := s.SeqResolver.Serialize(); err != nil {
+		return seq, fmt.Errorf("snapshot: failed to write the sequence resolver: %w", err)
+	}
+
+	for _, rel := range snapshotFiles {
+		err := copyTree(r.GetPath(rel...), snap.GetPath(rel...))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return seq, fmt.Errorf("snapshot: failed to copy %s: %w", filepath.Join(rel...), err)
+		}
+	}
+
+	backupPath := snap.GetPath(repo.PrefixMultiLog, snapshotBadgerFile)
+	if err := os.MkdirAll(filepath.Dir(backupPath), 0700); err != nil {
+		return seq, err
+	}
+	f, err := os.OpenFile(backupPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return seq, fmt.Errorf("snapshot: failed to create the index backup: %w", err)
+	}
+	var w io.WriteCloser = nopWriteCloser{f}
+	if s.repoKey != nil {
+		w = repo.NewSealingWriter(f, s.repoKey)
+	}
+	_, err = s.indexStore.Backup(w, 0)
+	if cerr := w.Close(); err == nil && cerr != nil {
+		err = cerr
+	}
+	if cerr := f.Close(); err == nil && cerr != nil {
+		err = cerr
+	}
+	if err != nil {
+		return seq, fmt.Errorf("snapshot: failed to back up the indexes: %w", err)
+	}
+	return seq, nil
+}
+
+// RestoreSnapshot creates the repo at repoPath, which must not exist yet or be empty, from the snapshot in dir.
+// The snapshot of an encrypted repo needs its secret, passed with WithRepoSecret in opts.
+// A snapshot that isn't encrypted is restored into an encrypted repo if opts have a secret.
+// To validate it, the repo is opened with opts and once the indexes caught up,
+// FSCK checks the lengths of the feeds and then runs mode, unless that is FSCKModeLength as well.
+// If the restore fails, the repo is removed again.
+func RestoreSnapshot(dir, repoPath string, mode FSCKMode, opts ...Option) (ssb.SnapshotInfo, error) {
+	data, err := os.ReadFile(filepath.Join(dir, snapshotInfoFile))
+	if err != nil {
+		return ssb.SnapshotInfo{}, fmt.Errorf("restore: not a complete snapshot: %w", err)
+	}
+	var manifest snapshotManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return ssb.SnapshotInfo{}, fmt.Errorf("restore: invalid snapshot manifest: %w", err)
+	}
+	if manifest.Version != snapshotVersion {
+		return manifest.SnapshotInfo, fmt.Errorf("restore: unsupported snapshot version %d", manifest.Version)
+	}
+
+	if err := createEmptyDir(repoPath); err != nil {
+		return manifest.SnapshotInfo, fmt.Errorf("restore: %w", err)
+	}
+
+	err = restoreSnapshot(dir, repoPath, manifest.SnapshotInfo, mode, opts)
+	if err != nil {
+		os.RemoveAll(repoPath)
+	}
+	return manifest.SnapshotInfo, err
+}
+
+func restoreSnapshot(dir, repoPath string, info ssb.SnapshotInfo, mode FSCKMode, opts []Option) error {
+	// the data key of an encrypted snapshot is the one of the restored repo
+	snapEncrypted, err := repo.IsEncrypted(repo.New(dir))
+	if err != nil {
+		return fmt.Errorf("restore: %w", err)
+	}
+	if snapEncrypted {
+		err = copyTree(filepath.Join(dir, repo.EncryptionFile), filepath.Join(repoPath, repo.EncryptionFile))
+		if err != nil {
+			return fmt.Errorf("restore: failed to copy the data key: %w", err)
+		}
+	}
+	r, key, err := unlockRepo(repoPath, repoSecretOf(opts))
+	if err != nil {
+		return fmt.Errorf("restore: %w", err)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return fmt.Errorf("restore: %w", err)
+	}
+	for _, e := range entries {
+		src, dst := filepath.Join(dir, e.Name()), r.GetPath(e.Name())
+		switch e.Name() {
+		case snapshotInfoFile, repo.EncryptionFile:
+			continue
+		case "blobs":
+			_, err = linkTree(src, dst)
+		case "log":
+			if key != nil && !snapEncrypted {
+				err = encryptLog(src, dst, key)
+			} else {
+				err = copyTree(src, dst)
+			}
+		default:
+			// everything else is changed in place by the bot, which the snapshot must not see
+			err = copyTree(src, dst)
+		}
+		if err != nil {
+			return fmt.Errorf("restore: failed to copy %s: %w", e.Name(), err)
+		}
+	}
+
+	backupPath := r.GetPath(repo.PrefixMultiLog, snapshotBadgerFile)
+	backup, err := os.Open(backupPath)
+	if err != nil {
+		return fmt.Errorf("restore: no index backup: %w", err)
+	}
+	var rd io.Reader = backup
+	if snapEncrypted {
+		rd = repo.NewOpeningReader(backup, key)
+	}
+	db, err := repo.OpenBadgerDBWithKey(r.GetPath(repo.PrefixMultiLog, "shared-badger"), key)
+	if err == nil {
+		err = db.Load(rd, 256)
+		if cerr := db.Close(); err == nil && cerr != nil {
+			err = cerr
+		}
+	}
+	backup.Close()
+	if err != nil {
+		return fmt.Errorf("restore: failed to load the indexes: %w", err)
+	}
+	if err := os.Remove(backupPath); err != nil {
+		return err
+	}
+
+	if err := syncTree(repoPath); err != nil {
+		return fmt.Errorf("restore: failed to sync: %w", err)
+	}
+
+	bot, err := New(append([]Option{
+		WithRepoPath(repoPath),
+		DisableNetworkNode(),
+		DisableLiveIndexMode(),
+	}, opts...)...)
+	if err != nil {
+		return fmt.Errorf("restore: failed to open the repo: %w", err)
+	}
+
+	err = checkRestored(bot, info, mode)
+	bot.Shutdown()
+	if cerr := bot.Close(); err == nil && cerr != nil {
+		err = fmt.Errorf("restore: failed to close the repo: %w", cerr)
+	}
+	return err
+}
+
+// encryptLog copies the log in src into an encrypted log in dst, in the same format
+func encryptLog(src, dst string, key *repo.DataKey) error {
+	from, err := repo.OpenLogAt(src, "")
+	if err != nil {
+		return err
+	}
+	defer from.Close()
+
+	format, err := repo.DetectLogFormat(src)
+	if err != nil {
+		return err
+	}
+	to, err := repo.OpenLogAtWithKey(dst, format, key)
+	if err != nil {
+		return err
+	}
+	if from.Seq() != margaret.SeqEmpty {
+		_, err = repo.ConvertLog(from, to, -1)
+	}
+	if cerr := to.Close(); err == nil && cerr != nil {
+		err = cerr
+	}
+	return err
+}
+
+type nopWriteCloser struct{ io.Writer }
+
+func (nopWriteCloser) Close() error { return nil }
+
+func checkRestored(bot *Sbot, info ssb.SnapshotInfo, mode FSCKMode) error {
+	if !bot.KeyPair.ID().Equal(info.Feed) {
+		return fmt.Errorf("restore: the repo is the one of %s, not %s", bot.KeyPair.ID().ShortSigil(), info.Feed.ShortSigil())
+	}
+	if seq := bot.ReceiveLog.Seq(); seq != info.Seq {
+		return fmt.Errorf("restore: the receive log ends at %d instead of %d", seq, info.Seq)
+	}
+
+	bot.WaitUntilIndexesAreSynced()
+
+	if err := bot.FSCK(FSCKWithMode(FSCKModeLength)); err != nil {
+		return fmt.Errorf("restore: the snapshot is inconsistent: %w", err)
+	}
+	if mode != 0 && mode != FSCKModeLength {
+		if err := bot.FSCK(FSCKWithMode(mode)); err != nil {
+			return fmt.Errorf("restore: the snapshot is inconsistent: %w", err)
+		}
+	}
+	return nil
+}
+
+// createEmptyDir creates the directory path, which is also fine if it exists but is empty
+func createEmptyDir(path string) error {
+	entries, err := os.ReadDir(path)
+	if err == nil {
+		if len(entries) > 0 {
+			return fmt.Errorf("%s is not empty", path)
+		}
+		return nil
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return os.MkdirAll(path, 0700)
+}
+
+// copyTree copies the file or directory src to dst
+func copyTree(src, dst string) error {
+	return walkTree(src, dst, copyFile)
+}
+
+// linkTree hard-links the files below src into dst, or copies them if that isn't possible, and returns how many there were.
+// The tmp folder of the blob store is left out.
+func linkTree(src, dst string) (int64, error) {
+	var n int64
+	err := walkTree(src, dst, func(from, to string, fi os.FileInfo) error {
+		n++
+		if err := os.Link(from, to); err == nil {
+			return nil
+		}
+		return copyFile(from, to, fi)
+	})
+	return n, err
+}
+
+func walkTree(src, dst string, fn func(from, to string, fi os.FileInfo) error) error {
+	return filepath.Walk(src, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		to := filepath.Join(dst, rel)
+
+		if fi.IsDir() {
+			if rel == "tmp" && filepath.Base(src) == "blobs" {
+				return filepath.SkipDir
+			}
+			return os.MkdirAll(to, 0700)
+		}
+		if !fi.Mode().IsRegular() {
+			return nil
+		}
+		if err := os.MkdirAll(filepath.Dir(to), 0700); err != nil {
+			return err
+		}
+		return fn(path, to, fi)
+	})
+}
+
+func copyFile(from, to string, fi os.FileInfo) error {
+	in, err := os.Open(from)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(to, os.O_CREATE|os.O_EXCL|os.O_WRONLY, fi.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, in)
+	if cerr := out.Close(); err == nil && cerr != nil {
+		err = cerr
+	}
+	return err
+}
@@ -0,0 +1,128 @@
+// SPDX-FileCopyrightText: 2021 The Go-SSB Authors
+//
+// SPDX-License-Identifier: MIT
+
+package sbot
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.mindeco.de/log"
+
+	refs "github.com/ssbc/go-ssb-refs"
+	"github.com/ssbc/go-ssb/repo"
+)
+
+func TestSnapshot(t *testing.T) {
+	r := require.New(t)
+
+	tRepoPath := filepath.Join("testrun", t.Name())
+	os.RemoveAll(tRepoPath)
+
+	logger := log.NewNopLogger()
+	if testing.Verbose() {
+		logger = log.NewLogfmtLogger(os.Stderr)
+	}
+
+	bot, err := New(
+		WithInfo(logger),
+		WithRepoPath(filepath.Join(tRepoPath, "live")),
+		DisableNetworkNode(),
+	)
+	r.NoError(err)
+
+	arny, err := repo.NewKeyPair(repo.New(filepath.Join(tRepoPath, "live")), "arny", refs.RefAlgoFeedSSB1)
+	r.NoError(err)
+
+	blobContent := strings.Repeat("snapshotted blob ", 100)
+	blob, err := bot.BlobStore.Put(strings.NewReader(blobContent))
+	r.NoError(err)
+
+	var keys []refs.MessageRef
+	for i := 0; i < 5; i++ {
+		msg, err := bot.PublishAs("arny", map[string]interface{}{"type": "test", "i": i})
+		r.NoError(err)
+		keys = append(keys, msg.Key())
+	}
+	msg, err := bot.PublishLog.Publish(refs.NewContactFollow(arny.ID()))
+	r.NoError(err)
+	keys = append(keys, msg.Key())
+	bot.WaitUntilIndexesAreSynced()
+
+	// a paused index is taken as far as it got and stays paused
+	paused := bot.IndexProgress()[0].Name
+	r.NoError(bot.PauseIndexing(paused))
+	msg, err = bot.PublishLog.Publish(map[string]interface{}{"type": "post", "text": "look", "mentions": []interface{}{map[string]interface{}{"link": blob.String()}}})
+	r.NoError(err)
+	keys = append(keys, msg.Key())
+
+	snapDir, err := filepath.Abs(filepath.Join(tRepoPath, "snapshot"))
+	r.NoError(err)
+	info, err := bot.Snapshot(snapDir)
+	r.NoError(err)
+	r.True(info.Feed.Equal(bot.KeyPair.ID()))
+	r.EqualValues(len(keys)-1, info.Seq)
+	r.EqualValues(1, info.Blobs)
+	r.Equal(string(repo.LogFormatOffset2), info.LogFormat)
+
+	for _, p := range bot.IndexProgress() {
+		r.Equal(p.Name == paused, p.Paused, "index %s", p.Name)
+	}
+	r.NoError(bot.ResumeIndexing(paused))
+
+	// the bot keeps going, the snapshot doesn't change
+	later, err := bot.PublishAs("arny", map[string]interface{}{"type": "test", "i": "later"})
+	r.NoError(err)
+	bot.WaitUntilIndexesAreSynced()
+
+	_, err = bot.Snapshot(snapDir)
+	r.Error(err, "not empty")
+
+	bot.Shutdown()
+	r.NoError(bot.Close())
+
+	restoredPath := filepath.Join(tRepoPath, "restored")
+	restored, err := RestoreSnapshot(snapDir, restoredPath, FSCKModeVerify, WithInfo(logger))
+	r.NoError(err)
+	r.Equal(info.Seq, restored.Seq)
+
+	_, err = RestoreSnapshot(snapDir, restoredPath, FSCKModeSequences, WithInfo(logger))
+	r.Error(err, "the repo exists")
+
+	bot, err = New(
+		WithInfo(logger),
+		WithRepoPath(restoredPath),
+		DisableNetworkNode(),
+	)
+	r.NoError(err)
+	bot.WaitUntilIndexesAreSynced()
+
+	r.Equal(info.Seq, bot.ReceiveLog.Seq())
+	for _, key := range keys {
+		msg, err := bot.Get(key)
+		r.NoError(err)
+		r.True(msg.Key().Equal(key))
+	}
+	_, err = bot.Get(later.Key())
+	r.Error(err)
+
+	rd, err := bot.BlobStore.Get(blob)
+	r.NoError(err)
+	got, err := ioutil.ReadAll(rd)
+	r.NoError(err)
+	r.Equal(blobContent, string(got))
+
+	// the restored bot continues the feeds
+	next, err := bot.PublishAs("arny", map[string]interface{}{"type": "test", "i": "restored"})
+	r.NoError(err)
+	r.EqualValues(6, next.Seq())
+	r.NoError(bot.FSCK(FSCKWithMode(FSCKModeSequences)))
+
+	bot.Shutdown()
+	r.NoError(bot.Close())
+}
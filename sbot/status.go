@@ -5,16 +5,14 @@
 package sbot
 
 import (
-	"net"
 	"os"
 	"sort"
 	"time"
 
 	"github.com/dustin/go-humanize"
-	"github.com/ssbc/go-netwrap"
 
 	"github.com/ssbc/go-ssb"
-	multiserver "github.com/ssbc/go-ssb-multiserver"
+	"github.com/ssbc/go-ssb/network"
 )
 
 func (sbot *Sbot) Status() (ssb.Status, error) {
@@ -29,13 +27,8 @@ func (sbot *Sbot) Status() (ssb.Status, error) {
 	sort.Sort(byConnTime(edps))
 
 	for _, es := range edps {
-		var ms multiserver.NetAddress
-		ms.Ref = es.ID
-		if tcpAddr, ok := netwrap.GetAddr(es.Addr, "tcp").(*net.TCPAddr); ok {
-			ms.Addr = *tcpAddr
-		}
 		s.Peers = append(s.Peers, ssb.PeerStatus{
-			Addr:  ms.String(),
+			Addr:  network.MultiserverAddress(es.Addr),
 			Since: humanize.Time(time.Now().Add(-es.Since)),
 		})
 	}